@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"keeper/internal/client/api"
 	"keeper/internal/client/service"
 	"keeper/internal/logger"
@@ -11,19 +12,26 @@ import (
 )
 
 func main() {
+	os.Exit(run())
+}
+
+// run запускает клиент и возвращает код завершения
+func run() int {
 	log := logger.InitLog(logrus.DebugLevel)
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	service, err := service.GetService(log)
-	defer service.Close()
 	if err != nil {
-		return
+		return api.ExitCode(err)
 	}
+	defer service.Close()
 	app := api.InitCLIApp(ctx, log, service)
 
 	err = app.Run(os.Args)
 	if err != nil {
-		return
+		fmt.Fprintln(os.Stderr, api.ErrorMessage(err))
+		return api.ExitCode(err)
 	}
+	return 0
 }
@@ -2,19 +2,28 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"keeper/internal/certs"
 	"keeper/internal/config"
 	"keeper/internal/logger"
+	"keeper/internal/model"
 	"keeper/internal/server/handlers"
+	"keeper/internal/server/kvstore"
 	"keeper/internal/server/service"
 	"keeper/internal/server/storage"
 	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/grpc-ecosystem/go-grpc-middleware/v2/interceptors/auth"
 	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 
@@ -25,19 +34,271 @@ import (
 func main() {
 
 	log := logger.InitLog(logrus.DebugLevel)
+
+	app := cli.NewApp()
+	app.Name = "Сервер менеджера паролей"
+	// флаги важнее переменных окружения и файла конфигурации
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "config",
+			Usage: "файл конфигурации в формате json, по умолчанию из CONFIG",
+		},
+		cli.StringFlag{
+			Name:  "listen",
+			Usage: "TCP адрес сервера",
+		},
+		cli.StringFlag{
+			Name:  "unix-socket",
+			Usage: "путь к сокету Unix для дополнительных подключений",
+		},
+		cli.StringFlag{
+			Name:  "storage",
+			Usage: "хранилище данных: postgres, memory или bolt",
+		},
+		cli.StringFlag{
+			Name:  "storage-path",
+			Usage: "файл хранилища bolt",
+		},
+		cli.StringFlag{
+			Name:  "database-dsn",
+			Usage: "строка подключения к бд",
+		},
+		cli.StringFlag{
+			Name:  "log-level",
+			Usage: "уровень журнала: debug, info, warn, error",
+		},
+		cli.StringFlag{
+			Name:  "secret-file",
+			Usage: "файл с секретом сервера",
+		},
+	}
+	app.Action = func(c *cli.Context) error {
+		return runServer(log, configFlags(c))
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:  "rotate-key",
+			Usage: "Перевести ключи пользователей и данные на новый секрет сервера",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "old-secret",
+					Usage:  "прежний секрет сервера",
+					EnvVar: "KEEPER_OLD_SECRET",
+				},
+				cli.StringFlag{
+					Name:   "new-secret",
+					Usage:  "новый секрет сервера",
+					EnvVar: "KEEPER_NEW_SECRET",
+				},
+				cli.IntFlag{
+					Name:  "batch-size",
+					Usage: "количество записей, обрабатываемых в одной транзакции",
+					Value: 100,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return rotateKey(log, configFlags(c), c.String("old-secret"),
+					c.String("new-secret"), c.Int("batch-size"))
+			},
+		},
+		{
+			Name:  "gen-certs",
+			Usage: "Создать самоподписанный центр сертификации и сертификаты сервера и клиента",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "out",
+					Usage: "каталог для сертификатов и ключей",
+					Value: "certs",
+				},
+				cli.StringSliceFlag{
+					Name:  "host",
+					Usage: "имя или IP адрес сервера в сертификате, можно указать несколько раз",
+				},
+				cli.IntFlag{
+					Name:  "days",
+					Usage: "срок действия сертификатов в днях",
+					Value: 365,
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return genCerts(log, c.String("out"), c.StringSlice("host"), c.Int("days"))
+			},
+		},
+		{
+			Name:  "migrate",
+			Usage: "Миграции схемы бд",
+			Subcommands: []cli.Command{
+				{
+					Name:  "status",
+					Usage: "Вывести примененные и ожидающие миграции",
+					Action: func(c *cli.Context) error {
+						return migrate(log, configFlags(c), printMigrations)
+					},
+				},
+				{
+					Name:  "up",
+					Usage: "Применить ожидающие миграции",
+					Action: func(c *cli.Context) error {
+						return migrate(log, configFlags(c), func(ctx context.Context,
+							migrator *storage.Migrator) error {
+							applied, err := migrator.Up(ctx)
+							fmt.Printf("Применено миграций: %d\n", applied)
+							return err
+						})
+					},
+				},
+				{
+					Name:  "down",
+					Usage: "Откатить последние миграции, данные в удаляемых таблицах теряются",
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "steps",
+							Usage: "количество откатываемых миграций",
+							Value: 1,
+						},
+						cli.BoolFlag{
+							Name:  "yes",
+							Usage: "подтвердить откат",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.Int("steps") <= 0 {
+							return cli.NewExitError("steps должен быть положительным", 1)
+						}
+						if !c.Bool("yes") {
+							return cli.NewExitError("Откат миграций удаляет данные, подтвердите флагом --yes", 1)
+						}
+						return migrate(log, configFlags(c), func(ctx context.Context,
+							migrator *storage.Migrator) error {
+							reverted, err := migrator.Down(ctx, c.Int("steps"))
+							fmt.Printf("Откачено миграций: %d\n", reverted)
+							return err
+						})
+					},
+				},
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "Работа с конфигурацией сервера",
+			Subcommands: []cli.Command{
+				{
+					Name:  "print",
+					Usage: "Вывести действующую конфигурацию, секреты скрыты",
+					Action: func(c *cli.Context) error {
+						// в stdout выводится только конфигурация
+						log.SetOutput(os.Stderr)
+						return printConfig(log, configFlags(c))
+					},
+				},
+			},
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Error(err.Error())
+		os.Exit(1)
+	}
+}
+
+// configFlags собирает глобальные флаги, заменяющие значения конфигурации
+func configFlags(c *cli.Context) config.Flags {
+	return config.Flags{
+		ConfigFile:  c.GlobalString("config"),
+		Listen:      c.GlobalString("listen"),
+		UnixSocket:  c.GlobalString("unix-socket"),
+		Storage:     c.GlobalString("storage"),
+		StoragePath: c.GlobalString("storage-path"),
+		Database:    c.GlobalString("database-dsn"),
+		LogLevel:    c.GlobalString("log-level"),
+		SecretFile:  c.GlobalString("secret-file"),
+	}
+}
+
+// storer - хранилище данных сервера вместе с методом, который
+// вызывается при остановке
+type storer interface {
+	service.Storer
+	Close()
+}
+
+// openStorage открывает хранилище данных, выбранное в конфигурации
+func openStorage(ctx context.Context, log *logrus.Logger, config model.Config) (storer, error) {
+	switch config.Storage {
+	case model.StorageMemory:
+		log.Warn("Данные хранятся в памяти и пропадут при остановке сервера")
+		return kvstore.NewMemory(log), nil
+	case model.StorageBolt:
+		db, err := kvstore.OpenBolt(config.StoragePath, log)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	default:
+		db, err := storage.NewStorage(ctx, log, config)
+		if err != nil {
+			return nil, err
+		}
+		return db, nil
+	}
+}
+
+// runServer запускает gRPC сервер и останавливает его по сигналу
+func runServer(log *logrus.Logger, flags config.Flags) error {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	config, err := config.GetConfig(log)
+	config, err := config.GetConfig(log, flags)
 	if err != nil {
-		return
+		return err
 	}
+	// уровень уже проверен при загрузке конфигурации
+	level, _ := logrus.ParseLevel(config.LogLevel)
+	log.SetLevel(level)
 
-	storage, err := storage.NewStorage(ctx, log, config)
+	storage, err := openStorage(ctx, log, config)
 	if err != nil {
-		return
+		return err
 	}
+
 	service := service.NewService(ctx, storage, log, config)
+	// очищаем историю записей и корзину по политике хранения, пока работает сервер
+	go service.RunHistoryPruning(ctx)
+	go service.RunTrashPurge(ctx)
+	// записи в устаревшем формате перешифровываются один раз, не задерживая запуск
+	go service.RunLegacyCipherMigration(ctx)
+
+	creds, err := certs.ServerCredentials(config.TLS)
+	if err != nil {
+		return err
+	}
+	if config.TLS.Insecure {
+		log.Warn("TLS выключен, пароли и токены передаются открытым текстом")
+	}
+
+	// один сервер обслуживает оба сервиса, методы AuthService без токена
+	// пропускаются интерсептором через HandlersAuth.AuthFuncOverride
+	authFunc := data.NewAuthInterceptor(service, log)
+	options := []grpc.ServerOption{
+		grpc.Creds(creds),
+		grpc.UnaryInterceptor(auth.UnaryServerInterceptor(authFunc)),
+		grpc.StreamInterceptor(auth.StreamServerInterceptor(authFunc)),
+		grpc.MaxRecvMsgSize(config.Limits.MaxMessageBytes),
+		grpc.MaxSendMsgSize(config.Limits.MaxMessageBytes),
+	}
+	if config.Limits.MaxConcurrentStreams > 0 {
+		options = append(options, grpc.MaxConcurrentStreams(uint32(config.Limits.MaxConcurrentStreams)))
+	}
+	server := grpc.NewServer(options...)
+	reflection.Register(server)
+	authService.RegisterAuthServiceServer(server, handlers.NewHandlersAuth(service, log))
+	data.RegisterDataServiceServer(server, handlers.NewHandlersData(service, log))
+
+	listeners, err := openListeners(config, log)
+	if err != nil {
+		storage.Close()
+		return err
+	}
 
 	// канал для перенаправления прерываний
 	// поскольку нужно отловить всего одно прерывание,
@@ -47,54 +308,180 @@ func main() {
 	signal.Notify(sigint, syscall.SIGTERM, syscall.SIGINT, syscall.SIGQUIT)
 
 	var wg sync.WaitGroup
-	var serverAuth *grpc.Server
-	var serverData *grpc.Server
-
-	wg.Add(1)
-	// Запускаем сервер авторизации пользователей
-	go func() {
-		defer wg.Done()
-		lisAuth, err := net.Listen("tcp", ":9090")
-		if err != nil {
-			return
-		}
-
-		serverAuth = grpc.NewServer()
-		authService.RegisterAuthServiceServer(serverAuth, handlers.NewHandlersAuth(
-			service, log))
-		log.Info("Запустили gRPC сервис для аутентификации на порте 9090")
-		serverAuth.Serve(lisAuth)
-	}()
-
-	wg.Add(1)
-	// Запускаем сервер взаимодействия с данными
-	go func() {
-		defer wg.Done()
-		lisData, err := net.Listen("tcp", ":9091")
-		if err != nil {
-			return
-		}
-		serverData = grpc.NewServer(
-			grpc.UnaryInterceptor(auth.UnaryServerInterceptor(data.AuthInterceptor)),
-		)
-
-		reflection.Register(serverData)
-		data.RegisterDataServiceServer(serverData, handlers.NewHandlersData(service, log))
-		log.Info("Запустили gRPC сервис для CRUD операци на порте 9091")
-		serverData.Serve(lisData)
-	}()
+	for _, listener := range listeners {
+		wg.Add(1)
+		go func(listener net.Listener) {
+			defer wg.Done()
+			log.Info("Запустили gRPC сервер на " + listener.Addr().String())
+			if err := server.Serve(listener); err != nil {
+				log.Error(err.Error())
+			}
+		}(listener)
+	}
 
 	sig := <-sigint
 	log.WithFields(logrus.Fields{
 		"signal": sig,
 	}).Info("Полученный сигнал")
 
-	serverAuth.Stop()
-	serverData.Stop()
+	server.Stop()
 
 	log.Info("Server shutdown gracefully")
 
 	wg.Wait()
 	// закрываем ресурсы перед выходом
 	storage.Close()
+	return nil
+}
+
+// openListeners открывает TCP адрес сервера и, если он задан, сокет Unix.
+// Оставшийся от прошлого запуска файл сокета удаляется
+func openListeners(config model.Config, log *logrus.Logger) ([]net.Listener, error) {
+	tcpListener, err := net.Listen("tcp", config.Listen)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	listeners := []net.Listener{tcpListener}
+	if config.UnixSocket == "" {
+		return listeners, nil
+	}
+
+	if err = os.Remove(config.UnixSocket); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		tcpListener.Close()
+		log.Error(err.Error())
+		return nil, err
+	}
+	unixListener, err := net.Listen("unix", config.UnixSocket)
+	if err != nil {
+		tcpListener.Close()
+		log.Error(err.Error())
+		return nil, err
+	}
+	return append(listeners, unixListener), nil
+}
+
+// rotateKey переводит ключи пользователей и данные с прежнего секрета сервера
+// на новый. Прерванную ротацию можно продолжить, запустив команду повторно
+// с теми же секретами. На время ротации работающему серверу нужно задать новый
+// секрет в KEEPER_SECRET и прежний в KEEPER_PREVIOUS_SECRET
+func rotateKey(log *logrus.Logger, flags config.Flags, oldSecret string,
+	newSecret string, batchSize int) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM,
+		syscall.SIGINT, syscall.SIGQUIT)
+	defer cancel()
+
+	if batchSize <= 0 {
+		return cli.NewExitError("batch-size должен быть положительным", 1)
+	}
+
+	// секреты ротации заменяют секреты из конфигурации. Сервер не
+	// запускается, поэтому TLS и остальные его настройки не проверяются
+	flags.Secret = newSecret
+	config, err := config.GetRotationConfig(log, flags)
+	if err != nil {
+		return err
+	}
+	config.PreviousSecretPassword = oldSecret
+
+	storage, err := openStorage(ctx, log, config)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+
+	rotation, err := service.NewService(ctx, storage, log, config).RotateMasterKey(ctx, batchSize)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"phase":     rotation.Phase,
+			"processed": rotation.Processed,
+		}).Error("Ротация прервана, запустите команду повторно, чтобы продолжить")
+		return err
+	}
+	if rotation.Phase == model.RotationPhaseDone {
+		log.WithFields(logrus.Fields{
+			"processed": rotation.Processed,
+		}).Info("Ротация секрета сервера завершена, KEEPER_PREVIOUS_SECRET можно удалить")
+	}
+	return nil
+}
+
+// genCerts создает сертификаты для локального развертывания и выводит
+// отпечаток ключа сервера для закрепления на клиенте
+func genCerts(log *logrus.Logger, dir string, hosts []string, days int) error {
+	if days <= 0 {
+		return cli.NewExitError("days должен быть положительным", 1)
+	}
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	pin, err := certs.Generate(dir, hosts, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		return err
+	}
+	log.WithFields(logrus.Fields{
+		"dir":   dir,
+		"hosts": strings.Join(hosts, ","),
+	}).Info("Создали сертификаты, укажите их в секции tls файла конфигурации")
+	fmt.Printf("Отпечаток ключа сервера для KEEPER_PIN_SHA256: %s\n", pin)
+	return nil
+}
+
+// printConfig выводит действующую конфигурацию со скрытыми секретами.
+// Некорректная конфигурация тоже выводится, чтобы было видно, откуда
+// взялись значения, но команда завершается ошибкой
+func printConfig(log *logrus.Logger, flags config.Flags) error {
+	cfg, err := config.GetConfig(log, flags)
+	if err != nil && !errors.Is(err, model.ErrInvalidConfig) {
+		return err
+	}
+	out, redactErr := config.Redacted(cfg)
+	if redactErr != nil {
+		return redactErr
+	}
+	fmt.Println(string(out))
+	return err
+}
+
+// migrate подключается к бд и выполняет действие с миграциями схемы
+func migrate(log *logrus.Logger, flags config.Flags,
+	action func(ctx context.Context, migrator *storage.Migrator) error) error {
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGTERM,
+		syscall.SIGINT, syscall.SIGQUIT)
+	defer cancel()
+
+	// миграциям нужна только бд, секрет и TLS не требуются
+	config, err := config.GetStorageConfig(log, flags)
+	if err != nil {
+		return err
+	}
+	// остальные хранилища создают свою структуру сами при открытии
+	if config.Storage != model.StoragePostgres {
+		return cli.NewExitError("Миграции схемы нужны только хранилищу "+model.StoragePostgres, 1)
+	}
+	migrator, err := storage.NewMigrator(ctx, log, config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	return action(ctx, migrator)
+}
+
+// printMigrations выводит состояние миграций схемы бд
+func printMigrations(ctx context.Context, migrator *storage.Migrator) error {
+	migrations, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range migrations {
+		state := "ожидает"
+		switch {
+		case migration.Unknown:
+			state = "применена более новой версией сервера"
+		case migration.Applied:
+			state = "применена " + migration.AppliedAt.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Printf("%04d  %-20s  %s\n", migration.Version, migration.Name, state)
+	}
+	return nil
 }
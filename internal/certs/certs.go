@@ -0,0 +1,169 @@
+package certs
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"keeper/internal/model"
+)
+
+// ServerCredentials возвращает транспортные настройки gRPC серверов
+func ServerCredentials(cfg model.TLSConfig) (credentials.TransportCredentials, error) {
+	if cfg.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig, err := serverTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// ClientCredentials возвращает транспортные настройки клиентских соединений
+func ClientCredentials(cfg model.ClientTLS) (credentials.TransportCredentials, error) {
+	if cfg.Insecure {
+		return insecure.NewCredentials(), nil
+	}
+	tlsConfig, err := clientTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(tlsConfig), nil
+}
+
+// serverTLSConfig собирает настройки TLS сервера. Если задан центр
+// сертификации клиентов, сервер проверяет их сертификаты, а с
+// RequireClientCert не принимает клиентов без сертификата
+func serverTLSConfig(cfg model.TLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, model.ErrTLSNotConfigured
+	}
+	certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, tlsFileError(err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCAFile == "" {
+		if cfg.RequireClientCert {
+			return nil, model.ErrClientCARequired
+		}
+		return tlsConfig, nil
+	}
+	tlsConfig.ClientCAs, err = loadCertPool(cfg.ClientCAFile)
+	if err != nil {
+		return nil, tlsFileError(err)
+	}
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	if cfg.RequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
+
+// tlsFileError подсказывает, как создать отсутствующие файлы сертификатов
+func tlsFileError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", model.ErrTLSFileNotFound, err)
+	}
+	return err
+}
+
+// clientTLSConfig собирает настройки TLS клиента. Без центра сертификации
+// клиент доверяет системным сертификатам. Если закреплен ключ сервера,
+// а центр сертификации не задан, доверие основано только на закреплении,
+// так можно подключаться к серверу с самоподписанным сертификатом
+func clientTLSConfig(cfg model.ClientTLS) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		ServerName: cfg.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if cfg.CAFile != "" {
+		pool, err := loadCertPool(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return nil, model.ErrClientKeyPair
+	}
+	if cfg.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	pins := parsePins(cfg.PinSHA256)
+	if len(pins) == 0 {
+		return tlsConfig, nil
+	}
+	pinOnly := cfg.CAFile == ""
+	// при закреплении без центра сертификации стандартную проверку цепочки
+	// заменяет проверка ключа в VerifyPeerCertificate
+	tlsConfig.InsecureSkipVerify = pinOnly
+	tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return model.ErrPinMismatch
+		}
+		leaf, err := x509.ParseCertificate(rawCerts[0])
+		if err != nil {
+			return err
+		}
+		if pinOnly && tlsConfig.ServerName != "" {
+			if err = leaf.VerifyHostname(tlsConfig.ServerName); err != nil {
+				return err
+			}
+		}
+		if !pins[PublicKeyPin(leaf)] {
+			return model.ErrPinMismatch
+		}
+		return nil
+	}
+	return tlsConfig, nil
+}
+
+// PublicKeyPin возвращает отпечаток открытого ключа сертификата для
+// закрепления на клиенте: sha256 от SubjectPublicKeyInfo в base64
+func PublicKeyPin(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// parsePins разбирает список закрепленных отпечатков через запятую
+func parsePins(value string) map[string]bool {
+	pins := make(map[string]bool)
+	for _, pin := range strings.Split(value, ",") {
+		if pin = strings.TrimSpace(pin); pin != "" {
+			pins[strings.TrimPrefix(pin, "sha256/")] = true
+		}
+	}
+	return pins
+}
+
+// loadCertPool читает сертификаты центра сертификации в формате PEM
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pemData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("%w: %s", model.ErrInvalidCA, path)
+	}
+	return pool, nil
+}
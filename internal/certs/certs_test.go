@@ -0,0 +1,145 @@
+package certs
+
+import (
+	"crypto/tls"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"keeper/internal/model"
+)
+
+// handshake соединяет клиента и сервер через локальный порт
+// и возвращает ошибку рукопожатия на стороне клиента и сервера
+func handshake(t *testing.T, serverConfig *tls.Config, clientConfig *tls.Config) (error, error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	require.NoError(t, err)
+	defer listener.Close()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		defer conn.Close()
+		// в TLS 1.3 сервер проверяет сертификат клиента, когда клиент
+		// уже завершил рукопожатие, поэтому ждем от клиента данные
+		buf := make([]byte, 1)
+		if _, err = conn.Read(buf); err == nil {
+			_, err = conn.Write(buf)
+		}
+		serverErr <- err
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if err != nil {
+		return err, <-serverErr
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte{1})
+	if err == nil {
+		// ошибку проверки сертификата клиента сервер присылает вместо ответа
+		_, err = conn.Read(make([]byte, 1))
+	}
+	return err, <-serverErr
+}
+
+func TestCertsHandshake(t *testing.T) {
+	dir := t.TempDir()
+	pin, err := Generate(dir, []string{"localhost", "127.0.0.1"}, time.Hour)
+	require.NoError(t, err)
+	path := func(name string) string { return filepath.Join(dir, name) }
+
+	server := model.TLSConfig{
+		CertFile: path(ServerCertFile),
+		KeyFile:  path(ServerKeyFile),
+	}
+	mutual := server
+	mutual.ClientCAFile = path(CACertFile)
+	mutual.RequireClientCert = true
+
+	tests := []struct {
+		name          string
+		server        model.TLSConfig
+		client        model.ClientTLS
+		wantClientErr bool
+		wantServerErr bool
+	}{
+		{
+			name:   "Доверие центру сертификации",
+			server: server,
+			client: model.ClientTLS{CAFile: path(CACertFile), ServerName: "localhost"},
+		},
+		{
+			name:          "Неизвестный центр сертификации",
+			server:        server,
+			client:        model.ClientTLS{ServerName: "localhost"},
+			wantClientErr: true,
+			wantServerErr: true,
+		},
+		{
+			name:   "Закрепленный ключ без центра сертификации",
+			server: server,
+			client: model.ClientTLS{PinSHA256: pin, ServerName: "localhost"},
+		},
+		{
+			name:          "Ключ не совпадает с закрепленным",
+			server:        server,
+			client:        model.ClientTLS{CAFile: path(CACertFile), ServerName: "localhost", PinSHA256: "AAAA"},
+			wantClientErr: true,
+			wantServerErr: true,
+		},
+		{
+			name:   "Сертификат клиента",
+			server: mutual,
+			client: model.ClientTLS{CAFile: path(CACertFile), ServerName: "localhost",
+				CertFile: path(ClientCertFile), KeyFile: path(ClientKeyFile)},
+		},
+		{
+			name:          "Клиент без сертификата",
+			server:        mutual,
+			client:        model.ClientTLS{CAFile: path(CACertFile), ServerName: "localhost"},
+			wantClientErr: true,
+			wantServerErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			serverConfig, err := serverTLSConfig(tt.server)
+			require.NoError(t, err)
+			clientConfig, err := clientTLSConfig(tt.client)
+			require.NoError(t, err)
+
+			clientErr, serverErr := handshake(t, serverConfig, clientConfig)
+			assert.Equal(t, tt.wantClientErr, clientErr != nil, "клиент: %v", clientErr)
+			assert.Equal(t, tt.wantServerErr, serverErr != nil, "сервер: %v", serverErr)
+		})
+	}
+}
+
+func TestCertsServerTLSConfig(t *testing.T) {
+	_, err := serverTLSConfig(model.TLSConfig{})
+	assert.ErrorIs(t, err, model.ErrTLSNotConfigured)
+
+	dir := t.TempDir()
+	// сертификаты еще не созданы командой gen-certs
+	_, err = serverTLSConfig(model.TLSConfig{
+		CertFile: filepath.Join(dir, ServerCertFile),
+		KeyFile:  filepath.Join(dir, ServerKeyFile),
+	})
+	assert.ErrorIs(t, err, model.ErrTLSFileNotFound)
+
+	_, err = Generate(dir, []string{"localhost"}, time.Hour)
+	require.NoError(t, err)
+	_, err = serverTLSConfig(model.TLSConfig{
+		CertFile:          filepath.Join(dir, ServerCertFile),
+		KeyFile:           filepath.Join(dir, ServerKeyFile),
+		RequireClientCert: true,
+	})
+	assert.ErrorIs(t, err, model.ErrClientCARequired)
+}
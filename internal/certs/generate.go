@@ -0,0 +1,132 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Имена файлов, которые создает Generate
+const (
+	CACertFile     = "ca.crt"
+	CAKeyFile      = "ca.key"
+	ServerCertFile = "server.crt"
+	ServerKeyFile  = "server.key"
+	ClientCertFile = "client.crt"
+	ClientKeyFile  = "client.key"
+)
+
+// Generate создает в каталоге dir самоподписанный центр сертификации
+// и выпущенные им сертификаты сервера для hosts и клиента для mTLS.
+// Возвращает отпечаток ключа сервера для закрепления на клиенте.
+// Сертификаты предназначены для локального развертывания
+func Generate(dir string, hosts []string, validity time.Duration) (string, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(validity)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	caTemplate := &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "keeper local CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caCert, err := issue(caTemplate, caTemplate, caKey, caKey, dir, CACertFile, CAKeyFile)
+	if err != nil {
+		return "", err
+	}
+
+	serverKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	serverTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "keeper server"},
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			serverTemplate.IPAddresses = append(serverTemplate.IPAddresses, ip)
+		} else {
+			serverTemplate.DNSNames = append(serverTemplate.DNSNames, host)
+		}
+	}
+	serverCert, err := issue(serverTemplate, caCert, serverKey, caKey, dir, ServerCertFile, ServerKeyFile)
+	if err != nil {
+		return "", err
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	clientTemplate := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "keeper client"},
+		NotBefore:   notBefore,
+		NotAfter:    notAfter,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if _, err = issue(clientTemplate, caCert, clientKey, caKey, dir, ClientCertFile, ClientKeyFile); err != nil {
+		return "", err
+	}
+	return PublicKeyPin(serverCert), nil
+}
+
+// issue подписывает сертификат ключом издателя и сохраняет сертификат
+// и закрытый ключ в файлы PEM. Закрытый ключ доступен только владельцу
+func issue(template *x509.Certificate, parent *x509.Certificate, key *ecdsa.PrivateKey,
+	parentKey *ecdsa.PrivateKey, dir string, certFile string, keyFile string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template.SerialNumber = serial
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err = writePEM(filepath.Join(dir, keyFile), "EC PRIVATE KEY", keyDER, 0600); err != nil {
+		return nil, err
+	}
+	if err = writePEM(filepath.Join(dir, certFile), "CERTIFICATE", der, 0644); err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// writePEM записывает блок PEM в файл с правами perm
+func writePEM(path string, blockType string, der []byte, perm os.FileMode) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	err = pem.Encode(file, &pem.Block{Type: blockType, Bytes: der})
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -2,6 +2,7 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -17,16 +18,52 @@ import (
 type Service interface {
 	Register(ctx context.Context, login string, password string) (string, error)
 	Auth(ctx context.Context, login string, password string) (string, error)
+	CompleteSecondFactor(ctx context.Context, code string) (string, error)
+	EnrollTOTP(ctx context.Context, jwtToken string) (model.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, jwtToken string, code string) ([]string, error)
 	Add(ctx context.Context, jwtToken string, data model.DataBlock) error
 	Get(ctx context.Context, jwtToken string, dataKeyWord string) ([]model.DataBlock, error)
+	ListData(ctx context.Context, jwtToken string, query model.ListQuery) ([]model.DataHeader,
+		string, error)
 	Delete(ctx context.Context, jwtToken string, dataKeyWord string) error
 	Change(ctx context.Context, jwtToken string, data model.DataBlock) error
+	UnlockVault(ctx context.Context, jwtToken string, masterPassword string) error
+	Resume(login string)
+	Connect(address string) error
+	RestoreSession(login string) (string, error)
+	Logout(ctx context.Context, jwtToken string) error
+	LogoutAll(ctx context.Context, jwtToken string) (int64, error)
+	UploadFile(ctx context.Context, jwtToken string, path string, dataKeyWord string,
+		metaData string) (model.FileInfo, error)
+	DownloadFile(ctx context.Context, jwtToken string, dataKeyWord string,
+		path string) (model.FileInfo, error)
+	Sync(ctx context.Context, jwtToken string) (model.SyncStats, error)
+	ListConflicts(ctx context.Context, jwtToken string, dataKeyWord string) ([]model.Conflict, error)
+	ResolveConflict(ctx context.Context, jwtToken string,
+		resolution model.ConflictResolution) (int64, error)
+	ListTrash(ctx context.Context, jwtToken string) ([]model.TrashedRecord, error)
+	RestoreFromTrash(ctx context.Context, jwtToken string, trashID int64) (int64, error)
+	EmptyTrash(ctx context.Context, jwtToken string) (int64, error)
+	GetHistory(ctx context.Context, jwtToken string, dataKeyWord string) ([]model.Revision, error)
+	RestoreRevision(ctx context.Context, jwtToken string, revisionID int64,
+		expectedVersion int64) (int64, error)
 	/*checkData() // проверить размер файлов */
 }
 
 func InitCLIApp(ctx context.Context, log *logrus.Logger, service Service) *cli.App {
 	app := cli.NewApp()
 	app.Name = "Веб приложение для хранения паролей"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:   "server",
+			Usage:  "адрес сервера: host:port или unix:///путь/к/сокету",
+			EnvVar: "KEEPER_SERVER",
+			Value:  model.DefaultServerAddress,
+		},
+	}
+	app.Before = func(c *cli.Context) error {
+		return service.Connect(c.GlobalString("server"))
+	}
 
 	app.Commands = []cli.Command{
 		{
@@ -35,8 +72,10 @@ func InitCLIApp(ctx context.Context, log *logrus.Logger, service Service) *cli.A
 			Action: func(c *cli.Context) error {
 
 				fmt.Println("Приложение запущено. Для выхода введите exit")
-				var jwtToken string
-				var err error
+				jwtToken, err := restoreSession(ctx, log, service)
+				if err != nil {
+					return err
+				}
 				for {
 					var input string
 					fmt.Scanln(&input)
@@ -48,11 +87,28 @@ func InitCLIApp(ctx context.Context, log *logrus.Logger, service Service) *cli.A
 						if err != nil {
 							return err
 						}
+						if jwtToken != "" {
+							if err = unlock(ctx, log, service, jwtToken); err != nil {
+								return err
+							}
+						}
 					case "auth":
 						jwtToken, err = auth(ctx, log, service)
 						if err != nil {
 							return err
 						}
+						if jwtToken != "" {
+							if err = unlock(ctx, log, service, jwtToken); err != nil {
+								return err
+							}
+						}
+					case "unlock":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = unlock(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
 					case "add":
 						if checkAuth(jwtToken, log) {
 							continue
@@ -67,6 +123,13 @@ func InitCLIApp(ctx context.Context, log *logrus.Logger, service Service) *cli.A
 						if err = get(ctx, log, service, jwtToken); err != nil {
 							return err
 						}
+					case "list":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = list(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
 					case "delete":
 						if checkAuth(jwtToken, log) {
 							continue
@@ -81,21 +144,141 @@ func InitCLIApp(ctx context.Context, log *logrus.Logger, service Service) *cli.A
 						if err = change(ctx, log, service, jwtToken); err != nil {
 							return err
 						}
+					case "upload":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = upload(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
+					case "download":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = download(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
+					case "sync":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = sync(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
+					case "trash":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = trash(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
+					case "restore":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = restore(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
+					case "empty-trash":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = emptyTrash(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
+					case "history":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = history(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
+					case "conflicts":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = conflicts(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
+					case "totp":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = enrollTOTP(ctx, log, service, jwtToken); err != nil {
+							return err
+						}
+					case "logout":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = logout(ctx, log, service, jwtToken, false); err != nil {
+							return err
+						}
+						jwtToken = ""
+					case "logout-all":
+						if checkAuth(jwtToken, log) {
+							continue
+						}
+						if err = logout(ctx, log, service, jwtToken, true); err != nil {
+							return err
+						}
+						jwtToken = ""
 					default:
 						fmt.Println("register - регистрация пользователя")
 						fmt.Println("auth - аутентификация пользователя")
+						fmt.Println("unlock - ввести мастер-пароль для сквозного шифрования")
 						fmt.Println("add - добавить данные")
 						fmt.Println("get - получить данные")
+						fmt.Println("list - список сохраненных записей")
 						fmt.Println("change - изменить данные")
-						fmt.Println("delete - удалить данные")
+						fmt.Println("delete - переместить данные в корзину")
+						fmt.Println("upload - загрузить файл")
+						fmt.Println("download - скачать файл")
+						fmt.Println("sync - отправить изменения, сделанные без связи с сервером, и обновить локальную копию")
+						fmt.Println("trash - показать записи в корзине")
+						fmt.Println("restore - вернуть запись из корзины")
+						fmt.Println("empty-trash - удалить записи из корзины навсегда")
+						fmt.Println("history - показать прежние версии записи и восстановить одну из них")
+						fmt.Println("conflicts - разрешить конфликты изменений с разных устройств")
+						fmt.Println("totp - подключить приложение-аутентификатор для входа")
+						fmt.Println("logout - выйти из текущей сессии")
+						fmt.Println("logout-all - выйти на всех устройствах")
 					}
 				}
 			},
 		},
 	}
+	app.Commands = append(app.Commands, scriptCommands(ctx, log, service)...)
 	return app
 }
 
+// restoreSession продолжает сессию, сохраненную при прошлом запуске клиента
+func restoreSession(ctx context.Context, log *logrus.Logger, service Service) (string, error) {
+	jwtToken, err := service.RestoreSession("")
+	if err != nil {
+		if errors.Is(err, model.ErrNoSession) {
+			return "", nil
+		}
+		if errors.Is(err, model.ErrInvalidSession) || errors.Is(err, model.ErrInsecureKeyFile) {
+			fmt.Println(err.Error())
+			return "", nil
+		}
+		return "", err
+	}
+	// без мастер-пароля клиент узнает, задан ли он, чтобы не отправить данные открытыми
+	err = service.UnlockVault(ctx, jwtToken, "")
+	switch {
+	case errors.Is(err, model.ErrVaultLocked):
+		fmt.Println("Сессия восстановлена. " + err.Error())
+	case err != nil:
+		log.Warn("Не удалось проверить хранилище: " + err.Error())
+		fmt.Println("Сессия восстановлена. Если данные зашифрованы на клиенте, введите мастер-пароль командой unlock")
+	default:
+		fmt.Println("Сессия восстановлена")
+	}
+	return jwtToken, nil
+}
+
 func checkAuth(jwtToken string, log *logrus.Logger) bool {
 	if jwtToken != "" {
 		return false
@@ -106,16 +289,7 @@ func checkAuth(jwtToken string, log *logrus.Logger) bool {
 
 func register(ctx context.Context, log *logrus.Logger,
 	service Service) (string, error) {
-	var login string
-	var password string
-	fmt.Println("Введите логин")
-	_, err := fmt.Scanln(&login)
-	if err != nil {
-		log.Error(err.Error())
-		return "", err
-	}
-	fmt.Println("Введите пароль")
-	_, err = fmt.Scanln(&password)
+	login, password, err := readLoginPassword()
 	if err != nil {
 		log.Error(err.Error())
 		return "", err
@@ -125,7 +299,7 @@ func register(ctx context.Context, log *logrus.Logger,
 		if e, ok := status.FromError(err); ok {
 			switch e.Code() {
 			case codes.AlreadyExists:
-				fmt.Println(e.Message())
+				fmt.Println(statusMessage(e))
 				return "", nil
 			default:
 				log.Error(err.Error())
@@ -141,123 +315,210 @@ func register(ctx context.Context, log *logrus.Logger,
 
 func auth(ctx context.Context, log *logrus.Logger,
 	service Service) (string, error) {
-	var login string
-	var password string
-	fmt.Println("Введите логин")
-	_, err := fmt.Scanln(&login)
-	if err != nil {
-		log.Error(err.Error())
-		return "", err
-	}
-	fmt.Println("Введите пароль")
-	_, err = fmt.Scanln(&password)
+	login, password, err := readLoginPassword()
 	if err != nil {
 		log.Error(err.Error())
 		return "", err
 	}
 	jwtToken, err := service.Auth(ctx, login, password)
+	if errors.Is(err, model.ErrSecondFactorRequired) {
+		var code string
+		if code, err = prompt(err.Error()); err != nil {
+			log.Error(err.Error())
+			return "", err
+		}
+		jwtToken, err = service.CompleteSecondFactor(ctx, strings.TrimSpace(code))
+	}
 	if err != nil {
 		if e, ok := status.FromError(err); ok {
 			switch e.Code() {
-			case codes.Unauthenticated:
-				fmt.Println(e.Message())
+			case codes.Unauthenticated, codes.ResourceExhausted:
+				fmt.Println(statusMessage(e))
 				return "", nil
 			default:
 				log.Error(err.Error())
 				return "", err
 			}
 		}
+		if errors.Is(err, model.ErrWrongCachePassword) {
+			fmt.Println(err.Error())
+			return "", nil
+		}
 		log.Error(err.Error())
 		return "", err
 	}
+	if jwtToken == model.OfflineToken {
+		fmt.Println(model.ErrOffline.Error() + ". Когда связь появится, повторите auth и sync")
+		return jwtToken, nil
+	}
 	fmt.Println("Аутентификация успешна")
 	return jwtToken, nil
 }
 
-func add(ctx context.Context, log *logrus.Logger,
-	service Service, jwtToken string) error {
-	fmt.Println("Введите строку с данными для добавления или путь к файлу")
-	var data model.DataBlock
-	_, err := fmt.Scanln(&data.Data)
+// unlock запрашивает мастер-пароль для сквозного шифрования данных на клиенте.
+// Неверный мастер-пароль запрашивается повторно
+func unlock(ctx context.Context, log *logrus.Logger, service Service, jwtToken string) error {
+	for {
+		masterPassword, err := readSecret("Введите мастер-пароль для шифрования данных на клиенте " +
+			"(оставьте пустым, чтобы данные шифровались на сервере)")
+		if err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		err = service.UnlockVault(ctx, jwtToken, masterPassword)
+		switch {
+		case errors.Is(err, model.ErrWrongMasterPassword):
+			fmt.Println(err.Error())
+			continue
+		case errors.Is(err, model.ErrVaultLocked):
+			fmt.Println(err.Error())
+			return nil
+		case err != nil:
+			log.Error(err.Error())
+			return err
+		}
+		if masterPassword != "" {
+			fmt.Println("Сквозное шифрование включено")
+		}
+		return nil
+	}
+}
+
+// enrollTOTP подключает приложение-аутентификатор и выводит коды восстановления
+func enrollTOTP(ctx context.Context, log *logrus.Logger, service Service,
+	jwtToken string) error {
+	enrollment, err := service.EnrollTOTP(ctx, jwtToken)
 	if err != nil {
+		if e, ok := status.FromError(err); ok && e.Code() == codes.AlreadyExists {
+			fmt.Println(statusMessage(e))
+			return nil
+		}
 		log.Error(err.Error())
 		return err
 	}
-	if strings.Contains(data.Data, `\`) {
-		data.Data, err = readFromFile(data.Data, log)
+	fmt.Println("Добавьте в приложение-аутентификатор ссылку или секрет:")
+	fmt.Println(enrollment.URI)
+	fmt.Println(enrollment.Secret)
+	fmt.Println("Введите код из приложения для подтверждения")
+	var code string
+	if _, err = fmt.Scanln(&code); err != nil {
+		log.Error(err.Error())
+		return err
 	}
-	fmt.Println("Введите ключ для однозначной идентификации данных")
-	_, err = fmt.Scanln(&data.DataKeyWord)
+	recoveryCodes, err := service.ConfirmTOTP(ctx, jwtToken, code)
 	if err != nil {
+		if e, ok := status.FromError(err); ok && e.Code() == codes.InvalidArgument {
+			fmt.Println(statusMessage(e))
+			return nil
+		}
 		log.Error(err.Error())
 		return err
 	}
-	fmt.Println(`Введите дополнительные метаданные (не рекомендуется вводить чувствительную информацию),` +
-		`если необходимо`)
-	_, err = fmt.Scan(&data.MetaData)
+	fmt.Println("Второй фактор подключен. Сохраните коды восстановления, " +
+		"каждый можно использовать один раз:")
+	for _, recoveryCode := range recoveryCodes {
+		fmt.Println(recoveryCode)
+	}
+	return nil
+}
+
+// logout завершает текущую сессию или все сессии пользователя
+func logout(ctx context.Context, log *logrus.Logger, service Service,
+	jwtToken string, all bool) error {
+	if !all {
+		if err := service.Logout(ctx, jwtToken); err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		fmt.Println("Сессия завершена")
+		return nil
+	}
+	revoked, err := service.LogoutAll(ctx, jwtToken)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	fmt.Printf("Завершено сессий: %d\n", revoked)
+	return nil
+}
+
+func add(ctx context.Context, log *logrus.Logger,
+	service Service, jwtToken string) error {
+	data, err := readRecord(log)
+	if err != nil {
+		if errors.Is(err, model.ErrBigFile) {
+			fmt.Println("Файл слишком большой для записи, загрузите его командой upload")
+			return nil
+		}
+		if errors.Is(err, model.ErrInvalidPayload) {
+			fmt.Println(err.Error())
+			return nil
+		}
+		return err
+	}
+	data.DataKeyWord, err = prompt("Введите ключ для однозначной идентификации данных")
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	data.MetaData, err = readMetaData()
 	if err != nil {
 		log.Error(err.Error())
 		return err
 	}
-	fmt.Printf("Вы ввели %s\n", data)
 	err = service.Add(ctx, jwtToken, data)
 	if err != nil {
+		if invalidRecord(err) || offlineError(err) {
+			return nil
+		}
 		return err
 	}
 	fmt.Println("Данные успешно добавлены")
 	return nil
 }
 
+// readFromFile читает небольшой файл для сохранения в записи.
+// Большие файлы загружаются командой upload
 func readFromFile(fileName string, log *logrus.Logger) (string, error) {
-
-	// Открываем файл для чтения.
-	file, err := os.OpenFile(fileName, os.O_RDONLY, 0666)
-	if err != nil {
-		log.Fatalf("Ошибка при открытии файла: %v", err)
-	}
-	defer file.Close()
-
-	// Определяем размер файла.
-	fileInfo, err := file.Stat()
+	fileInfo, err := os.Stat(fileName)
 	if err != nil {
 		log.Error(err.Error())
 		return "", err
 	}
-	fileSize := fileInfo.Size()
-	if fileSize > 3072 {
+	if fileInfo.Size() > model.MaxBinarySize {
 		err = model.ErrBigFile
 		log.Error(err.Error())
 		return "", err
 	}
 
-	// Создаем буфер для чтения данных из файла.
-	data := make([]byte, fileSize)
-
-	// Читаем данные из файла в буфер.
-	_, err = file.Read(data)
+	data, err := os.ReadFile(fileName)
 	if err != nil {
 		log.Error(err.Error())
 		return "", err
 	}
-
-	return string(data), err
+	return string(data), nil
 }
 
 func get(ctx context.Context, log *logrus.Logger,
 	service Service, jwtToken string) error {
-	var keyWord string
-	fmt.Println("Введите ключ для однозначной идентификации данных")
-	_, err := fmt.Scanln(&keyWord)
+	keyWord, err := prompt("Введите ключ для однозначной идентификации данных")
 	if err != nil {
 		log.Error(err.Error())
 		return err
 	}
 	data, err := service.Get(ctx, jwtToken, keyWord)
 	if err != nil {
+		if errors.Is(err, model.ErrVaultLocked) || errors.Is(err, model.ErrWrongMasterPassword) {
+			fmt.Println(err.Error())
+			return nil
+		}
+		if offlineError(err) {
+			return nil
+		}
 		if e, ok := status.FromError(err); ok {
 			switch e.Code() {
 			case codes.NotFound:
-				fmt.Println(e.Message())
+				fmt.Println(statusMessage(e))
 				return nil
 			default:
 				log.Error(err.Error())
@@ -267,55 +528,157 @@ func get(ctx context.Context, log *logrus.Logger,
 		log.Error(err.Error())
 		return err
 	}
-	fmt.Printf("Сохраненные данные: %s\n", data)
+	for _, dataBlock := range data {
+		if err = printRecord(log, dataBlock); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func delete(ctx context.Context, log *logrus.Logger,
 	service Service, jwtToken string) error {
-	var keyWord string
-	fmt.Println("Введите ключ для однозначной идентификации данных")
-	_, err := fmt.Scanln(&keyWord)
+	keyWord, err := prompt("Введите ключ для однозначной идентификации данных")
 	if err != nil {
 		log.Error(err.Error())
 		return err
 	}
+	confirmed, err := confirm(fmt.Sprintf("Переместить запись %s в корзину? (y/n)", keyWord))
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if !confirmed {
+		return nil
+	}
 	err = service.Delete(ctx, jwtToken, keyWord)
 	if err != nil {
+		if offlineError(err) {
+			return nil
+		}
 		return err
 	}
-	fmt.Println("Запись удалена")
+	fmt.Println("Запись перемещена в корзину, вернуть ее можно командой restore")
 	return nil
 }
 
 func change(ctx context.Context, log *logrus.Logger,
 	service Service, jwtToken string) error {
 	var data model.DataBlock
-	fmt.Println("Введите ключ для однозначной идентификации данных")
-	_, err := fmt.Scanln(&data.DataKeyWord)
+	var err error
+	data.DataKeyWord, err = prompt("Введите ключ для однозначной идентификации данных")
 	if err != nil {
 		log.Error(err.Error())
 		return err
 	}
-	fmt.Println("Введите данные для изменения")
-	_, err = fmt.Scanln(&data.Data)
+	// запоминаем версию записи, чтобы не затереть изменения с другого устройства
+	current, err := service.Get(ctx, jwtToken, data.DataKeyWord)
 	if err != nil {
+		if errors.Is(err, model.ErrVaultLocked) || errors.Is(err, model.ErrWrongMasterPassword) {
+			fmt.Println(err.Error())
+			return nil
+		}
+		if e, ok := status.FromError(err); ok && e.Code() == codes.NotFound {
+			fmt.Println(statusMessage(e))
+			return nil
+		}
+		if offlineError(err) {
+			return nil
+		}
 		log.Error(err.Error())
 		return err
 	}
-	fmt.Println(`Введите дополнительные метаданные (не рекомендуется вводить чувствительную информацию),` +
-		`если необходимо`)
-	_, err = fmt.Scan(&data.MetaData)
+	if current[0].DataType == model.DataTypeFile {
+		fmt.Println(model.ErrFileReadOnly.Error())
+		return nil
+	}
+	data.Version = current[0].Version
+	record, err := readRecord(log)
+	if err != nil {
+		if errors.Is(err, model.ErrBigFile) {
+			fmt.Println("Файл слишком большой для записи, загрузите его командой upload")
+			return nil
+		}
+		if errors.Is(err, model.ErrInvalidPayload) {
+			fmt.Println(err.Error())
+			return nil
+		}
+		return err
+	}
+	data.Data, data.Payload = record.Data, record.Payload
+	data.MetaData, err = readMetaData()
 	if err != nil {
 		log.Error(err.Error())
 		return err
 	}
-	fmt.Printf("Вы ввели %s", data)
-
 	err = service.Change(ctx, jwtToken, data)
 	if err != nil {
+		if errors.Is(err, model.ErrVersionMismatch) {
+			fmt.Println(err.Error() + ". Получите запись командой get и повторите изменение")
+			return nil
+		}
+		if errors.Is(err, model.ErrFileReadOnly) {
+			fmt.Println(err.Error())
+			return nil
+		}
+		if e, ok := status.FromError(err); ok && e.Code() == codes.NotFound {
+			fmt.Println(statusMessage(e))
+			return nil
+		}
+		if invalidRecord(err) || offlineError(err) {
+			return nil
+		}
 		return err
 	}
 	fmt.Println("Данные успешно изменены")
 	return nil
 }
+
+// invalidRecord выводит пользователю ошибку проверки записи
+// на клиенте или на сервере
+func invalidRecord(err error) bool {
+	if errors.Is(err, model.ErrInvalidPayload) {
+		fmt.Println(err.Error())
+		return true
+	}
+	if e, ok := status.FromError(err); ok &&
+		(e.Code() == codes.InvalidArgument || e.Code() == codes.ResourceExhausted) {
+		fmt.Println(statusMessage(e))
+		return true
+	}
+	return false
+}
+
+// offlineError выводит пользователю сообщение о работе без связи с сервером
+func offlineError(err error) bool {
+	if errors.Is(err, model.ErrSavedOffline) || errors.Is(err, model.ErrCacheLocked) {
+		fmt.Println(err.Error())
+		return true
+	}
+	if e, ok := status.FromError(err); ok && e.Code() == codes.Unavailable {
+		fmt.Println(model.ErrOffline.Error())
+		return true
+	}
+	return false
+}
+
+// sync отправляет изменения, сделанные без связи с сервером,
+// и обновляет локальную копию
+func sync(ctx context.Context, log *logrus.Logger, service Service, jwtToken string) error {
+	stats, err := service.Sync(ctx, jwtToken)
+	if err != nil {
+		if offlineError(err) {
+			return nil
+		}
+		log.Error(err.Error())
+		return err
+	}
+	fmt.Printf("Отправлено изменений: %d, получено с сервера: %d\n", stats.Pushed, stats.Pulled)
+	for _, failed := range stats.Failed {
+		fmt.Println("Изменение не принято сервером: " + failed)
+	}
+	if stats.Conflicts > 0 {
+		fmt.Printf("Изменений с конфликтами: %d, разрешите их командой conflicts\n", stats.Conflicts)
+	}
+	return nil
+}
@@ -13,6 +13,8 @@ import (
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestApiRegister(t *testing.T) {
@@ -28,7 +30,6 @@ func TestApiRegister(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Регистрация",
 			args: args{
@@ -89,6 +90,8 @@ func TestApiAuth(t *testing.T) {
 		service  *mocks.Service
 		login    string
 		password string
+		// code - код второго фактора, если он включен
+		code string
 	}
 	tests := []struct {
 		name    string
@@ -96,7 +99,6 @@ func TestApiAuth(t *testing.T) {
 		want    string
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Аутентификация",
 			args: args{
@@ -108,6 +110,18 @@ func TestApiAuth(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Аутентификация со вторым фактором",
+			args: args{
+				ctx:      context.Background(),
+				log:      logger.InitLog(logrus.InfoLevel),
+				service:  new(mocks.Service),
+				login:    "testlogin",
+				password: "testpassword",
+				code:     "123456",
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -133,10 +147,21 @@ func TestApiAuth(t *testing.T) {
 				assert.NoError(t, err)
 				_, err = fmt.Fprintln(wMock, tt.args.password)
 				assert.NoError(t, err)
+				if tt.args.code != "" {
+					_, err = fmt.Fprintln(wMock, tt.args.code)
+					assert.NoError(t, err)
+				}
 			}()
 
-			tt.args.service.On("Auth", tt.args.ctx,
-				tt.args.login, tt.args.password).Return("token", nil)
+			if tt.args.code != "" {
+				tt.args.service.On("Auth", tt.args.ctx,
+					tt.args.login, tt.args.password).Return("", model.ErrSecondFactorRequired)
+				tt.args.service.On("CompleteSecondFactor", tt.args.ctx,
+					tt.args.code).Return("token", nil)
+			} else {
+				tt.args.service.On("Auth", tt.args.ctx,
+					tt.args.login, tt.args.password).Return("token", nil)
+			}
 
 			got, err := auth(tt.args.ctx, tt.args.log, tt.args.service)
 			if (err != nil) != tt.wantErr {
@@ -150,30 +175,106 @@ func TestApiAuth(t *testing.T) {
 
 func TestApiAdd(t *testing.T) {
 	type args struct {
-		ctx         context.Context
-		log         *logrus.Logger
-		service     *mocks.Service
-		jwtToken    string
-		dataKeyWord string
-		data        string
-		metadata    string
+		ctx      context.Context
+		log      *logrus.Logger
+		service  *mocks.Service
+		jwtToken string
+		// input - строки, которые вводит пользователь
+		input []string
 	}
 	tests := []struct {
 		name    string
 		args    args
+		want    model.DataBlock
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Добавление данных",
 			args: args{
-				ctx:         context.Background(),
-				log:         logger.InitLog(logrus.InfoLevel),
-				service:     new(mocks.Service),
-				jwtToken:    "token",
-				dataKeyWord: "testkey",
-				data:        "testdata",
-				metadata:    "testmetadata",
+				ctx:      context.Background(),
+				log:      logger.InitLog(logrus.InfoLevel),
+				service:  new(mocks.Service),
+				jwtToken: "token",
+				input:    []string{"", "testdata", "testkey", "testmetadata"},
+			},
+			want: model.DataBlock{
+				DataKeyWord: "testkey",
+				Data:        "testdata",
+				MetaData:    "testmetadata",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Добавление банковской карты",
+			args: args{
+				ctx:      context.Background(),
+				log:      logger.InitLog(logrus.InfoLevel),
+				service:  new(mocks.Service),
+				jwtToken: "token",
+				input: []string{"card", "4111 1111 1111 1111", "12/30", "IVAN IVANOV", "123",
+					"card", "testmetadata"},
+			},
+			want: model.DataBlock{
+				DataKeyWord: "card",
+				MetaData:    "testmetadata",
+				Payload: &model.Payload{BankCard: &model.BankCard{
+					Number: "4111111111111111",
+					Expiry: "12/30",
+					Holder: "IVAN IVANOV",
+					CVV:    "123",
+				}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Добавление многострочной заметки",
+			args: args{
+				ctx:      context.Background(),
+				log:      logger.InitLog(logrus.InfoLevel),
+				service:  new(mocks.Service),
+				jwtToken: "token",
+				input:    []string{"text", "первая строка", "", "третья строка", ".", "note", "личная заметка"},
+			},
+			want: model.DataBlock{
+				DataKeyWord: "note",
+				MetaData:    "личная заметка",
+				Payload: &model.Payload{Text: &model.TextNote{
+					Text: "первая строка\n\nтретья строка",
+				}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Ключ с пробелами",
+			args: args{
+				ctx:      context.Background(),
+				log:      logger.InitLog(logrus.InfoLevel),
+				service:  new(mocks.Service),
+				jwtToken: "token",
+				input:    []string{"", "testdata", "рабочая почта", "testmetadata"},
+			},
+			want: model.DataBlock{
+				DataKeyWord: "рабочая почта",
+				Data:        "testdata",
+				MetaData:    "testmetadata",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Добавление пароля с пробелами",
+			args: args{
+				ctx:      context.Background(),
+				log:      logger.InitLog(logrus.InfoLevel),
+				service:  new(mocks.Service),
+				jwtToken: "token",
+				input:    []string{"credentials", "user", "correct horse battery", "", "mail", ""},
+			},
+			want: model.DataBlock{
+				DataKeyWord: "mail",
+				Payload: &model.Payload{Credentials: &model.Credentials{
+					Login:    "user",
+					Password: "correct horse battery",
+				}},
 			},
 			wantErr: false,
 		},
@@ -198,24 +299,138 @@ func TestApiAdd(t *testing.T) {
 			wMock := io.MultiWriter(w, io.Writer(&outputBuf))
 
 			go func() {
-				_, err := fmt.Fprintln(wMock, tt.args.data)
-				assert.NoError(t, err)
-				_, err = fmt.Fprintln(wMock, tt.args.dataKeyWord)
-				assert.NoError(t, err)
-				_, err = fmt.Fprintln(wMock, tt.args.metadata)
-				assert.NoError(t, err)
+				for _, line := range tt.args.input {
+					_, err := fmt.Fprintln(wMock, line)
+					assert.NoError(t, err)
+				}
 			}()
 
-			data := model.DataBlock{
-				DataKeyWord: tt.args.dataKeyWord,
-				Data:        tt.args.data,
-				MetaData:    tt.args.metadata,
-			}
-			tt.args.service.On("Add", tt.args.ctx, tt.args.jwtToken, data).Return(nil)
+			tt.args.service.On("Add", tt.args.ctx, tt.args.jwtToken, tt.want).Return(nil)
 
 			if err := add(tt.args.ctx, tt.args.log, tt.args.service, tt.args.jwtToken); (err != nil) != tt.wantErr {
 				t.Errorf("add() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			tt.args.service.AssertExpectations(t)
+		})
+	}
+}
+
+func TestApiMergeMetaData(t *testing.T) {
+	tests := []struct {
+		name   string
+		theirs string
+		mine   string
+		want   string
+	}{
+		{name: "Одинаковые метаданные", theirs: "банк", mine: "банк", want: "банк"},
+		{name: "Метаданные только на сервере", theirs: "банк", mine: "", want: "банк"},
+		{name: "Метаданные только у клиента", theirs: "", mine: "почта", want: "почта"},
+		{name: "Разные метаданные", theirs: "банк", mine: "почта", want: "банк; почта"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, mergeMetaData(tt.theirs, tt.mine))
+		})
+	}
+}
+
+func TestApiConflicts(t *testing.T) {
+	conflict := model.Conflict{
+		ID:          1,
+		DataKeyWord: "key",
+		BaseVersion: 2,
+		Base:        &model.DataBlock{DataKeyWord: "key", Data: "base", Version: 2},
+		Mine:        model.DataBlock{DataKeyWord: "key", Data: "mine", MetaData: "почта"},
+		Theirs: &model.DataBlock{DataKeyWord: "key", Data: "theirs", MetaData: "банк",
+			Version: 3},
+	}
+	tests := []struct {
+		name string
+		// input - строки, которые вводит пользователь
+		input []string
+		// want - выбор, отправленный на сервер, nil - конфликт пропущен
+		want       *model.ConflictResolution
+		resolveErr error
+		wantErr    bool
+	}{
+		{
+			name:  "Оставить свое изменение",
+			input: []string{"mine"},
+			want: &model.ConflictResolution{ConflictID: 1, Strategy: model.ResolveKeepMine,
+				ExpectedVersion: 3},
+		},
+		{
+			name:  "Сохранить обе версии под предложенным ключом",
+			input: []string{"both", ""},
+			want: &model.ConflictResolution{ConflictID: 1, Strategy: model.ResolveKeepBoth,
+				ExpectedVersion: 3, RenamedKey: "key-conflict-1"},
+		},
+		{
+			name:  "Сохранить обе версии под ключом с пробелами",
+			input: []string{"both", "мой ключ"},
+			want: &model.ConflictResolution{ConflictID: 1, Strategy: model.ResolveKeepBoth,
+				ExpectedVersion: 3, RenamedKey: "мой ключ"},
+		},
+		{
+			name:  "Объединить версии",
+			input: []string{"merge", "mine", ""},
+			want: &model.ConflictResolution{ConflictID: 1, Strategy: model.ResolveMerge,
+				ExpectedVersion: 3, Merged: model.DataBlock{DataKeyWord: "key", Data: "mine",
+					MetaData: "банк; почта"}},
+		},
+		{
+			name:  "Пропустить конфликт",
+			input: []string{""},
+		},
+		{
+			name:  "Запись на сервере снова изменилась",
+			input: []string{"theirs"},
+			want: &model.ConflictResolution{ConflictID: 1, Strategy: model.ResolveKeepTheirs,
+				ExpectedVersion: 3},
+			resolveErr: &model.VersionMismatchError{Current: 4},
+		},
+		{
+			name:  "Новый ключ занят",
+			input: []string{"both", "taken"},
+			want: &model.ConflictResolution{ConflictID: 1, Strategy: model.ResolveKeepBoth,
+				ExpectedVersion: 3, RenamedKey: "taken"},
+			resolveErr: status.Error(codes.AlreadyExists, model.ErrDataKeyWordExists.Error()),
+		},
+		{
+			name:  "Ошибка сервера",
+			input: []string{"mine"},
+			want: &model.ConflictResolution{ConflictID: 1, Strategy: model.ResolveKeepMine,
+				ExpectedVersion: 3},
+			resolveErr: status.Error(codes.Internal, model.ErrInternal.Error()),
+			wantErr:    true,
+		},
+	}
+	ctx := context.Background()
+	log := logger.InitLog(logrus.InfoLevel)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			originalStdin := os.Stdin
+			r, w, _ := os.Pipe()
+			os.Stdin = r
+			defer func() {
+				os.Stdin = originalStdin
+			}()
+			go func() {
+				for _, line := range tt.input {
+					_, err := fmt.Fprintln(w, line)
+					assert.NoError(t, err)
+				}
+			}()
+
+			service := new(mocks.Service)
+			service.On("ListConflicts", ctx, "token", "").Return([]model.Conflict{conflict}, nil)
+			if tt.want != nil {
+				service.On("ResolveConflict", ctx, "token", *tt.want).Return(int64(4), tt.resolveErr)
+			}
+
+			err := conflicts(ctx, log, service, "token")
+			assert.Equal(t, tt.wantErr, err != nil)
+			service.AssertExpectations(t)
 		})
 	}
 }
@@ -0,0 +1,660 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/urfave/cli"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"keeper/internal/model"
+)
+
+// stdinPath - значение --from-file для чтения данных из стандартного ввода
+const stdinPath = "-"
+
+// authFlags - флаги входа, общие для неинтерактивных команд.
+// Секреты лучше передавать через переменные окружения или стандартный ввод,
+// чтобы они не попадали в историю команд и список процессов
+var authFlags = []cli.Flag{
+	cli.StringFlag{Name: "login", EnvVar: "KEEPER_LOGIN", Usage: "логин пользователя"},
+	cli.StringFlag{Name: "password", EnvVar: "KEEPER_PASSWORD", Usage: "пароль пользователя"},
+	cli.BoolFlag{Name: "password-stdin", Usage: "прочитать пароль из первой строки стандартного ввода"},
+	cli.StringFlag{Name: "totp", Usage: "код из приложения-аутентификатора или код восстановления"},
+	cli.StringFlag{Name: "token", EnvVar: "KEEPER_TOKEN", Usage: "jwt токен, полученный командой login"},
+	cli.StringFlag{Name: "master-password", EnvVar: "KEEPER_MASTER_PASSWORD",
+		Usage: "мастер-пароль для сквозного шифрования"},
+}
+
+var jsonFlag = cli.BoolFlag{Name: "json", Usage: "вывести результат в формате JSON"}
+
+// recordFlags - флаги содержимого записи для команд add и change
+var recordFlags = []cli.Flag{
+	cli.StringFlag{Name: "key", Usage: "ключ записи"},
+	cli.StringFlag{Name: "type", Usage: "тип записи: credentials, card, text, binary (пусто для строки)"},
+	cli.StringFlag{Name: "meta", Usage: "метаданные записи"},
+	cli.StringFlag{Name: "data", Usage: "содержимое записи"},
+	cli.StringFlag{Name: "from-file", Usage: "прочитать содержимое записи из файла, - для стандартного ввода. " +
+		"Для credentials и card файл содержит JSON"},
+}
+
+// recordJSON - запись в машиночитаемом выводе команды get
+type recordJSON struct {
+	Key      string          `json:"key"`
+	Type     string          `json:"type,omitempty"`
+	MetaData string          `json:"metadata,omitempty"`
+	Version  int64           `json:"version"`
+	Data     string          `json:"data,omitempty"`
+	Payload  *model.Payload  `json:"payload,omitempty"`
+	File     *model.FileInfo `json:"file,omitempty"`
+}
+
+// headerJSON - заголовок записи в машиночитаемом выводе команды list
+type headerJSON struct {
+	Key       string    `json:"key"`
+	Type      string    `json:"type,omitempty"`
+	MetaData  string    `json:"metadata,omitempty"`
+	Size      int64     `json:"size"`
+	Version   int64     `json:"version"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// recordField - поле записи, которое можно получить через get --field
+type recordField struct {
+	Name  string
+	Value string
+}
+
+// scriptCommands возвращает команды для запуска из скриптов. Каждая команда
+// выполняет одно действие по флагам и завершается с кодом по статусу ошибки
+func scriptCommands(ctx context.Context, log *logrus.Logger, service Service) []cli.Command {
+	before := func(c *cli.Context) error {
+		// вывод команды разбирают скрипты, поэтому журнал пишем отдельно
+		log.SetOutput(os.Stderr)
+		return nil
+	}
+	return []cli.Command{
+		{
+			Name:         "login",
+			Usage:        "Войти и сохранить сессию для следующих команд, вывести jwt токен",
+			Flags:        append(withoutFlag(authFlags, "token"), jsonFlag),
+			Before:       before,
+			OnUsageError: usageError,
+			Action: func(c *cli.Context) error {
+				jwtToken, err := signIn(ctx, c, service)
+				if err != nil {
+					return err
+				}
+				if jwtToken == model.OfflineToken {
+					return model.ErrOffline
+				}
+				if c.Bool("json") {
+					return writeJSON(map[string]string{"token": jwtToken})
+				}
+				fmt.Println(jwtToken)
+				return nil
+			},
+		},
+		{
+			Name:  "logout",
+			Usage: "Завершить сохраненную сессию",
+			Flags: append(authFlags,
+				cli.BoolFlag{Name: "all", Usage: "завершить сессии на всех устройствах"}),
+			Before:       before,
+			OnUsageError: usageError,
+			Action: func(c *cli.Context) error {
+				jwtToken, err := signIn(ctx, c, service)
+				if err != nil {
+					return err
+				}
+				if c.Bool("all") {
+					_, err = service.LogoutAll(ctx, jwtToken)
+					return err
+				}
+				return service.Logout(ctx, jwtToken)
+			},
+		},
+		{
+			Name:         "add",
+			Usage:        "Добавить запись",
+			Flags:        append(authFlags, recordFlags...),
+			Before:       before,
+			OnUsageError: usageError,
+			Action: func(c *cli.Context) error {
+				if err := requireFlags(c, "key"); err != nil {
+					return err
+				}
+				jwtToken, err := signIn(ctx, c, service)
+				if err != nil {
+					return err
+				}
+				data, err := recordFromFlags(c)
+				if err != nil {
+					return err
+				}
+				return savedOffline(service.Add(ctx, jwtToken, data))
+			},
+		},
+		{
+			Name:  "change",
+			Usage: "Изменить запись",
+			Flags: append(append(authFlags, recordFlags...),
+				cli.Int64Flag{Name: "version", Usage: "версия, которую видел клиент (по умолчанию текущая)"}),
+			Before:       before,
+			OnUsageError: usageError,
+			Action: func(c *cli.Context) error {
+				if err := requireFlags(c, "key"); err != nil {
+					return err
+				}
+				jwtToken, err := signIn(ctx, c, service)
+				if err != nil {
+					return err
+				}
+				data, err := recordFromFlags(c)
+				if err != nil {
+					return err
+				}
+				data.Version = c.Int64("version")
+				if data.Version == 0 {
+					current, err := service.Get(ctx, jwtToken, data.DataKeyWord)
+					if err != nil {
+						return err
+					}
+					if current[0].DataType == model.DataTypeFile {
+						return model.ErrFileReadOnly
+					}
+					data.Version = current[0].Version
+				}
+				return savedOffline(service.Change(ctx, jwtToken, data))
+			},
+		},
+		{
+			Name:  "get",
+			Usage: "Получить запись",
+			Flags: append(authFlags,
+				cli.StringFlag{Name: "key", Usage: "ключ записи"},
+				cli.StringFlag{Name: "field", Usage: "вывести только значение поля, например password"},
+				jsonFlag),
+			Before:       before,
+			OnUsageError: usageError,
+			Action: func(c *cli.Context) error {
+				if err := requireFlags(c, "key"); err != nil {
+					return err
+				}
+				jwtToken, err := signIn(ctx, c, service)
+				if err != nil {
+					return err
+				}
+				records, err := service.Get(ctx, jwtToken, c.String("key"))
+				if err != nil {
+					return err
+				}
+				return writeRecords(os.Stdout, records, c.String("field"), c.Bool("json"))
+			},
+		},
+		{
+			Name:  "list",
+			Usage: "Вывести список записей",
+			Flags: append(authFlags,
+				cli.StringFlag{Name: "type", Usage: "тип записей"},
+				cli.StringFlag{Name: "prefix", Usage: "начало ключа"},
+				cli.StringFlag{Name: "sort", Usage: "поле сортировки: key, type, created, updated, size, " +
+					"минус для обратного порядка"},
+				jsonFlag),
+			Before:       before,
+			OnUsageError: usageError,
+			Action: func(c *cli.Context) error {
+				jwtToken, err := signIn(ctx, c, service)
+				if err != nil {
+					return err
+				}
+				query := model.ListQuery{
+					DataType:   c.String("type"),
+					KeyPrefix:  c.String("prefix"),
+					SortBy:     strings.TrimPrefix(c.String("sort"), "-"),
+					Descending: strings.HasPrefix(c.String("sort"), "-"),
+				}
+				headers, err := listAll(ctx, service, jwtToken, query)
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					return writeJSON(headersJSON(headers))
+				}
+				printHeaders(headers)
+				return nil
+			},
+		},
+		{
+			Name:  "delete",
+			Usage: "Переместить запись в корзину",
+			Flags: append(authFlags,
+				cli.StringFlag{Name: "key", Usage: "ключ записи"},
+				cli.BoolFlag{Name: "yes", Usage: "не спрашивать подтверждение"}),
+			Before:       before,
+			OnUsageError: usageError,
+			Action: func(c *cli.Context) error {
+				if err := requireFlags(c, "key"); err != nil {
+					return err
+				}
+				jwtToken, err := signIn(ctx, c, service)
+				if err != nil {
+					return err
+				}
+				if !c.Bool("yes") {
+					confirmed, err := confirm(fmt.Sprintf("Переместить запись %s в корзину? (y/n)",
+						c.String("key")))
+					if err != nil || !confirmed {
+						return err
+					}
+				}
+				return savedOffline(service.Delete(ctx, jwtToken, c.String("key")))
+			},
+		},
+		{
+			Name:         "sync",
+			Usage:        "Отправить изменения, сделанные без связи с сервером, и обновить локальную копию",
+			Flags:        append(authFlags, jsonFlag),
+			Before:       before,
+			OnUsageError: usageError,
+			Action: func(c *cli.Context) error {
+				jwtToken, err := signIn(ctx, c, service)
+				if err != nil {
+					return err
+				}
+				stats, err := service.Sync(ctx, jwtToken)
+				if err != nil {
+					return err
+				}
+				if c.Bool("json") {
+					return writeJSON(map[string]any{
+						"pushed":    stats.Pushed,
+						"failed":    stats.Failed,
+						"pulled":    stats.Pulled,
+						"conflicts": stats.Conflicts,
+					})
+				}
+				fmt.Printf("Отправлено изменений: %d, получено записей: %d, конфликтов: %d\n",
+					stats.Pushed, stats.Pulled, stats.Conflicts)
+				return nil
+			},
+		},
+	}
+}
+
+// exitFailure - код завершения для ошибок без статуса gRPC, он не
+// пересекается с кодами статусов 0-16
+const exitFailure = 20
+
+// ExitCode возвращает код завершения клиента для ошибки команды.
+// Ошибки сервера завершают клиент с кодом статуса gRPC, ошибки клиента
+// сопоставлены с близкими по смыслу статусами, прочие ошибки - exitFailure
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	switch {
+	case errors.Is(err, context.Canceled):
+		return int(codes.Canceled)
+	case errors.Is(err, context.DeadlineExceeded):
+		return int(codes.DeadlineExceeded)
+	case errors.Is(err, model.ErrInvalidArguments), errors.Is(err, model.ErrInvalidPayload),
+		errors.Is(err, model.ErrUnknownDataType), errors.Is(err, model.ErrBigFile):
+		return int(codes.InvalidArgument)
+	case errors.Is(err, model.ErrFieldNotFound), errors.Is(err, os.ErrNotExist):
+		return int(codes.NotFound)
+	case errors.Is(err, model.ErrVersionMismatch), errors.Is(err, model.ErrFileReadOnly):
+		return int(codes.FailedPrecondition)
+	case errors.Is(err, os.ErrPermission), errors.Is(err, model.ErrInsecureKeyFile),
+		errors.Is(err, model.ErrPlaintextForbidden):
+		return int(codes.PermissionDenied)
+	case errors.Is(err, model.ErrNoAuthentification), errors.Is(err, model.ErrSecondFactorRequired),
+		errors.Is(err, model.ErrWrongCachePassword), errors.Is(err, model.ErrVaultLocked),
+		errors.Is(err, model.ErrWrongMasterPassword):
+		return int(codes.Unauthenticated)
+	case errors.Is(err, model.ErrOffline), errors.Is(err, model.ErrCacheLocked):
+		return int(codes.Unavailable)
+	}
+	if e, ok := status.FromError(err); ok {
+		return int(e.Code())
+	}
+	return exitFailure
+}
+
+// usageError помечает ошибки разбора флагов, чтобы код завершения
+// отличал их от ошибок выполнения
+func usageError(c *cli.Context, err error, isSubcommand bool) error {
+	return fmt.Errorf("%w: %w", model.ErrInvalidArguments, err)
+}
+
+// requireFlags проверяет, что обязательные флаги заданы
+func requireFlags(c *cli.Context, names ...string) error {
+	for _, name := range names {
+		if c.String(name) == "" {
+			return fmt.Errorf("%w: укажите --%s", model.ErrInvalidArguments, name)
+		}
+	}
+	return nil
+}
+
+// withoutFlag возвращает копию набора флагов без флага name
+func withoutFlag(flags []cli.Flag, name string) []cli.Flag {
+	result := make([]cli.Flag, 0, len(flags))
+	for _, flag := range flags {
+		if flag.GetName() != name {
+			result = append(result, flag)
+		}
+	}
+	return result
+}
+
+// signIn получает jwt токен по флагам команды: берет готовый токен,
+// проходит аутентификацию по логину и паролю или продолжает сохраненную
+// сессию, затем открывает хранилище
+func signIn(ctx context.Context, c *cli.Context, service Service) (string, error) {
+	login := c.String("login")
+	jwtToken := c.String("token")
+	password := c.String("password")
+	if c.Bool("password-stdin") {
+		var err error
+		if password, err = readLine(); err != nil {
+			return "", err
+		}
+	}
+	switch {
+	case jwtToken != "":
+		if login != "" {
+			service.Resume(login)
+		}
+	case password == "":
+		var err error
+		jwtToken, err = service.RestoreSession(login)
+		if errors.Is(err, model.ErrNoSession) {
+			return "", fmt.Errorf("%w: выполните login или укажите --login и пароль", model.ErrNoAuthentification)
+		}
+		if err != nil {
+			return "", err
+		}
+	default:
+		if login == "" {
+			return "", fmt.Errorf("%w: укажите --login", model.ErrInvalidArguments)
+		}
+		var err error
+		jwtToken, err = service.Auth(ctx, login, password)
+		if errors.Is(err, model.ErrSecondFactorRequired) && c.String("totp") != "" {
+			jwtToken, err = service.CompleteSecondFactor(ctx, c.String("totp"))
+		}
+		if err != nil {
+			return "", err
+		}
+		if jwtToken == model.OfflineToken {
+			fmt.Fprintln(os.Stderr, model.ErrOffline.Error())
+		}
+	}
+	// хранилище проверяется и без мастер-пароля, чтобы не отправить
+	// открытыми данные пользователя, который его уже задал
+	masterPassword := c.String("master-password")
+	err := service.UnlockVault(ctx, jwtToken, masterPassword)
+	if masterPassword == "" && (errors.Is(err, model.ErrVaultLocked) ||
+		errors.Is(err, model.ErrNoAuthentification)) {
+		return jwtToken, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return jwtToken, nil
+}
+
+// savedOffline считает изменение, сохраненное в локальной копии, успешным
+// и сообщает об этом в поток ошибок
+func savedOffline(err error) error {
+	if errors.Is(err, model.ErrSavedOffline) {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return nil
+	}
+	return err
+}
+
+// recordFromFlags собирает запись из флагов add и change
+func recordFromFlags(c *cli.Context) (model.DataBlock, error) {
+	content := []byte(c.String("data"))
+	fileName := ""
+	if path := c.String("from-file"); path != "" {
+		if c.String("data") != "" {
+			return model.DataBlock{}, fmt.Errorf("%w: укажите --data или --from-file",
+				model.ErrInvalidArguments)
+		}
+		var err error
+		if content, err = readInput(path); err != nil {
+			return model.DataBlock{}, err
+		}
+		if path != stdinPath {
+			fileName = filepath.Base(path)
+		}
+	}
+	data, err := buildRecord(c.String("type"), content, fileName)
+	if err != nil {
+		return data, err
+	}
+	data.DataKeyWord = c.String("key")
+	data.MetaData = c.String("meta")
+	return data, nil
+}
+
+// readInput читает содержимое записи из файла или стандартного ввода
+func readInput(path string) ([]byte, error) {
+	reader := io.Reader(os.Stdin)
+	if path != stdinPath {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+	content, err := io.ReadAll(io.LimitReader(reader, model.MaxBinarySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > model.MaxBinarySize {
+		return nil, fmt.Errorf("%w: %w", model.ErrInvalidPayload, model.ErrBigFile)
+	}
+	return content, nil
+}
+
+// buildRecord разбирает содержимое записи типа dataType. Логин с паролем
+// и банковская карта передаются в JSON, текст и строка - как есть
+func buildRecord(dataType string, content []byte, fileName string) (model.DataBlock, error) {
+	var data model.DataBlock
+	var payload model.Payload
+	text := strings.TrimSuffix(strings.TrimSuffix(string(content), "\n"), "\r")
+	switch dataType {
+	case "":
+		data.Data = text
+		return data, nil
+	case model.DataTypeCredentials:
+		payload.Credentials = &model.Credentials{}
+		if err := json.Unmarshal(content, payload.Credentials); err != nil {
+			return data, fmt.Errorf("%w: %w", model.ErrInvalidPayload, err)
+		}
+	case model.DataTypeBankCard:
+		payload.BankCard = &model.BankCard{}
+		if err := json.Unmarshal(content, payload.BankCard); err != nil {
+			return data, fmt.Errorf("%w: %w", model.ErrInvalidPayload, err)
+		}
+		payload.BankCard.Number = model.NormalizeCardNumber(payload.BankCard.Number)
+	case model.DataTypeText:
+		payload.Text = &model.TextNote{Text: text}
+	case model.DataTypeBinary:
+		payload.Binary = &model.BinaryData{Data: content, FileName: fileName}
+	default:
+		return data, fmt.Errorf("%w: %w", model.ErrInvalidPayload, model.ErrUnknownDataType)
+	}
+	data.Payload = &payload
+	return data, nil
+}
+
+// listAll получает все страницы списка записей
+func listAll(ctx context.Context, service Service, jwtToken string,
+	query model.ListQuery) ([]model.DataHeader, error) {
+	var headers []model.DataHeader
+	for {
+		page, nextPageToken, err := service.ListData(ctx, jwtToken, query)
+		if err != nil {
+			return nil, err
+		}
+		headers = append(headers, page...)
+		if nextPageToken == "" {
+			return headers, nil
+		}
+		query.PageToken = nextPageToken
+	}
+}
+
+// recordFields возвращает поля записи в порядке вывода
+func recordFields(data model.DataBlock) []recordField {
+	fields := []recordField{{Name: "key", Value: data.DataKeyWord}}
+	if data.DataType != "" {
+		fields = append(fields, recordField{Name: "type", Value: data.DataType})
+	}
+	if data.MetaData != "" {
+		fields = append(fields, recordField{Name: "metadata", Value: data.MetaData})
+	}
+	fields = append(fields, recordField{Name: "version", Value: strconv.FormatInt(data.Version, 10)})
+	add := func(name, value string) {
+		if value != "" {
+			fields = append(fields, recordField{Name: name, Value: value})
+		}
+	}
+
+	switch {
+	case data.DataType == model.DataTypeFile:
+		var info model.FileInfo
+		if err := json.Unmarshal([]byte(data.Data), &info); err == nil {
+			add("filename", info.FileName)
+			add("size", strconv.FormatInt(info.Size, 10))
+		}
+	case data.Payload == nil:
+		add("data", data.Data)
+	case data.Payload.Credentials != nil:
+		add("login", data.Payload.Credentials.Login)
+		add("password", data.Payload.Credentials.Password)
+		add("url", data.Payload.Credentials.URL)
+	case data.Payload.BankCard != nil:
+		add("number", data.Payload.BankCard.Number)
+		add("expiry", data.Payload.BankCard.Expiry)
+		add("holder", data.Payload.BankCard.Holder)
+		add("cvv", data.Payload.BankCard.CVV)
+	case data.Payload.Text != nil:
+		add("text", data.Payload.Text.Text)
+	case data.Payload.Binary != nil:
+		add("filename", data.Payload.Binary.FileName)
+		add("data", string(data.Payload.Binary.Data))
+	}
+	return fields
+}
+
+// writeRecords выводит записи целиком, в JSON или только значение поля field.
+// Значение поля выводится без изменений, чтобы его можно было передать
+// другой программе
+func writeRecords(out io.Writer, records []model.DataBlock, field string, asJSON bool) error {
+	if field != "" {
+		for _, data := range records {
+			value, ok := "", false
+			for _, f := range recordFields(data) {
+				if f.Name == field {
+					value, ok = f.Value, true
+				}
+			}
+			if !ok {
+				return fmt.Errorf("%w: %s", model.ErrFieldNotFound, field)
+			}
+			fmt.Fprint(out, value)
+			// бинарные данные выводим без перевода строки, чтобы не испортить файл
+			if data.Payload == nil || data.Payload.Binary == nil {
+				fmt.Fprintln(out)
+			}
+		}
+		return nil
+	}
+	if asJSON {
+		result := make([]recordJSON, 0, len(records))
+		for _, data := range records {
+			result = append(result, toRecordJSON(data))
+		}
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	for i, data := range records {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		for _, f := range recordFields(data) {
+			if f.Name == "data" && data.Payload != nil && data.Payload.Binary != nil {
+				fmt.Fprintf(out, "%s: %d байт, выведите их с --field data\n", f.Name,
+					len(data.Payload.Binary.Data))
+				continue
+			}
+			fmt.Fprintf(out, "%s: %s\n", f.Name, f.Value)
+		}
+	}
+	return nil
+}
+
+// toRecordJSON преобразует запись для машиночитаемого вывода
+func toRecordJSON(data model.DataBlock) recordJSON {
+	record := recordJSON{
+		Key:      data.DataKeyWord,
+		Type:     data.DataType,
+		MetaData: data.MetaData,
+		Version:  data.Version,
+		Payload:  data.Payload,
+	}
+	if data.DataType == model.DataTypeFile {
+		var info model.FileInfo
+		if err := json.Unmarshal([]byte(data.Data), &info); err == nil {
+			record.File = &info
+		}
+		return record
+	}
+	if data.Payload == nil {
+		record.Data = data.Data
+	}
+	return record
+}
+
+// headersJSON преобразует заголовки записей для машиночитаемого вывода
+func headersJSON(headers []model.DataHeader) []headerJSON {
+	result := make([]headerJSON, 0, len(headers))
+	for _, header := range headers {
+		result = append(result, headerJSON{
+			Key:       header.DataKeyWord,
+			Type:      header.DataType,
+			MetaData:  header.MetaData,
+			Size:      header.Size,
+			Version:   header.Version,
+			CreatedAt: header.CreatedAt,
+			UpdatedAt: header.UpdatedAt,
+		})
+	}
+	return result
+}
+
+// writeJSON выводит значение в формате JSON
+func writeJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,195 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"keeper/internal/model"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestApiExitCode(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "Без ошибки", err: nil, want: 0},
+		{name: "Статус сервера", err: status.Error(codes.NotFound, "Запись не найдена"), want: 5},
+		{name: "Неверные аргументы", err: fmt.Errorf("%w: укажите --key", model.ErrInvalidArguments), want: 3},
+		{name: "Конфликт версий", err: model.ErrVersionMismatch, want: 9},
+		{name: "Хранилище закрыто", err: model.ErrVaultLocked, want: 16},
+		{name: "Сервер недоступен", err: model.ErrOffline, want: 14},
+		{name: "Отмена команды", err: fmt.Errorf("синхронизация: %w", context.Canceled), want: 1},
+		{name: "Нет доступа к файлу", err: &fs.PathError{Op: "open", Path: "session", Err: fs.ErrPermission}, want: 7},
+		{name: "Открытые данные запрещены", err: status.Error(codes.PermissionDenied, "Нужен мастер-пароль"), want: 7},
+		{name: "Прочая ошибка", err: errors.New("ошибка"), want: 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ExitCode(tt.err))
+		})
+	}
+}
+
+func TestApiErrorMessage(t *testing.T) {
+	badRequest, err := status.New(codes.InvalidArgument, "Некорректный номер карты").WithDetails(
+		&errdetails.BadRequest{FieldViolations: []*errdetails.BadRequest_FieldViolation{
+			{Field: "payload.bankCard.number", Description: "Некорректный номер карты"},
+		}})
+	require.NoError(t, err)
+	tooLarge, err := status.New(codes.ResourceExhausted, "Слишком большой файл").WithDetails(
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{
+			{Subject: "info.size", Description: "Слишком большой файл"},
+		}})
+	require.NoError(t, err)
+	busy, err := status.New(codes.Unavailable, "Сервер занят").WithDetails(
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Second)})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{name: "Ошибка клиента", err: model.ErrVaultLocked, want: model.ErrVaultLocked.Error()},
+		{name: "Статус без подробностей", err: status.Error(codes.NotFound, "Запись не найдена"),
+			want: "Запись не найдена"},
+		{name: "Некорректное поле", err: badRequest.Err(),
+			want: "Некорректный номер карты (поле payload.bankCard.number)"},
+		{name: "Превышено ограничение", err: tooLarge.Err(),
+			want: "Слишком большой файл (поле info.size)"},
+		{name: "Повтор запроса", err: busy.Err(), want: "Сервер занят, повторите запрос через 1s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ErrorMessage(tt.err))
+		})
+	}
+}
+
+func TestApiBuildRecord(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataType string
+		content  string
+		want     model.DataBlock
+		wantErr  error
+	}{
+		{
+			name:    "Строка",
+			content: "данные\n",
+			want:    model.DataBlock{Data: "данные"},
+		},
+		{
+			name:     "Банковская карта из JSON",
+			dataType: model.DataTypeBankCard,
+			content:  `{"number": "4111 1111 1111 1111", "expiry": "12/30"}`,
+			want: model.DataBlock{Payload: &model.Payload{
+				BankCard: &model.BankCard{Number: "4111111111111111", Expiry: "12/30"},
+			}},
+		},
+		{
+			name:     "Текст",
+			dataType: model.DataTypeText,
+			content:  "заметка\n",
+			want:     model.DataBlock{Payload: &model.Payload{Text: &model.TextNote{Text: "заметка"}}},
+		},
+		{
+			name:     "Некорректный JSON",
+			dataType: model.DataTypeCredentials,
+			content:  "логин",
+			wantErr:  model.ErrInvalidPayload,
+		},
+		{
+			name:     "Неизвестный тип",
+			dataType: "photo",
+			content:  "данные",
+			wantErr:  model.ErrUnknownDataType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := buildRecord(tt.dataType, []byte(tt.content), "")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestApiWriteRecords(t *testing.T) {
+	credentials := model.DataBlock{
+		DataKeyWord: "почта",
+		DataType:    model.DataTypeCredentials,
+		Version:     2,
+		Payload: &model.Payload{Credentials: &model.Credentials{
+			Login: "user", Password: "secret pass",
+		}},
+	}
+	tests := []struct {
+		name    string
+		field   string
+		asJSON  bool
+		want    string
+		wantErr error
+	}{
+		{
+			name:  "Значение поля",
+			field: "password",
+			want:  "secret pass\n",
+		},
+		{
+			name:    "Нет такого поля",
+			field:   "cvv",
+			wantErr: model.ErrFieldNotFound,
+		},
+		{
+			name:   "JSON",
+			asJSON: true,
+			want: `[
+  {
+    "key": "почта",
+    "type": "credentials",
+    "version": 2,
+    "payload": {
+      "credentials": {
+        "login": "user",
+        "password": "secret pass"
+      }
+    }
+  }
+]
+`,
+		},
+		{
+			name: "Поля записи",
+			want: "key: почта\ntype: credentials\nversion: 2\nlogin: user\npassword: secret pass\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out strings.Builder
+			err := writeRecords(&out, []model.DataBlock{credentials}, tt.field, tt.asJSON)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, out.String())
+		})
+	}
+}
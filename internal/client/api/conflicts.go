@@ -0,0 +1,174 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"keeper/internal/model"
+)
+
+// conflicts выводит конфликты изменений записей и предлагает выбрать,
+// какую версию оставить
+func conflicts(ctx context.Context, log *logrus.Logger,
+	service Service, jwtToken string) error {
+	list, err := service.ListConflicts(ctx, jwtToken, "")
+	if err != nil {
+		if errors.Is(err, model.ErrVaultLocked) || errors.Is(err, model.ErrWrongMasterPassword) {
+			fmt.Println(err.Error())
+			return nil
+		}
+		if offlineError(err) {
+			return nil
+		}
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Println("Конфликтов нет")
+		return nil
+	}
+
+	for _, conflict := range list {
+		if err = printConflict(log, conflict); err != nil {
+			return err
+		}
+		resolution, err := readResolution(conflict)
+		if err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		if resolution == nil {
+			fmt.Println("Конфликт пропущен")
+			continue
+		}
+		if _, err = service.ResolveConflict(ctx, jwtToken, *resolution); err != nil {
+			if errors.Is(err, model.ErrVersionMismatch) {
+				fmt.Println(err.Error() + ". Запустите conflicts снова, чтобы увидеть новую версию")
+				continue
+			}
+			if e, ok := status.FromError(err); ok {
+				switch e.Code() {
+				case codes.InvalidArgument, codes.NotFound, codes.AlreadyExists:
+					fmt.Println(statusMessage(e))
+					continue
+				}
+			}
+			if invalidRecord(err) || offlineError(err) {
+				return nil
+			}
+			return err
+		}
+		fmt.Println("Конфликт разрешен")
+	}
+	return nil
+}
+
+// printConflict выводит три версии записи: исходную, измененную
+// пользователем и текущую на сервере
+func printConflict(log *logrus.Logger, conflict model.Conflict) error {
+	fmt.Printf("Конфликт %d, запись %s, изменение от %s\n", conflict.ID, conflict.DataKeyWord,
+		conflict.CreatedAt.Local().Format(time.DateTime))
+	versions := []struct {
+		title   string
+		record  *model.DataBlock
+		missing string
+	}{
+		{"Исходная версия (base)", conflict.Base, "запись создана без связи с сервером"},
+		{"Мое изменение (mine)", &conflict.Mine, "запись удалена"},
+		{"На сервере (theirs)", conflict.Theirs, "запись удалена"},
+	}
+	if conflict.MineDeleted {
+		versions[1].record = nil
+	}
+	for _, version := range versions {
+		fmt.Printf("--- %s\n", version.title)
+		if version.record == nil {
+			fmt.Println(version.missing)
+			continue
+		}
+		if err := printRecord(log, *version.record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readResolution запрашивает у пользователя способ разрешения конфликта.
+// Возвращает nil, если пользователь пропустил конфликт
+func readResolution(conflict model.Conflict) (*model.ConflictResolution, error) {
+	strategy, err := prompt("Выберите: mine - оставить мое изменение, theirs - оставить версию " +
+		"на сервере, merge - объединить, both - сохранить обе версии (оставьте пустым, " +
+		"чтобы пропустить)")
+	if err != nil {
+		return nil, err
+	}
+	resolution := &model.ConflictResolution{
+		ConflictID: conflict.ID,
+		Strategy:   strings.TrimSpace(strategy),
+	}
+	if conflict.Theirs != nil {
+		resolution.ExpectedVersion = conflict.Theirs.Version
+	}
+
+	switch resolution.Strategy {
+	case "":
+		return nil, nil
+	case model.ResolveMerge:
+		if conflict.MineDeleted || conflict.Theirs == nil {
+			fmt.Println("Объединить можно только две существующие версии записи")
+			return nil, nil
+		}
+		content, err := prompt("Чье содержимое оставить в объединенной записи? (mine/theirs)")
+		if err != nil {
+			return nil, err
+		}
+		merged := *conflict.Theirs
+		if strings.TrimSpace(content) == model.ResolveKeepMine {
+			merged = conflict.Mine
+		}
+		metaData := mergeMetaData(conflict.Theirs.MetaData, conflict.Mine.MetaData)
+		edited, err := prompt(fmt.Sprintf("Метаданные объединенной записи: %s. Введите другие "+
+			"или оставьте пустым, чтобы согласиться", metaData))
+		if err != nil {
+			return nil, err
+		}
+		if edited = strings.TrimSpace(edited); edited != "" {
+			metaData = edited
+		}
+		resolution.Merged = model.DataBlock{
+			DataKeyWord: conflict.DataKeyWord,
+			Data:        merged.Data,
+			Payload:     merged.Payload,
+			MetaData:    metaData,
+		}
+	case model.ResolveKeepBoth:
+		renamedKey := fmt.Sprintf("%s-conflict-%d", conflict.DataKeyWord, conflict.ID)
+		key, err := prompt(fmt.Sprintf("Введите ключ для моего изменения (оставьте пустым для %s)",
+			renamedKey))
+		if err != nil {
+			return nil, err
+		}
+		if key = strings.TrimSpace(key); key != "" {
+			renamedKey = key
+		}
+		resolution.RenamedKey = renamedKey
+	}
+	return resolution, nil
+}
+
+// mergeMetaData объединяет метаданные двух версий записи без повторов
+func mergeMetaData(theirs string, mine string) string {
+	switch {
+	case mine == "" || mine == theirs:
+		return theirs
+	case theirs == "":
+		return mine
+	}
+	return theirs + "; " + mine
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorMessage возвращает текст ошибки команды для пользователя.
+// Для ошибок сервера к тексту добавляются подробности из статуса gRPC
+func ErrorMessage(err error) string {
+	if e, ok := status.FromError(err); ok {
+		return statusMessage(e)
+	}
+	return err.Error()
+}
+
+// statusMessage возвращает текст статуса сервера вместе с полями запроса,
+// которые сервер отклонил или которые превысили ограничение, и временем,
+// через которое запрос можно повторить
+func statusMessage(e *status.Status) string {
+	var fields []string
+	var hint string
+	for _, detail := range e.Details() {
+		switch detail := detail.(type) {
+		case *errdetails.BadRequest:
+			for _, violation := range detail.GetFieldViolations() {
+				fields = append(fields, violation.GetField())
+			}
+		case *errdetails.QuotaFailure:
+			for _, violation := range detail.GetViolations() {
+				fields = append(fields, violation.GetSubject())
+			}
+		case *errdetails.RetryInfo:
+			hint = fmt.Sprintf("повторите запрос через %s",
+				detail.GetRetryDelay().AsDuration())
+		}
+	}
+
+	message := e.Message()
+	if len(fields) > 0 {
+		message += fmt.Sprintf(" (поле %s)", strings.Join(fields, ", "))
+	}
+	if hint != "" {
+		message += ", " + hint
+	}
+	return message
+}
@@ -0,0 +1,91 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"keeper/internal/model"
+)
+
+// upload загружает файл на сервер по частям
+func upload(ctx context.Context, log *logrus.Logger,
+	service Service, jwtToken string) error {
+	path, err := prompt("Введите путь к файлу")
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	keyWord, err := prompt("Введите ключ для однозначной идентификации данных")
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	metaData, err := prompt(`Введите дополнительные метаданные (не рекомендуется вводить ` +
+		`чувствительную информацию), если необходимо`)
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	info, err := service.UploadFile(ctx, jwtToken, path, keyWord, metaData)
+	if err != nil {
+		if fileError(err) {
+			return nil
+		}
+		return err
+	}
+	fmt.Printf("Файл %s загружен, %d байт\n", info.FileName, info.Size)
+	return nil
+}
+
+// download скачивает файл с сервера и сохраняет его на диск
+func download(ctx context.Context, log *logrus.Logger,
+	service Service, jwtToken string) error {
+	keyWord, err := prompt("Введите ключ для однозначной идентификации данных")
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	path, err := prompt("Введите путь для сохранения файла")
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	info, err := service.DownloadFile(ctx, jwtToken, keyWord, path)
+	if err != nil {
+		if fileError(err) {
+			return nil
+		}
+		return err
+	}
+	fmt.Printf("Файл %s сохранен в %s\n", info.FileName, path)
+	return nil
+}
+
+// fileError выводит пользователю ошибку загрузки или скачивания файла,
+// после которой можно продолжить работу
+func fileError(err error) bool {
+	if errors.Is(err, model.ErrBigFile) || errors.Is(err, model.ErrChecksumMismatch) ||
+		errors.Is(err, model.ErrVaultLocked) || errors.Is(err, model.ErrWrongMasterPassword) {
+		fmt.Println(err.Error())
+		return true
+	}
+	if e, ok := status.FromError(err); ok {
+		switch e.Code() {
+		case codes.InvalidArgument, codes.AlreadyExists, codes.NotFound,
+			codes.FailedPrecondition, codes.DataLoss, codes.ResourceExhausted:
+			fmt.Println(statusMessage(e))
+			return true
+		case codes.Unavailable:
+			fmt.Println("Сервер недоступен, файлы загружаются и скачиваются только при связи с сервером")
+			return true
+		}
+	}
+	return false
+}
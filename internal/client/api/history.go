@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"keeper/internal/model"
+)
+
+// history выводит прежние версии записи и предлагает восстановить одну из них
+func history(ctx context.Context, log *logrus.Logger,
+	service Service, jwtToken string) error {
+	dataKeyWord, err := prompt("Введите ключ записи")
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	dataKeyWord = strings.TrimSpace(dataKeyWord)
+	revisions, err := service.GetHistory(ctx, jwtToken, dataKeyWord)
+	if err != nil {
+		if errors.Is(err, model.ErrVaultLocked) || errors.Is(err, model.ErrWrongMasterPassword) {
+			fmt.Println(err.Error())
+			return nil
+		}
+		if offlineError(err) {
+			return nil
+		}
+		return err
+	}
+	if len(revisions) == 0 {
+		fmt.Println("У записи нет прежних версий")
+		return nil
+	}
+
+	fmt.Printf("%-6s %-7s %-19s %-19s %s\n", "Номер", "Версия", "Записана", "Заменена", "Устройство")
+	for _, revision := range revisions {
+		device := revision.Device
+		if device == "" {
+			device = "-"
+		}
+		if revision.Deleted {
+			device += " (удалена)"
+		}
+		fmt.Printf("%-6d %-7d %-19s %-19s %s\n", revision.ID, revision.Data.Version,
+			revision.CreatedAt.Local().Format(time.DateTime),
+			revision.ReplacedAt.Local().Format(time.DateTime), device)
+	}
+
+	answer, err := prompt("Введите номер версии, чтобы восстановить ее (оставьте пустым, чтобы выйти)")
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return nil
+	}
+	revisionID, err := strconv.ParseInt(answer, 10, 64)
+	if err != nil {
+		fmt.Println(model.ErrRevisionNotFound.Error())
+		return nil
+	}
+	var revision *model.Revision
+	for i := range revisions {
+		if revisions[i].ID == revisionID {
+			revision = &revisions[i]
+		}
+	}
+	if revision == nil {
+		fmt.Println(model.ErrRevisionNotFound.Error())
+		return nil
+	}
+	if err = printRecord(log, revision.Data); err != nil {
+		return err
+	}
+
+	// версия текущей записи защищает от затирания изменений с другого устройства,
+	// удаленная запись восстанавливается с нулевой версией
+	var expectedVersion int64
+	current, err := service.Get(ctx, jwtToken, dataKeyWord)
+	switch {
+	case err == nil:
+		expectedVersion = current[0].Version
+	case status.Code(err) == codes.NotFound:
+	case errors.Is(err, model.ErrVaultLocked) || errors.Is(err, model.ErrWrongMasterPassword):
+		fmt.Println(err.Error())
+		return nil
+	case offlineError(err):
+		return nil
+	default:
+		log.Error(err.Error())
+		return err
+	}
+
+	version, err := service.RestoreRevision(ctx, jwtToken, revision.ID, expectedVersion)
+	if err != nil {
+		if errors.Is(err, model.ErrVersionMismatch) {
+			fmt.Println(err.Error() + ". Запустите history снова и повторите восстановление")
+			return nil
+		}
+		if e, ok := status.FromError(err); ok && e.Code() == codes.NotFound {
+			fmt.Println(statusMessage(e))
+			return nil
+		}
+		if offlineError(err) {
+			return nil
+		}
+		return err
+	}
+	fmt.Printf("Версия восстановлена, новая версия записи: %d\n", version)
+	return nil
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"keeper/internal/model"
+)
+
+// list выводит список записей пользователя постранично
+func list(ctx context.Context, log *logrus.Logger,
+	service Service, jwtToken string) error {
+	query, err := readListQuery()
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+
+	for {
+		headers, nextPageToken, err := service.ListData(ctx, jwtToken, query)
+		if err != nil {
+			if e, ok := status.FromError(err); ok && e.Code() == codes.InvalidArgument {
+				fmt.Println(statusMessage(e))
+				return nil
+			}
+			if offlineError(err) {
+				return nil
+			}
+			return err
+		}
+		if len(headers) == 0 && query.PageToken == "" {
+			fmt.Println("Записей не найдено")
+			return nil
+		}
+		printHeaders(headers)
+
+		if nextPageToken == "" {
+			return nil
+		}
+		answer, err := prompt("Показать следующую страницу? (y/n)")
+		if err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		if strings.TrimSpace(answer) != "y" {
+			return nil
+		}
+		query.PageToken = nextPageToken
+	}
+}
+
+// readListQuery запрашивает фильтры и сортировку списка записей
+func readListQuery() (model.ListQuery, error) {
+	var query model.ListQuery
+	var err error
+	if query.DataType, err = prompt("Введите тип записей (оставьте пустым для всех типов)"); err != nil {
+		return query, err
+	}
+	if query.KeyPrefix, err = prompt("Введите начало ключа (оставьте пустым для всех ключей)"); err != nil {
+		return query, err
+	}
+	sortBy, err := prompt("Введите поле сортировки: key, type, created, updated, size. " +
+		"Для обратного порядка добавьте минус, например -updated")
+	if err != nil {
+		return query, err
+	}
+	sortBy = strings.TrimSpace(sortBy)
+	query.Descending = strings.HasPrefix(sortBy, "-")
+	query.SortBy = strings.TrimPrefix(sortBy, "-")
+	return query, nil
+}
+
+// printHeaders выводит заголовки записей по одной в строке
+func printHeaders(headers []model.DataHeader) {
+	for _, header := range headers {
+		dataType := header.DataType
+		if dataType == "" {
+			dataType = "-"
+		}
+		fmt.Printf("%s\t%s\t%d байт\tизменена %s\t%s\n", header.DataKeyWord, dataType, header.Size,
+			header.UpdatedAt.Local().Format(time.DateTime), header.MetaData)
+	}
+}
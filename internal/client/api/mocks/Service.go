@@ -66,6 +66,70 @@ func (_m *Service) Change(ctx context.Context, jwtToken string, data model.DataB
 	return r0
 }
 
+// CompleteSecondFactor provides a mock function with given fields: ctx, code
+func (_m *Service) CompleteSecondFactor(ctx context.Context, code string) (string, error) {
+	ret := _m.Called(ctx, code)
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (string, error)); ok {
+		return rf(ctx, code)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, code)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, code)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ConfirmTOTP provides a mock function with given fields: ctx, jwtToken, code
+func (_m *Service) ConfirmTOTP(ctx context.Context, jwtToken string, code string) ([]string, error) {
+	ret := _m.Called(ctx, jwtToken, code)
+
+	var r0 []string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) ([]string, error)); ok {
+		return rf(ctx, jwtToken, code)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []string); ok {
+		r0 = rf(ctx, jwtToken, code)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]string)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, jwtToken, code)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Connect provides a mock function with given fields: address
+func (_m *Service) Connect(address string) error {
+	ret := _m.Called(address)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string) error); ok {
+		r0 = rf(address)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Delete provides a mock function with given fields: ctx, jwtToken, dataKeyWord
 func (_m *Service) Delete(ctx context.Context, jwtToken string, dataKeyWord string) error {
 	ret := _m.Called(ctx, jwtToken, dataKeyWord)
@@ -80,6 +144,78 @@ func (_m *Service) Delete(ctx context.Context, jwtToken string, dataKeyWord stri
 	return r0
 }
 
+// DownloadFile provides a mock function with given fields: ctx, jwtToken, dataKeyWord, path
+func (_m *Service) DownloadFile(ctx context.Context, jwtToken string, dataKeyWord string, path string) (model.FileInfo, error) {
+	ret := _m.Called(ctx, jwtToken, dataKeyWord, path)
+
+	var r0 model.FileInfo
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) (model.FileInfo, error)); ok {
+		return rf(ctx, jwtToken, dataKeyWord, path)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string) model.FileInfo); ok {
+		r0 = rf(ctx, jwtToken, dataKeyWord, path)
+	} else {
+		r0 = ret.Get(0).(model.FileInfo)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string) error); ok {
+		r1 = rf(ctx, jwtToken, dataKeyWord, path)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EmptyTrash provides a mock function with given fields: ctx, jwtToken
+func (_m *Service) EmptyTrash(ctx context.Context, jwtToken string) (int64, error) {
+	ret := _m.Called(ctx, jwtToken)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (int64, error)); ok {
+		return rf(ctx, jwtToken)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
+		r0 = rf(ctx, jwtToken)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, jwtToken)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EnrollTOTP provides a mock function with given fields: ctx, jwtToken
+func (_m *Service) EnrollTOTP(ctx context.Context, jwtToken string) (model.TOTPEnrollment, error) {
+	ret := _m.Called(ctx, jwtToken)
+
+	var r0 model.TOTPEnrollment
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.TOTPEnrollment, error)); ok {
+		return rf(ctx, jwtToken)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.TOTPEnrollment); ok {
+		r0 = rf(ctx, jwtToken)
+	} else {
+		r0 = ret.Get(0).(model.TOTPEnrollment)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, jwtToken)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Get provides a mock function with given fields: ctx, jwtToken, dataKeyWord
 func (_m *Service) Get(ctx context.Context, jwtToken string, dataKeyWord string) ([]model.DataBlock, error) {
 	ret := _m.Called(ctx, jwtToken, dataKeyWord)
@@ -106,6 +242,155 @@ func (_m *Service) Get(ctx context.Context, jwtToken string, dataKeyWord string)
 	return r0, r1
 }
 
+// GetHistory provides a mock function with given fields: ctx, jwtToken, dataKeyWord
+func (_m *Service) GetHistory(ctx context.Context, jwtToken string, dataKeyWord string) ([]model.Revision, error) {
+	ret := _m.Called(ctx, jwtToken, dataKeyWord)
+
+	var r0 []model.Revision
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) ([]model.Revision, error)); ok {
+		return rf(ctx, jwtToken, dataKeyWord)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []model.Revision); ok {
+		r0 = rf(ctx, jwtToken, dataKeyWord)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Revision)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, jwtToken, dataKeyWord)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListConflicts provides a mock function with given fields: ctx, jwtToken, dataKeyWord
+func (_m *Service) ListConflicts(ctx context.Context, jwtToken string, dataKeyWord string) ([]model.Conflict, error) {
+	ret := _m.Called(ctx, jwtToken, dataKeyWord)
+
+	var r0 []model.Conflict
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) ([]model.Conflict, error)); ok {
+		return rf(ctx, jwtToken, dataKeyWord)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []model.Conflict); ok {
+		r0 = rf(ctx, jwtToken, dataKeyWord)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Conflict)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, jwtToken, dataKeyWord)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListData provides a mock function with given fields: ctx, jwtToken, query
+func (_m *Service) ListData(ctx context.Context, jwtToken string, query model.ListQuery) ([]model.DataHeader, string, error) {
+	ret := _m.Called(ctx, jwtToken, query)
+
+	var r0 []model.DataHeader
+	var r1 string
+	var r2 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ListQuery) ([]model.DataHeader, string, error)); ok {
+		return rf(ctx, jwtToken, query)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ListQuery) []model.DataHeader); ok {
+		r0 = rf(ctx, jwtToken, query)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DataHeader)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.ListQuery) string); ok {
+		r1 = rf(ctx, jwtToken, query)
+	} else {
+		r1 = ret.Get(1).(string)
+	}
+
+	if rf, ok := ret.Get(2).(func(context.Context, string, model.ListQuery) error); ok {
+		r2 = rf(ctx, jwtToken, query)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
+
+// ListTrash provides a mock function with given fields: ctx, jwtToken
+func (_m *Service) ListTrash(ctx context.Context, jwtToken string) ([]model.TrashedRecord, error) {
+	ret := _m.Called(ctx, jwtToken)
+
+	var r0 []model.TrashedRecord
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]model.TrashedRecord, error)); ok {
+		return rf(ctx, jwtToken)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.TrashedRecord); ok {
+		r0 = rf(ctx, jwtToken)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.TrashedRecord)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, jwtToken)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Logout provides a mock function with given fields: ctx, jwtToken
+func (_m *Service) Logout(ctx context.Context, jwtToken string) error {
+	ret := _m.Called(ctx, jwtToken)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, jwtToken)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// LogoutAll provides a mock function with given fields: ctx, jwtToken
+func (_m *Service) LogoutAll(ctx context.Context, jwtToken string) (int64, error) {
+	ret := _m.Called(ctx, jwtToken)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (int64, error)); ok {
+		return rf(ctx, jwtToken)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
+		r0 = rf(ctx, jwtToken)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, jwtToken)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Register provides a mock function with given fields: ctx, login, password
 func (_m *Service) Register(ctx context.Context, login string, password string) (string, error) {
 	ret := _m.Called(ctx, login, password)
@@ -130,6 +415,169 @@ func (_m *Service) Register(ctx context.Context, login string, password string)
 	return r0, r1
 }
 
+// ResolveConflict provides a mock function with given fields: ctx, jwtToken, resolution
+func (_m *Service) ResolveConflict(ctx context.Context, jwtToken string, resolution model.ConflictResolution) (int64, error) {
+	ret := _m.Called(ctx, jwtToken, resolution)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ConflictResolution) (int64, error)); ok {
+		return rf(ctx, jwtToken, resolution)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ConflictResolution) int64); ok {
+		r0 = rf(ctx, jwtToken, resolution)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.ConflictResolution) error); ok {
+		r1 = rf(ctx, jwtToken, resolution)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreFromTrash provides a mock function with given fields: ctx, jwtToken, trashID
+func (_m *Service) RestoreFromTrash(ctx context.Context, jwtToken string, trashID int64) (int64, error) {
+	ret := _m.Called(ctx, jwtToken, trashID)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) (int64, error)); ok {
+		return rf(ctx, jwtToken, trashID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) int64); ok {
+		r0 = rf(ctx, jwtToken, trashID)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64) error); ok {
+		r1 = rf(ctx, jwtToken, trashID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreRevision provides a mock function with given fields: ctx, jwtToken, revisionID, expectedVersion
+func (_m *Service) RestoreRevision(ctx context.Context, jwtToken string, revisionID int64, expectedVersion int64) (int64, error) {
+	ret := _m.Called(ctx, jwtToken, revisionID, expectedVersion)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, int64) (int64, error)); ok {
+		return rf(ctx, jwtToken, revisionID, expectedVersion)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, int64) int64); ok {
+		r0 = rf(ctx, jwtToken, revisionID, expectedVersion)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64, int64) error); ok {
+		r1 = rf(ctx, jwtToken, revisionID, expectedVersion)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreSession provides a mock function with given fields: login
+func (_m *Service) RestoreSession(login string) (string, error) {
+	ret := _m.Called(login)
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(string) (string, error)); ok {
+		return rf(login)
+	}
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(login)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(login)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Resume provides a mock function with given fields: login
+func (_m *Service) Resume(login string) {
+	_m.Called(login)
+}
+
+// Sync provides a mock function with given fields: ctx, jwtToken
+func (_m *Service) Sync(ctx context.Context, jwtToken string) (model.SyncStats, error) {
+	ret := _m.Called(ctx, jwtToken)
+
+	var r0 model.SyncStats
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.SyncStats, error)); ok {
+		return rf(ctx, jwtToken)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.SyncStats); ok {
+		r0 = rf(ctx, jwtToken)
+	} else {
+		r0 = ret.Get(0).(model.SyncStats)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, jwtToken)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UnlockVault provides a mock function with given fields: ctx, jwtToken, masterPassword
+func (_m *Service) UnlockVault(ctx context.Context, jwtToken string, masterPassword string) error {
+	ret := _m.Called(ctx, jwtToken, masterPassword)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, jwtToken, masterPassword)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UploadFile provides a mock function with given fields: ctx, jwtToken, path, dataKeyWord, metaData
+func (_m *Service) UploadFile(ctx context.Context, jwtToken string, path string, dataKeyWord string, metaData string) (model.FileInfo, error) {
+	ret := _m.Called(ctx, jwtToken, path, dataKeyWord, metaData)
+
+	var r0 model.FileInfo
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, string) (model.FileInfo, error)); ok {
+		return rf(ctx, jwtToken, path, dataKeyWord, metaData)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, string) model.FileInfo); ok {
+		r0 = rf(ctx, jwtToken, path, dataKeyWord, metaData)
+	} else {
+		r0 = ret.Get(0).(model.FileInfo)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, string) error); ok {
+		r1 = rf(ctx, jwtToken, path, dataKeyWord, metaData)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewService creates a new instance of Service. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewService(t interface {
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
+
+	"keeper/internal/model"
+)
+
+// noteTerminator - строка, которой заканчивается ввод многострочного текста
+const noteTerminator = "."
+
+// readLine читает строку целиком, включая пробелы, и допускает пустой ввод.
+// Читает по одному байту, чтобы не забрать из os.Stdin ввод,
+// предназначенный для следующих fmt.Scanln
+func readLine() (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(line) > 0 {
+				break
+			}
+			return "", err
+		}
+	}
+	return strings.TrimRight(string(line), "\r"), nil
+}
+
+// prompt выводит подсказку и читает ответ пользователя
+func prompt(text string) (string, error) {
+	fmt.Println(text)
+	return readLine()
+}
+
+// readSecret выводит подсказку и читает секрет, не отображая его на экране.
+// Если ввод идет не с терминала, например из канала, секрет читается
+// как обычная строка
+func readSecret(text string) (string, error) {
+	fmt.Println(text)
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return readLine()
+	}
+	secret, err := term.ReadPassword(fd)
+	fmt.Println()
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+// readMultiline читает текст из нескольких строк, ввод заканчивается
+// строкой noteTerminator или концом ввода
+func readMultiline(text string) (string, error) {
+	fmt.Printf("%s. Для завершения введите строку из одной точки\n", text)
+	var lines []string
+	for {
+		line, err := readLine()
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(lines) > 0 {
+				break
+			}
+			return "", err
+		}
+		if line == noteTerminator {
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// readLoginPassword запрашивает логин и пароль пользователя
+func readLoginPassword() (string, string, error) {
+	login, err := prompt("Введите логин")
+	if err != nil {
+		return "", "", err
+	}
+	password, err := readSecret("Введите пароль")
+	if err != nil {
+		return "", "", err
+	}
+	return strings.TrimSpace(login), password, nil
+}
+
+// readMetaData запрашивает метаданные записи, допускает пустой ввод
+func readMetaData() (string, error) {
+	return prompt(`Введите дополнительные метаданные (не рекомендуется вводить чувствительную информацию), ` +
+		`если необходимо`)
+}
+
+// readRecord запрашивает тип записи и ее содержимое
+func readRecord(log *logrus.Logger) (model.DataBlock, error) {
+	var data model.DataBlock
+	dataType, err := prompt("Выберите тип записи: credentials - логин и пароль, " +
+		"card - банковская карта, text - текст, binary - файл " +
+		"(оставьте пустым для строки)")
+	if err != nil {
+		log.Error(err.Error())
+		return data, err
+	}
+
+	var payload model.Payload
+	switch strings.TrimSpace(dataType) {
+	case "":
+		if data.Data, err = prompt("Введите строку с данными или путь к файлу"); err != nil {
+			log.Error(err.Error())
+			return data, err
+		}
+		if strings.Contains(data.Data, `\`) {
+			data.Data, err = readFromFile(data.Data, log)
+		}
+		return data, err
+	case model.DataTypeCredentials:
+		payload.Credentials, err = readCredentials()
+	case model.DataTypeBankCard:
+		payload.BankCard, err = readBankCard()
+	case model.DataTypeText:
+		var text string
+		text, err = readMultiline("Введите текст")
+		payload.Text = &model.TextNote{Text: text}
+	case model.DataTypeBinary:
+		payload.Binary, err = readBinary(log)
+	default:
+		return data, fmt.Errorf("%w: %w", model.ErrInvalidPayload, model.ErrUnknownDataType)
+	}
+	if err != nil {
+		log.Error(err.Error())
+		return data, err
+	}
+	data.Payload = &payload
+	return data, nil
+}
+
+// readCredentials запрашивает логин, пароль и адрес сервиса
+func readCredentials() (*model.Credentials, error) {
+	var credentials model.Credentials
+	var err error
+	if credentials.Login, err = prompt("Введите логин"); err != nil {
+		return nil, err
+	}
+	if credentials.Password, err = readSecret("Введите пароль"); err != nil {
+		return nil, err
+	}
+	if credentials.URL, err = prompt("Введите адрес сервиса (необязательно)"); err != nil {
+		return nil, err
+	}
+	return &credentials, nil
+}
+
+// readBankCard запрашивает данные банковской карты
+func readBankCard() (*model.BankCard, error) {
+	var card model.BankCard
+	var err error
+	if card.Number, err = prompt("Введите номер карты"); err != nil {
+		return nil, err
+	}
+	card.Number = model.NormalizeCardNumber(card.Number)
+	if card.Expiry, err = prompt("Введите срок действия в формате ММ/ГГ"); err != nil {
+		return nil, err
+	}
+	if card.Holder, err = prompt("Введите имя владельца (необязательно)"); err != nil {
+		return nil, err
+	}
+	if card.CVV, err = readSecret("Введите CVV (необязательно)"); err != nil {
+		return nil, err
+	}
+	return &card, nil
+}
+
+// readBinary запрашивает путь к файлу и читает его содержимое
+func readBinary(log *logrus.Logger) (*model.BinaryData, error) {
+	path, err := prompt("Введите путь к файлу")
+	if err != nil {
+		return nil, err
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.Size() > model.MaxBinarySize {
+		return nil, fmt.Errorf("%w: %w", model.ErrInvalidPayload, model.ErrBigFile)
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &model.BinaryData{
+		Data:     content,
+		FileName: fileInfo.Name(),
+	}, nil
+}
+
+// printRecord выводит запись с учетом ее типа. Бинарные данные
+// по желанию пользователя сохраняются в файл
+func printRecord(log *logrus.Logger, data model.DataBlock) error {
+	fmt.Printf("Ключ: %s\n", data.DataKeyWord)
+	if data.MetaData != "" {
+		fmt.Printf("Метаданные: %s\n", data.MetaData)
+	}
+	if data.DataType == model.DataTypeFile {
+		var info model.FileInfo
+		if err := json.Unmarshal([]byte(data.Data), &info); err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		fmt.Printf("Файл %s, %d байт, скачайте его командой download\n", info.FileName, info.Size)
+		return nil
+	}
+	if data.Payload == nil {
+		fmt.Printf("Сохраненные данные: %s\n", data.Data)
+		return nil
+	}
+
+	payload := data.Payload
+	switch {
+	case payload.Credentials != nil:
+		fmt.Printf("Логин: %s\n", payload.Credentials.Login)
+		fmt.Printf("Пароль: %s\n", payload.Credentials.Password)
+		if payload.Credentials.URL != "" {
+			fmt.Printf("Адрес: %s\n", payload.Credentials.URL)
+		}
+	case payload.BankCard != nil:
+		fmt.Printf("Номер карты: %s\n", payload.BankCard.Number)
+		fmt.Printf("Срок действия: %s\n", payload.BankCard.Expiry)
+		if payload.BankCard.Holder != "" {
+			fmt.Printf("Владелец: %s\n", payload.BankCard.Holder)
+		}
+		if payload.BankCard.CVV != "" {
+			fmt.Printf("CVV: %s\n", payload.BankCard.CVV)
+		}
+	case payload.Text != nil:
+		fmt.Printf("Текст: %s\n", payload.Text.Text)
+	case payload.Binary != nil:
+		fmt.Printf("Файл %s, %d байт\n", payload.Binary.FileName, len(payload.Binary.Data))
+		path, err := prompt("Введите путь для сохранения файла (оставьте пустым, чтобы пропустить)")
+		if err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		if path == "" {
+			return nil
+		}
+		if err = os.WriteFile(path, payload.Binary.Data, 0600); err != nil {
+			log.Error(err.Error())
+			return err
+		}
+		fmt.Println("Файл сохранен")
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"keeper/internal/model"
+)
+
+// trash выводит записи в корзине
+func trash(ctx context.Context, log *logrus.Logger,
+	service Service, jwtToken string) error {
+	records, err := service.ListTrash(ctx, jwtToken)
+	if err != nil {
+		if offlineError(err) {
+			return nil
+		}
+		return err
+	}
+	if len(records) == 0 {
+		fmt.Println("Корзина пуста")
+		return nil
+	}
+
+	fmt.Printf("%-6s %-20s %-10s %-19s %s\n", "Номер", "Ключ", "Тип", "Удалена", "Метаданные")
+	for _, record := range records {
+		dataType := record.Header.DataType
+		if dataType == "" {
+			dataType = "-"
+		}
+		fmt.Printf("%-6d %-20s %-10s %-19s %s\n", record.ID, record.Header.DataKeyWord, dataType,
+			record.DeletedAt.Local().Format(time.DateTime), record.Header.MetaData)
+	}
+	return nil
+}
+
+// restore возвращает запись из корзины по номеру
+func restore(ctx context.Context, log *logrus.Logger,
+	service Service, jwtToken string) error {
+	answer, err := prompt("Введите номер записи в корзине (список выводит команда trash)")
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	trashID, err := strconv.ParseInt(strings.TrimSpace(answer), 10, 64)
+	if err != nil {
+		fmt.Println(model.ErrTrashNotFound.Error())
+		return nil
+	}
+	if _, err = service.RestoreFromTrash(ctx, jwtToken, trashID); err != nil {
+		if e, ok := status.FromError(err); ok {
+			switch e.Code() {
+			case codes.NotFound:
+				fmt.Println(statusMessage(e))
+				return nil
+			case codes.AlreadyExists:
+				fmt.Println(statusMessage(e) + ". Удалите или переименуйте запись с этим ключом")
+				return nil
+			}
+		}
+		if offlineError(err) {
+			return nil
+		}
+		return err
+	}
+	fmt.Println("Запись восстановлена")
+	return nil
+}
+
+// emptyTrash после подтверждения навсегда удаляет записи из корзины
+func emptyTrash(ctx context.Context, log *logrus.Logger,
+	service Service, jwtToken string) error {
+	confirmed, err := confirm("Удалить записи из корзины навсегда? (y/n)")
+	if err != nil {
+		log.Error(err.Error())
+		return err
+	}
+	if !confirmed {
+		return nil
+	}
+	purged, err := service.EmptyTrash(ctx, jwtToken)
+	if err != nil {
+		if offlineError(err) {
+			return nil
+		}
+		return err
+	}
+	fmt.Printf("Удалено записей: %d\n", purged)
+	return nil
+}
+
+// confirm запрашивает у пользователя подтверждение действия
+func confirm(text string) (bool, error) {
+	answer, err := prompt(text)
+	if err != nil {
+		return false, err
+	}
+	return strings.TrimSpace(answer) == "y", nil
+}
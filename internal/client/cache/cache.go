@@ -0,0 +1,390 @@
+package cache
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+var (
+	recordsBucket = []byte("records")
+	queueBucket   = []byte("queue")
+	checkKey      = []byte("check")
+	cursorKey     = []byte("cursor")
+	vaultCheckKey = []byte("vaultCheck")
+)
+
+// checkValue шифруется ключом локальной копии, чтобы без сервера
+// проверить пароль пользователя
+const checkValue = "keeper-cache"
+
+// Cache - локальная копия записей пользователя и очередь изменений,
+// сделанных без связи с сервером. Все значения зашифрованы ключом,
+// полученным из пароля пользователя, ключи записей заменены на HMAC
+type Cache struct {
+	db    *bolt.DB
+	log   *logrus.Logger
+	login string
+	key   []byte
+}
+
+// Open открывает файл локальной копии, создавая его при необходимости
+func Open(path string, log *logrus.Logger) (*Cache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return &Cache{db: db, log: log}, nil
+}
+
+// DefaultPath возвращает путь к локальной копии: из переменной
+// окружения KEEPER_CACHE или в каталоге кэша пользователя
+func DefaultPath() string {
+	if path := os.Getenv("KEEPER_CACHE"); path != "" {
+		return path
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "keeper", "cache.db")
+}
+
+// Close закрывает файл локальной копии
+func (c *Cache) Close() error {
+	return c.db.Close()
+}
+
+// Unlock открывает локальную копию пользователя ключом key.
+// Если копии еще нет, она создается
+func (c *Cache) Unlock(login string, key []byte) error {
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(userBucket(login))
+		if err != nil {
+			return err
+		}
+		if check := bucket.Get(checkKey); check != nil {
+			value, err := utils.VaultDecipher(check, key)
+			if err != nil || value != checkValue {
+				return model.ErrWrongCachePassword
+			}
+			return nil
+		}
+		check, err := utils.VaultCipher(checkValue, key)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(checkKey, check)
+	})
+	if err != nil {
+		c.log.Error(err.Error())
+		return err
+	}
+	c.login, c.key = login, key
+	return nil
+}
+
+// Reset удаляет локальную копию пользователя, например после смены пароля
+func (c *Cache) Reset(login string) error {
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		err := tx.DeleteBucket(userBucket(login))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		c.log.Error(err.Error())
+	}
+	if c.login == login {
+		c.Lock()
+	}
+	return err
+}
+
+// Lock забывает ключ локальной копии
+func (c *Cache) Lock() {
+	c.login, c.key = "", nil
+}
+
+// Unlocked проверяет, что локальная копия открыта
+func (c *Cache) Unlocked() bool {
+	return c.key != nil
+}
+
+// PutRecord сохраняет запись в локальной копии
+func (c *Cache) PutRecord(record model.CachedRecord) error {
+	return c.update(func(bucket *bolt.Bucket) error {
+		records, err := bucket.CreateBucketIfNotExists(recordsBucket)
+		if err != nil {
+			return err
+		}
+		value, err := c.seal(record)
+		if err != nil {
+			return err
+		}
+		return records.Put(c.recordKey(record.Data.DataKeyWord), value)
+	})
+}
+
+// DeleteRecord удаляет запись из локальной копии
+func (c *Cache) DeleteRecord(dataKeyWord string) error {
+	return c.update(func(bucket *bolt.Bucket) error {
+		records := bucket.Bucket(recordsBucket)
+		if records == nil {
+			return nil
+		}
+		return records.Delete(c.recordKey(dataKeyWord))
+	})
+}
+
+// GetRecord возвращает запись из локальной копии
+func (c *Cache) GetRecord(dataKeyWord string) (model.CachedRecord, error) {
+	var record model.CachedRecord
+	err := c.view(func(bucket *bolt.Bucket) error {
+		records := bucket.Bucket(recordsBucket)
+		if records == nil {
+			return model.ErrNoRowsSelected
+		}
+		value := records.Get(c.recordKey(dataKeyWord))
+		if value == nil {
+			return model.ErrNoRowsSelected
+		}
+		return c.open(value, &record)
+	})
+	return record, err
+}
+
+// Records возвращает все записи локальной копии
+func (c *Cache) Records() ([]model.CachedRecord, error) {
+	var result []model.CachedRecord
+	err := c.view(func(bucket *bolt.Bucket) error {
+		records := bucket.Bucket(recordsBucket)
+		if records == nil {
+			return nil
+		}
+		return records.ForEach(func(_, value []byte) error {
+			var record model.CachedRecord
+			if err := c.open(value, &record); err != nil {
+				return err
+			}
+			result = append(result, record)
+			return nil
+		})
+	})
+	return result, err
+}
+
+// Enqueue добавляет изменение в очередь на отправку серверу. Прежнее изменение
+// той же записи из очереди заменяется новым, но версия, которую изменял
+// клиент, берется из прежнего: иначе сервер принял бы ее за конфликт
+func (c *Cache) Enqueue(operation model.SyncOperation) error {
+	return c.update(func(bucket *bolt.Bucket) error {
+		queue, err := bucket.CreateBucketIfNotExists(queueBucket)
+		if err != nil {
+			return err
+		}
+		var replaced [][]byte
+		err = queue.ForEach(func(key, value []byte) error {
+			var queued model.SyncOperation
+			if err := c.open(value, &queued); err != nil {
+				return err
+			}
+			if queued.Data.DataKeyWord == operation.Data.DataKeyWord {
+				operation.BaseVersion, operation.Base = queued.BaseVersion, queued.Base
+				replaced = append(replaced, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range replaced {
+			if err = queue.Delete(key); err != nil {
+				return err
+			}
+		}
+		id, err := queue.NextSequence()
+		if err != nil {
+			return err
+		}
+		value, err := c.seal(operation)
+		if err != nil {
+			return err
+		}
+		return queue.Put(sequenceKey(id), value)
+	})
+}
+
+// Queue возвращает изменения из очереди в порядке добавления
+// и их идентификаторы для Dequeue
+func (c *Cache) Queue() ([]uint64, []model.SyncOperation, error) {
+	var ids []uint64
+	var operations []model.SyncOperation
+	err := c.view(func(bucket *bolt.Bucket) error {
+		queue := bucket.Bucket(queueBucket)
+		if queue == nil {
+			return nil
+		}
+		return queue.ForEach(func(key, value []byte) error {
+			var operation model.SyncOperation
+			if err := c.open(value, &operation); err != nil {
+				return err
+			}
+			ids = append(ids, binary.BigEndian.Uint64(key))
+			operations = append(operations, operation)
+			return nil
+		})
+	})
+	return ids, operations, err
+}
+
+// Dequeue удаляет отправленные изменения из очереди
+func (c *Cache) Dequeue(ids []uint64) error {
+	return c.update(func(bucket *bolt.Bucket) error {
+		queue := bucket.Bucket(queueBucket)
+		if queue == nil {
+			return nil
+		}
+		for _, id := range ids {
+			if err := queue.Delete(sequenceKey(id)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Cursor возвращает курсор последней синхронизации
+func (c *Cache) Cursor() (string, error) {
+	var cursor string
+	err := c.view(func(bucket *bolt.Bucket) error {
+		value := bucket.Get(cursorKey)
+		if value == nil {
+			return nil
+		}
+		return c.open(value, &cursor)
+	})
+	return cursor, err
+}
+
+// SetCursor сохраняет курсор последней синхронизации
+func (c *Cache) SetCursor(cursor string) error {
+	return c.update(func(bucket *bolt.Bucket) error {
+		value, err := c.seal(cursor)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(cursorKey, value)
+	})
+}
+
+// VaultCheck возвращает сохраненное проверочное значение хранилища
+// или nil, если оно еще не получено с сервера
+func (c *Cache) VaultCheck() ([]byte, error) {
+	var check []byte
+	err := c.view(func(bucket *bolt.Bucket) error {
+		value := bucket.Get(vaultCheckKey)
+		if value == nil {
+			return nil
+		}
+		return c.open(value, &check)
+	})
+	return check, err
+}
+
+// SetVaultCheck сохраняет проверочное значение хранилища, чтобы
+// проверять мастер-пароль без сервера
+func (c *Cache) SetVaultCheck(check []byte) error {
+	return c.update(func(bucket *bolt.Bucket) error {
+		value, err := c.seal(check)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(vaultCheckKey, value)
+	})
+}
+
+// update выполняет fn в транзакции записи над копией открытого пользователя
+func (c *Cache) update(fn func(bucket *bolt.Bucket) error) error {
+	if !c.Unlocked() {
+		return model.ErrCacheLocked
+	}
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(userBucket(c.login))
+		if bucket == nil {
+			return model.ErrCacheLocked
+		}
+		return fn(bucket)
+	})
+	if err != nil {
+		c.log.Error(err.Error())
+	}
+	return err
+}
+
+// view выполняет fn в транзакции чтения над копией открытого пользователя
+func (c *Cache) view(fn func(bucket *bolt.Bucket) error) error {
+	if !c.Unlocked() {
+		return model.ErrCacheLocked
+	}
+	return c.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(userBucket(c.login))
+		if bucket == nil {
+			return model.ErrCacheLocked
+		}
+		return fn(bucket)
+	})
+}
+
+// seal сериализует и шифрует значение ключом локальной копии
+func (c *Cache) seal(value interface{}) ([]byte, error) {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return utils.VaultCipher(string(raw), c.key)
+}
+
+// open расшифровывает и разбирает значение локальной копии
+func (c *Cache) open(sealed []byte, value interface{}) error {
+	raw, err := utils.VaultDecipher(sealed, c.key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(raw), value)
+}
+
+// recordKey скрывает ключ записи, чтобы по файлу нельзя было узнать,
+// какие записи хранит пользователь
+func (c *Cache) recordKey(dataKeyWord string) []byte {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(dataKeyWord))
+	return mac.Sum(nil)
+}
+
+// userBucket - корзина с локальной копией пользователя
+func userBucket(login string) []byte {
+	return []byte("user:" + login)
+}
+
+func sequenceKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+func openTestCache(t *testing.T) (*Cache, string) {
+	path := filepath.Join(t.TempDir(), "cache.db")
+	cache, err := Open(path, logger.InitLog(logrus.InfoLevel))
+	require.NoError(t, err)
+	t.Cleanup(func() { cache.Close() })
+	return cache, path
+}
+
+func TestCacheUnlock(t *testing.T) {
+	cache, _ := openTestCache(t)
+	key := utils.DeriveCacheKey("user", "password")
+
+	tests := []struct {
+		name    string
+		login   string
+		key     []byte
+		wantErr error
+	}{
+		{
+			name:  "Создание локальной копии",
+			login: "user",
+			key:   key,
+		},
+		{
+			name:  "Повторное открытие тем же паролем",
+			login: "user",
+			key:   key,
+		},
+		{
+			name:    "Неверный пароль",
+			login:   "user",
+			key:     utils.DeriveCacheKey("user", "wrong"),
+			wantErr: model.ErrWrongCachePassword,
+		},
+		{
+			name:  "Копия другого пользователя",
+			login: "other",
+			key:   utils.DeriveCacheKey("other", "password"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := cache.Unlock(tt.login, tt.key)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, cache.Unlocked())
+		})
+	}
+}
+
+func TestCacheRecords(t *testing.T) {
+	cache, path := openTestCache(t)
+
+	record := model.CachedRecord{
+		Header: model.DataHeader{DataKeyWord: "secretKey", DataType: model.DataTypeText},
+		Data:   model.DataBlock{DataKeyWord: "secretKey", Data: "secretValue"},
+	}
+	assert.ErrorIs(t, cache.PutRecord(record), model.ErrCacheLocked)
+
+	require.NoError(t, cache.Unlock("user", utils.DeriveCacheKey("user", "password")))
+	require.NoError(t, cache.PutRecord(record))
+
+	got, err := cache.GetRecord("secretKey")
+	require.NoError(t, err)
+	assert.Equal(t, record, got)
+
+	records, err := cache.Records()
+	require.NoError(t, err)
+	assert.Len(t, records, 1)
+
+	// ни ключ, ни содержимое записи не хранятся в файле открыто
+	raw, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(raw), "secretKey")
+	assert.NotContains(t, string(raw), "secretValue")
+
+	require.NoError(t, cache.DeleteRecord("secretKey"))
+	_, err = cache.GetRecord("secretKey")
+	assert.ErrorIs(t, err, model.ErrNoRowsSelected)
+
+	// после сброса копия пользователя пуста
+	require.NoError(t, cache.PutRecord(record))
+	require.NoError(t, cache.Reset("user"))
+	assert.False(t, cache.Unlocked())
+	require.NoError(t, cache.Unlock("user", utils.DeriveCacheKey("user", "new password")))
+	records, err = cache.Records()
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func TestCacheQueue(t *testing.T) {
+	cache, _ := openTestCache(t)
+	require.NoError(t, cache.Unlock("user", utils.DeriveCacheKey("user", "password")))
+
+	operations := []model.SyncOperation{
+		{Data: model.DataBlock{DataKeyWord: "first", Data: "value"}},
+		{Data: model.DataBlock{DataKeyWord: "second"}, Deleted: true},
+		{Data: model.DataBlock{DataKeyWord: "third", Data: "value"}},
+	}
+	for _, operation := range operations {
+		require.NoError(t, cache.Enqueue(operation))
+	}
+
+	ids, queued, err := cache.Queue()
+	require.NoError(t, err)
+	assert.Equal(t, operations, queued)
+
+	require.NoError(t, cache.Dequeue(ids[:2]))
+	_, queued, err = cache.Queue()
+	require.NoError(t, err)
+	assert.Equal(t, operations[2:], queued)
+
+	// повторное изменение записи заменяет прежнее и сохраняет исходную версию
+	base := &model.DataBlock{DataKeyWord: "third", Data: "base", Version: 4}
+	require.NoError(t, cache.Dequeue(ids[2:]))
+	require.NoError(t, cache.Enqueue(model.SyncOperation{
+		Data: model.DataBlock{DataKeyWord: "third", Data: "edited"}, BaseVersion: 4, Base: base,
+	}))
+	require.NoError(t, cache.Enqueue(model.SyncOperation{
+		Data: model.DataBlock{DataKeyWord: "third"}, Deleted: true, BaseVersion: 5,
+	}))
+	_, queued, err = cache.Queue()
+	require.NoError(t, err)
+	assert.Equal(t, []model.SyncOperation{{
+		Data: model.DataBlock{DataKeyWord: "third"}, Deleted: true, BaseVersion: 4, Base: base,
+	}}, queued)
+
+	cursor, err := cache.Cursor()
+	require.NoError(t, err)
+	assert.Empty(t, cursor)
+	require.NoError(t, cache.SetCursor("MTA"))
+	cursor, err = cache.Cursor()
+	require.NoError(t, err)
+	assert.Equal(t, "MTA", cursor)
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+
+	"keeper/internal/model"
+
+	dataService "keeper/internal/server/handlers/proto/dataService"
+)
+
+// ListConflicts получает конфликты изменений записей и расшифровывает
+// все три версии записи
+func (s *service) ListConflicts(ctx context.Context, jwtToken string,
+	dataKeyWord string) ([]model.Conflict, error) {
+	var response *dataService.ListConflictsResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		response, err = s.dataClient.ListConflicts(ctx, &dataService.ListConflictsRequest{
+			DataKeyWord: dataKeyWord,
+		})
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+
+	conflicts := make([]model.Conflict, 0, len(response.Conflicts))
+	for _, protoConflict := range response.Conflicts {
+		conflict := model.Conflict{
+			ID:          protoConflict.Id,
+			DataKeyWord: protoConflict.DataKeyWord,
+			BaseVersion: protoConflict.BaseVersion,
+			MineDeleted: protoConflict.MineDeleted,
+			CreatedAt:   protoConflict.CreatedAt.AsTime(),
+		}
+		if protoConflict.Base != nil {
+			if conflict.Base, err = s.openResponse(protoConflict.Base); err != nil {
+				return nil, err
+			}
+		}
+		if protoConflict.Mine != nil {
+			mine, err := s.openResponse(protoConflict.Mine)
+			if err != nil {
+				return nil, err
+			}
+			conflict.Mine = *mine
+		}
+		if protoConflict.Theirs != nil {
+			if conflict.Theirs, err = s.openResponse(protoConflict.Theirs); err != nil {
+				return nil, err
+			}
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	return conflicts, nil
+}
+
+// openResponse расшифровывает запись из ответа сервера
+func (s *service) openResponse(response *dataService.GetResponse) (*model.DataBlock, error) {
+	dataBlock, err := s.openRecord(recordFromResponse(response))
+	if err != nil {
+		return nil, err
+	}
+	return &dataBlock, nil
+}
+
+// ResolveConflict передает выбор пользователя для конфликта, возвращает
+// версию записи после разрешения. Объединенная запись шифруется так же,
+// как при изменении
+func (s *service) ResolveConflict(ctx context.Context, jwtToken string,
+	resolution model.ConflictResolution) (int64, error) {
+	requestResolve := &dataService.ResolveConflictRequest{
+		Id:              resolution.ConflictID,
+		Resolution:      resolution.Strategy,
+		ExpectedVersion: resolution.ExpectedVersion,
+		RenamedKey:      resolution.RenamedKey,
+	}
+	if resolution.Strategy == model.ResolveMerge {
+		merged := &dataService.AddingRequest{
+			DataKeyWord: resolution.Merged.DataKeyWord,
+			MetaData:    resolution.Merged.MetaData,
+		}
+		var err error
+		if resolution.Merged.Payload != nil {
+			merged.DataType, merged.EncryptedData, merged.Payload, err =
+				s.sealPayload(resolution.Merged.Payload)
+		} else {
+			merged.Data, merged.EncryptedData, err = s.sealData(resolution.Merged.Data)
+		}
+		if err != nil {
+			return 0, err
+		}
+		requestResolve.Merged = merged
+	}
+
+	var response *dataService.ResolveConflictResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		response, err = s.dataClient.ResolveConflict(ctx, requestResolve)
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		if mismatch := versionMismatch(err); mismatch != nil {
+			return 0, mismatch
+		}
+		return 0, err
+	}
+	return response.Version, nil
+}
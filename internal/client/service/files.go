@@ -0,0 +1,185 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+
+	dataService "keeper/internal/server/handlers/proto/dataService"
+)
+
+// UploadFile передает файл в RPC метод загрузки по частям. Файл читается
+// частями по model.FileChunkSize, при включенном сквозном шифровании каждая
+// часть шифруется ключом хранилища. Поток завершается контрольной суммой
+// переданных частей
+func (s *service) UploadFile(ctx context.Context, jwtToken string, path string,
+	dataKeyWord string, metaData string) (model.FileInfo, error) {
+	var info model.FileInfo
+	if s.vaultKey == nil && s.vaultRequired {
+		return info, model.ErrVaultLocked
+	}
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		fileInfo, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if fileInfo.Size() > model.MaxFileSize {
+			return model.ErrBigFile
+		}
+		info = model.FileInfo{
+			DataKeyWord:  dataKeyWord,
+			FileName:     fileInfo.Name(),
+			MetaData:     metaData,
+			Size:         fileInfo.Size(),
+			ClientSealed: s.vaultKey != nil,
+		}
+		if info.ClientSealed {
+			chunks := (info.Size + model.FileChunkSize - 1) / model.FileChunkSize
+			info.Size += chunks * utils.VaultChunkOverhead
+		}
+
+		stream, err := s.dataClient.UploadFile(ctx)
+		if err != nil {
+			return err
+		}
+		if err = stream.Send(dataService.FileChunkFromModel(model.FileChunk{Info: &info})); err != nil {
+			// причину закрытия потока сервером возвращает CloseAndRecv
+			_, err = stream.CloseAndRecv()
+			return err
+		}
+
+		checksum := sha256.New()
+		if err = s.sendChunks(file, info, checksum, func(chunk []byte) error {
+			return stream.Send(dataService.FileChunkFromModel(model.FileChunk{Data: chunk}))
+		}); err != nil {
+			if errors.Is(err, io.EOF) {
+				_, err = stream.CloseAndRecv()
+			}
+			return err
+		}
+		info.Checksum = checksum.Sum(nil)
+		if err = stream.Send(dataService.FileChunkFromModel(
+			model.FileChunk{Checksum: info.Checksum})); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		resp, err := stream.CloseAndRecv()
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(resp.Checksum, info.Checksum) {
+			return model.ErrChecksumMismatch
+		}
+		return nil
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return info, err
+}
+
+// sendChunks читает файл частями, при необходимости шифрует их
+// и передает в send, учитывая каждую переданную часть в контрольной сумме
+func (s *service) sendChunks(file io.Reader, info model.FileInfo, checksum hash.Hash,
+	send func(chunk []byte) error) error {
+	buf := make([]byte, model.FileChunkSize)
+	for seq := int64(0); ; seq++ {
+		n, err := io.ReadFull(file, buf)
+		if n == 0 && (errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)) {
+			return nil
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return err
+		}
+		chunk := buf[:n]
+		if info.ClientSealed {
+			if chunk, err = utils.VaultChunkCipher(chunk, s.vaultKey, info.DataKeyWord, seq); err != nil {
+				return err
+			}
+		}
+		checksum.Write(chunk)
+		if err = send(chunk); err != nil {
+			return err
+		}
+	}
+}
+
+// DownloadFile получает файл по частям из RPC метода скачивания и сохраняет
+// его по пути path. Файл пишется во временный файл рядом и переименовывается
+// только после проверки контрольной суммы
+func (s *service) DownloadFile(ctx context.Context, jwtToken string, dataKeyWord string,
+	path string) (model.FileInfo, error) {
+	var info model.FileInfo
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		stream, err := s.dataClient.DownloadFile(ctx, &dataService.DownloadRequest{
+			DataKeyWord: dataKeyWord,
+		})
+		if err != nil {
+			return err
+		}
+		first, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		header := dataService.FileChunkToModel(first)
+		if header.Info == nil {
+			return model.ErrFileInfoRequired
+		}
+		info = *header.Info
+		if info.ClientSealed && s.vaultKey == nil {
+			return model.ErrVaultLocked
+		}
+
+		tmp, err := os.CreateTemp(filepath.Dir(path), ".keeper-download-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		checksum := sha256.New()
+		for seq := int64(0); ; seq++ {
+			in, err := stream.Recv()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			chunk := dataService.FileChunkToModel(in).Data
+			checksum.Write(chunk)
+			if info.ClientSealed {
+				if chunk, err = utils.VaultChunkDecipher(chunk, s.vaultKey, dataKeyWord, seq); err != nil {
+					return err
+				}
+			}
+			if _, err = tmp.Write(chunk); err != nil {
+				return err
+			}
+		}
+		if !bytes.Equal(checksum.Sum(nil), info.Checksum) {
+			return model.ErrChecksumMismatch
+		}
+		if err = tmp.Close(); err != nil {
+			return err
+		}
+		return os.Rename(tmp.Name(), path)
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return info, err
+}
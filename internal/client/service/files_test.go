@@ -0,0 +1,135 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"keeper/internal/client/service/mocks"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	dataservice "keeper/internal/server/handlers/proto/dataService"
+)
+
+// uploadStream запоминает сообщения, отправленные в поток загрузки
+type uploadStream struct {
+	grpc.ClientStream
+	sent []*dataservice.FileChunk
+}
+
+// Send копирует сообщение, как это делает gRPC при сериализации:
+// клиент переиспользует буфер чтения файла
+func (u *uploadStream) Send(chunk *dataservice.FileChunk) error {
+	u.sent = append(u.sent, proto.Clone(chunk).(*dataservice.FileChunk))
+	return nil
+}
+
+func (u *uploadStream) CloseAndRecv() (*dataservice.UploadResponse, error) {
+	last := u.sent[len(u.sent)-1]
+	return &dataservice.UploadResponse{Checksum: last.GetChecksum()}, nil
+}
+
+// downloadStream отдает заранее заданные сообщения потока скачивания
+type downloadStream struct {
+	grpc.ClientStream
+	chunks []*dataservice.FileChunk
+}
+
+func (d *downloadStream) Recv() (*dataservice.FileChunk, error) {
+	if len(d.chunks) == 0 {
+		return nil, io.EOF
+	}
+	chunk := d.chunks[0]
+	d.chunks = d.chunks[1:]
+	return chunk, nil
+}
+
+func TestClientServiceFiles(t *testing.T) {
+	tests := []struct {
+		name           string
+		masterPassword string
+	}{
+		{
+			name: "Загрузка и скачивание файла",
+		},
+		{
+			name:           "Загрузка и скачивание файла со сквозным шифрованием",
+			masterPassword: "master",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockServiceClient := new(mocks.DataServiceClient)
+			s := &service{
+				log:        logger.InitLog(logrus.InfoLevel),
+				dataClient: mockServiceClient,
+				login:      "user",
+			}
+			ctx := context.Background()
+			outgoingCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs("token", "token"))
+			mockServiceClient.On("GetVaultCheck", outgoingCtx, &emptypb.Empty{}).
+				Return(&dataservice.VaultCheck{}, nil)
+			mockServiceClient.On("SetVaultCheck", outgoingCtx, mock.Anything).
+				Return(&emptypb.Empty{}, nil).Maybe()
+			require.NoError(t, s.UnlockVault(ctx, "token", tt.masterPassword))
+
+			dir := t.TempDir()
+			content := bytes.Repeat([]byte("little gopher "), model.FileChunkSize/4)
+			source := filepath.Join(dir, "gopher.txt")
+			require.NoError(t, os.WriteFile(source, content, 0600))
+
+			upload := &uploadStream{}
+			mockServiceClient.On("UploadFile", outgoingCtx).Return(upload, nil)
+
+			info, err := s.UploadFile(ctx, "token", source, "key", "metadata")
+			require.NoError(t, err)
+			assert.Equal(t, tt.masterPassword != "", info.ClientSealed)
+			require.Greater(t, len(upload.sent), 3)
+
+			var sent []byte
+			for _, chunk := range upload.sent[1 : len(upload.sent)-1] {
+				sent = append(sent, chunk.GetData()...)
+			}
+			assert.Equal(t, info.Size, int64(len(sent)))
+			// со сквозным шифрованием на сервер уходит только шифротекст
+			assert.Equal(t, !info.ClientSealed, bytes.Contains(sent, []byte("little gopher")))
+
+			// сервер возвращает те же части, что принял
+			chunks := append([]*dataservice.FileChunk{}, upload.sent[:len(upload.sent)-1]...)
+			chunks[0] = dataservice.FileChunkFromModel(model.FileChunk{Info: &info})
+			mockServiceClient.On("DownloadFile", outgoingCtx,
+				&dataservice.DownloadRequest{DataKeyWord: "key"}).
+				Return(&downloadStream{chunks: chunks}, nil).Once()
+
+			target := filepath.Join(dir, "downloaded.txt")
+			_, err = s.DownloadFile(ctx, "token", "key", target)
+			require.NoError(t, err)
+			downloaded, err := os.ReadFile(target)
+			require.NoError(t, err)
+			assert.Equal(t, content, downloaded)
+
+			// поврежденная часть не попадает на диск
+			corrupted := append([]*dataservice.FileChunk{}, chunks...)
+			corrupted[1] = dataservice.FileChunkFromModel(model.FileChunk{
+				Data: bytes.Repeat([]byte{0}, len(chunks[1].GetData())),
+			})
+			mockServiceClient.On("DownloadFile", outgoingCtx, mock.Anything).
+				Return(&downloadStream{chunks: corrupted}, nil).Once()
+			_, err = s.DownloadFile(ctx, "token", "key", filepath.Join(dir, "corrupted.txt"))
+			assert.Error(t, err)
+			assert.NoFileExists(t, filepath.Join(dir, "corrupted.txt"))
+		})
+	}
+}
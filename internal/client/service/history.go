@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+
+	"keeper/internal/model"
+
+	dataService "keeper/internal/server/handlers/proto/dataService"
+)
+
+// GetHistory получает прежние версии записи и расшифровывает их
+func (s *service) GetHistory(ctx context.Context, jwtToken string,
+	dataKeyWord string) ([]model.Revision, error) {
+	var response *dataService.GetHistoryResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		response, err = s.dataClient.GetHistory(ctx, &dataService.GetHistoryRequest{
+			DataKeyWord: dataKeyWord,
+		})
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+
+	revisions := make([]model.Revision, 0, len(response.Revisions))
+	for _, protoRevision := range response.Revisions {
+		record, err := s.openResponse(protoRevision.GetRecord())
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, model.Revision{
+			ID:         protoRevision.Id,
+			Data:       *record,
+			Device:     protoRevision.Device,
+			CreatedAt:  protoRevision.CreatedAt.AsTime(),
+			ReplacedAt: protoRevision.ReplacedAt.AsTime(),
+			Deleted:    protoRevision.Deleted,
+		})
+	}
+	return revisions, nil
+}
+
+// RestoreRevision восстанавливает прежнюю версию записи и возвращает новую
+// версию записи. expectedVersion - текущая версия записи, 0 - запись удалена
+func (s *service) RestoreRevision(ctx context.Context, jwtToken string, revisionID int64,
+	expectedVersion int64) (int64, error) {
+	var response *dataService.RestoreRevisionResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		response, err = s.dataClient.RestoreRevision(ctx, &dataService.RestoreRevisionRequest{
+			Id:              revisionID,
+			ExpectedVersion: expectedVersion,
+		})
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		if mismatch := versionMismatch(err); mismatch != nil {
+			return 0, mismatch
+		}
+		return 0, err
+	}
+	return response.Version, nil
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"keeper/internal/client/service/mocks"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	dataService "keeper/internal/server/handlers/proto/dataService"
+)
+
+func TestClientServiceHistory(t *testing.T) {
+	mockServiceClient := new(mocks.DataServiceClient)
+	s := &service{
+		log:        logger.InitLog(logrus.InfoLevel),
+		dataClient: mockServiceClient,
+		device:     "laptop",
+	}
+	// вместе с токеном серверу передается имя устройства
+	ctx := metadata.NewOutgoingContext(context.Background(),
+		metadata.Pairs("token", "token", "device", "laptop"))
+
+	replacedAt := timestamppb.Now()
+	mockServiceClient.On("GetHistory", ctx, &dataService.GetHistoryRequest{DataKeyWord: "key"}).
+		Return(&dataService.GetHistoryResponse{Revisions: []*dataService.Revision{{
+			Id:         5,
+			Record:     &dataService.GetResponse{DataKeyWord: "key", Data: "old", Version: 2},
+			Device:     "phone",
+			CreatedAt:  replacedAt,
+			ReplacedAt: replacedAt,
+		}}}, nil).Once()
+
+	revisions, err := s.GetHistory(context.Background(), "token", "key")
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, int64(5), revisions[0].ID)
+	assert.Equal(t, "old", revisions[0].Data.Data)
+	assert.Equal(t, int64(2), revisions[0].Data.Version)
+	assert.Equal(t, "phone", revisions[0].Device)
+
+	mockServiceClient.On("RestoreRevision", ctx,
+		&dataService.RestoreRevisionRequest{Id: 5, ExpectedVersion: 3}).
+		Return(&dataService.RestoreRevisionResponse{Version: 4}, nil).Once()
+	version, err := s.RestoreRevision(context.Background(), "token", 5, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), version)
+
+	mismatch, err := status.New(codes.FailedPrecondition, "version mismatch").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason:   model.VersionMismatchReason,
+			Domain:   model.ErrorDomain,
+			Metadata: map[string]string{model.CurrentVersionMetadata: "6"},
+		})
+	require.NoError(t, err)
+	mockServiceClient.On("RestoreRevision", ctx,
+		&dataService.RestoreRevisionRequest{Id: 5, ExpectedVersion: 4}).
+		Return(nil, mismatch.Err()).Once()
+	_, err = s.RestoreRevision(context.Background(), "token", 5, 4)
+	var versionErr *model.VersionMismatchError
+	require.ErrorAs(t, err, &versionErr)
+	assert.Equal(t, int64(6), versionErr.Current)
+	mockServiceClient.AssertExpectations(t)
+}
@@ -6,6 +6,8 @@ import (
 	context "context"
 	authservice "keeper/internal/server/handlers/proto/authService"
 
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+
 	grpc "google.golang.org/grpc"
 
 	mock "github.com/stretchr/testify/mock"
@@ -16,6 +18,204 @@ type AuthServiceClient struct {
 	mock.Mock
 }
 
+// CompleteSecondFactor provides a mock function with given fields: ctx, in, opts
+func (_m *AuthServiceClient) CompleteSecondFactor(ctx context.Context, in *authservice.SecondFactorRequest, opts ...grpc.CallOption) (*authservice.AuthResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *authservice.AuthResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *authservice.SecondFactorRequest, ...grpc.CallOption) (*authservice.AuthResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *authservice.SecondFactorRequest, ...grpc.CallOption) *authservice.AuthResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*authservice.AuthResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *authservice.SecondFactorRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ConfirmTOTP provides a mock function with given fields: ctx, in, opts
+func (_m *AuthServiceClient) ConfirmTOTP(ctx context.Context, in *authservice.ConfirmTOTPRequest, opts ...grpc.CallOption) (*authservice.ConfirmTOTPResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *authservice.ConfirmTOTPResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *authservice.ConfirmTOTPRequest, ...grpc.CallOption) (*authservice.ConfirmTOTPResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *authservice.ConfirmTOTPRequest, ...grpc.CallOption) *authservice.ConfirmTOTPResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*authservice.ConfirmTOTPResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *authservice.ConfirmTOTPRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EnrollTOTP provides a mock function with given fields: ctx, in, opts
+func (_m *AuthServiceClient) EnrollTOTP(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*authservice.EnrollTOTPResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *authservice.EnrollTOTPResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) (*authservice.EnrollTOTPResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) *authservice.EnrollTOTPResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*authservice.EnrollTOTPResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Logout provides a mock function with given fields: ctx, in, opts
+func (_m *AuthServiceClient) Logout(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *emptypb.Empty
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) (*emptypb.Empty, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) *emptypb.Empty); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*emptypb.Empty)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LogoutAll provides a mock function with given fields: ctx, in, opts
+func (_m *AuthServiceClient) LogoutAll(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*authservice.LogoutAllResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *authservice.LogoutAllResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) (*authservice.LogoutAllResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) *authservice.LogoutAllResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*authservice.LogoutAllResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RefreshToken provides a mock function with given fields: ctx, in, opts
+func (_m *AuthServiceClient) RefreshToken(ctx context.Context, in *authservice.RefreshRequest, opts ...grpc.CallOption) (*authservice.RefreshResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *authservice.RefreshResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *authservice.RefreshRequest, ...grpc.CallOption) (*authservice.RefreshResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *authservice.RefreshRequest, ...grpc.CallOption) *authservice.RefreshResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*authservice.RefreshResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *authservice.RefreshRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // UserAuth provides a mock function with given fields: ctx, in, opts
 func (_m *AuthServiceClient) UserAuth(ctx context.Context, in *authservice.AuthRequest, opts ...grpc.CallOption) (*authservice.AuthResponse, error) {
 	_va := make([]interface{}, len(opts))
@@ -52,7 +52,7 @@ func (_m *DataServiceClient) AddData(ctx context.Context, in *dataservice.Adding
 }
 
 // ChangeData provides a mock function with given fields: ctx, in, opts
-func (_m *DataServiceClient) ChangeData(ctx context.Context, in *dataservice.ChangingRequest, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+func (_m *DataServiceClient) ChangeData(ctx context.Context, in *dataservice.ChangingRequest, opts ...grpc.CallOption) (*dataservice.ChangeResponse, error) {
 	_va := make([]interface{}, len(opts))
 	for _i := range opts {
 		_va[_i] = opts[_i]
@@ -62,16 +62,16 @@ func (_m *DataServiceClient) ChangeData(ctx context.Context, in *dataservice.Cha
 	_ca = append(_ca, _va...)
 	ret := _m.Called(_ca...)
 
-	var r0 *emptypb.Empty
+	var r0 *dataservice.ChangeResponse
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ChangingRequest, ...grpc.CallOption) (*emptypb.Empty, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ChangingRequest, ...grpc.CallOption) (*dataservice.ChangeResponse, error)); ok {
 		return rf(ctx, in, opts...)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ChangingRequest, ...grpc.CallOption) *emptypb.Empty); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ChangingRequest, ...grpc.CallOption) *dataservice.ChangeResponse); ok {
 		r0 = rf(ctx, in, opts...)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).(*emptypb.Empty)
+			r0 = ret.Get(0).(*dataservice.ChangeResponse)
 		}
 	}
 
@@ -117,6 +117,72 @@ func (_m *DataServiceClient) DeleteData(ctx context.Context, in *dataservice.Del
 	return r0, r1
 }
 
+// DownloadFile provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) DownloadFile(ctx context.Context, in *dataservice.DownloadRequest, opts ...grpc.CallOption) (dataservice.DataService_DownloadFileClient, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 dataservice.DataService_DownloadFileClient
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.DownloadRequest, ...grpc.CallOption) (dataservice.DataService_DownloadFileClient, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.DownloadRequest, ...grpc.CallOption) dataservice.DataService_DownloadFileClient); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(dataservice.DataService_DownloadFileClient)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *dataservice.DownloadRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EmptyTrash provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) EmptyTrash(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*dataservice.EmptyTrashResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.EmptyTrashResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) (*dataservice.EmptyTrashResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) *dataservice.EmptyTrashResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.EmptyTrashResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetData provides a mock function with given fields: ctx, in, opts
 func (_m *DataServiceClient) GetData(ctx context.Context, in *dataservice.GetRequest, opts ...grpc.CallOption) (*dataservice.GetResponseList, error) {
 	_va := make([]interface{}, len(opts))
@@ -150,6 +216,369 @@ func (_m *DataServiceClient) GetData(ctx context.Context, in *dataservice.GetReq
 	return r0, r1
 }
 
+// GetHistory provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) GetHistory(ctx context.Context, in *dataservice.GetHistoryRequest, opts ...grpc.CallOption) (*dataservice.GetHistoryResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.GetHistoryResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.GetHistoryRequest, ...grpc.CallOption) (*dataservice.GetHistoryResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.GetHistoryRequest, ...grpc.CallOption) *dataservice.GetHistoryResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.GetHistoryResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *dataservice.GetHistoryRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetVaultCheck provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) GetVaultCheck(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*dataservice.VaultCheck, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.VaultCheck
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) (*dataservice.VaultCheck, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) *dataservice.VaultCheck); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.VaultCheck)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListConflicts provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) ListConflicts(ctx context.Context, in *dataservice.ListConflictsRequest, opts ...grpc.CallOption) (*dataservice.ListConflictsResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.ListConflictsResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ListConflictsRequest, ...grpc.CallOption) (*dataservice.ListConflictsResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ListConflictsRequest, ...grpc.CallOption) *dataservice.ListConflictsResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.ListConflictsResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *dataservice.ListConflictsRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListData provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) ListData(ctx context.Context, in *dataservice.ListRequest, opts ...grpc.CallOption) (*dataservice.ListResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.ListResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ListRequest, ...grpc.CallOption) (*dataservice.ListResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ListRequest, ...grpc.CallOption) *dataservice.ListResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.ListResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *dataservice.ListRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListTrash provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) ListTrash(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*dataservice.ListTrashResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.ListTrashResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) (*dataservice.ListTrashResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) *dataservice.ListTrashResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.ListTrashResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *emptypb.Empty, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ResolveConflict provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) ResolveConflict(ctx context.Context, in *dataservice.ResolveConflictRequest, opts ...grpc.CallOption) (*dataservice.ResolveConflictResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.ResolveConflictResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ResolveConflictRequest, ...grpc.CallOption) (*dataservice.ResolveConflictResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.ResolveConflictRequest, ...grpc.CallOption) *dataservice.ResolveConflictResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.ResolveConflictResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *dataservice.ResolveConflictRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreFromTrash provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) RestoreFromTrash(ctx context.Context, in *dataservice.RestoreFromTrashRequest, opts ...grpc.CallOption) (*dataservice.RestoreFromTrashResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.RestoreFromTrashResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.RestoreFromTrashRequest, ...grpc.CallOption) (*dataservice.RestoreFromTrashResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.RestoreFromTrashRequest, ...grpc.CallOption) *dataservice.RestoreFromTrashResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.RestoreFromTrashResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *dataservice.RestoreFromTrashRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreRevision provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) RestoreRevision(ctx context.Context, in *dataservice.RestoreRevisionRequest, opts ...grpc.CallOption) (*dataservice.RestoreRevisionResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.RestoreRevisionResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.RestoreRevisionRequest, ...grpc.CallOption) (*dataservice.RestoreRevisionResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.RestoreRevisionRequest, ...grpc.CallOption) *dataservice.RestoreRevisionResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.RestoreRevisionResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *dataservice.RestoreRevisionRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetVaultCheck provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) SetVaultCheck(ctx context.Context, in *dataservice.VaultCheck, opts ...grpc.CallOption) (*emptypb.Empty, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *emptypb.Empty
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.VaultCheck, ...grpc.CallOption) (*emptypb.Empty, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.VaultCheck, ...grpc.CallOption) *emptypb.Empty); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*emptypb.Empty)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *dataservice.VaultCheck, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Sync provides a mock function with given fields: ctx, in, opts
+func (_m *DataServiceClient) Sync(ctx context.Context, in *dataservice.SyncRequest, opts ...grpc.CallOption) (*dataservice.SyncResponse, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx, in)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 *dataservice.SyncResponse
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.SyncRequest, ...grpc.CallOption) (*dataservice.SyncResponse, error)); ok {
+		return rf(ctx, in, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, *dataservice.SyncRequest, ...grpc.CallOption) *dataservice.SyncResponse); ok {
+		r0 = rf(ctx, in, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*dataservice.SyncResponse)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, *dataservice.SyncRequest, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, in, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UploadFile provides a mock function with given fields: ctx, opts
+func (_m *DataServiceClient) UploadFile(ctx context.Context, opts ...grpc.CallOption) (dataservice.DataService_UploadFileClient, error) {
+	_va := make([]interface{}, len(opts))
+	for _i := range opts {
+		_va[_i] = opts[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	var r0 dataservice.DataService_UploadFileClient
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, ...grpc.CallOption) (dataservice.DataService_UploadFileClient, error)); ok {
+		return rf(ctx, opts...)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, ...grpc.CallOption) dataservice.DataService_UploadFileClient); ok {
+		r0 = rf(ctx, opts...)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(dataservice.DataService_UploadFileClient)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, ...grpc.CallOption) error); ok {
+		r1 = rf(ctx, opts...)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewDataServiceClient creates a new instance of DataServiceClient. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewDataServiceClient(t interface {
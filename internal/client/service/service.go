@@ -2,55 +2,159 @@ package service
 
 import (
 	"context"
+	"fmt"
+	"keeper/internal/certs"
+	"keeper/internal/client/cache"
+	"keeper/internal/client/session"
 	"keeper/internal/model"
+	"keeper/internal/utils"
+	"net"
+	"os"
+	"strconv"
+	"strings"
 
+	"github.com/caarlos0/env"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 
 	authservice "keeper/internal/server/handlers/proto/authService"
 	dataService "keeper/internal/server/handlers/proto/dataService"
 )
 
 type service struct {
-	log            *logrus.Logger
-	connAuthClient *grpc.ClientConn
-	authClient     authservice.AuthServiceClient
-	connDataClient *grpc.ClientConn
-	dataClient     dataService.DataServiceClient
+	log *logrus.Logger
+	// conn - соединение с сервером, оба сервиса работают через него
+	conn    *grpc.ClientConn
+	address string
+	// tlsConfig - настройки TLS из переменных окружения, имя сервера
+	// без KEEPER_SERVER_NAME берется из адреса подключения
+	tlsConfig  model.ClientTLS
+	authClient authservice.AuthServiceClient
+	dataClient dataService.DataServiceClient
+	// login - логин аутентифицированного пользователя
+	login string
+	// vaultKey - ключ хранилища для сквозного шифрования,
+	// пустой, если мастер-пароль не введен
+	vaultKey []byte
+	// vaultRequired - у пользователя есть проверочное значение хранилища,
+	// поэтому без мастер-пароля данные не отправляются открытыми
+	vaultRequired bool
+	// refreshToken - токен обновления, полученный при входе
+	refreshToken string
+	// renewedTokens - токены доступа, выданные взамен истекших
+	renewedTokens map[string]string
+	// challenge - незавершенный вход, ожидающий код второго фактора
+	challenge      string
+	challengeLogin string
+	// challengeCacheKey - ключ локальной копии для незавершенного входа
+	challengeCacheKey []byte
+	// cache - зашифрованная локальная копия записей, nil, если файл
+	// копии открыть не удалось
+	cache *cache.Cache
+	// device - имя устройства, сервер сохраняет его в истории записей
+	device string
+	// session - файл с сессией для следующих запусков клиента,
+	// nil, если сессию сохранять не нужно
+	session *session.Store
 }
 
-// GetService устанавливает клиентские соединения с gRPC серверами аутентификации и взаимодействия с данными
-// и возвращает их в качестве полей структуры service
+// GetService устанавливает соединение с gRPC сервером по адресу из переменной
+// окружения KEEPER_SERVER или адресу по умолчанию и возвращает структуру service
 func GetService(l *logrus.Logger) (*service, error) {
 	var service service
 	var err error
 	service.log = l
 
-	service.connAuthClient, err = grpc.Dial(":9090", grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
+	if err = env.Parse(&service.tlsConfig); err != nil {
 		l.Error(err.Error())
 		return nil, err
 	}
 
-	service.authClient = authservice.NewAuthServiceClient(service.connAuthClient)
+	address := os.Getenv("KEEPER_SERVER")
+	if address == "" {
+		address = model.DefaultServerAddress
+	}
+	if err = service.Connect(address); err != nil {
+		return nil, err
+	}
 
-	service.connDataClient, err = grpc.Dial(":9091", grpc.WithTransportCredentials(insecure.NewCredentials()))
+	service.device, err = os.Hostname()
 	if err != nil {
-		l.Error(err.Error())
-		return nil, err
+		l.Warn("Не удалось определить имя устройства: " + err.Error())
 	}
 
-	service.dataClient = dataService.NewDataServiceClient(service.connDataClient)
+	service.session = session.NewStore(session.DefaultPath(), l)
+
+	// без локальной копии клиент работает только при связи с сервером
+	service.cache, err = cache.Open(cache.DefaultPath(), l)
+	if err != nil {
+		l.Warn("Локальная копия недоступна, работа без связи с сервером невозможна")
+	}
 
 	return &service, nil
 }
 
-// Close закрывает клиентские соединения с gRPC серверами аутентификации и взаимодействия с данными
+// Connect подключается к серверу по адресу address: host:port или
+// unix:///путь/к/сокету. Прежнее соединение закрывается
+func (s *service) Connect(address string) error {
+	if s.conn != nil && s.address == address {
+		return nil
+	}
+	tlsConfig := s.tlsConfig
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = serverName(address)
+	}
+	creds, err := certs.ClientCredentials(tlsConfig)
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	s.conn, s.address = conn, address
+	s.authClient = authservice.NewAuthServiceClient(conn)
+	s.dataClient = dataService.NewDataServiceClient(conn)
+	return nil
+}
+
+// serverName возвращает имя сервера для проверки сертификата по адресу
+// подключения. Для сокета Unix и адреса без хоста это localhost
+func serverName(address string) string {
+	if strings.HasPrefix(address, "unix:") {
+		return "localhost"
+	}
+	if i := strings.Index(address, ":///"); i >= 0 {
+		address = address[i+len(":///"):]
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	if host == "" {
+		return "localhost"
+	}
+	return host
+}
+
+// Close закрывает соединение с сервером и локальную копию
 func (s *service) Close() {
-	s.connAuthClient.Close()
-	s.connDataClient.Close()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	if s.cache != nil {
+		s.cache.Close()
+	}
 }
 
 // Register передает введенные пользователем логин и пароль в
@@ -65,72 +169,575 @@ func (s *service) Register(ctx context.Context, login string, password string) (
 	if err != nil {
 		return "", err
 	}
+	s.setLogin(login)
+	s.refreshToken = resp.RefreshToken
+	s.openCache(ctx, resp.JwtToken, utils.DeriveCacheKey(login, password))
+	s.saveSession(resp.JwtToken)
 	return resp.JwtToken, err
 }
 
 // Auth передает введенные пользователем логин и пароль в
-// в метод аутентификации gRPC сервера, получает jwt токен
+// в метод аутентификации gRPC сервера, получает jwt токен.
+// Если сервер недоступен, открывает локальную копию и возвращает model.OfflineToken
 func (s *service) Auth(ctx context.Context, login string, password string) (string, error) {
 	requestAuth := &authservice.AuthRequest{
 		Login:    login,
 		Password: password,
 	}
 
+	cacheKey := utils.DeriveCacheKey(login, password)
 	resp, err := s.authClient.UserAuth(ctx, requestAuth)
 	if err != nil {
+		if status.Code(err) == codes.Unavailable && s.cache != nil {
+			return s.authOffline(login, cacheKey)
+		}
 		return "", err
 	}
+	if resp.SecondFactorRequired {
+		s.challenge = resp.Challenge
+		s.challengeLogin = login
+		s.challengeCacheKey = cacheKey
+		return "", model.ErrSecondFactorRequired
+	}
+	s.setLogin(login)
+	s.refreshToken = resp.RefreshToken
+	s.openCache(ctx, resp.JwtToken, cacheKey)
+	s.saveSession(resp.JwtToken)
 	return resp.JwtToken, err
 }
 
+// CompleteSecondFactor передает код второго фактора для завершения входа,
+// начатого в Auth, получает jwt токен
+func (s *service) CompleteSecondFactor(ctx context.Context, code string) (string, error) {
+	if s.challenge == "" {
+		return "", model.ErrNoAuthentification
+	}
+	resp, err := s.authClient.CompleteSecondFactor(ctx, &authservice.SecondFactorRequest{
+		Challenge: s.challenge,
+		Code:      code,
+	})
+	if err != nil {
+		return "", err
+	}
+	s.setLogin(s.challengeLogin)
+	s.refreshToken = resp.RefreshToken
+	s.openCache(ctx, resp.JwtToken, s.challengeCacheKey)
+	s.challenge, s.challengeLogin, s.challengeCacheKey = "", "", nil
+	s.saveSession(resp.JwtToken)
+	return resp.JwtToken, nil
+}
+
+// EnrollTOTP запрашивает у сервера секрет для приложения-аутентификатора
+func (s *service) EnrollTOTP(ctx context.Context, jwtToken string) (model.TOTPEnrollment, error) {
+	var resp *authservice.EnrollTOTPResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		resp, err = s.authClient.EnrollTOTP(ctx, &emptypb.Empty{})
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		return model.TOTPEnrollment{}, err
+	}
+	return model.TOTPEnrollment{
+		Secret: resp.Secret,
+		URI:    resp.OtpauthURI,
+	}, nil
+}
+
+// ConfirmTOTP передает первый код из приложения-аутентификатора,
+// получает коды восстановления
+func (s *service) ConfirmTOTP(ctx context.Context, jwtToken string, code string) ([]string, error) {
+	var resp *authservice.ConfirmTOTPResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		resp, err = s.authClient.ConfirmTOTP(ctx, &authservice.ConfirmTOTPRequest{Code: code})
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	return resp.RecoveryCodes, nil
+}
+
+// withToken добавляет jwt токен в метаданные запроса и выполняет его.
+// Если сервер отвечает Unauthenticated, токен доступа обновляется
+// и запрос повторяется один раз. С model.OfflineToken запрос не выполняется
+// и возвращается ошибка Unavailable
+func (s *service) withToken(ctx context.Context, jwtToken string,
+	call func(ctx context.Context) error) error {
+	if jwtToken == model.OfflineToken {
+		return status.Error(codes.Unavailable, model.ErrOffline.Error())
+	}
+	token := jwtToken
+	if renewed, ok := s.renewedTokens[jwtToken]; ok {
+		token = renewed
+	}
+
+	err := call(s.outgoingContext(ctx, token))
+	if status.Code(err) != codes.Unauthenticated || s.refreshToken == "" {
+		return err
+	}
+
+	s.log.Debug("Обновляем токен доступа")
+	resp, refreshErr := s.authClient.RefreshToken(ctx, &authservice.RefreshRequest{
+		RefreshToken: s.refreshToken,
+	})
+	if refreshErr != nil {
+		s.log.Error(refreshErr.Error())
+		s.refreshToken = ""
+		if status.Code(refreshErr) == codes.Unauthenticated {
+			// токен обновления истек или отозван, сохраненная сессия больше не нужна
+			s.removeSession()
+		}
+		return err
+	}
+	s.refreshToken = resp.RefreshToken
+	if s.renewedTokens == nil {
+		s.renewedTokens = make(map[string]string)
+	}
+	s.renewedTokens[jwtToken] = resp.JwtToken
+	s.saveSession(resp.JwtToken)
+
+	return call(s.outgoingContext(ctx, resp.JwtToken))
+}
+
+// outgoingContext добавляет в метаданные запроса jwt токен и имя устройства
+func (s *service) outgoingContext(ctx context.Context, token string) context.Context {
+	md := metadata.Pairs("token", token)
+	if s.device != "" {
+		md.Set("device", s.device)
+	}
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// Logout завершает текущую сессию на сервере и забывает токены
+// и ключ хранилища. Если сервер уже не принимает токен, сессия
+// завершается только на клиенте
+func (s *service) Logout(ctx context.Context, jwtToken string) error {
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		_, err := s.authClient.Logout(ctx, &emptypb.Empty{})
+		return err
+	})
+	if status.Code(err) == codes.Unauthenticated {
+		s.forget()
+		return nil
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	s.forget()
+	return nil
+}
+
+// LogoutAll завершает все сессии пользователя на всех устройствах,
+// возвращает количество завершенных сессий
+func (s *service) LogoutAll(ctx context.Context, jwtToken string) (int64, error) {
+	var resp *authservice.LogoutAllResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		resp, err = s.authClient.LogoutAll(ctx, &emptypb.Empty{})
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, err
+	}
+	s.forget()
+	return resp.RevokedSessions, nil
+}
+
+// forget сбрасывает сведения о вошедшем пользователе
+func (s *service) forget() {
+	s.login = ""
+	s.vaultKey, s.vaultRequired = nil, false
+	s.refreshToken = ""
+	s.renewedTokens = nil
+	if s.cache != nil {
+		s.cache.Lock()
+	}
+	s.removeSession()
+}
+
+// RestoreSession продолжает сессию, сохраненную при прошлом запуске
+// клиента, возвращает jwt токен. Если login задан, сессия должна
+// принадлежать этому пользователю. Локальная копия при этом остается
+// закрытой, для работы без связи с сервером нужно пройти аутентификацию
+func (s *service) RestoreSession(login string) (string, error) {
+	if s.session == nil {
+		return "", model.ErrNoSession
+	}
+	saved, err := s.session.Load()
+	if err != nil {
+		return "", err
+	}
+	if login != "" && saved.Login != login {
+		return "", fmt.Errorf("%w: сохранена сессия другого пользователя, укажите пароль", model.ErrNoAuthentification)
+	}
+	s.setLogin(saved.Login)
+	s.refreshToken = saved.RefreshToken
+	return saved.JwtToken, nil
+}
+
+// saveSession сохраняет сессию для следующих запусков клиента.
+// Без сохраненной сессии клиент продолжает работать
+func (s *service) saveSession(jwtToken string) {
+	if s.session == nil || jwtToken == model.OfflineToken {
+		return
+	}
+	err := s.session.Save(model.ClientSession{
+		Login:        s.login,
+		JwtToken:     jwtToken,
+		RefreshToken: s.refreshToken,
+	})
+	if err != nil {
+		s.log.Warn("Не удалось сохранить сессию: " + err.Error())
+	}
+}
+
+// removeSession удаляет сохраненную сессию
+func (s *service) removeSession() {
+	if s.session != nil {
+		s.session.Remove()
+	}
+}
+
+// setLogin запоминает логин пользователя. При смене пользователя
+// ключ хранилища сбрасывается
+func (s *service) setLogin(login string) {
+	if s.login != login {
+		s.vaultKey, s.vaultRequired = nil, false
+	}
+	s.login = login
+}
+
+// Resume продолжает работу с токеном, полученным при прошлом входе.
+// Логин нужен, чтобы получить ключ хранилища из мастер-пароля
+func (s *service) Resume(login string) {
+	s.setLogin(login)
+}
+
+// UnlockVault получает ключ хранилища из мастер-пароля и сверяет его
+// с проверочным значением хранилища. При первом вводе мастер-пароля
+// проверочное значение сохраняется на сервере. Пока ключ задан, данные
+// шифруются на клиенте и сервер хранит только шифротекст. Пустой
+// мастер-пароль отключает сквозное шифрование, только если проверочного
+// значения еще нет, иначе возвращается model.ErrVaultLocked
+func (s *service) UnlockVault(ctx context.Context, jwtToken string, masterPassword string) error {
+	if s.login == "" {
+		return model.ErrNoAuthentification
+	}
+	check, err := s.getVaultCheck(ctx, jwtToken)
+	if err != nil {
+		return err
+	}
+	s.vaultKey, s.vaultRequired = nil, check != nil
+	if masterPassword == "" {
+		if s.vaultRequired {
+			return model.ErrVaultLocked
+		}
+		return nil
+	}
+
+	vaultKey := utils.DeriveVaultKey(s.login, masterPassword)
+	if check == nil {
+		check, err = s.setVaultCheck(ctx, jwtToken, vaultKey)
+		if err != nil {
+			return err
+		}
+	}
+	if err = utils.VerifyVaultCheck(check, vaultKey); err != nil {
+		return err
+	}
+	s.vaultKey, s.vaultRequired = vaultKey, true
+	return nil
+}
+
+// getVaultCheck получает проверочное значение хранилища с сервера,
+// а без связи с сервером - из локальной копии. Возвращает nil, если
+// мастер-пароль еще не задан
+func (s *service) getVaultCheck(ctx context.Context, jwtToken string) ([]byte, error) {
+	var resp *dataService.VaultCheck
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		resp, err = s.dataClient.GetVaultCheck(ctx, &emptypb.Empty{})
+		return err
+	})
+	if s.offline(err) {
+		return s.cache.VaultCheck()
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	if len(resp.EncryptedData) == 0 {
+		return nil, nil
+	}
+	s.cacheVaultCheck(resp.EncryptedData)
+	return resp.EncryptedData, nil
+}
+
+// setVaultCheck сохраняет на сервере проверочное значение для нового
+// мастер-пароля и возвращает проверочное значение хранилища. Если другое
+// устройство успело сохранить свое значение, возвращается оно
+func (s *service) setVaultCheck(ctx context.Context, jwtToken string,
+	vaultKey []byte) ([]byte, error) {
+	// записи, зашифрованные до появления проверочного значения,
+	// не дают задать мастер-пароль, которым их не расшифровать
+	if err := s.verifySealedRecord(vaultKey); err != nil {
+		return nil, err
+	}
+	check, err := utils.NewVaultCheck(vaultKey)
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	err = s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		_, err := s.dataClient.SetVaultCheck(ctx, &dataService.VaultCheck{EncryptedData: check})
+		return err
+	})
+	switch {
+	case status.Code(err) == codes.AlreadyExists:
+		return s.getVaultCheck(ctx, jwtToken)
+	case s.offline(err):
+		s.log.Warn("Сервер недоступен, мастер-пароль будет проверен при следующем unlock")
+		return check, nil
+	case err != nil:
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	s.cacheVaultCheck(check)
+	return check, nil
+}
+
+// verifySealedRecord проверяет ключ хранилища на записи локальной копии,
+// зашифрованной на клиенте. Без таких записей проверка не выполняется
+func (s *service) verifySealedRecord(vaultKey []byte) error {
+	if s.cache == nil || !s.cache.Unlocked() {
+		return nil
+	}
+	records, err := s.cache.Records()
+	if err != nil {
+		s.log.Warn("Не удалось прочитать локальную копию: " + err.Error())
+		return nil
+	}
+	for _, record := range records {
+		if len(record.Data.EncryptedData) > 0 {
+			_, err = utils.VaultDecipher(record.Data.EncryptedData, vaultKey)
+			return err
+		}
+	}
+	return nil
+}
+
+// cacheVaultCheck сохраняет проверочное значение в локальной копии,
+// чтобы проверять мастер-пароль без связи с сервером
+func (s *service) cacheVaultCheck(check []byte) {
+	if s.cache == nil || !s.cache.Unlocked() {
+		return
+	}
+	if err := s.cache.SetVaultCheck(check); err != nil {
+		s.log.Warn("Не удалось сохранить проверочное значение хранилища: " + err.Error())
+	}
+}
+
+// sealData шифрует данные ключом хранилища, если сквозное шифрование включено
+func (s *service) sealData(data string) (string, []byte, error) {
+	if s.vaultKey == nil {
+		if s.vaultRequired {
+			return "", nil, model.ErrVaultLocked
+		}
+		return data, nil, nil
+	}
+	encryptedData, err := utils.VaultCipher(data, s.vaultKey)
+	if err != nil {
+		s.log.Error(err.Error())
+		return "", nil, err
+	}
+	return "", encryptedData, nil
+}
+
+// openData расшифровывает данные, зашифрованные на клиенте
+func (s *service) openData(data string, encryptedData []byte) (string, error) {
+	if len(encryptedData) == 0 {
+		return data, nil
+	}
+	if s.vaultKey == nil {
+		return "", model.ErrVaultLocked
+	}
+	return utils.VaultDecipher(encryptedData, s.vaultKey)
+}
+
+// sealPayload готовит типизированную запись к отправке: при включенном
+// сквозном шифровании сериализует и шифрует ее на клиенте
+func (s *service) sealPayload(payload *model.Payload) (string, []byte, *dataService.Payload, error) {
+	if err := payload.Validate(); err != nil {
+		return "", nil, nil, err
+	}
+	if s.vaultKey == nil {
+		if s.vaultRequired {
+			return "", nil, nil, model.ErrVaultLocked
+		}
+		return "", nil, dataService.PayloadFromModel(payload), nil
+	}
+	encoded, err := payload.Encode()
+	if err != nil {
+		return "", nil, nil, err
+	}
+	_, encryptedData, err := s.sealData(encoded)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return payload.Type(), encryptedData, nil, nil
+}
+
 // Add передает введенные пользователем данные в RPC метод добавления данных
 func (s *service) Add(ctx context.Context, jwtToken string, data model.DataBlock) error {
 
 	requestAdd := &dataService.AddingRequest{
 		DataKeyWord: data.DataKeyWord,
-		Data:        data.Data,
 		MetaData:    data.MetaData,
 	}
+	var err error
+	if data.Payload != nil {
+		requestAdd.DataType, requestAdd.EncryptedData, requestAdd.Payload, err =
+			s.sealPayload(data.Payload)
+	} else {
+		requestAdd.Data, requestAdd.EncryptedData, err = s.sealData(data.Data)
+	}
+	if err != nil {
+		return err
+	}
 
-	md := metadata.Pairs("token", jwtToken)
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	_, err := s.dataClient.AddData(ctx, requestAdd)
+	err = s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		_, err := s.dataClient.AddData(ctx, requestAdd)
+		return err
+	})
 	if err != nil {
+		if s.offline(err) {
+			return s.saveOffline(model.SyncOperation{Data: recordFromRequest(requestAdd)})
+		}
 		s.log.Error(err.Error())
+		return err
 	}
-
-	return err
+	s.cacheRecord(recordFromRequest(requestAdd), true)
+	return nil
 }
 
 // Get передает введенный пользователем ключ для идентификации данных в RPC метод получения данных,
-// получает данные и метаданные
+// получает данные и метаданные. Без связи с сервером запись берется из локальной копии
 func (s *service) Get(ctx context.Context, jwtToken string, dataKeyWord string) ([]model.DataBlock, error) {
 
 	requestGet := &dataService.GetRequest{
 		DataKeyWord: dataKeyWord,
 	}
 
-	md := metadata.Pairs("token", jwtToken)
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	responseList, err := s.dataClient.GetData(ctx, requestGet)
+	var responseList *dataService.GetResponseList
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		responseList, err = s.dataClient.GetData(ctx, requestGet)
+		return err
+	})
 	if err != nil {
+		if s.offline(err) {
+			return s.getCached(dataKeyWord)
+		}
 		return nil, err
 	}
 
 	var data []model.DataBlock
 
 	for _, resp := range responseList.Response {
-		data = append(data, model.DataBlock{
-			DataKeyWord: resp.DataKeyWord,
-			Data:        resp.Data,
-			MetaData:    resp.MetaData,
-		})
+		record := recordFromResponse(resp)
+		s.cacheRecord(record, false)
+		dataBlock, err := s.openRecord(record)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, dataBlock)
 	}
 	return data, nil
 }
 
+// openRecord расшифровывает запись в том виде, в котором ее хранит сервер
+func (s *service) openRecord(record model.DataBlock) (model.DataBlock, error) {
+	plainData, err := s.openData(record.Data, record.EncryptedData)
+	if err != nil {
+		s.log.Error(err.Error())
+		return model.DataBlock{}, err
+	}
+	dataBlock := model.DataBlock{
+		DataKeyWord: record.DataKeyWord,
+		DataType:    record.DataType,
+		Data:        plainData,
+		MetaData:    record.MetaData,
+		Payload:     record.Payload,
+		Version:     record.Version,
+	}
+	// типизированная запись, зашифрованная на клиенте
+	if len(record.EncryptedData) > 0 && model.IsKnownDataType(record.DataType) {
+		dataBlock.Payload, err = model.DecodePayload(record.DataType, plainData)
+		if err != nil {
+			s.log.Error(err.Error())
+			return model.DataBlock{}, err
+		}
+		dataBlock.Data = ""
+	}
+	return dataBlock, nil
+}
+
+// ListData передает фильтры и параметры сортировки в RPC метод получения
+// списка записей, возвращает заголовки записей и токен следующей страницы.
+// Без связи с сервером список строится по локальной копии одной страницей
+func (s *service) ListData(ctx context.Context, jwtToken string,
+	query model.ListQuery) ([]model.DataHeader, string, error) {
+
+	requestList := &dataService.ListRequest{
+		DataType:   query.DataType,
+		KeyPrefix:  query.KeyPrefix,
+		SortBy:     query.SortBy,
+		Descending: query.Descending,
+		PageSize:   query.PageSize,
+		PageToken:  query.PageToken,
+	}
+
+	var response *dataService.ListResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		response, err = s.dataClient.ListData(ctx, requestList)
+		return err
+	})
+	if err != nil {
+		if s.offline(err) {
+			headers, err := s.listCached(query)
+			return headers, "", err
+		}
+		s.log.Error(err.Error())
+		return nil, "", err
+	}
+
+	headers := make([]model.DataHeader, 0, len(response.Headers))
+	for _, header := range response.Headers {
+		headers = append(headers, headerFromProto(header))
+	}
+	return headers, response.NextPageToken, nil
+}
+
+// headerFromProto преобразует заголовок записи из сообщения protobuf
+func headerFromProto(header *dataService.DataHeader) model.DataHeader {
+	return model.DataHeader{
+		DataKeyWord: header.GetDataKeyWord(),
+		DataType:    header.GetDataType(),
+		MetaData:    header.GetMetaData(),
+		CreatedAt:   header.GetCreatedAt().AsTime(),
+		UpdatedAt:   header.GetUpdatedAt().AsTime(),
+		Size:        header.GetSize(),
+		Version:     header.GetVersion(),
+	}
+}
+
 // Delete передает введенный пользователем ключ для идентификации данных в RPC метод удаления данных
 func (s *service) Delete(ctx context.Context, jwtToken string, dataKeyWord string) error {
 
@@ -138,31 +745,98 @@ func (s *service) Delete(ctx context.Context, jwtToken string, dataKeyWord strin
 		DataKeyWord: dataKeyWord,
 	}
 
-	md := metadata.Pairs("token", jwtToken)
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	_, err := s.dataClient.DeleteData(ctx, requestDelete)
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		_, err := s.dataClient.DeleteData(ctx, requestDelete)
+		return err
+	})
 	if err != nil {
+		if s.offline(err) {
+			return s.saveOffline(model.SyncOperation{
+				Data:    model.DataBlock{DataKeyWord: dataKeyWord},
+				Deleted: true,
+			})
+		}
 		s.log.Error(err.Error())
+		return err
 	}
-	return err
+	s.uncacheRecord(dataKeyWord)
+	return nil
 }
 
-// Change передает данные для изменения в RPC метод для изменения данных
+// Change передает данные для изменения в RPC метод для изменения данных.
+// data.Version - версия записи, полученная клиентом. Если запись с тех пор
+// изменилась, возвращается *model.VersionMismatchError. Запись с файлом
+// из локальной копии не изменяется, возвращается model.ErrFileReadOnly
 func (s *service) Change(ctx context.Context, jwtToken string, data model.DataBlock) error {
+	if s.cache != nil && s.cache.Unlocked() {
+		cached, err := s.cache.GetRecord(data.DataKeyWord)
+		if err == nil && cached.Header.DataType == model.DataTypeFile {
+			return model.ErrFileReadOnly
+		}
+	}
 
 	requestChange := &dataService.ChangingRequest{
 		DataKeyWord:       data.DataKeyWord,
-		DataForChange:     data.Data,
 		MetaDataForChange: data.MetaData,
+		ExpectedVersion:   data.Version,
+	}
+	var err error
+	if data.Payload != nil {
+		requestChange.DataTypeForChange, requestChange.EncryptedDataForChange,
+			requestChange.PayloadForChange, err = s.sealPayload(data.Payload)
+	} else {
+		requestChange.DataForChange, requestChange.EncryptedDataForChange, err =
+			s.sealData(data.Data)
+	}
+	if err != nil {
+		return err
 	}
 
-	md := metadata.Pairs("token", jwtToken)
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	_, err := s.dataClient.ChangeData(ctx, requestChange)
+	var response *dataService.ChangeResponse
+	err = s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		response, err = s.dataClient.ChangeData(ctx, requestChange)
+		return err
+	})
+	record := model.DataBlock{
+		DataKeyWord:   requestChange.DataKeyWord,
+		DataType:      requestChange.DataTypeForChange,
+		Data:          requestChange.DataForChange,
+		EncryptedData: requestChange.EncryptedDataForChange,
+		MetaData:      requestChange.MetaDataForChange,
+		Payload:       dataService.PayloadToModel(requestChange.PayloadForChange),
+	}
 	if err != nil {
+		if s.offline(err) {
+			return s.saveOffline(model.SyncOperation{Data: record})
+		}
 		s.log.Error(err.Error())
+		if mismatch := versionMismatch(err); mismatch != nil {
+			return mismatch
+		}
+		return err
+	}
+	record.Version = response.GetVersion()
+	s.cacheRecord(record, true)
+	return nil
+}
+
+// versionMismatch извлекает текущую версию записи из ответа FailedPrecondition
+func versionMismatch(err error) error {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.FailedPrecondition {
+		return nil
+	}
+	for _, detail := range st.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok || info.Reason != model.VersionMismatchReason {
+			continue
+		}
+		current, err := strconv.ParseInt(info.Metadata[model.CurrentVersionMetadata], 10, 64)
+		if err != nil {
+			return nil
+		}
+		return &model.VersionMismatchError{Current: current}
 	}
-	return err
+	return nil
 }
@@ -3,16 +3,25 @@ package service
 import (
 	"context"
 	"keeper/internal/client/service/mocks"
+	"keeper/internal/client/session"
 	"keeper/internal/logger"
 	"keeper/internal/model"
 	authservice "keeper/internal/server/handlers/proto/authService"
 	dataService "keeper/internal/server/handlers/proto/dataService"
 	dataservice "keeper/internal/server/handlers/proto/dataService"
+	"keeper/internal/utils"
+	"path/filepath"
 	"testing"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 )
 
@@ -31,7 +40,6 @@ func TestClientServiceRegister(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешная отправка запроса на регистрацию",
 			s: &service{
@@ -83,7 +91,6 @@ func TestClientServiceAuth(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешная отправка запроса на аутентификацию",
 			s: &service{
@@ -134,7 +141,6 @@ func TestClientServiceAdd(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешная отправка запроса на добавление данных",
 			s: &service{
@@ -173,6 +179,184 @@ func TestClientServiceAdd(t *testing.T) {
 	}
 }
 
+func TestClientServiceVault(t *testing.T) {
+	mockServiceClient := new(mocks.DataServiceClient)
+	s := &service{
+		log:        logger.InitLog(logrus.InfoLevel),
+		dataClient: mockServiceClient,
+		login:      "user",
+	}
+	ctx := context.Background()
+	outgoingCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs("token", "token"))
+
+	// первый ввод мастер-пароля сохраняет проверочное значение на сервере
+	var check []byte
+	mockServiceClient.On("GetVaultCheck", outgoingCtx, &emptypb.Empty{}).
+		Return(&dataservice.VaultCheck{}, nil).Once()
+	mockServiceClient.On("SetVaultCheck", outgoingCtx, mock.MatchedBy(
+		func(in *dataservice.VaultCheck) bool {
+			check = in.EncryptedData
+			return utils.IsClientSealed(in.EncryptedData)
+		})).Return(&emptypb.Empty{}, nil).Once()
+
+	err := s.UnlockVault(ctx, "token", "master")
+	assert.NoError(t, err)
+
+	var sent *dataservice.AddingRequest
+	mockServiceClient.On("AddData", outgoingCtx, mock.MatchedBy(
+		func(in *dataservice.AddingRequest) bool {
+			sent = in
+			return in.Data == "" && len(in.EncryptedData) > 0
+		})).Return(&emptypb.Empty{}, nil)
+
+	err = s.Add(ctx, "token", model.DataBlock{
+		DataKeyWord: "key",
+		Data:        "secret data",
+	})
+	assert.NoError(t, err)
+
+	mockServiceClient.On("GetData", outgoingCtx, &dataservice.GetRequest{DataKeyWord: "key"}).
+		Return(&dataservice.GetResponseList{
+			Response: []*dataservice.GetResponse{
+				{
+					DataKeyWord:   "key",
+					EncryptedData: sent.EncryptedData,
+				},
+			},
+		}, nil)
+
+	got, err := s.Get(ctx, "token", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret data", got[0].Data)
+
+	mockServiceClient.On("GetVaultCheck", outgoingCtx, &emptypb.Empty{}).
+		Return(func(context.Context, *emptypb.Empty, ...grpc.CallOption) *dataservice.VaultCheck {
+			return &dataservice.VaultCheck{EncryptedData: check}
+		}, nil)
+
+	// без мастер-пароля данные недоступны и не отправляются открытыми
+	err = s.UnlockVault(ctx, "token", "")
+	assert.ErrorIs(t, err, model.ErrVaultLocked)
+	_, err = s.Get(ctx, "token", "key")
+	assert.ErrorIs(t, err, model.ErrVaultLocked)
+	err = s.Add(ctx, "token", model.DataBlock{DataKeyWord: "other", Data: "secret data"})
+	assert.ErrorIs(t, err, model.ErrVaultLocked)
+
+	// неверный мастер-пароль не принимается
+	err = s.UnlockVault(ctx, "token", "wrong")
+	assert.ErrorIs(t, err, model.ErrWrongMasterPassword)
+	_, err = s.Get(ctx, "token", "key")
+	assert.ErrorIs(t, err, model.ErrVaultLocked)
+
+	err = s.UnlockVault(ctx, "token", "master")
+	assert.NoError(t, err)
+	got, err = s.Get(ctx, "token", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret data", got[0].Data)
+	mockServiceClient.AssertNumberOfCalls(t, "SetVaultCheck", 1)
+}
+
+func TestClientServiceRefreshToken(t *testing.T) {
+	mockDataClient := new(mocks.DataServiceClient)
+	mockAuthClient := new(mocks.AuthServiceClient)
+	s := &service{
+		log:          logger.InitLog(logrus.InfoLevel),
+		authClient:   mockAuthClient,
+		dataClient:   mockDataClient,
+		login:        "user",
+		refreshToken: "refresh",
+	}
+	ctx := context.Background()
+	expiredCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs("token", "expired"))
+	renewedCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs("token", "renewed"))
+	request := &dataservice.DeletionRequest{DataKeyWord: "key"}
+
+	mockDataClient.On("DeleteData", expiredCtx, request).
+		Return(nil, status.Error(codes.Unauthenticated, "token is expired")).Once()
+	mockAuthClient.On("RefreshToken", ctx, &authservice.RefreshRequest{RefreshToken: "refresh"}).
+		Return(&authservice.RefreshResponse{
+			JwtToken:     "renewed",
+			RefreshToken: "refresh2",
+		}, nil).Once()
+	mockDataClient.On("DeleteData", renewedCtx, request).Return(&emptypb.Empty{}, nil)
+
+	err := s.Delete(ctx, "expired", "key")
+	assert.NoError(t, err)
+	assert.Equal(t, "refresh2", s.refreshToken)
+
+	// следующий запрос сразу использует обновленный токен
+	err = s.Delete(ctx, "expired", "key")
+	assert.NoError(t, err)
+
+	mockDataClient.AssertExpectations(t)
+	mockAuthClient.AssertExpectations(t)
+}
+
+func TestClientServiceLogout(t *testing.T) {
+	mockAuthClient := new(mocks.AuthServiceClient)
+	s := &service{
+		log:          logger.InitLog(logrus.InfoLevel),
+		authClient:   mockAuthClient,
+		login:        "user",
+		vaultKey:     []byte("vault key"),
+		refreshToken: "refresh",
+	}
+	ctx := context.Background()
+	outgoingCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs("token", "token"))
+
+	mockAuthClient.On("LogoutAll", outgoingCtx, &emptypb.Empty{}).
+		Return(&authservice.LogoutAllResponse{RevokedSessions: 3}, nil).Once()
+
+	revoked, err := s.LogoutAll(ctx, "token")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), revoked)
+	// после выхода токен обновления и ключ хранилища забыты
+	assert.Empty(t, s.refreshToken)
+	assert.Nil(t, s.vaultKey)
+	mockAuthClient.AssertExpectations(t)
+}
+
+func TestClientServiceSession(t *testing.T) {
+	mockAuthClient := new(mocks.AuthServiceClient)
+	log := logger.InitLog(logrus.InfoLevel)
+	store := session.NewStore(filepath.Join(t.TempDir(), "session"), log)
+	s := &service{
+		log:        log,
+		authClient: mockAuthClient,
+		session:    store,
+	}
+	ctx := context.Background()
+
+	mockAuthClient.On("UserAuth", ctx, &authservice.AuthRequest{Login: "user", Password: "password"}).
+		Return(&authservice.AuthResponse{JwtToken: "token", RefreshToken: "refresh"}, nil).Once()
+	_, err := s.Auth(ctx, "user", "password")
+	require.NoError(t, err)
+
+	// следующий запуск клиента продолжает сохраненную сессию
+	restarted := &service{log: log, authClient: mockAuthClient, session: store}
+	// сессия другого пользователя не продолжается
+	_, err = restarted.RestoreSession("other")
+	assert.ErrorIs(t, err, model.ErrNoAuthentification)
+	assert.Empty(t, restarted.login)
+
+	jwtToken, err := restarted.RestoreSession("user")
+	require.NoError(t, err)
+	assert.Equal(t, "token", jwtToken)
+	assert.Equal(t, "user", restarted.login)
+	assert.Equal(t, "refresh", restarted.refreshToken)
+
+	// сессия, которую сервер уже не принимает, завершается на клиенте
+	outgoingCtx := metadata.NewOutgoingContext(ctx, metadata.Pairs("token", "token"))
+	mockAuthClient.On("Logout", outgoingCtx, &emptypb.Empty{}).
+		Return(nil, status.Error(codes.Unauthenticated, model.ErrSessionRevoked.Error())).Once()
+	mockAuthClient.On("RefreshToken", ctx, &authservice.RefreshRequest{RefreshToken: "refresh"}).
+		Return(nil, status.Error(codes.Unauthenticated, model.ErrRefreshTokenExpired.Error())).Once()
+	require.NoError(t, restarted.Logout(ctx, jwtToken))
+	_, err = restarted.RestoreSession("")
+	assert.ErrorIs(t, err, model.ErrNoSession)
+	mockAuthClient.AssertExpectations(t)
+}
+
 func TestClientServiceGet(t *testing.T) {
 	mockServiceClient := new(mocks.DataServiceClient)
 	type args struct {
@@ -187,7 +371,6 @@ func TestClientServiceGet(t *testing.T) {
 		want    []model.DataBlock
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешная отправка запроса на получение данных",
 			s: &service{
@@ -252,7 +435,6 @@ func TestClientServiceDelete(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешная отправка запроса на удаление данных",
 			s: &service{
@@ -292,13 +474,22 @@ func TestClientServiceChange(t *testing.T) {
 		jwtToken string
 		data     model.DataBlock
 	}
+	mismatch, err := status.New(codes.FailedPrecondition, "version mismatch").WithDetails(
+		&errdetails.ErrorInfo{
+			Reason:   model.VersionMismatchReason,
+			Domain:   model.ErrorDomain,
+			Metadata: map[string]string{model.CurrentVersionMetadata: "7"},
+		})
+	require.NoError(t, err)
+
 	tests := []struct {
-		name    string
-		s       *service
-		args    args
-		wantErr bool
+		name         string
+		s            *service
+		args         args
+		serverErr    error
+		wantErr      bool
+		wantMismatch int64
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешная отправка запроса на изменение",
 			s: &service{
@@ -312,9 +503,29 @@ func TestClientServiceChange(t *testing.T) {
 					DataKeyWord: "key",
 					Data:        "data",
 					MetaData:    "metadata",
+					Version:     3,
 				},
 			},
 		},
+		{
+			name: "Запись изменена на другом устройстве",
+			s: &service{
+				log:        logger.InitLog(logrus.InfoLevel),
+				dataClient: mockServiceClient,
+			},
+			args: args{
+				ctx:      context.Background(),
+				jwtToken: "token",
+				data: model.DataBlock{
+					DataKeyWord: "stale",
+					Data:        "data",
+					Version:     5,
+				},
+			},
+			serverErr:    mismatch.Err(),
+			wantErr:      true,
+			wantMismatch: 7,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -326,12 +537,20 @@ func TestClientServiceChange(t *testing.T) {
 				DataKeyWord:       tt.args.data.DataKeyWord,
 				DataForChange:     tt.args.data.Data,
 				MetaDataForChange: tt.args.data.MetaData,
+				ExpectedVersion:   tt.args.data.Version,
 			}
-			mockServiceClient.On("ChangeData", ctx, requestChange).Return(&emptypb.Empty{}, nil)
+			mockServiceClient.On("ChangeData", ctx, requestChange).Return(
+				&dataService.ChangeResponse{Version: tt.args.data.Version + 1}, tt.serverErr)
 
-			if err := tt.s.Change(tt.args.ctx, tt.args.jwtToken, tt.args.data); (err != nil) != tt.wantErr {
+			err := tt.s.Change(tt.args.ctx, tt.args.jwtToken, tt.args.data)
+			if (err != nil) != tt.wantErr {
 				t.Errorf("service.Change() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.wantMismatch != 0 {
+				var mismatch *model.VersionMismatchError
+				require.ErrorAs(t, err, &mismatch)
+				assert.Equal(t, tt.wantMismatch, mismatch.Current)
+			}
 		})
 	}
 }
@@ -345,7 +564,6 @@ func TestGetService(t *testing.T) {
 		args    args
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Получение объекта структуры сервис",
 			args: args{
@@ -356,12 +574,34 @@ func TestGetService(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("KEEPER_CACHE", filepath.Join(t.TempDir(), "cache.db"))
 			got, err := GetService(tt.args.l)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetService() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			assert.NotNil(t, got)
+			got.Close()
+		})
+	}
+}
+
+func TestClientServiceServerName(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+	}{
+		{"Адрес по умолчанию", "localhost:9090", "localhost"},
+		{"Имя хоста", "keeper.example.com:443", "keeper.example.com"},
+		{"IP адрес", "127.0.0.1:9090", "127.0.0.1"},
+		{"Адрес со схемой", "dns:///keeper.example.com:443", "keeper.example.com"},
+		{"Без хоста", ":9090", "localhost"},
+		{"Сокет Unix", "unix:///tmp/keeper.sock", "localhost"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, serverName(tt.address))
 		})
 	}
 }
@@ -0,0 +1,335 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"keeper/internal/model"
+
+	dataService "keeper/internal/server/handlers/proto/dataService"
+)
+
+// openCache открывает локальную копию после входа на сервер и отправляет
+// изменения, сделанные без связи с сервером. Ошибки локальной копии
+// не мешают работе с сервером
+func (s *service) openCache(ctx context.Context, jwtToken string, cacheKey []byte) {
+	if s.cache == nil {
+		return
+	}
+	err := s.cache.Unlock(s.login, cacheKey)
+	if errors.Is(err, model.ErrWrongCachePassword) {
+		// пароль сменили на другом устройстве, прежнюю копию не расшифровать
+		s.log.Warn("Локальная копия зашифрована другим паролем, собираем ее заново")
+		if err = s.cache.Reset(s.login); err == nil {
+			err = s.cache.Unlock(s.login, cacheKey)
+		}
+	}
+	if err != nil {
+		s.log.Warn("Локальная копия недоступна: " + err.Error())
+		return
+	}
+	stats, err := s.Sync(ctx, jwtToken)
+	if err != nil {
+		s.log.Warn("Не удалось синхронизировать локальную копию: " + err.Error())
+		return
+	}
+	for _, failed := range stats.Failed {
+		s.log.Warn("Изменение не принято сервером: " + failed)
+	}
+}
+
+// authOffline открывает локальную копию без связи с сервером.
+// Пароль проверяется расшифровкой локальной копии
+func (s *service) authOffline(login string, cacheKey []byte) (string, error) {
+	if err := s.cache.Unlock(login, cacheKey); err != nil {
+		return "", err
+	}
+	s.setLogin(login)
+	s.refreshToken = ""
+	return model.OfflineToken, nil
+}
+
+// offline проверяет, что запрос не выполнен из-за отсутствия связи
+// с сервером и его можно обслужить из локальной копии
+func (s *service) offline(err error) bool {
+	return status.Code(err) == codes.Unavailable && s.cache != nil && s.cache.Unlocked()
+}
+
+// saveOffline ставит изменение в очередь на отправку и применяет его
+// к локальной копии. Вместе с изменением запоминается запись, которую
+// изменял пользователь, чтобы сервер мог обнаружить конфликт
+func (s *service) saveOffline(operation model.SyncOperation) error {
+	if cached, err := s.cache.GetRecord(operation.Data.DataKeyWord); err == nil {
+		base := cached.Data
+		operation.Base, operation.BaseVersion = &base, base.Version
+	}
+	if err := s.cache.Enqueue(operation); err != nil {
+		return err
+	}
+	if operation.Deleted {
+		s.uncacheRecord(operation.Data.DataKeyWord)
+	} else {
+		operation.Data.Version = operation.BaseVersion
+		s.cacheRecord(operation.Data, true)
+	}
+	return model.ErrSavedOffline
+}
+
+// cacheRecord сохраняет запись в локальной копии в том виде, в котором ее
+// хранит сервер. modified обновляет время изменения записи
+func (s *service) cacheRecord(record model.DataBlock, modified bool) {
+	if s.cache == nil || !s.cache.Unlocked() {
+		return
+	}
+	now := time.Now()
+	header := model.DataHeader{CreatedAt: now, UpdatedAt: now}
+	if cached, err := s.cache.GetRecord(record.DataKeyWord); err == nil {
+		header = cached.Header
+		if modified {
+			header.UpdatedAt = now
+		}
+	}
+	header.DataKeyWord = record.DataKeyWord
+	header.DataType = record.DataType
+	header.MetaData = record.MetaData
+	header.Version = record.Version
+	header.Size = int64(len(record.Data) + len(record.EncryptedData))
+	if record.Payload != nil {
+		if encoded, err := record.Payload.Encode(); err == nil {
+			header.Size = int64(len(encoded))
+		}
+	}
+	if err := s.cache.PutRecord(model.CachedRecord{Header: header, Data: record}); err != nil {
+		s.log.Warn("Не удалось сохранить запись в локальной копии: " + err.Error())
+	}
+}
+
+// uncacheRecord удаляет запись из локальной копии
+func (s *service) uncacheRecord(dataKeyWord string) {
+	if s.cache == nil || !s.cache.Unlocked() {
+		return
+	}
+	if err := s.cache.DeleteRecord(dataKeyWord); err != nil {
+		s.log.Warn("Не удалось удалить запись из локальной копии: " + err.Error())
+	}
+}
+
+// getCached возвращает запись из локальной копии
+func (s *service) getCached(dataKeyWord string) ([]model.DataBlock, error) {
+	cached, err := s.cache.GetRecord(dataKeyWord)
+	if err != nil {
+		if errors.Is(err, model.ErrNoRowsSelected) {
+			return nil, status.Error(codes.NotFound, model.ErrNoRowsSelected.Error())
+		}
+		return nil, err
+	}
+	dataBlock, err := s.openRecord(cached.Data)
+	if err != nil {
+		return nil, err
+	}
+	return []model.DataBlock{dataBlock}, nil
+}
+
+// listCached фильтрует и сортирует заголовки записей локальной копии
+func (s *service) listCached(query model.ListQuery) ([]model.DataHeader, error) {
+	if query.SortBy == "" {
+		query.SortBy = model.SortByKey
+	}
+	if !model.IsKnownSortField(query.SortBy) {
+		return nil, status.Error(codes.InvalidArgument, model.ErrInvalidSortField.Error())
+	}
+	records, err := s.cache.Records()
+	if err != nil {
+		return nil, err
+	}
+
+	headers := make([]model.DataHeader, 0, len(records))
+	for _, record := range records {
+		if query.DataType != "" && record.Header.DataType != query.DataType {
+			continue
+		}
+		if !strings.HasPrefix(record.Header.DataKeyWord, query.KeyPrefix) {
+			continue
+		}
+		headers = append(headers, record.Header)
+	}
+
+	sort.Slice(headers, func(i, j int) bool {
+		a, b := headers[i], headers[j]
+		if query.Descending {
+			a, b = b, a
+		}
+		switch {
+		case query.SortBy == model.SortByType && a.DataType != b.DataType:
+			return a.DataType < b.DataType
+		case query.SortBy == model.SortByCreated && !a.CreatedAt.Equal(b.CreatedAt):
+			return a.CreatedAt.Before(b.CreatedAt)
+		case query.SortBy == model.SortByUpdated && !a.UpdatedAt.Equal(b.UpdatedAt):
+			return a.UpdatedAt.Before(b.UpdatedAt)
+		case query.SortBy == model.SortBySize && a.Size != b.Size:
+			return a.Size < b.Size
+		}
+		return a.DataKeyWord < b.DataKeyWord
+	})
+	return headers, nil
+}
+
+// Sync отправляет серверу изменения, сделанные без связи с ним,
+// и применяет к локальной копии изменения с сервера после сохраненного курсора.
+// Изменения над устаревшими версиями записей сервер сохраняет как конфликты
+func (s *service) Sync(ctx context.Context, jwtToken string) (model.SyncStats, error) {
+	var stats model.SyncStats
+	if s.cache == nil || !s.cache.Unlocked() {
+		return stats, model.ErrCacheLocked
+	}
+	ids, operations, err := s.cache.Queue()
+	if err != nil {
+		return stats, err
+	}
+	cursor, err := s.cache.Cursor()
+	if err != nil {
+		return stats, err
+	}
+
+	for {
+		requestSync := &dataService.SyncRequest{Cursor: cursor}
+		for _, operation := range operations {
+			syncOperation := &dataService.SyncOperation{
+				Deleted:     operation.Deleted,
+				Record:      addingRequest(operation.Data),
+				BaseVersion: operation.BaseVersion,
+			}
+			if operation.Base != nil {
+				syncOperation.Base = addingRequest(*operation.Base)
+			}
+			requestSync.Operations = append(requestSync.Operations, syncOperation)
+		}
+
+		var response *dataService.SyncResponse
+		err = s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+			var err error
+			response, err = s.dataClient.Sync(ctx, requestSync)
+			return err
+		})
+		if err != nil {
+			s.log.Error(err.Error())
+			return stats, err
+		}
+
+		// отклоненные сервером изменения не повторяются, о них сообщается пользователю.
+		// Локальная копия этих записей заменяется версией с сервера
+		var rejected []string
+		for i, operationError := range response.OperationErrors {
+			if operationError == "" {
+				stats.Pushed++
+				continue
+			}
+			stats.Failed = append(stats.Failed,
+				fmt.Sprintf("%s: %s", operations[i].Data.DataKeyWord, operationError))
+			rejected = append(rejected, operations[i].Data.DataKeyWord)
+		}
+		stats.Conflicts += int(response.Conflicts)
+		if err = s.cache.Dequeue(ids); err != nil {
+			return stats, err
+		}
+		ids, operations = nil, nil
+		for _, dataKeyWord := range rejected {
+			if err = s.refetchRecord(ctx, jwtToken, dataKeyWord); err != nil {
+				return stats, err
+			}
+		}
+
+		for _, change := range response.Changes {
+			if err = s.applyChange(change); err != nil {
+				return stats, err
+			}
+			stats.Pulled++
+		}
+		cursor = response.Cursor
+		if err = s.cache.SetCursor(cursor); err != nil {
+			return stats, err
+		}
+		if !response.HasMore {
+			return stats, nil
+		}
+	}
+}
+
+// refetchRecord заменяет запись в локальной копии версией с сервера
+// или удаляет ее, если на сервере записи нет
+func (s *service) refetchRecord(ctx context.Context, jwtToken string, dataKeyWord string) error {
+	var responseList *dataService.GetResponseList
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		responseList, err = s.dataClient.GetData(ctx, &dataService.GetRequest{DataKeyWord: dataKeyWord})
+		return err
+	})
+	if status.Code(err) == codes.NotFound {
+		return s.cache.DeleteRecord(dataKeyWord)
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	for _, response := range responseList.Response {
+		s.cacheRecord(recordFromResponse(response), false)
+	}
+	return nil
+}
+
+// applyChange применяет изменение с сервера к локальной копии
+func (s *service) applyChange(change *dataService.SyncChange) error {
+	header := headerFromProto(change.GetHeader())
+	if change.Deleted {
+		return s.cache.DeleteRecord(header.DataKeyWord)
+	}
+	return s.cache.PutRecord(model.CachedRecord{
+		Header: header,
+		Data:   recordFromResponse(change.GetRecord()),
+	})
+}
+
+// recordFromRequest возвращает запись из запроса на добавление
+// в том виде, в котором ее хранит сервер
+func recordFromRequest(request *dataService.AddingRequest) model.DataBlock {
+	return model.DataBlock{
+		DataKeyWord:   request.GetDataKeyWord(),
+		DataType:      request.GetDataType(),
+		Data:          request.GetData(),
+		EncryptedData: request.GetEncryptedData(),
+		MetaData:      request.GetMetaData(),
+		Payload:       dataService.PayloadToModel(request.GetPayload()),
+	}
+}
+
+// recordFromResponse возвращает запись из ответа сервера без расшифровки
+func recordFromResponse(response *dataService.GetResponse) model.DataBlock {
+	return model.DataBlock{
+		DataKeyWord:   response.GetDataKeyWord(),
+		DataType:      response.GetDataType(),
+		Data:          response.GetData(),
+		EncryptedData: response.GetEncryptedData(),
+		MetaData:      response.GetMetaData(),
+		Payload:       dataService.PayloadToModel(response.GetPayload()),
+		Version:       response.GetVersion(),
+	}
+}
+
+// addingRequest преобразует запись из очереди в сообщение для Sync
+func addingRequest(record model.DataBlock) *dataService.AddingRequest {
+	return &dataService.AddingRequest{
+		DataKeyWord:   record.DataKeyWord,
+		DataType:      record.DataType,
+		Data:          record.Data,
+		EncryptedData: record.EncryptedData,
+		MetaData:      record.MetaData,
+		Payload:       dataService.PayloadFromModel(record.Payload),
+	}
+}
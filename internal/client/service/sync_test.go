@@ -0,0 +1,155 @@
+package service
+
+import (
+	"context"
+	"keeper/internal/client/cache"
+	"keeper/internal/client/service/mocks"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/utils"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	authservice "keeper/internal/server/handlers/proto/authService"
+	dataservice "keeper/internal/server/handlers/proto/dataService"
+)
+
+func TestClientServiceOffline(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	localCache, err := cache.Open(filepath.Join(t.TempDir(), "cache.db"), log)
+	require.NoError(t, err)
+	defer localCache.Close()
+	require.NoError(t, localCache.Unlock("user", utils.DeriveCacheKey("user", "password")))
+	localCache.Lock()
+
+	mockAuthClient := new(mocks.AuthServiceClient)
+	mockDataClient := new(mocks.DataServiceClient)
+	s := &service{
+		log:        log,
+		authClient: mockAuthClient,
+		dataClient: mockDataClient,
+		cache:      localCache,
+	}
+	ctx := context.Background()
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	mockAuthClient.On("UserAuth", ctx, &authservice.AuthRequest{Login: "user", Password: "wrong"}).
+		Return(nil, unavailable).Once()
+	_, err = s.Auth(ctx, "user", "wrong")
+	assert.ErrorIs(t, err, model.ErrWrongCachePassword)
+
+	mockAuthClient.On("UserAuth", ctx, &authservice.AuthRequest{Login: "user", Password: "password"}).
+		Return(nil, unavailable).Once()
+	token, err := s.Auth(ctx, "user", "password")
+	require.NoError(t, err)
+	require.Equal(t, model.OfflineToken, token)
+
+	// без связи изменения попадают в очередь и сразу видны в локальной копии
+	err = s.Add(ctx, token, model.DataBlock{DataKeyWord: "note", Data: "offline", MetaData: "meta"})
+	assert.ErrorIs(t, err, model.ErrSavedOffline)
+	err = s.Add(ctx, token, model.DataBlock{DataKeyWord: "removed", Data: "value"})
+	assert.ErrorIs(t, err, model.ErrSavedOffline)
+	err = s.Delete(ctx, token, "removed")
+	assert.ErrorIs(t, err, model.ErrSavedOffline)
+
+	data, err := s.Get(ctx, token, "note")
+	require.NoError(t, err)
+	assert.Equal(t, "offline", data[0].Data)
+	_, err = s.Get(ctx, token, "removed")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	headers, nextPageToken, err := s.ListData(ctx, token, model.ListQuery{KeyPrefix: "no"})
+	require.NoError(t, err)
+	assert.Empty(t, nextPageToken)
+	require.Len(t, headers, 1)
+	assert.Equal(t, "note", headers[0].DataKeyWord)
+
+	// при синхронизации очередь уходит на сервер, а изменения с сервера
+	// применяются к локальной копии. Запись, добавленная и удаленная
+	// без связи, отправляется одним удалением без исходной версии
+	mockDataClient.On("Sync", mock.Anything, mock.MatchedBy(func(in *dataservice.SyncRequest) bool {
+		return in.Cursor == "" && len(in.Operations) == 2 &&
+			in.Operations[0].GetRecord().GetData() == "offline" &&
+			in.Operations[1].Deleted && in.Operations[1].BaseVersion == 0
+	})).Return(&dataservice.SyncResponse{
+		OperationErrors: []string{"", ""},
+		Conflicts:       1,
+		Changes: []*dataservice.SyncChange{
+			{
+				Record: &dataservice.GetResponse{DataKeyWord: "remote", Data: "from server"},
+				Header: &dataservice.DataHeader{DataKeyWord: "remote", UpdatedAt: timestamppb.Now()},
+			},
+			{Deleted: true, Header: &dataservice.DataHeader{DataKeyWord: "note"}},
+			{
+				Record: &dataservice.GetResponse{DataKeyWord: "photo", DataType: model.DataTypeFile,
+					Data: "{}"},
+				Header: &dataservice.DataHeader{DataKeyWord: "photo", DataType: model.DataTypeFile,
+					UpdatedAt: timestamppb.Now()},
+			},
+		},
+		Cursor: "Mw",
+	}, nil).Once()
+
+	stats, err := s.Sync(ctx, "token")
+	require.NoError(t, err)
+	assert.Equal(t, model.SyncStats{Pushed: 2, Pulled: 3, Conflicts: 1}, stats)
+
+	_, operations, err := localCache.Queue()
+	require.NoError(t, err)
+	assert.Empty(t, operations)
+	cursor, err := localCache.Cursor()
+	require.NoError(t, err)
+	assert.Equal(t, "Mw", cursor)
+
+	data, err = s.Get(ctx, token, "remote")
+	require.NoError(t, err)
+	assert.Equal(t, "from server", data[0].Data)
+	_, err = s.Get(ctx, token, "note")
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	// запись с файлом не изменяется и не попадает в очередь
+	err = s.Change(ctx, token, model.DataBlock{DataKeyWord: "photo", Data: "edit"})
+	assert.ErrorIs(t, err, model.ErrFileReadOnly)
+	_, operations, err = localCache.Queue()
+	require.NoError(t, err)
+	assert.Empty(t, operations)
+
+	// отклоненные сервером изменения не остаются в локальной копии:
+	// измененная запись заменяется версией с сервера, новая удаляется
+	err = s.Change(ctx, token, model.DataBlock{DataKeyWord: "remote", Data: "rejected"})
+	assert.ErrorIs(t, err, model.ErrSavedOffline)
+	err = s.Add(ctx, token, model.DataBlock{DataKeyWord: "invalid", Data: "rejected"})
+	assert.ErrorIs(t, err, model.ErrSavedOffline)
+
+	mockDataClient.On("Sync", mock.Anything, mock.MatchedBy(func(in *dataservice.SyncRequest) bool {
+		return in.Cursor == "Mw" && len(in.Operations) == 2
+	})).Return(&dataservice.SyncResponse{
+		OperationErrors: []string{"invalid payload", "invalid payload"},
+		Cursor:          "Mw",
+	}, nil).Once()
+	mockDataClient.On("GetData", mock.Anything, &dataservice.GetRequest{DataKeyWord: "remote"}).
+		Return(&dataservice.GetResponseList{Response: []*dataservice.GetResponse{
+			{DataKeyWord: "remote", Data: "from server", Version: 1},
+		}}, nil).Once()
+	mockDataClient.On("GetData", mock.Anything, &dataservice.GetRequest{DataKeyWord: "invalid"}).
+		Return(nil, status.Error(codes.NotFound, model.ErrNoRowsSelected.Error())).Once()
+
+	stats, err = s.Sync(ctx, "token")
+	require.NoError(t, err)
+	assert.Len(t, stats.Failed, 2)
+
+	cached, err := localCache.GetRecord("remote")
+	require.NoError(t, err)
+	assert.Equal(t, "from server", cached.Data.Data)
+	_, err = localCache.GetRecord("invalid")
+	assert.ErrorIs(t, err, model.ErrNoRowsSelected)
+	mockDataClient.AssertExpectations(t)
+}
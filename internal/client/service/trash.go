@@ -0,0 +1,68 @@
+package service
+
+import (
+	"context"
+
+	"google.golang.org/protobuf/types/known/emptypb"
+
+	"keeper/internal/model"
+
+	dataService "keeper/internal/server/handlers/proto/dataService"
+)
+
+// ListTrash получает заголовки записей в корзине
+func (s *service) ListTrash(ctx context.Context, jwtToken string) ([]model.TrashedRecord, error) {
+	var response *dataService.ListTrashResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		response, err = s.dataClient.ListTrash(ctx, &emptypb.Empty{})
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+
+	records := make([]model.TrashedRecord, 0, len(response.Records))
+	for _, record := range response.Records {
+		records = append(records, model.TrashedRecord{
+			ID:        record.Id,
+			Header:    headerFromProto(record.GetHeader()),
+			DeletedAt: record.DeletedAt.AsTime(),
+		})
+	}
+	return records, nil
+}
+
+// RestoreFromTrash возвращает запись из корзины и возвращает ее версию
+func (s *service) RestoreFromTrash(ctx context.Context, jwtToken string,
+	trashID int64) (int64, error) {
+	var response *dataService.RestoreFromTrashResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		response, err = s.dataClient.RestoreFromTrash(ctx, &dataService.RestoreFromTrashRequest{
+			Id: trashID,
+		})
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, err
+	}
+	return response.Version, nil
+}
+
+// EmptyTrash навсегда удаляет записи из корзины и возвращает их количество
+func (s *service) EmptyTrash(ctx context.Context, jwtToken string) (int64, error) {
+	var response *dataService.EmptyTrashResponse
+	err := s.withToken(ctx, jwtToken, func(ctx context.Context) error {
+		var err error
+		response, err = s.dataClient.EmptyTrash(ctx, &emptypb.Empty{})
+		return err
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, err
+	}
+	return response.Purged, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"context"
+	"keeper/internal/client/service/mocks"
+	"keeper/internal/logger"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	dataService "keeper/internal/server/handlers/proto/dataService"
+)
+
+func TestClientServiceTrash(t *testing.T) {
+	mockServiceClient := new(mocks.DataServiceClient)
+	s := &service{
+		log:        logger.InitLog(logrus.InfoLevel),
+		dataClient: mockServiceClient,
+	}
+	ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("token", "token"))
+
+	mockServiceClient.On("ListTrash", ctx, &emptypb.Empty{}).Return(&dataService.ListTrashResponse{
+		Records: []*dataService.TrashedRecord{{
+			Id:        3,
+			Header:    &dataService.DataHeader{DataKeyWord: "key", Version: 2},
+			DeletedAt: timestamppb.Now(),
+		}},
+	}, nil).Once()
+	records, err := s.ListTrash(context.Background(), "token")
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, int64(3), records[0].ID)
+	assert.Equal(t, "key", records[0].Header.DataKeyWord)
+
+	mockServiceClient.On("RestoreFromTrash", ctx, &dataService.RestoreFromTrashRequest{Id: 3}).
+		Return(nil, status.Error(codes.AlreadyExists, "exists")).Once()
+	_, err = s.RestoreFromTrash(context.Background(), "token", 3)
+	assert.Equal(t, codes.AlreadyExists, status.Code(err))
+
+	mockServiceClient.On("EmptyTrash", ctx, &emptypb.Empty{}).
+		Return(&dataService.EmptyTrashResponse{Purged: 1}, nil).Once()
+	purged, err := s.EmptyTrash(context.Background(), "token")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	mockServiceClient.AssertExpectations(t)
+}
@@ -0,0 +1,184 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/sirupsen/logrus"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+// keyLength - длина ключа шифрования файла сессии
+const keyLength = 32
+
+// Store - зашифрованный файл с сессией клиента. Ключ шифрования хранится
+// в отдельном файле рядом с сессией, оба файла доступны только владельцу
+type Store struct {
+	path    string
+	keyPath string
+	log     *logrus.Logger
+}
+
+// NewStore возвращает хранилище сессии в файле path, ключ хранится
+// в файле с тем же именем и расширением .key
+func NewStore(path string, log *logrus.Logger) *Store {
+	return &Store{
+		path:    path,
+		keyPath: path + ".key",
+		log:     log,
+	}
+}
+
+// DefaultPath возвращает путь к файлу сессии: из переменной окружения
+// KEEPER_SESSION или в каталоге настроек пользователя
+func DefaultPath() string {
+	if path := os.Getenv("KEEPER_SESSION"); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "keeper", "session")
+}
+
+// Save шифрует и сохраняет сессию. Файл записывается целиком через
+// временный файл, чтобы прерванная запись не испортила прежнюю сессию
+func (s *Store) Save(session model.ClientSession) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	key, err := s.key()
+	if err != nil {
+		return err
+	}
+	raw, err := json.Marshal(session)
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	cipherData, err := utils.VaultCipher(string(raw), key)
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err = tmp.Chmod(0600); err == nil {
+		_, err = tmp.Write(cipherData)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path)
+	}
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// Load читает сохраненную сессию. Если сессии нет, возвращает model.ErrNoSession
+func (s *Store) Load() (model.ClientSession, error) {
+	var session model.ClientSession
+	cipherData, err := readPrivate(s.path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return session, model.ErrNoSession
+		}
+		s.log.Error(err.Error())
+		return session, err
+	}
+	key, err := readPrivate(s.keyPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return session, model.ErrInvalidSession
+		}
+		s.log.Error(err.Error())
+		return session, err
+	}
+	raw, err := utils.VaultDecipher(cipherData, key)
+	if err != nil {
+		return session, model.ErrInvalidSession
+	}
+	if err = json.Unmarshal([]byte(raw), &session); err != nil || session.JwtToken == "" {
+		return session, model.ErrInvalidSession
+	}
+	return session, nil
+}
+
+// Remove удаляет сохраненную сессию. Ключ остается для следующих сессий
+func (s *Store) Remove() error {
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// key читает ключ шифрования сессии или создает новый
+func (s *Store) key() ([]byte, error) {
+	key, err := readPrivate(s.keyPath)
+	if err == nil {
+		if len(key) != keyLength {
+			err = fmt.Errorf("%w: %s", model.ErrInvalidSession, s.keyPath)
+			s.log.Error(err.Error())
+			return nil, err
+		}
+		return key, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+
+	key = make([]byte, keyLength)
+	if _, err = rand.Read(key); err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	file, err := os.OpenFile(s.keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	_, err = file.Write(key)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(s.keyPath)
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	return key, nil
+}
+
+// readPrivate читает файл, доступный только владельцу. На Windows права
+// доступа файлов не проверяются
+func readPrivate(path string) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%w: %s", model.ErrInsecureKeyFile, path)
+	}
+	return os.ReadFile(path)
+}
@@ -0,0 +1,91 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"keeper/internal/logger"
+	"keeper/internal/model"
+)
+
+func newTestStore(t *testing.T) *Store {
+	return NewStore(filepath.Join(t.TempDir(), "keeper", "session"), logger.InitLog(logrus.InfoLevel))
+}
+
+func TestSessionSaveLoad(t *testing.T) {
+	saved := model.ClientSession{Login: "user", JwtToken: "token", RefreshToken: "refresh"}
+
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, store *Store)
+		want    model.ClientSession
+		wantErr error
+	}{
+		{
+			name:    "Сессии нет",
+			prepare: func(t *testing.T, store *Store) {},
+			wantErr: model.ErrNoSession,
+		},
+		{
+			name: "Сохраненная сессия",
+			prepare: func(t *testing.T, store *Store) {
+				require.NoError(t, store.Save(saved))
+			},
+			want: saved,
+		},
+		{
+			name: "Сессия удалена",
+			prepare: func(t *testing.T, store *Store) {
+				require.NoError(t, store.Save(saved))
+				require.NoError(t, store.Remove())
+			},
+			wantErr: model.ErrNoSession,
+		},
+		{
+			name: "Ключ заменен",
+			prepare: func(t *testing.T, store *Store) {
+				require.NoError(t, store.Save(saved))
+				require.NoError(t, os.WriteFile(store.keyPath, make([]byte, keyLength), 0600))
+			},
+			wantErr: model.ErrInvalidSession,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			tt.prepare(t, store)
+
+			got, err := store.Load()
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSessionPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("права доступа файлов на Windows не проверяются")
+	}
+	store := newTestStore(t)
+	require.NoError(t, store.Save(model.ClientSession{Login: "user", JwtToken: "token"}))
+
+	for _, path := range []string{store.path, store.keyPath} {
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	}
+
+	require.NoError(t, os.Chmod(store.path, 0644))
+	_, err := store.Load()
+	assert.ErrorIs(t, err, model.ErrInsecureKeyFile)
+}
@@ -1,53 +1,335 @@
 package config
 
 import (
+	"bufio"
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"keeper/internal/model"
+	"net"
+	"net/url"
 	"os"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/caarlos0/env"
 	"github.com/sirupsen/logrus"
 )
 
-// GetConfig возвращает конфигурацию приложения
-func GetConfig(log *logrus.Logger) (model.Config, error) {
-	var cfg model.Config
+// redactedValue заменяет секреты при выводе конфигурации
+const redactedValue = "******"
 
-	if err := env.Parse(&cfg); err != nil {
-		log.Error(err.Error())
-		return cfg, err
+// dsnPassword находит пароль в строке подключения к бд вида key=value
+var dsnPassword = regexp.MustCompile(`(password=)('(?:[^'\\]|\\.)*'|[^\s&]+)`)
+
+// Flags - значения флагов командной строки. Они важнее остальных источников,
+// пустые значения конфигурацию не меняют
+type Flags struct {
+	ConfigFile  string
+	Listen      string
+	UnixSocket  string
+	Storage     string
+	StoragePath string
+	Database    string
+	LogLevel    string
+	SecretFile  string
+	// Secret - секрет сервера, который передает сама команда, например
+	// новый секрет при ротации. Пользователь задает секрет только через
+	// переменную окружения или файл
+	Secret string
+}
+
+// Default возвращает значения конфигурации по умолчанию
+func Default() model.Config {
+	return model.Config{
+		Listen:   model.DefaultListenAddress,
+		Storage:  model.DefaultStorage,
+		LogLevel: model.DefaultLogLevel,
+		History: model.HistoryRetention{
+			PruneIntervalMinutes: int(model.DefaultHistoryPruneInterval / time.Minute),
+		},
+		Trash: model.TrashRetention{
+			Days:                 model.DefaultTrashDays,
+			PurgeIntervalMinutes: int(model.DefaultTrashPurgeInterval / time.Minute),
+		},
+		Limits: model.Limits{
+			MaxMessageBytes: model.DefaultMaxMessageBytes,
+		},
 	}
-	config, err := readConfigFile(cfg.ConfigFile, log)
-	if err != nil {
-		log.Error(err.Error())
+}
+
+// GetConfig возвращает конфигурацию приложения. Значения по умолчанию
+// заменяются значениями из файла конфигурации, затем из переменных окружения
+// и флагов. Все ошибки проверки возвращаются вместе
+func GetConfig(log *logrus.Logger, flags Flags) (model.Config, error) {
+	return load(log, flags, Validate)
+}
+
+// GetStorageConfig возвращает конфигурацию для команд, которым нужно
+// только хранилище, например миграций схемы. Секрет и TLS не проверяются
+func GetStorageConfig(log *logrus.Logger, flags Flags) (model.Config, error) {
+	return load(log, flags, ValidateStorage)
+}
+
+// GetRotationConfig возвращает конфигурацию для ротации секрета сервера:
+// проверяются только хранилище и секрет
+func GetRotationConfig(log *logrus.Logger, flags Flags) (model.Config, error) {
+	return load(log, flags, func(config model.Config) error {
+		return joinErrors(append(storageErrors(config), secretErrors(config)...))
+	})
+}
+
+// load собирает конфигурацию из всех источников и проверяет ее функцией validate
+func load(log *logrus.Logger, flags Flags,
+	validate func(config model.Config) error) (model.Config, error) {
+	config := Default()
+
+	path := flags.ConfigFile
+	if path == "" {
+		path = os.Getenv("CONFIG")
+	}
+	// без файла работаем на переменных окружения, но указанный файл
+	// обязательно должен прочитаться
+	if path != "" {
+		if err := readConfigFile(path, &config); err != nil {
+			log.Error(err.Error())
+			return config, err
+		}
 	}
 
-	goprivate, exist := os.LookupEnv("GOPRIVATE")
+	// вложенные структуры библиотека env не обходит, разбираем их отдельно
+	for _, section := range []interface{}{&config, &config.TLS, &config.Limits} {
+		if err := env.Parse(section); err != nil {
+			log.Error(err.Error())
+			return config, err
+		}
+	}
+	config.ConfigFile = path
+	flags.apply(&config)
 
-	if !exist || goprivate == "" {
-		err := errors.New("Заполните переменную среды GOPRIVATE")
-		return model.Config{}, err
+	if err := loadSecret(&config, flags, log); err != nil {
+		log.Error(err.Error())
+		return config, err
 	}
-	config.SecretPassword = goprivate
-	return config, err
+	if err := validate(config); err != nil {
+		log.Error(err.Error())
+		return config, err
+	}
+	return config, nil
 }
 
-// readConfigFile читает конфигурационный файл в формате json
-func readConfigFile(filename string, log *logrus.Logger) (model.Config, error) {
-	var config model.Config
+// apply переносит в конфигурацию заданные флаги
+func (f Flags) apply(config *model.Config) {
+	for _, field := range []struct {
+		value string
+		dst   *string
+	}{
+		{f.Listen, &config.Listen},
+		{f.UnixSocket, &config.UnixSocket},
+		{f.Storage, &config.Storage},
+		{f.StoragePath, &config.StoragePath},
+		{f.Database, &config.Database},
+		{f.LogLevel, &config.LogLevel},
+		{f.SecretFile, &config.SecretFile},
+	} {
+		if field.value != "" {
+			*field.dst = field.value
+		}
+	}
+}
 
-	file, err := os.OpenFile(filename, os.O_RDONLY, 0664)
+// readConfigFile читает конфигурационный файл в формате json поверх
+// уже заполненных значений. Неизвестные поля считаются ошибкой
+func readConfigFile(filename string, config *model.Config) error {
+	file, err := os.Open(filename)
 	if err != nil {
-		log.Error(err.Error())
-		return config, err
+		return err
 	}
 	defer file.Close()
 
 	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
 
-	if err := decoder.Decode(&config); err != nil {
-		log.Error(err.Error())
+	if err := decoder.Decode(config); err != nil {
+		return fmt.Errorf("файл конфигурации %s: %w", filename, err)
+	}
+	return nil
+}
+
+// loadSecret заполняет секрет сервера. Флаг --secret-file важнее
+// переменной KEEPER_SECRET, а она важнее файла из конфигурации
+func loadSecret(config *model.Config, flags Flags, log *logrus.Logger) error {
+	switch {
+	case flags.Secret != "":
+		config.SecretPassword = flags.Secret
+		return nil
+	case flags.SecretFile != "":
+	case config.SecretPassword != "":
+		if os.Getenv("KEEPER_SECRET_FILE") != "" {
+			return model.ErrSecretConflict
+		}
+		return nil
+	case config.SecretFile == "":
+		// отсутствие секрета сообщит Validate вместе с остальными ошибками
+		return nil
+	}
+
+	secret, err := readSecretFile(config.SecretFile, log)
+	if err != nil {
+		return err
+	}
+	config.SecretPassword = secret
+	return nil
+}
+
+// readSecretFile читает секрет из первой строки файла
+func readSecretFile(filename string, log *logrus.Logger) (string, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		log.WithFields(logrus.Fields{
+			"file": filename,
+			"mode": info.Mode().Perm().String(),
+		}).Warn("Файл с секретом сервера доступен другим пользователям")
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Scan()
+	secret := strings.TrimRight(scanner.Text(), "\r")
+	if secret == "" {
+		return "", fmt.Errorf("%s: %w", filename, model.ErrSecretRequired)
+	}
+	return secret, nil
+}
+
+// Validate проверяет конфигурацию и возвращает все найденные ошибки
+func Validate(config model.Config) error {
+	errs := append(storageErrors(config), secretErrors(config)...)
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(config.Listen); err != nil {
+		add("listen: %w", err)
+	}
+	if _, err := logrus.ParseLevel(config.LogLevel); err != nil {
+		add("log_level: %w", err)
+	}
+
+	if !config.TLS.Insecure && (config.TLS.CertFile == "" || config.TLS.KeyFile == "") {
+		errs = append(errs, model.ErrTLSNotConfigured)
+	}
+	if config.TLS.RequireClientCert && config.TLS.ClientCAFile == "" {
+		errs = append(errs, model.ErrClientCARequired)
+	}
+	if config.TLS.Insecure && config.TLS.RequireClientCert {
+		add("tls: require_client_cert не работает вместе с insecure")
+	}
+
+	if config.History.Revisions < 0 || config.History.Days < 0 ||
+		config.History.PruneIntervalMinutes < 0 {
+		add("history: значения не могут быть отрицательными")
+	}
+	if config.Trash.Days < 0 || config.Trash.PurgeIntervalMinutes < 0 {
+		add("trash: значения не могут быть отрицательными")
+	}
+	// argon2 требует не меньше 8 КиБ памяти на каждый поток
+	if config.PasswordHash.Memory != 0 && config.PasswordHash.Threads != 0 &&
+		config.PasswordHash.Memory < 8*uint32(config.PasswordHash.Threads) {
+		add("password_hash: memory должна быть не меньше 8 КиБ на поток")
+	}
+	if config.Limits.MaxMessageBytes < model.MinMaxMessageBytes {
+		add("limits: max_message_bytes должен быть не меньше %d", model.MinMaxMessageBytes)
+	}
+
+	return joinErrors(errs)
+}
+
+// ValidateStorage проверяет только настройки хранилища
+func ValidateStorage(config model.Config) error {
+	return joinErrors(storageErrors(config))
+}
+
+// storageErrors возвращает ошибки настроек хранилища
+func storageErrors(config model.Config) []error {
+	switch config.Storage {
+	case model.StoragePostgres:
+		if config.Database == "" {
+			return []error{errors.New("database_conn: не задана строка подключения к бд")}
+		}
+	case model.StorageBolt:
+		if config.StoragePath == "" {
+			return []error{errors.New("storage_path: не задан файл хранилища")}
+		}
+	case model.StorageMemory:
+	default:
+		return []error{fmt.Errorf("storage: неизвестное хранилище %q, выберите %s, %s или %s",
+			config.Storage, model.StoragePostgres, model.StorageMemory, model.StorageBolt)}
+	}
+	return nil
+}
+
+// secretErrors возвращает ошибки настроек секрета сервера
+func secretErrors(config model.Config) []error {
+	if config.SecretPassword == "" {
+		return []error{model.ErrSecretRequired}
+	}
+	if config.SecretPassword == config.PreviousSecretPassword {
+		return []error{fmt.Errorf("KEEPER_PREVIOUS_SECRET: %w", model.ErrRotationSecrets)}
+	}
+	return nil
+}
+
+// joinErrors объединяет ошибки проверки в одну ошибку model.ErrInvalidConfig
+func joinErrors(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w:\n%w", model.ErrInvalidConfig, errors.Join(errs...))
+}
+
+// Redacted возвращает конфигурацию в формате json для вывода: секреты
+// и пароль в строке подключения к бд скрыты
+func Redacted(config model.Config) ([]byte, error) {
+	config.Database = redactDSN(config.Database)
+	raw, err := json.Marshal(config)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(raw, &fields); err != nil {
+		return nil, err
+	}
+	// эти поля не читаются из файла, но их полезно видеть
+	fields["config_file"] = config.ConfigFile
+	fields["secret"] = redact(config.SecretPassword)
+	fields["previous_secret"] = redact(config.PreviousSecretPassword)
+	fields["jwt_secret"] = redact(config.JWTSecret)
+	return json.MarshalIndent(fields, "", "    ")
+}
+
+// redact скрывает непустое значение
+func redact(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedValue
+}
+
+// redactDSN скрывает пароль в строке подключения к бд в виде url
+// или в виде key=value
+func redactDSN(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		dsn = u.Redacted()
 	}
-	return config, err
+	return dsnPassword.ReplaceAllString(dsn, "${1}"+redactedValue)
 }
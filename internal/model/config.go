@@ -0,0 +1,40 @@
+package model
+
+import "errors"
+
+// Значения конфигурации сервера по умолчанию
+const (
+	DefaultLogLevel = "info"
+	DefaultStorage  = StoragePostgres
+	// DefaultMaxMessageBytes - предельный размер сообщения gRPC
+	DefaultMaxMessageBytes = 4 << 20
+	// MinMaxMessageBytes - меньший предел не вмещает запись
+	// с бинарными данными максимального размера
+	MinMaxMessageBytes = 2 * MaxBinarySize
+)
+
+// Хранилища данных сервера
+const (
+	// StoragePostgres - Postgres, строка подключения задается в database_conn
+	StoragePostgres = "postgres"
+	// StorageMemory - хранилище в памяти, данные теряются при остановке
+	// сервера. Подходит для разработки и тестов
+	StorageMemory = "memory"
+	// StorageBolt - встроенное хранилище в файле storage_path
+	StorageBolt = "bolt"
+)
+
+// Limits - ограничения сервера из секции limits файла конфигурации
+type Limits struct {
+	// MaxMessageBytes - предельный размер входящего и исходящего сообщения
+	MaxMessageBytes int `json:"max_message_bytes" env:"KEEPER_MAX_MESSAGE_BYTES"`
+	// MaxConcurrentStreams - сколько запросов одновременно обслуживается
+	// в одном соединении, 0 - без ограничения
+	MaxConcurrentStreams uint `json:"max_concurrent_streams" env:"KEEPER_MAX_CONCURRENT_STREAMS"`
+}
+
+var (
+	ErrInvalidConfig  = errors.New("Некорректная конфигурация")
+	ErrSecretRequired = errors.New("Задайте секрет сервера в KEEPER_SECRET или файлом KEEPER_SECRET_FILE")
+	ErrSecretConflict = errors.New("Секрет сервера задан и в KEEPER_SECRET, и в KEEPER_SECRET_FILE, оставьте что-то одно")
+)
@@ -0,0 +1,56 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// Способы разрешения конфликта
+const (
+	// ResolveKeepTheirs оставляет запись на сервере, изменение клиента отбрасывается
+	ResolveKeepTheirs = "theirs"
+	// ResolveKeepMine заменяет запись на сервере изменением клиента
+	ResolveKeepMine = "mine"
+	// ResolveMerge сохраняет запись, собранную пользователем из двух версий
+	ResolveMerge = "merge"
+	// ResolveKeepBoth оставляет запись на сервере, а изменение клиента
+	// сохраняет под новым ключом
+	ResolveKeepBoth = "both"
+)
+
+// Conflict - изменение записи, сделанное клиентом на основе устаревшей версии.
+// Сервер не применяет его, а хранит рядом с текущей записью, пока
+// пользователь не выберет, какую версию оставить
+type Conflict struct {
+	ID          int64
+	DataKeyWord string
+	// BaseVersion - версия записи, которую изменял клиент, 0 - клиент создавал запись
+	BaseVersion int64
+	// Base - запись, которую изменял клиент, nil, если ее нет
+	Base *DataBlock
+	// Mine - изменение клиента
+	Mine        DataBlock
+	MineDeleted bool
+	// Theirs - текущая запись на сервере, nil, если запись удалена
+	Theirs    *DataBlock
+	CreatedAt time.Time
+}
+
+// ConflictResolution - выбор пользователя для конфликта
+type ConflictResolution struct {
+	ConflictID int64
+	Strategy   string
+	// ExpectedVersion - версия записи на сервере, которую видел пользователь
+	ExpectedVersion int64
+	// Merged - запись для ResolveMerge
+	Merged DataBlock
+	// RenamedKey - ключ для изменения клиента при ResolveKeepBoth
+	RenamedKey string
+}
+
+var (
+	ErrConflictNotFound   = errors.New("Конфликт не найден")
+	ErrUnknownResolution  = errors.New("Выберите mine, theirs, merge или both")
+	ErrRenamedKeyRequired = errors.New("Укажите новый ключ, отличный от ключа записи")
+	ErrKeepBothFile       = errors.New("Запись с файлом нельзя сохранить под новым ключом, выберите mine или theirs")
+)
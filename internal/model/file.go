@@ -0,0 +1,51 @@
+package model
+
+import "errors"
+
+// DataTypeFile - тип записи с файлом, загруженным по частям
+const DataTypeFile = "file"
+
+const (
+	// FileChunkSize - размер части файла, которую отправляет клиент
+	FileChunkSize = 64 << 10
+	// MaxFileChunkSize - максимальный размер части файла, принимаемой сервером
+	MaxFileChunkSize = 1 << 20
+	// MaxFileSize - максимальный размер файла
+	MaxFileSize = 1 << 30
+)
+
+// FileInfo - описание файла, загружаемого по частям. Хранится
+// в зашифрованном виде в записи типа file
+type FileInfo struct {
+	DataKeyWord string `json:"-"`
+	MetaData    string `json:"-"`
+	FileName    string `json:"fileName"`
+	Size        int64  `json:"size"`
+	// Checksum - sha256 переданных частей файла
+	Checksum []byte `json:"checksum"`
+	// ClientSealed - части файла зашифрованы на клиенте ключом хранилища
+	ClientSealed bool `json:"clientSealed,omitempty"`
+}
+
+// FileChunk - сообщение потока загрузки или скачивания файла.
+// Первым передается описание файла, затем части, при загрузке
+// поток завершается контрольной суммой
+type FileChunk struct {
+	Info     *FileInfo
+	Data     []byte
+	Checksum []byte
+}
+
+var (
+	ErrInvalidFile       = errors.New("Некорректный файл")
+	ErrFileInfoRequired  = errors.New("Первым сообщением должно быть описание файла")
+	ErrFileChunkTooBig   = errors.New("Слишком большая часть файла")
+	ErrFileSizeMismatch  = errors.New("Размер файла не совпадает с заявленным")
+	ErrChecksumMismatch  = errors.New("Контрольная сумма файла не совпадает")
+	ErrChecksumRequired  = errors.New("Загрузка файла не завершена контрольной суммой")
+	ErrNotFile           = errors.New("Запись не является файлом, используйте команду get")
+	ErrFileReadOnly      = errors.New("Запись с файлом нельзя изменить, загрузите файл заново командой upload")
+	ErrFileChunkNotFound = errors.New("Часть файла не найдена")
+	ErrUploadNotFound    = errors.New("Загрузка файла не найдена")
+	ErrDataKeyWordExists = errors.New("Запись с таким ключом уже существует")
+)
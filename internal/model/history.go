@@ -0,0 +1,37 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// DefaultHistoryPruneInterval - период очистки истории, если он не задан
+// в конфигурации
+const DefaultHistoryPruneInterval = time.Hour
+
+// Revision - прежняя версия записи, сохраненная при ее изменении или удалении
+type Revision struct {
+	ID int64
+	// Data - содержимое записи в этой версии
+	Data DataBlock
+	// Device - устройство, с которого записана версия
+	Device string
+	// CreatedAt - время, когда версия была записана
+	CreatedAt time.Time
+	// ReplacedAt - время, когда версию заменили или удалили
+	ReplacedAt time.Time
+	// Deleted - версия удалена вместе с записью
+	Deleted bool
+}
+
+// HistoryRetention - сколько хранить прежние версии записей. Версия удаляется,
+// если она не входит в Revisions последних версий записи или старше Days дней.
+// Нулевые значения не ограничивают историю
+type HistoryRetention struct {
+	Revisions int `json:"revisions"`
+	Days      int `json:"days"`
+	// PruneIntervalMinutes - период очистки истории в минутах
+	PruneIntervalMinutes int `json:"prune_interval_minutes"`
+}
+
+var ErrRevisionNotFound = errors.New("Версия записи не найдена")
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// Поля, по которым можно сортировать список записей
+const (
+	SortByKey     = "key"
+	SortByType    = "type"
+	SortByCreated = "created"
+	SortByUpdated = "updated"
+	SortBySize    = "size"
+)
+
+const (
+	// DefaultPageSize - размер страницы списка записей по умолчанию
+	DefaultPageSize = 50
+	// MaxPageSize - максимальный размер страницы списка записей
+	MaxPageSize = 500
+)
+
+// DataHeader - заголовок записи без ее содержимого
+type DataHeader struct {
+	DataKeyWord string
+	DataType    string
+	MetaData    string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	// Size - размер хранимых зашифрованных данных в байтах
+	Size    int64
+	Version int64
+}
+
+// ListQuery - параметры запроса списка записей
+type ListQuery struct {
+	DataType   string
+	KeyPrefix  string
+	SortBy     string
+	Descending bool
+	PageSize   int32
+	PageToken  string
+}
+
+// IsKnownSortField проверяет, что по полю можно сортировать
+func IsKnownSortField(sortBy string) bool {
+	switch sortBy {
+	case SortByKey, SortByType, SortByCreated, SortByUpdated, SortBySize:
+		return true
+	}
+	return false
+}
+
+var (
+	ErrInvalidSortField = errors.New("Сортировка возможна по полям key, type, created, updated, size")
+	ErrInvalidPageSize  = errors.New("Некорректный размер страницы")
+	ErrInvalidPageToken = errors.New("Некорректный токен страницы")
+)
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// Migration - состояние миграции схемы бд
+type Migration struct {
+	Version int64
+	Name    string
+	// Applied - миграция применена, AppliedAt - когда
+	Applied   bool
+	AppliedAt time.Time
+	// Unknown - миграция применена более новой версией сервера
+	// и неизвестна этой версии
+	Unknown bool
+}
+
+var (
+	ErrSchemaTooNew     = errors.New("Схема бд новее, чем знает этот сервер, обновите сервер")
+	ErrInvalidMigration = errors.New("invalid migration file")
+	ErrNothingToRevert  = errors.New("Нет примененных миграций для отката")
+)
@@ -2,6 +2,7 @@ package model
 
 import (
 	"errors"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 )
@@ -11,11 +12,96 @@ type Token struct {
 	jwt.StandardClaims
 }
 
-// Config - структура с данными конфигурации приложения
+// RefreshToken - сведения о токене обновления, хранящиеся на сервере
+type RefreshToken struct {
+	Login     string
+	SessionID string
+	ExpiresAt time.Time
+}
+
+// Tokens - пара токенов, выдаваемая пользователю при входе:
+// короткоживущий токен доступа и долгоживущий токен обновления.
+// Если у пользователя включен второй фактор, после проверки пароля
+// токены не выдаются, а заполняется Challenge для завершения входа
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
+	Challenge    string
+}
+
+// TOTP - настройки второго фактора пользователя
+type TOTP struct {
+	// CipherSecret - секрет TOTP, зашифрованный ключом данных пользователя
+	CipherSecret []byte
+	Confirmed    bool
+	// LastStep - последний принятый шаг TOTP, защищает от повторного
+	// использования кода
+	LastStep int64
+}
+
+// TOTPEnrollment - данные для подключения приложения-аутентификатора
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// AuthChallenge - незавершенный вход, ожидающий второй фактор
+type AuthChallenge struct {
+	Login     string
+	ExpiresAt time.Time
+	Attempts  int
+}
+
+const (
+	// DefaultListenAddress - адрес сервера, если он не задан в конфигурации
+	DefaultListenAddress = ":9090"
+	// DefaultServerAddress - адрес сервера, к которому подключается клиент
+	DefaultServerAddress = "localhost:9090"
+)
+
+// Config - структура с данными конфигурации приложения. Значения берутся
+// из флагов, переменных окружения, файла конфигурации и значений
+// по умолчанию, именно в таком порядке важности
 type Config struct {
-	ConfigFile     string `env:"CONFIG"`
-	Database       string `json:"database_conn"`
-	SecretPassword string
+	// ConfigFile - путь к файлу конфигурации в формате json
+	ConfigFile string `json:"-" env:"CONFIG"`
+	// Listen - TCP адрес, на котором сервер принимает запросы обоих сервисов
+	Listen string `json:"listen" env:"KEEPER_LISTEN"`
+	// UnixSocket - путь к сокету Unix, на котором сервер дополнительно
+	// принимает запросы, пустой, если сокет не нужен
+	UnixSocket string `json:"unix_socket" env:"KEEPER_UNIX_SOCKET"`
+	// Storage - хранилище данных сервера: postgres, memory или bolt
+	Storage string `json:"storage" env:"KEEPER_STORAGE"`
+	// StoragePath - файл хранилища bolt
+	StoragePath string `json:"storage_path" env:"KEEPER_STORAGE_PATH"`
+	Database    string `json:"database_conn" env:"KEEPER_DATABASE_DSN"`
+	// LogLevel - уровень журнала сервера: debug, info, warn, error
+	LogLevel string `json:"log_level" env:"KEEPER_LOG_LEVEL"`
+	// SecretPassword - секрет сервера, задается только переменной окружения
+	// KEEPER_SECRET или файлом SecretFile
+	SecretPassword string `json:"-" env:"KEEPER_SECRET"`
+	// SecretFile - файл, первая строка которого - секрет сервера
+	SecretFile string `json:"secret_file" env:"KEEPER_SECRET_FILE"`
+	// PreviousSecretPassword - прежний секрет сервера, действует на время
+	// ротации: данные, зашифрованные им, по-прежнему расшифровываются
+	PreviousSecretPassword string `json:"-" env:"KEEPER_PREVIOUS_SECRET"`
+	// JWTSecret - ключ подписи jwt токенов, задается только переменной
+	// окружения KEEPER_JWT_SECRET. Если ключ не задан, токены подписываются
+	// секретом сервера и на время ротации проверяются и прежним секретом
+	JWTSecret    string             `json:"-" env:"KEEPER_JWT_SECRET"`
+	PasswordHash PasswordHashParams `json:"password_hash"`
+	History      HistoryRetention   `json:"history"`
+	Trash        TrashRetention     `json:"trash"`
+	TLS          TLSConfig          `json:"tls"`
+	Limits       Limits             `json:"limits"`
+}
+
+// PasswordHashParams - параметры argon2id для хэширования паролей пользователей.
+// Нулевые значения заменяются значениями по умолчанию
+type PasswordHashParams struct {
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
 }
 
 // DataBlock - структура для операций с данными пользователя
@@ -25,20 +111,89 @@ type DataBlock struct {
 	DataType    string
 	Data        string
 	CipherData  []byte
-	MetaData    string
+	// EncryptedData - данные, зашифрованные на клиенте ключом хранилища.
+	// Сервер не может их расшифровать и хранит как есть
+	EncryptedData []byte
+	MetaData      string
+	// Payload - типизированное содержимое записи, при хранении
+	// сериализуется в Data
+	Payload *Payload
+	// FileID - идентификатор частей файла, загруженного потоком
+	FileID string
+	// Version - версия записи, растет при каждом изменении. При изменении
+	// клиент передает версию, которую он видел. Без проверки версии, с 0,
+	// записи изменяются только внутри сервера
+	Version int64
+	// Device - устройство, с которого записана запись
+	Device string
+}
+
+// Этапы ротации секрета сервера
+const (
+	RotationPhaseKeys = "keys"
+	RotationPhaseData = "data"
+	// RotationPhaseHistory - перешифровка прежних версий записей
+	RotationPhaseHistory = "history"
+	// RotationPhaseTrash - перешифровка записей в корзине
+	RotationPhaseTrash = "trash"
+	// RotationPhaseConflicts - перешифровка конфликтов синхронизации
+	RotationPhaseConflicts = "conflicts"
+	RotationPhaseDone      = "done"
+)
+
+// LegacyCipherRotationID - идентификатор перешифровки записей из устаревшего
+// формата в таблице ротаций. Новые записи в этом формате не появляются,
+// поэтому перешифровка выполняется один раз
+const LegacyCipherRotationID = "legacy-cipher"
+
+// KeyRotation - состояние ротации секрета сервера. Сохраняется после каждой
+// пачки, поэтому прерванную ротацию можно продолжить
+type KeyRotation struct {
+	RotationID  string
+	Phase       string
+	LastLogin   string
+	LastKeyWord string
+	// LastID - последняя обработанная строка таблиц с числовым ключом
+	// (история версий, корзина)
+	LastID    int64
+	Processed int64
 }
 
 var (
-	ErrLoginNotFound      = errors.New("Login not found")
-	ErrTokenNotFound      = errors.New("Token not found")
-	ErrNotValidToken      = errors.New("Not valid token")
-	ErrNoRowsSelected     = errors.New("No rows selected")
-	ErrUniqueViolation    = errors.New("Login unique violation")
-	ErrUserNotFound       = errors.New("User not found")
-	ErrUserRegister       = errors.New("error in user registration")
-	ErrUserAlreadyExists  = errors.New("Пользователь уже существует, придумайте другой логин")
-	ErrUserAuth           = errors.New("Неправильный логин или пароль")
-	ErrNoAuthentification = errors.New("Сначала пройдите регистрацию или аутентификацию")
-	ErrBigFile            = errors.New("Слишком большой файл")
-	ErrIncorrectPassword  = errors.New("incorrect login or password")
+	ErrLoginNotFound        = errors.New("Login not found")
+	ErrTokenNotFound        = errors.New("Token not found")
+	ErrNotValidToken        = errors.New("Not valid token")
+	ErrNoRowsSelected       = errors.New("No rows selected")
+	ErrUniqueViolation      = errors.New("Login unique violation")
+	ErrUserNotFound         = errors.New("User not found")
+	ErrUserRegister         = errors.New("error in user registration")
+	ErrUserAlreadyExists    = errors.New("Пользователь уже существует, придумайте другой логин")
+	ErrUserAuth             = errors.New("Неправильный логин или пароль")
+	ErrNoAuthentification   = errors.New("Сначала пройдите регистрацию или аутентификацию")
+	ErrBigFile              = errors.New("Слишком большой файл")
+	ErrIncorrectPassword    = errors.New("incorrect login or password")
+	ErrInvalidPasswordHash  = errors.New("invalid password hash format")
+	ErrRotationSecrets      = errors.New("rotation requires different old and new secrets")
+	ErrRefreshTokenNotFound = errors.New("Токен обновления не найден, пройдите аутентификацию")
+	ErrRefreshTokenExpired  = errors.New("Срок действия токена обновления истек, пройдите аутентификацию")
+	ErrSessionRevoked       = errors.New("Сессия завершена, пройдите аутентификацию")
+	ErrSecondFactorRequired = errors.New("Введите код из приложения-аутентификатора или код восстановления")
+	ErrIncorrectTOTPCode    = errors.New("Неверный код подтверждения")
+	ErrTOTPAlreadyEnabled   = errors.New("Второй фактор уже подключен")
+	ErrTOTPNotEnrolled      = errors.New("Второй фактор не подключен")
+	ErrChallengeExpired     = errors.New("Время на ввод кода истекло, пройдите аутентификацию заново")
+	ErrTooManyAttempts      = errors.New("Слишком много попыток ввода кода, пройдите аутентификацию заново")
+	ErrUnknownRotationPhase = errors.New("unknown key rotation phase")
+	ErrCipherTooShort       = errors.New("cipher data is too short")
+	ErrUnknownCipherVersion = errors.New("unknown cipher envelope version")
+	ErrLegacyCipher         = errors.New("secret is too short for legacy cipher format")
+	ErrNotClientSealed      = errors.New("encrypted data is not sealed by client")
+	ErrWrongMasterPassword  = errors.New("Не удалось расшифровать данные, проверьте мастер-пароль")
+	ErrVaultLocked          = errors.New("Данные зашифрованы на клиенте, введите мастер-пароль командой unlock")
+	ErrVaultCheckExists     = errors.New("Проверочное значение хранилища уже сохранено")
+	ErrPlaintextForbidden   = errors.New("Пользователь шифрует данные на клиенте, введите мастер-пароль командой unlock")
+	ErrInvalidArguments     = errors.New("Некорректные аргументы команды")
+	ErrFieldNotFound        = errors.New("Поле не найдено в записи")
+	ErrInternal             = errors.New("Внутренняя ошибка сервера")
+	ErrStorageBusy          = errors.New("Сервер временно не может выполнить запрос, повторите позже")
 )
@@ -0,0 +1,212 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Типы записей
+const (
+	DataTypeCredentials = "credentials"
+	DataTypeBankCard    = "card"
+	DataTypeText        = "text"
+	DataTypeBinary      = "binary"
+)
+
+// MaxBinarySize - максимальный размер бинарных данных в одной записи
+const MaxBinarySize = 1 << 20
+
+// Credentials - логин и пароль от стороннего сервиса
+type Credentials struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	URL      string `json:"url,omitempty"`
+}
+
+// BankCard - данные банковской карты
+type BankCard struct {
+	Number string `json:"number"`
+	// Expiry - срок действия в формате ММ/ГГ
+	Expiry string `json:"expiry"`
+	Holder string `json:"holder,omitempty"`
+	CVV    string `json:"cvv,omitempty"`
+}
+
+// TextNote - произвольный текст
+type TextNote struct {
+	Text string `json:"text"`
+}
+
+// BinaryData - произвольные бинарные данные
+type BinaryData struct {
+	Data     []byte `json:"data"`
+	FileName string `json:"fileName,omitempty"`
+}
+
+// Payload - содержимое типизированной записи. Заполнено ровно одно поле
+type Payload struct {
+	Credentials *Credentials `json:"credentials,omitempty"`
+	BankCard    *BankCard    `json:"card,omitempty"`
+	Text        *TextNote    `json:"text,omitempty"`
+	Binary      *BinaryData  `json:"binary,omitempty"`
+}
+
+var (
+	ErrInvalidPayload     = errors.New("Некорректные данные записи")
+	ErrUnknownDataType    = errors.New("Неизвестный тип записи")
+	ErrInvalidCardNumber  = errors.New("Некорректный номер карты")
+	ErrInvalidCardExpiry  = errors.New("Срок действия карты должен быть в формате ММ/ГГ")
+	ErrInvalidCardCVV     = errors.New("CVV должен состоять из 3 или 4 цифр")
+	ErrEmptyCredentials   = errors.New("Укажите логин или пароль")
+	ErrInvalidURL         = errors.New("Некорректный адрес сервиса")
+	ErrEmptyTextNote      = errors.New("Текст записи не может быть пустым")
+	ErrEmptyBinaryPayload = errors.New("Бинарные данные не могут быть пустыми")
+)
+
+// Type возвращает тип записи по заполненному полю
+func (p Payload) Type() string {
+	switch {
+	case p.Credentials != nil:
+		return DataTypeCredentials
+	case p.BankCard != nil:
+		return DataTypeBankCard
+	case p.Text != nil:
+		return DataTypeText
+	case p.Binary != nil:
+		return DataTypeBinary
+	}
+	return ""
+}
+
+// IsKnownDataType проверяет, что тип записи поддерживается
+func IsKnownDataType(dataType string) bool {
+	switch dataType {
+	case DataTypeCredentials, DataTypeBankCard, DataTypeText, DataTypeBinary:
+		return true
+	}
+	return false
+}
+
+// Encode сериализует содержимое записи для шифрования
+func (p Payload) Encode() (string, error) {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// DecodePayload восстанавливает содержимое записи типа dataType.
+// Для записей без типа возвращает nil
+func DecodePayload(dataType string, data string) (*Payload, error) {
+	if !IsKnownDataType(dataType) {
+		return nil, nil
+	}
+	var payload Payload
+	if err := json.Unmarshal([]byte(data), &payload); err != nil {
+		return nil, err
+	}
+	if payload.Type() != dataType {
+		return nil, ErrUnknownDataType
+	}
+	return &payload, nil
+}
+
+// Validate проверяет содержимое записи. Все ошибки проверки
+// оборачивают ErrInvalidPayload
+func (p Payload) Validate() error {
+	filled := 0
+	for _, set := range []bool{p.Credentials != nil, p.BankCard != nil, p.Text != nil,
+		p.Binary != nil} {
+		if set {
+			filled++
+		}
+	}
+	if filled != 1 {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, ErrUnknownDataType)
+	}
+
+	var err error
+	switch {
+	case p.Credentials != nil:
+		err = p.Credentials.validate()
+	case p.BankCard != nil:
+		err = p.BankCard.validate()
+	case p.Text != nil:
+		if p.Text.Text == "" {
+			err = ErrEmptyTextNote
+		}
+	case p.Binary != nil:
+		if len(p.Binary.Data) == 0 {
+			err = ErrEmptyBinaryPayload
+		} else if len(p.Binary.Data) > MaxBinarySize {
+			err = ErrBigFile
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidPayload, err)
+	}
+	return nil
+}
+
+func (c Credentials) validate() error {
+	if c.Login == "" && c.Password == "" {
+		return ErrEmptyCredentials
+	}
+	if c.URL != "" {
+		if _, err := url.ParseRequestURI(c.URL); err != nil {
+			return ErrInvalidURL
+		}
+	}
+	return nil
+}
+
+func (c BankCard) validate() error {
+	number := NormalizeCardNumber(c.Number)
+	if len(number) < 12 || len(number) > 19 || !isDigits(number) || !luhnValid(number) {
+		return ErrInvalidCardNumber
+	}
+	if _, err := time.Parse("01/06", c.Expiry); err != nil {
+		return ErrInvalidCardExpiry
+	}
+	if c.CVV != "" && (len(c.CVV) < 3 || len(c.CVV) > 4 || !isDigits(c.CVV)) {
+		return ErrInvalidCardCVV
+	}
+	return nil
+}
+
+// NormalizeCardNumber убирает из номера карты пробелы и дефисы
+func NormalizeCardNumber(number string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(number)
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// luhnValid проверяет контрольную цифру номера по алгоритму Луна
+func luhnValid(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,114 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPayloadValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload Payload
+		wantErr error
+	}{
+		{
+			name: "Логин и пароль",
+			payload: Payload{Credentials: &Credentials{
+				Login:    "user",
+				Password: "password",
+				URL:      "https://example.com/login",
+			}},
+		},
+		{
+			name:    "Пустые логин и пароль",
+			payload: Payload{Credentials: &Credentials{URL: "https://example.com"}},
+			wantErr: ErrEmptyCredentials,
+		},
+		{
+			name: "Карта с верным номером",
+			payload: Payload{BankCard: &BankCard{
+				Number: "4111 1111 1111 1111",
+				Expiry: "12/30",
+				CVV:    "123",
+			}},
+		},
+		{
+			name: "Номер карты не проходит проверку Луна",
+			payload: Payload{BankCard: &BankCard{
+				Number: "4111111111111112",
+				Expiry: "12/30",
+			}},
+			wantErr: ErrInvalidCardNumber,
+		},
+		{
+			name: "Неверный срок действия карты",
+			payload: Payload{BankCard: &BankCard{
+				Number: "4111111111111111",
+				Expiry: "13/30",
+			}},
+			wantErr: ErrInvalidCardExpiry,
+		},
+		{
+			name: "Неверный CVV",
+			payload: Payload{BankCard: &BankCard{
+				Number: "4111111111111111",
+				Expiry: "12/30",
+				CVV:    "12a",
+			}},
+			wantErr: ErrInvalidCardCVV,
+		},
+		{
+			name:    "Пустой текст",
+			payload: Payload{Text: &TextNote{}},
+			wantErr: ErrEmptyTextNote,
+		},
+		{
+			name:    "Бинарные данные",
+			payload: Payload{Binary: &BinaryData{Data: []byte{0, 1, 2}, FileName: "file.bin"}},
+		},
+		{
+			name:    "Слишком большой файл",
+			payload: Payload{Binary: &BinaryData{Data: make([]byte, MaxBinarySize+1)}},
+			wantErr: ErrBigFile,
+		},
+		{
+			name: "Заполнено несколько типов",
+			payload: Payload{
+				Text:   &TextNote{Text: "text"},
+				Binary: &BinaryData{Data: []byte{1}},
+			},
+			wantErr: ErrUnknownDataType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.payload.Validate()
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, ErrInvalidPayload)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestDecodePayload(t *testing.T) {
+	payload := Payload{Credentials: &Credentials{Login: "user", Password: "password"}}
+	encoded, err := payload.Encode()
+	require.NoError(t, err)
+
+	decoded, err := DecodePayload(payload.Type(), encoded)
+	require.NoError(t, err)
+	assert.Equal(t, &payload, decoded)
+
+	// запись без типа остается строкой
+	decoded, err = DecodePayload("", "data")
+	require.NoError(t, err)
+	assert.Nil(t, decoded)
+
+	_, err = DecodePayload(DataTypeBankCard, encoded)
+	assert.ErrorIs(t, err, ErrUnknownDataType)
+}
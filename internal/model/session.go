@@ -0,0 +1,17 @@
+package model
+
+import "errors"
+
+// ClientSession - сессия клиента, сохраненная между запусками
+type ClientSession struct {
+	Login    string `json:"login"`
+	JwtToken string `json:"jwtToken"`
+	// RefreshToken - токен обновления, пустой, если сервер его не выдал
+	RefreshToken string `json:"refreshToken,omitempty"`
+}
+
+var (
+	ErrNoSession       = errors.New("Сохраненной сессии нет, пройдите аутентификацию")
+	ErrInvalidSession  = errors.New("Не удалось прочитать сохраненную сессию, пройдите аутентификацию")
+	ErrInsecureKeyFile = errors.New("Файл сессии или ключа доступен другим пользователям, удалите его")
+)
@@ -0,0 +1,77 @@
+package model
+
+import "errors"
+
+const (
+	// SyncPageSize - сколько изменений сервер возвращает за один вызов Sync
+	SyncPageSize = 500
+	// OfflineToken - токен, который клиент выдает при входе без связи
+	// с сервером. Запросы с ним обслуживаются из локальной копии
+	OfflineToken = "offline"
+)
+
+// SyncOperation - изменение, сделанное клиентом без связи с сервером:
+// новая версия записи или ее удаление
+type SyncOperation struct {
+	Data    DataBlock
+	Deleted bool
+	// BaseVersion - версия записи, которую изменял клиент, 0 - новая запись.
+	// Если на сервере запись с тех пор изменилась, операция сохраняется как конфликт
+	BaseVersion int64
+	// Base - запись, которую изменял клиент, для сравнения при разрешении конфликта
+	Base *DataBlock
+}
+
+// ChangePosition - место изменения в порядке фиксации: транзакция Postgres,
+// в которой изменена запись, и номер изменения. Хранилища, которые
+// выполняют изменения по одному, оставляют TxID нулевым
+type ChangePosition struct {
+	TxID int64
+	Seq  int64
+}
+
+// After сообщает, что изменение в позиции p зафиксировано после other
+func (p ChangePosition) After(other ChangePosition) bool {
+	return p.TxID > other.TxID || p.TxID == other.TxID && p.Seq > other.Seq
+}
+
+// DataChange - изменение записи на сервере
+type DataChange struct {
+	Data    DataBlock
+	Header  DataHeader
+	Deleted bool
+	ChangePosition
+}
+
+// SyncResult - результат синхронизации: ошибки применения операций
+// клиента (пустая строка - операция применена) и изменения после курсора
+type SyncResult struct {
+	OperationErrors []string
+	Changes         []DataChange
+	Cursor          string
+	HasMore         bool
+	// Conflicts - сколько операций сохранено как конфликты
+	Conflicts int
+}
+
+// SyncStats - итог синхронизации на клиенте
+type SyncStats struct {
+	Pushed    int
+	Failed    []string
+	Pulled    int
+	Conflicts int
+}
+
+// CachedRecord - запись в локальной копии клиента
+type CachedRecord struct {
+	Header DataHeader
+	Data   DataBlock
+}
+
+var (
+	ErrInvalidSyncCursor  = errors.New("Некорректный курсор синхронизации")
+	ErrOffline            = errors.New("Сервер недоступен, работаем с локальной копией")
+	ErrSavedOffline       = errors.New("Сервер недоступен, изменение сохранено локально и будет отправлено командой sync")
+	ErrCacheLocked        = errors.New("Локальная копия недоступна, пройдите аутентификацию")
+	ErrWrongCachePassword = errors.New("Неверный логин или пароль для локальной копии")
+)
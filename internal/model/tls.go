@@ -0,0 +1,41 @@
+package model
+
+import "errors"
+
+// TLSConfig - настройки TLS серверов из секции tls файла конфигурации
+type TLSConfig struct {
+	CertFile string `json:"cert_file" env:"KEEPER_TLS_CERT_FILE"`
+	KeyFile  string `json:"key_file" env:"KEEPER_TLS_KEY_FILE"`
+	// ClientCAFile - сертификат центра, выпускающего сертификаты клиентов.
+	// Если задан, сервер проверяет сертификаты, которые предъявляют клиенты
+	ClientCAFile string `json:"client_ca_file" env:"KEEPER_TLS_CLIENT_CA_FILE"`
+	// RequireClientCert - принимать только клиентов с сертификатом (mTLS)
+	RequireClientCert bool `json:"require_client_cert" env:"KEEPER_TLS_REQUIRE_CLIENT_CERT"`
+	// Insecure - принимать соединения без TLS, только для локальной разработки
+	Insecure bool `json:"insecure" env:"KEEPER_TLS_INSECURE"`
+}
+
+// ClientTLS - настройки TLS клиента, задаются переменными окружения
+type ClientTLS struct {
+	// CAFile - сертификат центра, которому доверяет клиент, вместо системных
+	CAFile string `env:"KEEPER_CA_FILE"`
+	// CertFile и KeyFile - сертификат клиента для серверов с mTLS
+	CertFile string `env:"KEEPER_CERT_FILE"`
+	KeyFile  string `env:"KEEPER_KEY_FILE"`
+	// ServerName - имя сервера в сертификате, если оно отличается от адреса
+	ServerName string `env:"KEEPER_SERVER_NAME"`
+	// PinSHA256 - закрепленные отпечатки открытого ключа сервера (sha256
+	// от SubjectPublicKeyInfo в base64) через запятую
+	PinSHA256 string `env:"KEEPER_PIN_SHA256"`
+	// Insecure - подключаться без TLS, только для локальной разработки
+	Insecure bool `env:"KEEPER_INSECURE"`
+}
+
+var (
+	ErrTLSNotConfigured = errors.New("Укажите tls.cert_file и tls.key_file или включите tls.insecure для работы без TLS")
+	ErrClientCARequired = errors.New("Для проверки сертификатов клиентов укажите tls.client_ca_file")
+	ErrTLSFileNotFound  = errors.New("Файл сертификата не найден, создайте сертификаты командой сервера gen-certs")
+	ErrInvalidCA        = errors.New("Не удалось прочитать сертификаты центра сертификации")
+	ErrPinMismatch      = errors.New("Открытый ключ сервера не совпадает с закрепленным")
+	ErrClientKeyPair    = errors.New("Укажите сертификат и ключ клиента вместе")
+)
@@ -0,0 +1,31 @@
+package model
+
+import (
+	"errors"
+	"time"
+)
+
+// Значения по умолчанию для очистки корзины
+const (
+	DefaultTrashDays          = 30
+	DefaultTrashPurgeInterval = time.Hour
+)
+
+// TrashedRecord - удаленная запись в корзине
+type TrashedRecord struct {
+	ID     int64
+	Header DataHeader
+	// DeletedAt - время, когда запись переместили в корзину
+	DeletedAt time.Time
+}
+
+// TrashRetention - сколько хранить записи в корзине
+type TrashRetention struct {
+	// Days - через сколько дней запись удаляется из корзины навсегда,
+	// 0 - DefaultTrashDays
+	Days int `json:"days"`
+	// PurgeIntervalMinutes - период очистки корзины в минутах
+	PurgeIntervalMinutes int `json:"purge_interval_minutes"`
+}
+
+var ErrTrashNotFound = errors.New("Запись в корзине не найдена")
@@ -0,0 +1,33 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Сведения о несовпадении версии в ErrorInfo ответа gRPC
+const (
+	ErrorDomain            = "keeper"
+	VersionMismatchReason  = "VERSION_MISMATCH"
+	CurrentVersionMetadata = "currentVersion"
+)
+
+// ErrVersionMismatch - запись изменена после того, как клиент ее получил
+var ErrVersionMismatch = errors.New("Запись изменена на другом устройстве")
+
+// ErrVersionRequired - клиент не передал версию изменяемой записи
+var ErrVersionRequired = errors.New("Не указана версия изменяемой записи")
+
+// VersionMismatchError сообщает текущую версию записи на сервере,
+// чтобы клиент мог получить ее и повторить изменение
+type VersionMismatchError struct {
+	Current int64
+}
+
+func (e *VersionMismatchError) Error() string {
+	return fmt.Sprintf("%s, текущая версия %d", ErrVersionMismatch.Error(), e.Current)
+}
+
+func (e *VersionMismatchError) Unwrap() error {
+	return ErrVersionMismatch
+}
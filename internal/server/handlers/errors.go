@@ -0,0 +1,264 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"keeper/internal/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// retryDelay - через сколько клиенту стоит повторить запрос после
+// временной ошибки хранилища
+const retryDelay = time.Second
+
+// errorRule описывает ответ сервера на ошибку сервиса
+type errorRule struct {
+	// errs - ошибки, которые должна оборачивать ошибка сервиса
+	errs   []error
+	code   codes.Code
+	reason string
+	// field - поле запроса, к которому относится ошибка
+	field string
+	// message заменяет текст ошибки в ответе
+	message error
+	// retry - запрос можно повторить через retryDelay
+	retry bool
+}
+
+// errorRules сопоставляет ошибки из model статусам gRPC. Правила
+// проверяются по порядку, поэтому точные правила идут раньше общих
+var errorRules = []errorRule{
+	// ограничения размера данных и числа попыток
+	{errs: []error{model.ErrInvalidPayload, model.ErrBigFile}, code: codes.ResourceExhausted,
+		reason: "PAYLOAD_TOO_LARGE", field: "payload.binary.data"},
+	{errs: []error{model.ErrBigFile}, code: codes.ResourceExhausted,
+		reason: "FILE_TOO_LARGE", field: "info.size"},
+	{errs: []error{model.ErrFileChunkTooBig}, code: codes.ResourceExhausted,
+		reason: "FILE_CHUNK_TOO_LARGE", field: "data"},
+	{errs: []error{model.ErrTooManyAttempts}, code: codes.ResourceExhausted,
+		reason: "TOO_MANY_ATTEMPTS", field: "code"},
+
+	// проверка содержимого записи
+	{errs: []error{model.ErrInvalidCardNumber}, code: codes.InvalidArgument,
+		reason: "INVALID_PAYLOAD", field: "payload.bankCard.number"},
+	{errs: []error{model.ErrInvalidCardExpiry}, code: codes.InvalidArgument,
+		reason: "INVALID_PAYLOAD", field: "payload.bankCard.expiry"},
+	{errs: []error{model.ErrInvalidCardCVV}, code: codes.InvalidArgument,
+		reason: "INVALID_PAYLOAD", field: "payload.bankCard.cvv"},
+	{errs: []error{model.ErrEmptyCredentials}, code: codes.InvalidArgument,
+		reason: "INVALID_PAYLOAD", field: "payload.credentials"},
+	{errs: []error{model.ErrInvalidURL}, code: codes.InvalidArgument,
+		reason: "INVALID_PAYLOAD", field: "payload.credentials.url"},
+	{errs: []error{model.ErrEmptyTextNote}, code: codes.InvalidArgument,
+		reason: "INVALID_PAYLOAD", field: "payload.text.text"},
+	{errs: []error{model.ErrEmptyBinaryPayload}, code: codes.InvalidArgument,
+		reason: "INVALID_PAYLOAD", field: "payload.binary.data"},
+	{errs: []error{model.ErrUnknownDataType}, code: codes.InvalidArgument,
+		reason: "INVALID_PAYLOAD", field: "dataType"},
+	{errs: []error{model.ErrInvalidPayload}, code: codes.InvalidArgument,
+		reason: "INVALID_PAYLOAD", field: "payload"},
+	{errs: []error{model.ErrNotClientSealed}, code: codes.InvalidArgument,
+		reason: "NOT_CLIENT_SEALED", field: "encryptedData"},
+
+	// параметры запросов
+	{errs: []error{model.ErrInvalidSortField}, code: codes.InvalidArgument,
+		reason: "INVALID_LIST_QUERY", field: "sortBy"},
+	{errs: []error{model.ErrInvalidPageSize}, code: codes.InvalidArgument,
+		reason: "INVALID_LIST_QUERY", field: "pageSize"},
+	{errs: []error{model.ErrInvalidPageToken}, code: codes.InvalidArgument,
+		reason: "INVALID_LIST_QUERY", field: "pageToken"},
+	{errs: []error{model.ErrVersionRequired}, code: codes.InvalidArgument,
+		reason: "VERSION_REQUIRED", field: "expectedVersion"},
+	{errs: []error{model.ErrInvalidSyncCursor}, code: codes.InvalidArgument,
+		reason: "INVALID_SYNC_CURSOR", field: "cursor"},
+	{errs: []error{model.ErrUnknownResolution}, code: codes.InvalidArgument,
+		reason: "INVALID_RESOLUTION", field: "resolution"},
+	{errs: []error{model.ErrRenamedKeyRequired}, code: codes.InvalidArgument,
+		reason: "INVALID_RESOLUTION", field: "renamedKey"},
+	{errs: []error{model.ErrKeepBothFile}, code: codes.InvalidArgument,
+		reason: "INVALID_RESOLUTION", field: "strategy"},
+
+	// поток загрузки файла
+	{errs: []error{model.ErrFileInfoRequired}, code: codes.InvalidArgument,
+		reason: "INVALID_FILE", field: "info"},
+	{errs: []error{model.ErrFileSizeMismatch}, code: codes.InvalidArgument,
+		reason: "INVALID_FILE", field: "info.size"},
+	{errs: []error{model.ErrChecksumRequired}, code: codes.InvalidArgument,
+		reason: "INVALID_FILE", field: "checksum"},
+	{errs: []error{model.ErrInvalidFile}, code: codes.InvalidArgument, reason: "INVALID_FILE"},
+
+	{errs: []error{model.ErrNoRowsSelected}, code: codes.NotFound, reason: "RECORD_NOT_FOUND"},
+	{errs: []error{model.ErrConflictNotFound}, code: codes.NotFound, reason: "CONFLICT_NOT_FOUND"},
+	{errs: []error{model.ErrRevisionNotFound}, code: codes.NotFound, reason: "REVISION_NOT_FOUND"},
+	{errs: []error{model.ErrTrashNotFound}, code: codes.NotFound, reason: "TRASH_NOT_FOUND"},
+	{errs: []error{model.ErrUploadNotFound}, code: codes.NotFound, reason: "UPLOAD_NOT_FOUND"},
+
+	{errs: []error{model.ErrUserAlreadyExists}, code: codes.AlreadyExists, reason: "USER_EXISTS"},
+	{errs: []error{model.ErrDataKeyWordExists}, code: codes.AlreadyExists, reason: "RECORD_EXISTS"},
+	{errs: []error{model.ErrTOTPAlreadyEnabled}, code: codes.AlreadyExists, reason: "TOTP_ENABLED"},
+	{errs: []error{model.ErrVaultCheckExists}, code: codes.AlreadyExists,
+		reason: "VAULT_CHECK_EXISTS"},
+
+	// неизвестный логин и неверный пароль не различаются в ответе
+	{errs: []error{model.ErrUserNotFound}, code: codes.Unauthenticated,
+		reason: "INVALID_CREDENTIALS", message: model.ErrUserAuth},
+	{errs: []error{model.ErrIncorrectPassword}, code: codes.Unauthenticated,
+		reason: "INVALID_CREDENTIALS", message: model.ErrUserAuth},
+	{errs: []error{model.ErrTokenNotFound}, code: codes.Unauthenticated, reason: "INVALID_TOKEN"},
+	{errs: []error{model.ErrNotValidToken}, code: codes.Unauthenticated, reason: "INVALID_TOKEN"},
+	{errs: []error{model.ErrSessionRevoked}, code: codes.Unauthenticated, reason: "SESSION_REVOKED"},
+	{errs: []error{model.ErrRefreshTokenNotFound}, code: codes.Unauthenticated,
+		reason: "INVALID_REFRESH_TOKEN"},
+	{errs: []error{model.ErrRefreshTokenExpired}, code: codes.Unauthenticated,
+		reason: "INVALID_REFRESH_TOKEN"},
+	{errs: []error{model.ErrIncorrectTOTPCode}, code: codes.Unauthenticated, reason: "INVALID_CODE"},
+	{errs: []error{model.ErrChallengeExpired}, code: codes.Unauthenticated,
+		reason: "CHALLENGE_EXPIRED"},
+
+	{errs: []error{model.ErrPlaintextForbidden}, code: codes.PermissionDenied,
+		reason: "CLIENT_SEALING_REQUIRED"},
+
+	{errs: []error{model.ErrTOTPNotEnrolled}, code: codes.FailedPrecondition,
+		reason: "TOTP_NOT_ENROLLED"},
+	{errs: []error{model.ErrNotFile}, code: codes.FailedPrecondition, reason: "NOT_FILE"},
+	{errs: []error{model.ErrFileReadOnly}, code: codes.FailedPrecondition, reason: "FILE_READ_ONLY"},
+
+	{errs: []error{model.ErrChecksumMismatch}, code: codes.DataLoss, reason: "FILE_CORRUPTED"},
+	{errs: []error{model.ErrFileChunkNotFound}, code: codes.DataLoss, reason: "FILE_CORRUPTED"},
+
+	// хранилище помечает ошибки, после которых запрос можно повторить.
+	// Текст исходной ошибки остается только в журнале
+	{errs: []error{model.ErrStorageBusy}, code: codes.Unavailable, reason: "STORAGE_BUSY",
+		message: model.ErrStorageBusy, retry: true},
+}
+
+// changeFields - поля ChangingRequest, в которых передается запись
+var changeFields = map[string]string{
+	"payload":       "payloadForChange",
+	"encryptedData": "encryptedDataForChange",
+	"dataType":      "dataTypeForChange",
+}
+
+// mergedFields - поля записи, собранной пользователем при разрешении конфликта
+var mergedFields = map[string]string{
+	"payload":       "merged.payload",
+	"encryptedData": "merged.encryptedData",
+	"dataType":      "merged.dataType",
+}
+
+// statusError преобразует ошибку сервиса в статус gRPC
+func statusError(log *logrus.Logger, err error) error {
+	return recordStatusError(log, err, nil)
+}
+
+// recordStatusError преобразует ошибку сервиса в статус gRPC. Поля записи
+// из правил переименовываются в поля запроса по fields
+func recordStatusError(log *logrus.Logger, err error, fields map[string]string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	var mismatch *model.VersionMismatchError
+	if errors.As(err, &mismatch) {
+		return versionMismatchStatus(mismatch)
+	}
+
+	for _, rule := range errorRules {
+		if !rule.matches(err) {
+			continue
+		}
+		if name, ok := fields[rule.fieldRoot()]; ok {
+			rule.field = name + strings.TrimPrefix(rule.field, rule.fieldRoot())
+		}
+		if rule.retry {
+			log.Error(err.Error())
+		}
+		return rule.status(err)
+	}
+
+	log.Error(err.Error())
+	return status.Error(codes.Internal, model.ErrInternal.Error())
+}
+
+// matches проверяет, что ошибка оборачивает все ошибки правила
+func (rule errorRule) matches(err error) bool {
+	for _, target := range rule.errs {
+		if !errors.Is(err, target) {
+			return false
+		}
+	}
+	return true
+}
+
+// fieldRoot возвращает первый элемент пути к полю запроса
+func (rule errorRule) fieldRoot() string {
+	root, _, _ := strings.Cut(rule.field, ".")
+	return root
+}
+
+// status собирает статус gRPC по правилу. Причина ошибки передается
+// в ErrorInfo, поле запроса - в BadRequest для InvalidArgument
+// и в QuotaFailure для ResourceExhausted, задержка повтора - в RetryInfo
+func (rule errorRule) status(err error) error {
+	message := err.Error()
+	if rule.message != nil {
+		message = rule.message.Error()
+	}
+
+	st, detailsErr := status.New(rule.code, message).WithDetails(&errdetails.ErrorInfo{
+		Reason: rule.reason,
+		Domain: model.ErrorDomain,
+	})
+	if detailsErr == nil && rule.field != "" {
+		switch rule.code {
+		case codes.InvalidArgument:
+			st, detailsErr = st.WithDetails(&errdetails.BadRequest{
+				FieldViolations: []*errdetails.BadRequest_FieldViolation{
+					{Field: rule.field, Description: message},
+				},
+			})
+		case codes.ResourceExhausted:
+			st, detailsErr = st.WithDetails(&errdetails.QuotaFailure{
+				Violations: []*errdetails.QuotaFailure_Violation{
+					{Subject: rule.field, Description: message},
+				},
+			})
+		}
+	}
+	if detailsErr == nil && rule.retry {
+		st, detailsErr = st.WithDetails(&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(retryDelay),
+		})
+	}
+	if detailsErr != nil {
+		return status.Error(rule.code, message)
+	}
+	return st.Err()
+}
+
+// versionMismatchStatus возвращает FailedPrecondition с текущей версией
+// записи в ErrorInfo
+func versionMismatchStatus(mismatch *model.VersionMismatchError) error {
+	st, err := status.New(codes.FailedPrecondition, mismatch.Error()).WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: model.VersionMismatchReason,
+			Domain: model.ErrorDomain,
+			Metadata: map[string]string{
+				model.CurrentVersionMetadata: strconv.FormatInt(mismatch.Current, 10),
+			},
+		})
+	if err != nil {
+		return status.Error(codes.FailedPrecondition, mismatch.Error())
+	}
+	return st.Err()
+}
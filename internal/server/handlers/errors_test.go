@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		name        string
+		err         error
+		fields      map[string]string
+		wantCode    codes.Code
+		wantMessage string
+		wantReason  string
+		wantField   string
+		wantQuota   string
+		wantRetry   bool
+	}{
+		{
+			name:        "Запись с таким ключом уже есть",
+			err:         model.ErrDataKeyWordExists,
+			wantCode:    codes.AlreadyExists,
+			wantMessage: model.ErrDataKeyWordExists.Error(),
+			wantReason:  "RECORD_EXISTS",
+		},
+		{
+			name:        "Неизвестный логин",
+			err:         model.ErrUserNotFound,
+			wantCode:    codes.Unauthenticated,
+			wantMessage: model.ErrUserAuth.Error(),
+			wantReason:  "INVALID_CREDENTIALS",
+		},
+		{
+			name:        "Удаление отсутствующей записи",
+			err:         model.ErrNoRowsSelected,
+			wantCode:    codes.NotFound,
+			wantMessage: model.ErrNoRowsSelected.Error(),
+			wantReason:  "RECORD_NOT_FOUND",
+		},
+		{
+			name:       "Некорректный номер карты",
+			err:        fmt.Errorf("%w: %w", model.ErrInvalidPayload, model.ErrInvalidCardNumber),
+			wantCode:   codes.InvalidArgument,
+			wantReason: "INVALID_PAYLOAD",
+			wantField:  "payload.bankCard.number",
+		},
+		{
+			name:       "Некорректный номер карты при изменении",
+			err:        fmt.Errorf("%w: %w", model.ErrInvalidPayload, model.ErrInvalidCardNumber),
+			fields:     changeFields,
+			wantCode:   codes.InvalidArgument,
+			wantReason: "INVALID_PAYLOAD",
+			wantField:  "payloadForChange.bankCard.number",
+		},
+		{
+			name:        "Проверочное значение хранилища уже сохранено",
+			err:         model.ErrVaultCheckExists,
+			wantCode:    codes.AlreadyExists,
+			wantMessage: model.ErrVaultCheckExists.Error(),
+			wantReason:  "VAULT_CHECK_EXISTS",
+		},
+		{
+			name:       "Изменение записи без версии",
+			err:        model.ErrVersionRequired,
+			wantCode:   codes.InvalidArgument,
+			wantReason: "VERSION_REQUIRED",
+			wantField:  "expectedVersion",
+		},
+		{
+			name:       "Некорректный размер страницы",
+			err:        model.ErrInvalidPageSize,
+			wantCode:   codes.InvalidArgument,
+			wantReason: "INVALID_LIST_QUERY",
+			wantField:  "pageSize",
+		},
+		{
+			name:       "Слишком большой файл",
+			err:        fmt.Errorf("%w: %w", model.ErrInvalidFile, model.ErrBigFile),
+			wantCode:   codes.ResourceExhausted,
+			wantReason: "FILE_TOO_LARGE",
+			wantQuota:  "info.size",
+		},
+		{
+			name:       "Слишком большие бинарные данные",
+			err:        fmt.Errorf("%w: %w", model.ErrInvalidPayload, model.ErrBigFile),
+			wantCode:   codes.ResourceExhausted,
+			wantReason: "PAYLOAD_TOO_LARGE",
+			wantQuota:  "payload.binary.data",
+		},
+		{
+			name:       "Исчерпаны попытки ввода кода",
+			err:        model.ErrTooManyAttempts,
+			wantCode:   codes.ResourceExhausted,
+			wantReason: "TOO_MANY_ATTEMPTS",
+			wantQuota:  "code",
+		},
+		{
+			name:        "Хранилище временно недоступно",
+			err:         fmt.Errorf("%w: serialization failure", model.ErrStorageBusy),
+			wantCode:    codes.Unavailable,
+			wantMessage: model.ErrStorageBusy.Error(),
+			wantReason:  "STORAGE_BUSY",
+			wantRetry:   true,
+		},
+		{
+			name:       "Загрузка файла удалена",
+			err:        model.ErrUploadNotFound,
+			wantCode:   codes.NotFound,
+			wantReason: "UPLOAD_NOT_FOUND",
+		},
+		{
+			name:       "Открытые данные пользователя с мастер-паролем",
+			err:        model.ErrPlaintextForbidden,
+			wantCode:   codes.PermissionDenied,
+			wantReason: "CLIENT_SEALING_REQUIRED",
+		},
+		{
+			name:        "Неизвестная ошибка",
+			err:         errors.New("connection refused"),
+			wantCode:    codes.Internal,
+			wantMessage: model.ErrInternal.Error(),
+		},
+		{
+			name:     "Запрос отменен клиентом",
+			err:      fmt.Errorf("upload: %w", context.Canceled),
+			wantCode: codes.Canceled,
+		},
+	}
+
+	log := logger.InitLog(logrus.InfoLevel)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, ok := status.FromError(recordStatusError(log, tt.err, tt.fields))
+			require.True(t, ok)
+			assert.Equal(t, tt.wantCode, st.Code())
+			if tt.wantMessage != "" {
+				assert.Equal(t, tt.wantMessage, st.Message())
+			}
+
+			var reason, field, quota string
+			var retry time.Duration
+			for _, detail := range st.Details() {
+				switch detail := detail.(type) {
+				case *errdetails.ErrorInfo:
+					assert.Equal(t, model.ErrorDomain, detail.Domain)
+					reason = detail.Reason
+				case *errdetails.BadRequest:
+					require.Len(t, detail.FieldViolations, 1)
+					field = detail.FieldViolations[0].Field
+				case *errdetails.QuotaFailure:
+					require.Len(t, detail.Violations, 1)
+					quota = detail.Violations[0].Subject
+				case *errdetails.RetryInfo:
+					retry = detail.RetryDelay.AsDuration()
+				}
+			}
+			assert.Equal(t, tt.wantReason, reason)
+			assert.Equal(t, tt.wantField, field)
+			assert.Equal(t, tt.wantQuota, quota)
+			assert.Equal(t, tt.wantRetry, retry > 0)
+		})
+	}
+}
+
+func TestVersionMismatchStatus(t *testing.T) {
+	err := statusError(logger.InitLog(logrus.InfoLevel),
+		fmt.Errorf("change: %w", &model.VersionMismatchError{Current: 3}))
+	st, ok := status.FromError(err)
+	require.True(t, ok)
+	assert.Equal(t, codes.FailedPrecondition, st.Code())
+	require.Len(t, st.Details(), 1)
+	info, ok := st.Details()[0].(*errdetails.ErrorInfo)
+	require.True(t, ok)
+	assert.Equal(t, model.VersionMismatchReason, info.Reason)
+	assert.Equal(t, "3", info.Metadata[model.CurrentVersionMetadata])
+}
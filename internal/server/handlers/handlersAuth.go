@@ -5,21 +5,40 @@ import (
 	"errors"
 	"keeper/internal/model"
 	auth "keeper/internal/server/handlers/proto/authService"
+	data "keeper/internal/server/handlers/proto/dataService"
 
-	"github.com/jackc/pgconn"
-	"github.com/jackc/pgerrcode"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
 )
 
 type Service interface {
-	UserRegister(ctx context.Context, login string, password string) (string, error)
-	UserAuthentification(ctx context.Context, login string, password string) (string, error)
+	CheckSession(ctx context.Context) error
+	UserRegister(ctx context.Context, login string, password string) (model.Tokens, error)
+	UserAuthentification(ctx context.Context, login string, password string) (model.Tokens, error)
+	RefreshToken(ctx context.Context, refreshToken string) (model.Tokens, error)
+	CompleteSecondFactor(ctx context.Context, challenge string, code string) (model.Tokens, error)
+	Logout(ctx context.Context) error
+	LogoutAll(ctx context.Context) (int64, error)
+	EnrollTOTP(ctx context.Context) (model.TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, code string) ([]string, error)
 	AddData(ctx context.Context, data model.DataBlock) error
 	GetData(ctx context.Context, dataKeyWord string) ([]model.DataBlock, error)
-	ChangeData(ctx context.Context, dataForChange model.DataBlock) error
+	ChangeData(ctx context.Context, dataForChange model.DataBlock) (int64, error)
 	DeleteData(ctx context.Context, dataKeyWord string) error
+	ListData(ctx context.Context, query model.ListQuery) ([]model.DataHeader, string, error)
+	Sync(ctx context.Context, cursor string, operations []model.SyncOperation) (model.SyncResult, error)
+	ListConflicts(ctx context.Context, dataKeyWord string) ([]model.Conflict, error)
+	ResolveConflict(ctx context.Context, resolution model.ConflictResolution) (int64, error)
+	GetHistory(ctx context.Context, dataKeyWord string) ([]model.Revision, error)
+	RestoreRevision(ctx context.Context, revisionID int64, expectedVersion int64) (int64, error)
+	ListTrash(ctx context.Context) ([]model.TrashedRecord, error)
+	RestoreFromTrash(ctx context.Context, trashID int64) (int64, error)
+	EmptyTrash(ctx context.Context) (int64, error)
+	GetVaultCheck(ctx context.Context) ([]byte, error)
+	SetVaultCheck(ctx context.Context, check []byte) error
+	UploadFile(ctx context.Context, recv func() (model.FileChunk, error)) (model.FileInfo, error)
+	DownloadFile(ctx context.Context, dataKeyWord string, send func(model.FileChunk) error) error
 }
 
 // HandlerAuth реализует методы-хэндлеры регистрации
@@ -39,23 +58,37 @@ func NewHandlersAuth(service Service, log *logrus.Logger) *HandlersAuth {
 	return h
 }
 
+// publicMethods - методы AuthService, которые вызываются без jwt токена
+var publicMethods = map[string]bool{
+	auth.AuthService_UserRegister_FullMethodName:         true,
+	auth.AuthService_UserAuth_FullMethodName:             true,
+	auth.AuthService_CompleteSecondFactor_FullMethodName: true,
+	auth.AuthService_RefreshToken_FullMethodName:         true,
+}
+
+// AuthFuncOverride заменяет проверку jwt токена в интерсепторе для методов
+// AuthService: регистрация, вход и обновление токена доступны без токена,
+// остальные методы проверяют сессию так же, как DataService
+func (h HandlersAuth) AuthFuncOverride(ctx context.Context, fullMethodName string) (
+	context.Context, error) {
+	if publicMethods[fullMethodName] {
+		return ctx, nil
+	}
+	return data.NewAuthInterceptor(h.service, h.log)(ctx)
+}
+
 // UserRegister - хэндлер для регистрации пользователя
 func (h HandlersAuth) UserRegister(ctx context.Context, in *auth.RegisterRequest) (
 	*auth.RegisterResponse, error) {
 	var response auth.RegisterResponse
 	h.log.Debug("Хэндлер для регистрации пользователя")
-	jwtString, err := h.service.UserRegister(ctx, in.Login, in.Password)
+	tokens, err := h.service.UserRegister(ctx, in.Login, in.Password)
 	if err != nil {
-		var pgxError *pgconn.PgError
-		if errors.As(err, &pgxError) {
-			if pgxError.Code == pgerrcode.UniqueViolation {
-				return nil, status.Errorf(codes.AlreadyExists, model.ErrUserAlreadyExists.Error())
-			}
-		}
-		return nil, status.Errorf(codes.Internal, err.Error())
+		return nil, statusError(h.log, err)
 	}
 
-	response.JwtToken = jwtString
+	response.JwtToken = tokens.AccessToken
+	response.RefreshToken = tokens.RefreshToken
 
 	return &response, nil
 }
@@ -65,13 +98,94 @@ func (h HandlersAuth) UserAuth(ctx context.Context, in *auth.AuthRequest) (
 	*auth.AuthResponse, error) {
 	var response auth.AuthResponse
 	h.log.Debug("Хэндлер для аутентификации пользователя")
-	jwtString, err := h.service.UserAuthentification(ctx, in.Login, in.Password)
+	tokens, err := h.service.UserAuthentification(ctx, in.Login, in.Password)
 	if err != nil {
-		if errors.Is(err, model.ErrIncorrectPassword) {
-			return nil, status.Errorf(codes.Unauthenticated, model.ErrUserAuth.Error())
-		}
-		return nil, status.Errorf(codes.Internal, err.Error())
+		return nil, statusError(h.log, err)
+	}
+	if tokens.Challenge != "" {
+		response.SecondFactorRequired = true
+		response.Challenge = tokens.Challenge
+		return &response, nil
+	}
+	response.JwtToken = tokens.AccessToken
+	response.RefreshToken = tokens.RefreshToken
+	return &response, nil
+}
+
+// CompleteSecondFactor - хэндлер для завершения входа кодом второго фактора
+func (h HandlersAuth) CompleteSecondFactor(ctx context.Context, in *auth.SecondFactorRequest) (
+	*auth.AuthResponse, error) {
+	var response auth.AuthResponse
+	h.log.Debug("Хэндлер для проверки второго фактора")
+	tokens, err := h.service.CompleteSecondFactor(ctx, in.Challenge, in.Code)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+	response.JwtToken = tokens.AccessToken
+	response.RefreshToken = tokens.RefreshToken
+	return &response, nil
+}
+
+// RefreshToken - хэндлер для обновления токена доступа
+func (h HandlersAuth) RefreshToken(ctx context.Context, in *auth.RefreshRequest) (
+	*auth.RefreshResponse, error) {
+	var response auth.RefreshResponse
+	h.log.Debug("Хэндлер для обновления токена доступа")
+	tokens, err := h.service.RefreshToken(ctx, in.RefreshToken)
+	if err != nil {
+		return nil, statusError(h.log, err)
 	}
-	response.JwtToken = jwtString
+	response.JwtToken = tokens.AccessToken
+	response.RefreshToken = tokens.RefreshToken
 	return &response, nil
 }
+
+// Logout - хэндлер для завершения текущей сессии пользователя
+func (h HandlersAuth) Logout(ctx context.Context, in *emptypb.Empty) (*emptypb.Empty, error) {
+	h.log.Debug("Хэндлер для завершения сессии")
+	if err := h.service.Logout(ctx); err != nil {
+		return nil, statusError(h.log, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// LogoutAll - хэндлер для завершения всех сессий пользователя
+func (h HandlersAuth) LogoutAll(ctx context.Context, in *emptypb.Empty) (
+	*auth.LogoutAllResponse, error) {
+	h.log.Debug("Хэндлер для завершения всех сессий")
+	revoked, err := h.service.LogoutAll(ctx)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+	return &auth.LogoutAllResponse{RevokedSessions: revoked}, nil
+}
+
+// EnrollTOTP - хэндлер для подключения приложения-аутентификатора
+func (h HandlersAuth) EnrollTOTP(ctx context.Context, in *emptypb.Empty) (
+	*auth.EnrollTOTPResponse, error) {
+	h.log.Debug("Хэндлер для подключения второго фактора")
+	enrollment, err := h.service.EnrollTOTP(ctx)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+	return &auth.EnrollTOTPResponse{
+		Secret:     enrollment.Secret,
+		OtpauthURI: enrollment.URI,
+	}, nil
+}
+
+// ConfirmTOTP - хэндлер для подтверждения первого кода второго фактора
+func (h HandlersAuth) ConfirmTOTP(ctx context.Context, in *auth.ConfirmTOTPRequest) (
+	*auth.ConfirmTOTPResponse, error) {
+	h.log.Debug("Хэндлер для подтверждения второго фактора")
+	recoveryCodes, err := h.service.ConfirmTOTP(ctx, in.Code)
+	if err != nil {
+		// пользователь уже вошел, поэтому неверный код - ошибка аргумента
+		if errors.Is(err, model.ErrIncorrectTOTPCode) {
+			rule := errorRule{code: codes.InvalidArgument, reason: "INVALID_CODE", field: "code"}
+			return nil, rule.status(err)
+		}
+		return nil, statusError(h.log, err)
+	}
+	return &auth.ConfirmTOTPResponse{RecoveryCodes: recoveryCodes}, nil
+}
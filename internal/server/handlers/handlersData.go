@@ -2,14 +2,12 @@ package handlers
 
 import (
 	"context"
-	"errors"
 	"keeper/internal/model"
 	data "keeper/internal/server/handlers/proto/dataService"
 
 	"github.com/sirupsen/logrus"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // HandlersData релизует методы-хэндлеры для CRUD операций с данными
@@ -32,15 +30,8 @@ func (h HandlersData) AddData(ctx context.Context, in *data.AddingRequest) (
 	*emptypb.Empty, error) {
 	h.log.Debug("Хэндлер для добавления данных")
 
-	data := model.DataBlock{
-		DataKeyWord: in.DataKeyWord,
-		DataType:    in.DataType,
-		Data:        in.Data,
-		MetaData:    in.MetaData,
-	}
-
-	if err := h.service.AddData(ctx, data); err != nil {
-		return &emptypb.Empty{}, status.Errorf(codes.Internal, "error in adding data")
+	if err := h.service.AddData(ctx, dataBlockFromRequest(in)); err != nil {
+		return &emptypb.Empty{}, statusError(h.log, err)
 	}
 	return &emptypb.Empty{}, nil
 }
@@ -50,39 +41,296 @@ func (h HandlersData) GetData(ctx context.Context, in *data.GetRequest) (
 	*data.GetResponseList, error) {
 	h.log.Debug("Хэндлер для получения данных")
 	dataResponseList := &data.GetResponseList{}
-	var dataGetResponse data.GetResponse
 
-	data, err := h.service.GetData(ctx, in.DataKeyWord)
+	dataBlocks, err := h.service.GetData(ctx, in.DataKeyWord)
 	if err != nil {
-		if errors.Is(err, model.ErrNoRowsSelected) {
-			return nil, status.Error(codes.NotFound, model.ErrNoRowsSelected.Error())
-		}
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, statusError(h.log, err)
 	}
 
-	for _, dataLine := range data {
-		dataGetResponse.DataKeyWord = dataLine.DataKeyWord
-		dataGetResponse.Data = dataLine.Data
-		dataGetResponse.MetaData = dataLine.MetaData
-		dataResponseList.Response = append(dataResponseList.Response, &dataGetResponse)
+	for _, dataLine := range dataBlocks {
+		dataResponseList.Response = append(dataResponseList.Response, responseFromDataBlock(dataLine))
 	}
 	return dataResponseList, nil
 }
 
+// dataBlockFromRequest преобразует запрос на добавление в блок данных
+func dataBlockFromRequest(in *data.AddingRequest) model.DataBlock {
+	return model.DataBlock{
+		DataKeyWord:   in.GetDataKeyWord(),
+		DataType:      in.GetDataType(),
+		Data:          in.GetData(),
+		EncryptedData: in.GetEncryptedData(),
+		MetaData:      in.GetMetaData(),
+		Payload:       data.PayloadToModel(in.GetPayload()),
+	}
+}
+
+// responseFromDataBlock преобразует блок данных в ответ клиенту
+func responseFromDataBlock(dataBlock model.DataBlock) *data.GetResponse {
+	return &data.GetResponse{
+		DataKeyWord:   dataBlock.DataKeyWord,
+		DataType:      dataBlock.DataType,
+		Data:          dataBlock.Data,
+		EncryptedData: dataBlock.EncryptedData,
+		MetaData:      dataBlock.MetaData,
+		Payload:       data.PayloadFromModel(dataBlock.Payload),
+		Version:       dataBlock.Version,
+	}
+}
+
+// headerToProto преобразует заголовок записи в сообщение protobuf
+func headerToProto(header model.DataHeader) *data.DataHeader {
+	return &data.DataHeader{
+		DataKeyWord: header.DataKeyWord,
+		DataType:    header.DataType,
+		MetaData:    header.MetaData,
+		CreatedAt:   timestamppb.New(header.CreatedAt),
+		UpdatedAt:   timestamppb.New(header.UpdatedAt),
+		Size:        header.Size,
+		Version:     header.Version,
+	}
+}
+
+// ListData - хэндлер для получения списка записей пользователя
+func (h HandlersData) ListData(ctx context.Context, in *data.ListRequest) (
+	*data.ListResponse, error) {
+	h.log.Debug("Хэндлер для получения списка записей")
+
+	headers, nextPageToken, err := h.service.ListData(ctx, model.ListQuery{
+		DataType:   in.DataType,
+		KeyPrefix:  in.KeyPrefix,
+		SortBy:     in.SortBy,
+		Descending: in.Descending,
+		PageSize:   in.PageSize,
+		PageToken:  in.PageToken,
+	})
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+
+	response := &data.ListResponse{NextPageToken: nextPageToken}
+	for _, header := range headers {
+		response.Headers = append(response.Headers, headerToProto(header))
+	}
+	return response, nil
+}
+
+// Sync - хэндлер для синхронизации локальной копии клиента
+func (h HandlersData) Sync(ctx context.Context, in *data.SyncRequest) (*data.SyncResponse, error) {
+	h.log.Debug("Хэндлер для синхронизации данных")
+
+	operations := make([]model.SyncOperation, 0, len(in.Operations))
+	for _, operation := range in.Operations {
+		syncOperation := model.SyncOperation{
+			Data:        dataBlockFromRequest(operation.GetRecord()),
+			Deleted:     operation.GetDeleted(),
+			BaseVersion: operation.GetBaseVersion(),
+		}
+		if operation.Base != nil {
+			base := dataBlockFromRequest(operation.Base)
+			syncOperation.Base = &base
+		}
+		operations = append(operations, syncOperation)
+	}
+
+	result, err := h.service.Sync(ctx, in.Cursor, operations)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+
+	response := &data.SyncResponse{
+		OperationErrors: result.OperationErrors,
+		Cursor:          result.Cursor,
+		HasMore:         result.HasMore,
+		Conflicts:       int32(result.Conflicts),
+	}
+	for _, change := range result.Changes {
+		syncChange := &data.SyncChange{
+			Deleted: change.Deleted,
+			Header:  headerToProto(change.Header),
+		}
+		if !change.Deleted {
+			syncChange.Record = responseFromDataBlock(change.Data)
+		}
+		response.Changes = append(response.Changes, syncChange)
+	}
+	return response, nil
+}
+
+// ListConflicts - хэндлер для получения конфликтов изменений записей
+func (h HandlersData) ListConflicts(ctx context.Context, in *data.ListConflictsRequest) (
+	*data.ListConflictsResponse, error) {
+	h.log.Debug("Хэндлер для получения конфликтов")
+
+	conflicts, err := h.service.ListConflicts(ctx, in.DataKeyWord)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+
+	response := &data.ListConflictsResponse{}
+	for _, conflict := range conflicts {
+		protoConflict := &data.Conflict{
+			Id:          conflict.ID,
+			DataKeyWord: conflict.DataKeyWord,
+			BaseVersion: conflict.BaseVersion,
+			MineDeleted: conflict.MineDeleted,
+			CreatedAt:   timestamppb.New(conflict.CreatedAt),
+		}
+		if conflict.Base != nil {
+			protoConflict.Base = responseFromDataBlock(*conflict.Base)
+		}
+		if !conflict.MineDeleted {
+			protoConflict.Mine = responseFromDataBlock(conflict.Mine)
+		}
+		if conflict.Theirs != nil {
+			protoConflict.Theirs = responseFromDataBlock(*conflict.Theirs)
+		}
+		response.Conflicts = append(response.Conflicts, protoConflict)
+	}
+	return response, nil
+}
+
+// ResolveConflict - хэндлер для разрешения конфликта выбором пользователя
+func (h HandlersData) ResolveConflict(ctx context.Context, in *data.ResolveConflictRequest) (
+	*data.ResolveConflictResponse, error) {
+	h.log.Debug("Хэндлер для разрешения конфликта")
+
+	version, err := h.service.ResolveConflict(ctx, model.ConflictResolution{
+		ConflictID:      in.Id,
+		Strategy:        in.Resolution,
+		ExpectedVersion: in.ExpectedVersion,
+		Merged:          dataBlockFromRequest(in.GetMerged()),
+		RenamedKey:      in.RenamedKey,
+	})
+	if err != nil {
+		return nil, recordStatusError(h.log, err, mergedFields)
+	}
+	return &data.ResolveConflictResponse{Version: version}, nil
+}
+
+// GetHistory - хэндлер для получения прежних версий записи
+func (h HandlersData) GetHistory(ctx context.Context, in *data.GetHistoryRequest) (
+	*data.GetHistoryResponse, error) {
+	h.log.Debug("Хэндлер для получения истории записи")
+
+	revisions, err := h.service.GetHistory(ctx, in.DataKeyWord)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+
+	response := &data.GetHistoryResponse{}
+	for _, revision := range revisions {
+		response.Revisions = append(response.Revisions, &data.Revision{
+			Id:         revision.ID,
+			Record:     responseFromDataBlock(revision.Data),
+			Device:     revision.Device,
+			CreatedAt:  timestamppb.New(revision.CreatedAt),
+			ReplacedAt: timestamppb.New(revision.ReplacedAt),
+			Deleted:    revision.Deleted,
+		})
+	}
+	return response, nil
+}
+
+// RestoreRevision - хэндлер для восстановления прежней версии записи
+func (h HandlersData) RestoreRevision(ctx context.Context, in *data.RestoreRevisionRequest) (
+	*data.RestoreRevisionResponse, error) {
+	h.log.Debug("Хэндлер для восстановления версии записи")
+
+	version, err := h.service.RestoreRevision(ctx, in.Id, in.ExpectedVersion)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+	return &data.RestoreRevisionResponse{Version: version}, nil
+}
+
+// ListTrash - хэндлер для получения записей в корзине
+func (h HandlersData) ListTrash(ctx context.Context, in *emptypb.Empty) (
+	*data.ListTrashResponse, error) {
+	h.log.Debug("Хэндлер для получения корзины")
+
+	records, err := h.service.ListTrash(ctx)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+
+	response := &data.ListTrashResponse{}
+	for _, record := range records {
+		response.Records = append(response.Records, &data.TrashedRecord{
+			Id:        record.ID,
+			Header:    headerToProto(record.Header),
+			DeletedAt: timestamppb.New(record.DeletedAt),
+		})
+	}
+	return response, nil
+}
+
+// RestoreFromTrash - хэндлер для восстановления записи из корзины
+func (h HandlersData) RestoreFromTrash(ctx context.Context, in *data.RestoreFromTrashRequest) (
+	*data.RestoreFromTrashResponse, error) {
+	h.log.Debug("Хэндлер для восстановления записи из корзины")
+
+	version, err := h.service.RestoreFromTrash(ctx, in.Id)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+	return &data.RestoreFromTrashResponse{Version: version}, nil
+}
+
+// EmptyTrash - хэндлер для очистки корзины
+func (h HandlersData) EmptyTrash(ctx context.Context, in *emptypb.Empty) (
+	*data.EmptyTrashResponse, error) {
+	h.log.Debug("Хэндлер для очистки корзины")
+
+	purged, err := h.service.EmptyTrash(ctx)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+	return &data.EmptyTrashResponse{Purged: purged}, nil
+}
+
+// GetVaultCheck - хэндлер для получения проверочного значения хранилища
+func (h HandlersData) GetVaultCheck(ctx context.Context, in *emptypb.Empty) (
+	*data.VaultCheck, error) {
+	h.log.Debug("Хэндлер для получения проверочного значения хранилища")
+
+	check, err := h.service.GetVaultCheck(ctx)
+	if err != nil {
+		return nil, statusError(h.log, err)
+	}
+	return &data.VaultCheck{EncryptedData: check}, nil
+}
+
+// SetVaultCheck - хэндлер для сохранения проверочного значения хранилища
+func (h HandlersData) SetVaultCheck(ctx context.Context, in *data.VaultCheck) (
+	*emptypb.Empty, error) {
+	h.log.Debug("Хэндлер для сохранения проверочного значения хранилища")
+
+	if err := h.service.SetVaultCheck(ctx, in.EncryptedData); err != nil {
+		return nil, statusError(h.log, err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
 // ChangeData - хэндлер для изменения существующих данных пользователя
 func (h HandlersData) ChangeData(ctx context.Context, in *data.ChangingRequest) (
-	*emptypb.Empty, error) {
+	*data.ChangeResponse, error) {
 	h.log.Debug("Хэндлер для изменения данных")
-	data := model.DataBlock{
-		DataKeyWord: in.DataKeyWord,
-		Data:        in.DataForChange,
-		MetaData:    in.MetaDataForChange,
+	dataBlock := model.DataBlock{
+		DataKeyWord:   in.DataKeyWord,
+		DataType:      in.DataTypeForChange,
+		Data:          in.DataForChange,
+		EncryptedData: in.EncryptedDataForChange,
+		MetaData:      in.MetaDataForChange,
+		Payload:       data.PayloadToModel(in.PayloadForChange),
+		Version:       in.ExpectedVersion,
 	}
 
-	if err := h.service.ChangeData(ctx, data); err != nil {
-		return &emptypb.Empty{}, status.Errorf(codes.Internal, err.Error())
+	version, err := h.service.ChangeData(ctx, dataBlock)
+	if err != nil {
+		return nil, recordStatusError(h.log, err, changeFields)
 	}
-	return &emptypb.Empty{}, nil
+	return &data.ChangeResponse{Version: version}, nil
 }
 
 // DeleteData - хэндлер для удаления данных
@@ -91,7 +339,42 @@ func (h HandlersData) DeleteData(ctx context.Context, in *data.DeletionRequest)
 	h.log.Debug("Хэндлер для удаления данных")
 
 	if err := h.service.DeleteData(ctx, in.DataKeyWord); err != nil {
-		return &emptypb.Empty{}, status.Errorf(codes.Internal, err.Error())
+		return &emptypb.Empty{}, statusError(h.log, err)
 	}
 	return &emptypb.Empty{}, nil
 }
+
+// UploadFile - хэндлер для загрузки файла по частям
+func (h HandlersData) UploadFile(stream data.DataService_UploadFileServer) error {
+	h.log.Debug("Хэндлер для загрузки файла")
+
+	info, err := h.service.UploadFile(stream.Context(), func() (model.FileChunk, error) {
+		in, err := stream.Recv()
+		if err != nil {
+			return model.FileChunk{}, err
+		}
+		return data.FileChunkToModel(in), nil
+	})
+	if err != nil {
+		return statusError(h.log, err)
+	}
+	return stream.SendAndClose(&data.UploadResponse{
+		Size:     info.Size,
+		Checksum: info.Checksum,
+	})
+}
+
+// DownloadFile - хэндлер для скачивания файла по частям
+func (h HandlersData) DownloadFile(in *data.DownloadRequest,
+	stream data.DataService_DownloadFileServer) error {
+	h.log.Debug("Хэндлер для скачивания файла")
+
+	err := h.service.DownloadFile(stream.Context(), in.DataKeyWord,
+		func(chunk model.FileChunk) error {
+			return stream.Send(data.FileChunkFromModel(chunk))
+		})
+	if err != nil {
+		return statusError(h.log, err)
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package dataservice
+
+import "keeper/internal/model"
+
+// FileChunkFromModel преобразует сообщение потока файла в сообщение protobuf
+func FileChunkFromModel(chunk model.FileChunk) *FileChunk {
+	switch {
+	case chunk.Info != nil:
+		return &FileChunk{Part: &FileChunk_Info{Info: &FileInfo{
+			DataKeyWord:  chunk.Info.DataKeyWord,
+			FileName:     chunk.Info.FileName,
+			MetaData:     chunk.Info.MetaData,
+			Size:         chunk.Info.Size,
+			Checksum:     chunk.Info.Checksum,
+			ClientSealed: chunk.Info.ClientSealed,
+		}}}
+	case chunk.Checksum != nil:
+		return &FileChunk{Part: &FileChunk_Checksum{Checksum: chunk.Checksum}}
+	}
+	return &FileChunk{Part: &FileChunk_Data{Data: chunk.Data}}
+}
+
+// FileChunkToModel преобразует сообщение protobuf в сообщение потока файла
+func FileChunkToModel(chunk *FileChunk) model.FileChunk {
+	switch part := chunk.GetPart().(type) {
+	case *FileChunk_Info:
+		return model.FileChunk{Info: &model.FileInfo{
+			DataKeyWord:  part.Info.GetDataKeyWord(),
+			FileName:     part.Info.GetFileName(),
+			MetaData:     part.Info.GetMetaData(),
+			Size:         part.Info.GetSize(),
+			Checksum:     part.Info.GetChecksum(),
+			ClientSealed: part.Info.GetClientSealed(),
+		}}
+	case *FileChunk_Checksum:
+		return model.FileChunk{Checksum: part.Checksum}
+	case *FileChunk_Data:
+		return model.FileChunk{Data: part.Data}
+	}
+	return model.FileChunk{}
+}
@@ -3,30 +3,35 @@ package dataservice
 import (
 	"context"
 	"errors"
-	"keeper/internal/logger"
-	"keeper/internal/utils"
-	"os"
+	"keeper/internal/model"
 
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-// AuthInterceptor функция для интерсептора, которая проверяет jwt токены
-func AuthInterceptor(ctx context.Context) (context.Context, error) {
-	log := logger.InitLog(logrus.DebugLevel)
-	log.Info("Интерсептор с проверкой jwt токена")
-
-	goprivate, exist := os.LookupEnv("GOPRIVATE")
+// SessionChecker проверяет jwt токен из контекста и сессию пользователя
+type SessionChecker interface {
+	CheckSession(ctx context.Context) error
+}
 
-	if !exist || goprivate == "" {
-		err := errors.New("Заполните переменную среды GOPRIVATE")
-		return ctx, err
-	}
+// NewAuthInterceptor возвращает функцию для интерсептора, которая проверяет
+// jwt токены и отклоняет запросы с токенами отозванных сессий
+func NewAuthInterceptor(sessions SessionChecker,
+	log *logrus.Logger) func(ctx context.Context) (context.Context, error) {
+	return func(ctx context.Context) (context.Context, error) {
+		log.Debug("Интерсептор с проверкой jwt токена")
 
-	_, err := utils.GetLoginFromContext(ctx, goprivate)
-	if err != nil {
+		err := sessions.CheckSession(ctx)
+		if err == nil {
+			return ctx, nil
+		}
 		log.Error(err.Error())
-		return ctx, err
+		// клиент по коду Unauthenticated понимает, что токен нужно обновить
+		if errors.Is(err, model.ErrTokenNotFound) || errors.Is(err, model.ErrNotValidToken) ||
+			errors.Is(err, model.ErrSessionRevoked) {
+			return ctx, status.Error(codes.Unauthenticated, err.Error())
+		}
+		return ctx, status.Error(codes.Internal, err.Error())
 	}
-
-	return ctx, nil
 }
@@ -0,0 +1,68 @@
+package dataservice
+
+import "keeper/internal/model"
+
+// PayloadFromModel преобразует содержимое записи в сообщение protobuf
+func PayloadFromModel(payload *model.Payload) *Payload {
+	if payload == nil {
+		return nil
+	}
+	switch {
+	case payload.Credentials != nil:
+		return &Payload{Kind: &Payload_Credentials{Credentials: &Credentials{
+			Login:    payload.Credentials.Login,
+			Password: payload.Credentials.Password,
+			Url:      payload.Credentials.URL,
+		}}}
+	case payload.BankCard != nil:
+		return &Payload{Kind: &Payload_BankCard{BankCard: &BankCard{
+			Number: payload.BankCard.Number,
+			Expiry: payload.BankCard.Expiry,
+			Holder: payload.BankCard.Holder,
+			Cvv:    payload.BankCard.CVV,
+		}}}
+	case payload.Text != nil:
+		return &Payload{Kind: &Payload_Text{Text: &TextNote{
+			Text: payload.Text.Text,
+		}}}
+	case payload.Binary != nil:
+		return &Payload{Kind: &Payload_Binary{Binary: &BinaryData{
+			Data:     payload.Binary.Data,
+			FileName: payload.Binary.FileName,
+		}}}
+	}
+	return nil
+}
+
+// PayloadToModel преобразует сообщение protobuf в содержимое записи
+func PayloadToModel(payload *Payload) *model.Payload {
+	if payload == nil {
+		return nil
+	}
+	switch kind := payload.Kind.(type) {
+	case *Payload_Credentials:
+		return &model.Payload{Credentials: &model.Credentials{
+			Login:    kind.Credentials.GetLogin(),
+			Password: kind.Credentials.GetPassword(),
+			URL:      kind.Credentials.GetUrl(),
+		}}
+	case *Payload_BankCard:
+		return &model.Payload{BankCard: &model.BankCard{
+			Number: kind.BankCard.GetNumber(),
+			Expiry: kind.BankCard.GetExpiry(),
+			Holder: kind.BankCard.GetHolder(),
+			CVV:    kind.BankCard.GetCvv(),
+		}}
+	case *Payload_Text:
+		return &model.Payload{Text: &model.TextNote{
+			Text: kind.Text.GetText(),
+		}}
+	case *Payload_Binary:
+		return &model.Payload{Binary: &model.BinaryData{
+			Data:     kind.Binary.GetData(),
+			FileName: kind.Binary.GetFileName(),
+		}}
+	}
+	// пустой oneof - запись без содержимого, ее отклонит проверка
+	return &model.Payload{}
+}
@@ -0,0 +1,416 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"keeper/internal/model"
+	"time"
+)
+
+// AddUser добавляет нового пользователя. Если логин занят, возвращает
+// model.ErrUserAlreadyExists
+func (s *Storage) AddUser(ctx context.Context, login string, passwordHash string) error {
+	return s.update(ctx, func(tx kvTx) error {
+		if tx.get(bucketUsers, login) != nil {
+			return model.ErrUserAlreadyExists
+		}
+		return putJSON(tx, bucketUsers, login, userRow{PasswordHash: passwordHash})
+	})
+}
+
+// GetPasswordHash возвращает сохраненный хэш пароля пользователя
+func (s *Storage) GetPasswordHash(ctx context.Context, login string) (string, error) {
+	var user userRow
+	err := s.view(ctx, func(tx kvTx) error {
+		found, err := getJSON(tx, bucketUsers, login, &user)
+		if err == nil && !found {
+			err = model.ErrUserNotFound
+		}
+		return err
+	})
+	return user.PasswordHash, err
+}
+
+// UpdatePasswordHash заменяет хэш пароля пользователя
+func (s *Storage) UpdatePasswordHash(ctx context.Context, login string,
+	passwordHash string) error {
+	return s.update(ctx, func(tx kvTx) error {
+		if tx.get(bucketUsers, login) == nil {
+			return nil
+		}
+		return putJSON(tx, bucketUsers, login, userRow{PasswordHash: passwordHash})
+	})
+}
+
+// AddUserKey сохраняет обернутый ключ данных пользователя.
+// Если ключ уже существует, он не перезаписывается
+func (s *Storage) AddUserKey(ctx context.Context, login string, wrappedKey []byte) error {
+	return s.update(ctx, func(tx kvTx) error {
+		if tx.get(bucketUserKeys, login) != nil {
+			return nil
+		}
+		return tx.put(bucketUserKeys, login, wrappedKey)
+	})
+}
+
+// GetUserKey возвращает обернутый ключ данных пользователя
+func (s *Storage) GetUserKey(ctx context.Context, login string) ([]byte, error) {
+	var wrappedKey []byte
+	err := s.view(ctx, func(tx kvTx) error {
+		wrappedKey = tx.get(bucketUserKeys, login)
+		return nil
+	})
+	if err == nil && wrappedKey == nil {
+		err = model.ErrNoRowsSelected
+	}
+	return wrappedKey, err
+}
+
+// SetVaultCheck сохраняет проверочное значение хранилища пользователя.
+// Сохраненное значение не перезаписывается, в этом случае возвращается
+// model.ErrVaultCheckExists
+func (s *Storage) SetVaultCheck(ctx context.Context, login string, check []byte) error {
+	return s.update(ctx, func(tx kvTx) error {
+		if tx.get(bucketVaultChecks, login) != nil {
+			return model.ErrVaultCheckExists
+		}
+		return tx.put(bucketVaultChecks, login, check)
+	})
+}
+
+// GetVaultCheck возвращает проверочное значение хранилища пользователя
+// или nil, если оно еще не сохранено
+func (s *Storage) GetVaultCheck(ctx context.Context, login string) ([]byte, error) {
+	var check []byte
+	err := s.view(ctx, func(tx kvTx) error {
+		check = tx.get(bucketVaultChecks, login)
+		return nil
+	})
+	return check, err
+}
+
+// AddRefreshToken сохраняет хэш токена обновления пользователя
+func (s *Storage) AddRefreshToken(ctx context.Context, tokenHash string,
+	refreshToken model.RefreshToken) error {
+	return s.update(ctx, func(tx kvTx) error {
+		return putJSON(tx, bucketRefreshTokens, tokenHash, refreshToken)
+	})
+}
+
+// TakeRefreshToken удаляет токен обновления и возвращает сведения о нем.
+// Токен можно использовать только один раз
+func (s *Storage) TakeRefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken,
+	error) {
+	var refreshToken model.RefreshToken
+	err := s.update(ctx, func(tx kvTx) error {
+		found, err := getJSON(tx, bucketRefreshTokens, tokenHash, &refreshToken)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return model.ErrRefreshTokenNotFound
+		}
+		return tx.delete(bucketRefreshTokens, tokenHash)
+	})
+	if err != nil {
+		return model.RefreshToken{}, err
+	}
+	return refreshToken, nil
+}
+
+// deleteRefreshTokens удаляет токены обновления, для которых match вернула true
+func deleteRefreshTokens(tx kvTx, match func(token model.RefreshToken) bool) error {
+	var hashes []string
+	err := tx.each(bucketRefreshTokens, "", func(key string, value []byte) error {
+		var token model.RefreshToken
+		if err := json.Unmarshal(value, &token); err != nil {
+			return err
+		}
+		if match(token) {
+			hashes = append(hashes, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, hash := range hashes {
+		if err = tx.delete(bucketRefreshTokens, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// eachSession передает в fn сессии пользователя login
+func eachSession(tx kvTx, login string, fn func(jti string, session sessionRow) error) error {
+	return tx.each(bucketSessions, "", func(key string, value []byte) error {
+		var session sessionRow
+		if err := json.Unmarshal(value, &session); err != nil {
+			return err
+		}
+		if session.Login != login {
+			return nil
+		}
+		return fn(key, session)
+	})
+}
+
+// AddSession сохраняет сессию, открытую токеном доступа с идентификатором sessionID.
+// Заодно удаляются истекшие сессии пользователя
+func (s *Storage) AddSession(ctx context.Context, login string, sessionID string,
+	expiresAt time.Time) error {
+	return s.update(ctx, func(tx kvTx) error {
+		now := time.Now()
+		var expired []string
+		err := eachSession(tx, login, func(jti string, session sessionRow) error {
+			if session.ExpiresAt.Before(now) {
+				expired = append(expired, jti)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, jti := range expired {
+			if err = tx.delete(bucketSessions, jti); err != nil {
+				return err
+			}
+		}
+		return putJSON(tx, bucketSessions, sessionID, sessionRow{
+			Login:     login,
+			ExpiresAt: expiresAt,
+		})
+	})
+}
+
+// IsSessionActive проверяет, что сессия существует, не отозвана и не истекла
+func (s *Storage) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	var active bool
+	err := s.view(ctx, func(tx kvTx) error {
+		var session sessionRow
+		found, err := getJSON(tx, bucketSessions, sessionID, &session)
+		active = found && session.RevokedAt == nil && session.ExpiresAt.After(time.Now())
+		return err
+	})
+	return active, err
+}
+
+// RevokeSession отзывает сессию пользователя и удаляет выданные в ней
+// токены обновления
+func (s *Storage) RevokeSession(ctx context.Context, login string, sessionID string) error {
+	return s.update(ctx, func(tx kvTx) error {
+		var session sessionRow
+		found, err := getJSON(tx, bucketSessions, sessionID, &session)
+		if err != nil {
+			return err
+		}
+		if found && session.Login == login && session.RevokedAt == nil {
+			now := time.Now()
+			session.RevokedAt = &now
+			if err = putJSON(tx, bucketSessions, sessionID, session); err != nil {
+				return err
+			}
+		}
+		return deleteRefreshTokens(tx, func(token model.RefreshToken) bool {
+			return token.SessionID == sessionID
+		})
+	})
+}
+
+// RevokeAllSessions отзывает все действующие сессии пользователя и удаляет
+// его токены обновления. Возвращает количество отозванных сессий
+func (s *Storage) RevokeAllSessions(ctx context.Context, login string) (int64, error) {
+	var revoked int64
+	err := s.update(ctx, func(tx kvTx) error {
+		now := time.Now()
+		active := make(map[string]sessionRow)
+		err := eachSession(tx, login, func(jti string, session sessionRow) error {
+			if session.RevokedAt == nil && session.ExpiresAt.After(now) {
+				active[jti] = session
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for jti, session := range active {
+			session.RevokedAt = &now
+			if err = putJSON(tx, bucketSessions, jti, session); err != nil {
+				return err
+			}
+		}
+		revoked = int64(len(active))
+		return deleteRefreshTokens(tx, func(token model.RefreshToken) bool {
+			return token.Login == login
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+// SaveTOTP сохраняет зашифрованный секрет TOTP пользователя.
+// Секрет подтвержденного второго фактора не перезаписывается
+func (s *Storage) SaveTOTP(ctx context.Context, login string, cipherSecret []byte) error {
+	return s.update(ctx, func(tx kvTx) error {
+		var totp model.TOTP
+		if _, err := getJSON(tx, bucketTOTP, login, &totp); err != nil {
+			return err
+		}
+		if totp.Confirmed {
+			return model.ErrTOTPAlreadyEnabled
+		}
+		return putJSON(tx, bucketTOTP, login, model.TOTP{CipherSecret: cipherSecret})
+	})
+}
+
+// GetTOTP возвращает настройки второго фактора пользователя
+func (s *Storage) GetTOTP(ctx context.Context, login string) (model.TOTP, error) {
+	var totp model.TOTP
+	err := s.view(ctx, func(tx kvTx) error {
+		found, err := getJSON(tx, bucketTOTP, login, &totp)
+		if err == nil && !found {
+			err = model.ErrTOTPNotEnrolled
+		}
+		return err
+	})
+	if err != nil {
+		return model.TOTP{}, err
+	}
+	return totp, nil
+}
+
+// ConfirmTOTP включает второй фактор и заменяет коды восстановления пользователя
+func (s *Storage) ConfirmTOTP(ctx context.Context, login string, step int64,
+	recoveryCodeHashes []string) error {
+	return s.update(ctx, func(tx kvTx) error {
+		var totp model.TOTP
+		found, err := getJSON(tx, bucketTOTP, login, &totp)
+		if err != nil {
+			return err
+		}
+		if !found || totp.Confirmed || totp.LastStep >= step {
+			return model.ErrIncorrectTOTPCode
+		}
+		totp.Confirmed, totp.LastStep = true, step
+		if err = putJSON(tx, bucketTOTP, login, totp); err != nil {
+			return err
+		}
+
+		var previous []string
+		err = tx.each(bucketRecoveryCodes, loginPrefix(login), func(key string, value []byte) error {
+			previous = append(previous, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range previous {
+			if err = tx.delete(bucketRecoveryCodes, key); err != nil {
+				return err
+			}
+		}
+		for _, codeHash := range recoveryCodeHashes {
+			if err = tx.put(bucketRecoveryCodes, recordKey(login, codeHash), []byte{1}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseTOTPStep запоминает принятый шаг TOTP. Возвращает false, если код
+// этого или более позднего шага уже использовался
+func (s *Storage) UseTOTPStep(ctx context.Context, login string, step int64) (bool, error) {
+	var used bool
+	err := s.update(ctx, func(tx kvTx) error {
+		var totp model.TOTP
+		found, err := getJSON(tx, bucketTOTP, login, &totp)
+		if err != nil || !found || !totp.Confirmed || totp.LastStep >= step {
+			return err
+		}
+		totp.LastStep = step
+		used = true
+		return putJSON(tx, bucketTOTP, login, totp)
+	})
+	return used && err == nil, err
+}
+
+// UseRecoveryCode удаляет код восстановления. Возвращает false,
+// если такого кода у пользователя нет
+func (s *Storage) UseRecoveryCode(ctx context.Context, login string,
+	codeHash string) (bool, error) {
+	var used bool
+	err := s.update(ctx, func(tx kvTx) error {
+		key := recordKey(login, codeHash)
+		if tx.get(bucketRecoveryCodes, key) == nil {
+			return nil
+		}
+		used = true
+		return tx.delete(bucketRecoveryCodes, key)
+	})
+	return used && err == nil, err
+}
+
+// AddChallenge сохраняет незавершенный вход, ожидающий второй фактор.
+// Заодно удаляются истекшие
+func (s *Storage) AddChallenge(ctx context.Context, challengeHash string, login string,
+	expiresAt time.Time) error {
+	return s.update(ctx, func(tx kvTx) error {
+		now := time.Now()
+		var expired []string
+		err := tx.each(bucketChallenges, "", func(key string, value []byte) error {
+			var challenge model.AuthChallenge
+			if err := json.Unmarshal(value, &challenge); err != nil {
+				return err
+			}
+			if challenge.ExpiresAt.Before(now) {
+				expired = append(expired, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range expired {
+			if err = tx.delete(bucketChallenges, key); err != nil {
+				return err
+			}
+		}
+		return putJSON(tx, bucketChallenges, challengeHash, model.AuthChallenge{
+			Login:     login,
+			ExpiresAt: expiresAt,
+		})
+	})
+}
+
+// CountChallengeAttempt увеличивает счетчик попыток ввода кода
+// и возвращает незавершенный вход
+func (s *Storage) CountChallengeAttempt(ctx context.Context,
+	challengeHash string) (model.AuthChallenge, error) {
+	var challenge model.AuthChallenge
+	err := s.update(ctx, func(tx kvTx) error {
+		found, err := getJSON(tx, bucketChallenges, challengeHash, &challenge)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return model.ErrChallengeExpired
+		}
+		challenge.Attempts++
+		return putJSON(tx, bucketChallenges, challengeHash, challenge)
+	})
+	if err != nil {
+		return model.AuthChallenge{}, err
+	}
+	return challenge, nil
+}
+
+// DeleteChallenge удаляет незавершенный вход
+func (s *Storage) DeleteChallenge(ctx context.Context, challengeHash string) error {
+	return s.update(ctx, func(tx kvTx) error {
+		return tx.delete(bucketChallenges, challengeHash)
+	})
+}
@@ -0,0 +1,102 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"keeper/internal/model"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltEngine хранит бакеты в файле bbolt
+type boltEngine struct {
+	db *bolt.DB
+}
+
+// openBoltEngine открывает файл хранилища, создавая его и бакеты
+// при необходимости. Файл одновременно открывает только один сервер
+func openBoltEngine(path string) (*boltEngine, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, boltError(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range allBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &boltEngine{db: db}, nil
+}
+
+func (e *boltEngine) view(fn func(tx kvTx) error) error {
+	return boltError(e.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx})
+	}))
+}
+
+func (e *boltEngine) update(fn func(tx kvTx) error) error {
+	return boltError(e.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx: tx})
+	}))
+}
+
+// boltError оборачивает в model.ErrStorageBusy ошибку ожидания блокировки
+// файла, после которой запрос можно повторить
+func boltError(err error) error {
+	if errors.Is(err, bolt.ErrTimeout) {
+		return fmt.Errorf("%w: %w", model.ErrStorageBusy, err)
+	}
+	return err
+}
+
+func (e *boltEngine) close() error {
+	return e.db.Close()
+}
+
+// boltTx копирует значения: bbolt возвращает память файла,
+// которая действительна только до конца транзакции
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+func (t boltTx) get(bucket string, key string) []byte {
+	value := t.tx.Bucket([]byte(bucket)).Get([]byte(key))
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+func (t boltTx) put(bucket string, key string, value []byte) error {
+	return t.tx.Bucket([]byte(bucket)).Put([]byte(key), value)
+}
+
+func (t boltTx) delete(bucket string, key string) error {
+	return t.tx.Bucket([]byte(bucket)).Delete([]byte(key))
+}
+
+func (t boltTx) each(bucket string, prefix string,
+	fn func(key string, value []byte) error) error {
+	cursor := t.tx.Bucket([]byte(bucket)).Cursor()
+	for key, value := cursor.Seek([]byte(prefix)); key != nil &&
+		bytes.HasPrefix(key, []byte(prefix)); key, value = cursor.Next() {
+		if err := fn(string(key), append([]byte{}, value...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"keeper/internal/model"
+	"time"
+)
+
+// AddConflict сохраняет изменение клиента, не примененное из-за конфликта
+func (s *Storage) AddConflict(ctx context.Context, login string, conflict model.Conflict) error {
+	return s.update(ctx, func(tx kvTx) error {
+		id, err := nextSeq(tx, seqConflict)
+		if err != nil {
+			return err
+		}
+		row := conflictRow{
+			ID:          id,
+			DataKeyWord: conflict.DataKeyWord,
+			BaseVersion: conflict.BaseVersion,
+			DataType:    conflict.Mine.DataType,
+			MetaData:    conflict.Mine.MetaData,
+			Deleted:     conflict.MineDeleted,
+			Device:      conflict.Mine.Device,
+			CreatedAt:   time.Now(),
+		}
+		if conflict.Base != nil {
+			row.HasBase = true
+			row.BaseDataType, row.BaseMetaData = conflict.Base.DataType, conflict.Base.MetaData
+			row.BaseData = conflict.Base.CipherData
+		}
+		if !conflict.MineDeleted {
+			row.Data = conflict.Mine.CipherData
+		}
+		return putJSON(tx, bucketConflicts, idKey(login, id), row)
+	})
+}
+
+// GetConflicts выбирает конфликты пользователя, для непустого dataKeyWord -
+// только конфликты этой записи
+func (s *Storage) GetConflicts(ctx context.Context, login string,
+	dataKeyWord string) ([]model.Conflict, error) {
+	var conflicts []model.Conflict
+	err := s.view(ctx, func(tx kvTx) error {
+		return tx.each(bucketConflicts, loginPrefix(login), func(key string, value []byte) error {
+			var row conflictRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return err
+			}
+			if dataKeyWord != "" && row.DataKeyWord != dataKeyWord {
+				return nil
+			}
+			conflict := model.Conflict{
+				ID:          row.ID,
+				DataKeyWord: row.DataKeyWord,
+				BaseVersion: row.BaseVersion,
+				Mine: model.DataBlock{
+					DataKeyWord: row.DataKeyWord,
+					DataType:    row.DataType,
+					CipherData:  row.Data,
+					MetaData:    row.MetaData,
+				},
+				MineDeleted: row.Deleted,
+				CreatedAt:   row.CreatedAt,
+			}
+			if row.HasBase {
+				conflict.Base = &model.DataBlock{
+					DataKeyWord: row.DataKeyWord,
+					DataType:    row.BaseDataType,
+					CipherData:  row.BaseData,
+					MetaData:    row.BaseMetaData,
+					Version:     row.BaseVersion,
+				}
+			}
+			var theirs dataRow
+			found, err := getJSON(tx, bucketData, recordKey(login, row.DataKeyWord), &theirs)
+			if err != nil {
+				return err
+			}
+			if found {
+				block := theirs.block(row.DataKeyWord)
+				block.FileID = ""
+				conflict.Theirs = &block
+			}
+			conflicts = append(conflicts, conflict)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return conflicts, nil
+}
+
+// ResolveConflict в одной транзакции применяет выбор пользователя и удаляет
+// конфликт. Запись на сервере изменяется, только если ее версия совпадает
+// с resolution.ExpectedVersion. Возвращает версию измененной записи
+func (s *Storage) ResolveConflict(ctx context.Context, login string,
+	resolution model.ConflictResolution) (int64, error) {
+	version := resolution.ExpectedVersion
+	err := s.update(ctx, func(tx kvTx) error {
+		key := idKey(login, resolution.ConflictID)
+		var row conflictRow
+		found, err := getJSON(tx, bucketConflicts, key, &row)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return model.ErrConflictNotFound
+		}
+		mine := model.DataBlock{
+			Login:       login,
+			DataKeyWord: row.DataKeyWord,
+			DataType:    row.DataType,
+			CipherData:  row.Data,
+			MetaData:    row.MetaData,
+			Device:      row.Device,
+		}
+
+		switch resolution.Strategy {
+		case model.ResolveKeepTheirs:
+		case model.ResolveKeepMine, model.ResolveMerge:
+			record := mine
+			if resolution.Strategy == model.ResolveMerge {
+				record = resolution.Merged
+				record.Login, record.DataKeyWord = login, mine.DataKeyWord
+			}
+			switch {
+			case row.Deleted && resolution.Strategy == model.ResolveKeepMine:
+				version = 0
+				err = deleteData(tx, login, mine.DataKeyWord, resolution.ExpectedVersion)
+			case resolution.ExpectedVersion == 0:
+				version, err = insertDataIfAbsent(tx, record)
+			default:
+				record.Version = resolution.ExpectedVersion
+				version, err = changeData(tx, record)
+				if errors.Is(err, model.ErrNoRowsSelected) {
+					err = &model.VersionMismatchError{}
+				}
+			}
+		case model.ResolveKeepBoth:
+			if resolution.RenamedKey == "" || resolution.RenamedKey == mine.DataKeyWord {
+				return model.ErrRenamedKeyRequired
+			}
+			// данные записи с файлом - описание частей, привязанных к ее ключу,
+			// под новым ключом файл не скачать
+			if mine.DataType == model.DataTypeFile {
+				return model.ErrKeepBothFile
+			}
+			// удаление сохранять под новым ключом нечего
+			if !row.Deleted {
+				record := mine
+				record.DataKeyWord = resolution.RenamedKey
+				if _, err = insertDataIfAbsent(tx, record); errors.Is(err, model.ErrVersionMismatch) {
+					err = model.ErrDataKeyWordExists
+				}
+			}
+		default:
+			return model.ErrUnknownResolution
+		}
+		if err != nil {
+			return err
+		}
+		return tx.delete(bucketConflicts, key)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
@@ -0,0 +1,424 @@
+package kvstore
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"keeper/internal/model"
+	"slices"
+	"strings"
+	"time"
+)
+
+// listSortFields - поля, по которым разрешена сортировка списка записей
+var listSortFields = map[string]func(a, b model.DataHeader) int{
+	model.SortByKey: func(a, b model.DataHeader) int {
+		return strings.Compare(a.DataKeyWord, b.DataKeyWord)
+	},
+	model.SortByType: func(a, b model.DataHeader) int {
+		return strings.Compare(a.DataType, b.DataType)
+	},
+	model.SortByCreated: func(a, b model.DataHeader) int {
+		return a.CreatedAt.Compare(b.CreatedAt)
+	},
+	model.SortByUpdated: func(a, b model.DataHeader) int {
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	},
+	model.SortBySize: func(a, b model.DataHeader) int {
+		return cmp.Compare(a.Size, b.Size)
+	},
+}
+
+// block возвращает запись в том виде, в каком ее выбирает GetData
+func (row dataRow) block(dataKeyWord string) model.DataBlock {
+	return model.DataBlock{
+		DataKeyWord: dataKeyWord,
+		DataType:    row.DataType,
+		CipherData:  row.Data,
+		MetaData:    row.MetaData,
+		FileID:      row.FileID,
+		Version:     row.Version,
+	}
+}
+
+// header возвращает заголовок записи без содержимого
+func (row dataRow) header(tx kvTx, dataKeyWord string) model.DataHeader {
+	return model.DataHeader{
+		DataKeyWord: dataKeyWord,
+		DataType:    row.DataType,
+		MetaData:    row.MetaData,
+		CreatedAt:   row.CreatedAt,
+		UpdatedAt:   row.UpdatedAt,
+		Size:        dataSize(tx, row),
+		Version:     row.Version,
+	}
+}
+
+// dataSize - размер хранимых данных записи. Размер файла
+// складывается из размеров его частей
+func dataSize(tx kvTx, row dataRow) int64 {
+	size := int64(len(row.Data))
+	if row.FileID == "" {
+		return size
+	}
+	tx.each(bucketFileChunks, row.FileID+"\x00", func(key string, value []byte) error {
+		size += int64(len(value))
+		return nil
+	})
+	return size
+}
+
+// splitRecordKey разделяет ключ строки на логин и остаток ключа
+func splitRecordKey(key string) (string, string) {
+	login, rest, _ := strings.Cut(key, "\x00")
+	return login, rest
+}
+
+// insertRecord добавляет запись с новым номером изменения
+func insertRecord(tx kvTx, login string, dataKeyWord string, row dataRow) error {
+	seq, err := nextSeq(tx, seqDataChange)
+	if err != nil {
+		return err
+	}
+	row.ChangeSeq = seq
+	return putJSON(tx, bucketData, recordKey(login, dataKeyWord), row)
+}
+
+// newRecord возвращает первую версию записи data
+func newRecord(data model.DataBlock) dataRow {
+	now := time.Now()
+	return dataRow{
+		DataType:  data.DataType,
+		Data:      data.CipherData,
+		MetaData:  data.MetaData,
+		FileID:    data.FileID,
+		Device:    data.Device,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+}
+
+// addRevision сохраняет версию записи row в истории
+func addRevision(tx kvTx, login string, dataKeyWord string, row dataRow, deleted bool) error {
+	id, err := nextSeq(tx, seqRevision)
+	if err != nil {
+		return err
+	}
+	return putJSON(tx, bucketHistory, idKey(login, id), historyRow{
+		ID:          id,
+		DataKeyWord: dataKeyWord,
+		Version:     row.Version,
+		DataType:    row.DataType,
+		Data:        row.Data,
+		MetaData:    row.MetaData,
+		Device:      row.Device,
+		CreatedAt:   row.UpdatedAt,
+		ReplacedAt:  time.Now(),
+		Deleted:     deleted,
+	})
+}
+
+// changeData изменяет запись, если ее версия совпадает с data.Version
+// (0 - без проверки), и возвращает новую версию. Прежняя версия записи
+// сохраняется в истории. Запись с файлом не изменяется: ее данные - описание
+// частей файла
+func changeData(tx kvTx, data model.DataBlock) (int64, error) {
+	key := recordKey(data.Login, data.DataKeyWord)
+	var row dataRow
+	found, err := getJSON(tx, bucketData, key, &row)
+	if err != nil {
+		return 0, err
+	}
+	if !found {
+		return 0, model.ErrNoRowsSelected
+	}
+	if data.Version != 0 && row.Version != data.Version {
+		return 0, &model.VersionMismatchError{Current: row.Version}
+	}
+	if row.FileID != "" {
+		return 0, model.ErrFileReadOnly
+	}
+	if err = addRevision(tx, data.Login, data.DataKeyWord, row, false); err != nil {
+		return 0, err
+	}
+
+	row.DataType, row.Data, row.MetaData = data.DataType, data.CipherData, data.MetaData
+	row.Device, row.UpdatedAt = data.Device, time.Now()
+	row.Version++
+	if err = insertRecord(tx, data.Login, data.DataKeyWord, row); err != nil {
+		return 0, err
+	}
+	return row.Version, nil
+}
+
+// insertDataIfAbsent добавляет запись, если ключ свободен. Если запись
+// с таким ключом уже есть, возвращает *model.VersionMismatchError
+func insertDataIfAbsent(tx kvTx, data model.DataBlock) (int64, error) {
+	var current dataRow
+	found, err := getJSON(tx, bucketData, recordKey(data.Login, data.DataKeyWord), &current)
+	if err != nil {
+		return 0, err
+	}
+	if found {
+		return 0, &model.VersionMismatchError{Current: current.Version}
+	}
+	row := newRecord(data)
+	return row.Version, insertRecord(tx, data.Login, data.DataKeyWord, row)
+}
+
+// deleteData перемещает запись в корзину, если ее версия совпадает с version
+// (0 - без проверки), и отмечает удаление для синхронизации. Удаленная запись,
+// кроме файлов, сохраняется в истории. Отсутствие записи ошибкой не считается
+func deleteData(tx kvTx, login string, dataKeyWord string, version int64) error {
+	key := recordKey(login, dataKeyWord)
+	var row dataRow
+	found, err := getJSON(tx, bucketData, key, &row)
+	if err != nil || !found {
+		return err
+	}
+	if version != 0 && row.Version != version {
+		return &model.VersionMismatchError{Current: row.Version}
+	}
+	if err = tx.delete(bucketData, key); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	trashID, err := nextSeq(tx, seqTrash)
+	if err != nil {
+		return err
+	}
+	err = putJSON(tx, bucketTrash, idKey(login, trashID), trashRow{
+		ID:          trashID,
+		DataKeyWord: dataKeyWord,
+		Record:      row,
+		DeletedAt:   now,
+	})
+	if err != nil {
+		return err
+	}
+	if row.FileID == "" {
+		if err = addRevision(tx, login, dataKeyWord, row, true); err != nil {
+			return err
+		}
+	}
+	seq, err := nextSeq(tx, seqDataChange)
+	if err != nil {
+		return err
+	}
+	return putJSON(tx, bucketDeletedData, key, tombstoneRow{ChangeSeq: seq, DeletedAt: now})
+}
+
+// InsertData добавляет данные пользователя. Если ключ занят,
+// возвращает model.ErrDataKeyWordExists
+func (s *Storage) InsertData(ctx context.Context, data model.DataBlock) error {
+	return s.update(ctx, func(tx kvTx) error {
+		if tx.get(bucketData, recordKey(data.Login, data.DataKeyWord)) != nil {
+			return model.ErrDataKeyWordExists
+		}
+		row := newRecord(data)
+		row.FileID = ""
+		return insertRecord(tx, data.Login, data.DataKeyWord, row)
+	})
+}
+
+// GetData выбирает данные пользователя по ключу логин + ключевое слово
+func (s *Storage) GetData(ctx context.Context, login string,
+	dataKeyWord string) ([]model.DataBlock, error) {
+	var data []model.DataBlock
+	err := s.view(ctx, func(tx kvTx) error {
+		var row dataRow
+		found, err := getJSON(tx, bucketData, recordKey(login, dataKeyWord), &row)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return model.ErrNoRowsSelected
+		}
+		data = append(data, row.block(dataKeyWord))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// ChangeData изменяет данные пользователя, если версия записи совпадает
+// с data.Version, и возвращает новую версию. Если версия не совпала,
+// возвращается *model.VersionMismatchError с текущей версией записи.
+// Версия 0 изменяет запись без проверки, она допустима только для
+// внутренних вызовов сервера, сервис отклоняет ее в запросах клиента.
+// Запись с файлом не изменяется, возвращается model.ErrFileReadOnly
+func (s *Storage) ChangeData(ctx context.Context, data model.DataBlock) (int64, error) {
+	var version int64
+	err := s.update(ctx, func(tx kvTx) error {
+		var err error
+		version, err = changeData(tx, data)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// DeleteData перемещает запись пользователя в корзину. Если записи нет,
+// возвращает model.ErrNoRowsSelected
+func (s *Storage) DeleteData(ctx context.Context, login string, dataKeyWord string) error {
+	return s.update(ctx, func(tx kvTx) error {
+		if tx.get(bucketData, recordKey(login, dataKeyWord)) == nil {
+			return model.ErrNoRowsSelected
+		}
+		return deleteData(tx, login, dataKeyWord, 0)
+	})
+}
+
+// ApplyOperation применяет операцию клиента, если запись на сервере не менялась
+// с версии operation.BaseVersion. Иначе возвращает *model.VersionMismatchError,
+// текущая версия 0 означает, что запись удалена. Удаление с нулевой версией
+// означает, что клиент создал и удалил запись без связи с сервером: если
+// запись с таким ключом есть на сервере, это конфликт
+func (s *Storage) ApplyOperation(ctx context.Context, login string,
+	operation model.SyncOperation) error {
+	data := operation.Data
+	data.Login = login
+	return s.update(ctx, func(tx kvTx) error {
+		var err error
+		switch {
+		case operation.Deleted && operation.BaseVersion == 0:
+			var current dataRow
+			found, err := getJSON(tx, bucketData, recordKey(login, data.DataKeyWord), &current)
+			if err != nil || !found {
+				return err
+			}
+			return &model.VersionMismatchError{Current: current.Version}
+		case operation.Deleted:
+			err = deleteData(tx, login, data.DataKeyWord, operation.BaseVersion)
+		case operation.BaseVersion == 0:
+			_, err = insertDataIfAbsent(tx, data)
+		default:
+			data.Version = operation.BaseVersion
+			_, err = changeData(tx, data)
+			if errors.Is(err, model.ErrNoRowsSelected) {
+				err = &model.VersionMismatchError{}
+			}
+		}
+		return err
+	})
+}
+
+// GetChanges выбирает не больше limit изменений записей пользователя,
+// зафиксированных после позиции after. Изменения выполняются по одному,
+// поэтому порядок номеров совпадает с порядком фиксации
+func (s *Storage) GetChanges(ctx context.Context, login string, after model.ChangePosition,
+	limit int) ([]model.DataChange, error) {
+	var changes []model.DataChange
+	err := s.view(ctx, func(tx kvTx) error {
+		err := tx.each(bucketData, loginPrefix(login), func(key string, value []byte) error {
+			var row dataRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return err
+			}
+			if !(model.ChangePosition{Seq: row.ChangeSeq}).After(after) {
+				return nil
+			}
+			_, dataKeyWord := splitRecordKey(key)
+			change := model.DataChange{
+				Data:   row.block(dataKeyWord),
+				Header: row.header(tx, dataKeyWord),
+			}
+			change.Seq = row.ChangeSeq
+			change.Data.FileID = ""
+			changes = append(changes, change)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return tx.each(bucketDeletedData, loginPrefix(login), func(key string, value []byte) error {
+			var tombstone tombstoneRow
+			if err := json.Unmarshal(value, &tombstone); err != nil {
+				return err
+			}
+			if !(model.ChangePosition{Seq: tombstone.ChangeSeq}).After(after) {
+				return nil
+			}
+			_, dataKeyWord := splitRecordKey(key)
+			changes = append(changes, model.DataChange{
+				Data: model.DataBlock{DataKeyWord: dataKeyWord},
+				Header: model.DataHeader{
+					DataKeyWord: dataKeyWord,
+					CreatedAt:   tombstone.DeletedAt,
+					UpdatedAt:   tombstone.DeletedAt,
+				},
+				Deleted:        true,
+				ChangePosition: model.ChangePosition{Seq: tombstone.ChangeSeq},
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(changes, func(a, b model.DataChange) int {
+		return cmp.Compare(a.Seq, b.Seq)
+	})
+	if limit >= 0 && len(changes) > limit {
+		changes = changes[:limit]
+	}
+	return changes, nil
+}
+
+// ListData выбирает заголовки записей пользователя по фильтрам query,
+// отсортированные по query.SortBy. Если задан after, выбираются записи,
+// следующие за ним в порядке сортировки
+func (s *Storage) ListData(ctx context.Context, login string, query model.ListQuery,
+	after *model.DataHeader, limit int) ([]model.DataHeader, error) {
+	field, ok := listSortFields[query.SortBy]
+	if !ok {
+		err := model.ErrInvalidSortField
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	// compare сравнивает заголовки по полю сортировки, при равенстве - по ключу
+	compare := func(a, b model.DataHeader) int {
+		c := field(a, b)
+		if c == 0 {
+			c = strings.Compare(a.DataKeyWord, b.DataKeyWord)
+		}
+		if query.Descending {
+			c = -c
+		}
+		return c
+	}
+
+	var headers []model.DataHeader
+	err := s.view(ctx, func(tx kvTx) error {
+		prefix := loginPrefix(login)
+		return tx.each(bucketData, prefix+query.KeyPrefix, func(key string, value []byte) error {
+			var row dataRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return err
+			}
+			if query.DataType != "" && row.DataType != query.DataType {
+				return nil
+			}
+			header := row.header(tx, strings.TrimPrefix(key, prefix))
+			if after == nil || compare(header, *after) > 0 {
+				headers = append(headers, header)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(headers, compare)
+	if limit >= 0 && len(headers) > limit {
+		headers = headers[:limit]
+	}
+	return headers, nil
+}
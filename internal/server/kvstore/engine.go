@@ -0,0 +1,137 @@
+package kvstore
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// kvTx - транзакция хранилища ключ-значение. Значения, возвращаемые
+// get и each, можно хранить после завершения транзакции
+type kvTx interface {
+	// get возвращает значение ключа, nil - ключа нет
+	get(bucket string, key string) []byte
+	put(bucket string, key string, value []byte) error
+	delete(bucket string, key string) error
+	// each по возрастанию ключей передает в fn значения с ключами,
+	// начинающимися с prefix. Изменять бакет внутри fn нельзя
+	each(bucket string, prefix string, fn func(key string, value []byte) error) error
+}
+
+// engine - хранилище ключ-значение, на котором работает Storage
+type engine interface {
+	// view выполняет fn в транзакции только для чтения
+	view(fn func(tx kvTx) error) error
+	// update выполняет fn в транзакции для записи. Если fn вернула ошибку,
+	// изменения отбрасываются
+	update(fn func(tx kvTx) error) error
+	close() error
+}
+
+// memoryEngine хранит бакеты в памяти. Транзакции записи выполняются
+// по одной, изменения применяются только после успешного завершения
+type memoryEngine struct {
+	mu      sync.RWMutex
+	buckets map[string]map[string][]byte
+}
+
+func newMemoryEngine() *memoryEngine {
+	buckets := make(map[string]map[string][]byte, len(allBuckets))
+	for _, bucket := range allBuckets {
+		buckets[bucket] = make(map[string][]byte)
+	}
+	return &memoryEngine{buckets: buckets}
+}
+
+func (e *memoryEngine) view(fn func(tx kvTx) error) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return fn(&memoryTx{engine: e})
+}
+
+func (e *memoryEngine) update(fn func(tx kvTx) error) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	tx := &memoryTx{engine: e, writes: make(map[string]map[string]memoryWrite)}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	for bucket, writes := range tx.writes {
+		for key, write := range writes {
+			if write.deleted {
+				delete(e.buckets[bucket], key)
+			} else {
+				e.buckets[bucket][key] = write.value
+			}
+		}
+	}
+	return nil
+}
+
+func (e *memoryEngine) close() error {
+	return nil
+}
+
+// memoryWrite - изменение ключа, еще не примененное к бакету
+type memoryWrite struct {
+	value   []byte
+	deleted bool
+}
+
+// memoryTx читает изменения, сделанные в этой же транзакции, поверх бакетов
+type memoryTx struct {
+	engine *memoryEngine
+	// writes - nil в транзакции только для чтения
+	writes map[string]map[string]memoryWrite
+}
+
+func (tx *memoryTx) get(bucket string, key string) []byte {
+	if write, ok := tx.writes[bucket][key]; ok {
+		if write.deleted {
+			return nil
+		}
+		return write.value
+	}
+	return tx.engine.buckets[bucket][key]
+}
+
+func (tx *memoryTx) put(bucket string, key string, value []byte) error {
+	tx.write(bucket, key, memoryWrite{value: append([]byte{}, value...)})
+	return nil
+}
+
+func (tx *memoryTx) delete(bucket string, key string) error {
+	tx.write(bucket, key, memoryWrite{deleted: true})
+	return nil
+}
+
+func (tx *memoryTx) write(bucket string, key string, write memoryWrite) {
+	if tx.writes[bucket] == nil {
+		tx.writes[bucket] = make(map[string]memoryWrite)
+	}
+	tx.writes[bucket][key] = write
+}
+
+func (tx *memoryTx) each(bucket string, prefix string,
+	fn func(key string, value []byte) error) error {
+	var keys []string
+	for key := range tx.engine.buckets[bucket] {
+		if _, written := tx.writes[bucket][key]; !written && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	for key, write := range tx.writes[bucket] {
+		if !write.deleted && strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := fn(key, tx.get(bucket, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package kvstore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEngine(t *testing.T) {
+	tests := []struct {
+		name      string
+		newEngine func(t *testing.T) engine
+	}{
+		{
+			name: "В памяти",
+			newEngine: func(t *testing.T) engine {
+				return newMemoryEngine()
+			},
+		},
+		{
+			name: "Файл bbolt",
+			newEngine: func(t *testing.T) engine {
+				e, err := openBoltEngine(filepath.Join(t.TempDir(), "keeper.db"))
+				require.NoError(t, err)
+				t.Cleanup(func() { e.close() })
+				return e
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := tt.newEngine(t)
+			require.NoError(t, e.update(func(tx kvTx) error {
+				for _, key := range []string{"b\x00two", "a\x00one", "b\x00one", "bb\x00one"} {
+					if err := tx.put(bucketData, key, []byte(key)); err != nil {
+						return err
+					}
+				}
+				return nil
+			}))
+
+			// ошибка отменяет все изменения транзакции, хотя внутри
+			// нее они уже видны
+			errRollback := errors.New("rollback")
+			err := e.update(func(tx kvTx) error {
+				require.NoError(t, tx.put(bucketData, "b\x00three", []byte("three")))
+				require.NoError(t, tx.delete(bucketData, "b\x00one"))
+				assert.Equal(t, []string{"b\x00three", "b\x00two"}, keys(t, tx, "b\x00"))
+				return errRollback
+			})
+			assert.ErrorIs(t, err, errRollback)
+
+			require.NoError(t, e.view(func(tx kvTx) error {
+				assert.Equal(t, []byte("b\x00one"), tx.get(bucketData, "b\x00one"))
+				assert.Nil(t, tx.get(bucketData, "b\x00three"))
+				assert.Equal(t, []string{"b\x00one", "b\x00two"}, keys(t, tx, "b\x00"))
+				assert.Len(t, keys(t, tx, ""), 4)
+				return nil
+			}))
+		})
+	}
+}
+
+// keys возвращает ключи бакета с префиксом prefix в порядке обхода
+func keys(t *testing.T, tx kvTx, prefix string) []string {
+	var keys []string
+	require.NoError(t, tx.each(bucketData, prefix, func(key string, value []byte) error {
+		keys = append(keys, key)
+		return nil
+	}))
+	return keys
+}
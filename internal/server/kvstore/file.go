@@ -0,0 +1,170 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"keeper/internal/model"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// deleteUpload удаляет загрузку вместе с частями файла
+func deleteUpload(tx kvTx, fileID string) error {
+	var keys []string
+	err := tx.each(bucketFileChunks, fileID+"\x00", func(key string, value []byte) error {
+		keys = append(keys, key)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = tx.delete(bucketFileChunks, key); err != nil {
+			return err
+		}
+	}
+	return tx.delete(bucketFileUploads, fileID)
+}
+
+// fileReferenced сообщает, ссылаются ли на файл записи пользователя
+// или записи в его корзине
+func fileReferenced(tx kvTx, login string, fileID string) (bool, error) {
+	var referenced bool
+	check := func(bucket string, rowFileID func(value []byte) (string, error)) error {
+		return tx.each(bucket, loginPrefix(login), func(key string, value []byte) error {
+			id, err := rowFileID(value)
+			if err != nil {
+				return err
+			}
+			referenced = referenced || id == fileID
+			return nil
+		})
+	}
+	err := check(bucketData, func(value []byte) (string, error) {
+		var row dataRow
+		err := json.Unmarshal(value, &row)
+		return row.FileID, err
+	})
+	if err == nil {
+		err = check(bucketTrash, func(value []byte) (string, error) {
+			var row trashRow
+			err := json.Unmarshal(value, &row)
+			return row.Record.FileID, err
+		})
+	}
+	return referenced, err
+}
+
+// CreateFileUpload регистрирует загрузку файла. Заодно удаляет
+// незавершенные загрузки, начатые раньше staleBefore
+func (s *Storage) CreateFileUpload(ctx context.Context, login string, fileID string,
+	staleBefore time.Time) error {
+	return s.update(ctx, func(tx kvTx) error {
+		var stale []string
+		err := tx.each(bucketFileUploads, "", func(key string, value []byte) error {
+			var upload uploadRow
+			if err := json.Unmarshal(value, &upload); err != nil {
+				return err
+			}
+			if !upload.Completed && upload.CreatedAt.Before(staleBefore) {
+				stale = append(stale, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, staleID := range stale {
+			if err = deleteUpload(tx, staleID); err != nil {
+				return err
+			}
+		}
+		return putJSON(tx, bucketFileUploads, fileID, uploadRow{
+			Login:     login,
+			CreatedAt: time.Now(),
+		})
+	})
+}
+
+// AddFileChunk сохраняет зашифрованную часть файла. Если загрузки нет,
+// возвращает model.ErrUploadNotFound
+func (s *Storage) AddFileChunk(ctx context.Context, fileID string, seq int64,
+	cipherChunk []byte) error {
+	return s.update(ctx, func(tx kvTx) error {
+		if tx.get(bucketFileUploads, fileID) == nil {
+			return model.ErrUploadNotFound
+		}
+		return tx.put(bucketFileChunks, chunkKey(fileID, seq), cipherChunk)
+	})
+}
+
+// CompleteFileUpload в одной транзакции добавляет запись с описанием
+// файла и отмечает загрузку завершенной
+func (s *Storage) CompleteFileUpload(ctx context.Context, data model.DataBlock) error {
+	return s.update(ctx, func(tx kvTx) error {
+		if tx.get(bucketData, recordKey(data.Login, data.DataKeyWord)) != nil {
+			return model.ErrDataKeyWordExists
+		}
+		if err := insertRecord(tx, data.Login, data.DataKeyWord, newRecord(data)); err != nil {
+			return err
+		}
+		var upload uploadRow
+		found, err := getJSON(tx, bucketFileUploads, data.FileID, &upload)
+		if err != nil || !found {
+			return err
+		}
+		upload.Completed = true
+		return putJSON(tx, bucketFileUploads, data.FileID, upload)
+	})
+}
+
+// DeleteFileUpload удаляет незавершенную загрузку вместе с частями файла
+func (s *Storage) DeleteFileUpload(ctx context.Context, fileID string) error {
+	return s.update(ctx, func(tx kvTx) error {
+		return deleteUpload(tx, fileID)
+	})
+}
+
+// GetFileChunks по порядку передает части файла в функцию fn,
+// не загружая файл в память целиком. Каждая часть читается в отдельной
+// транзакции, чтобы fn не задерживала запись в хранилище
+func (s *Storage) GetFileChunks(ctx context.Context, fileID string,
+	fn func(seq int64, cipherChunk []byte) error) error {
+	prefix := fileID + "\x00"
+	var keys []string
+	err := s.view(ctx, func(tx kvTx) error {
+		return tx.each(bucketFileChunks, prefix, func(key string, value []byte) error {
+			keys = append(keys, key)
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		seq, err := strconv.ParseInt(strings.TrimPrefix(key, prefix), 10, 64)
+		if err != nil {
+			s.log.Error(err.Error())
+			return err
+		}
+		var cipherChunk []byte
+		err = s.view(ctx, func(tx kvTx) error {
+			cipherChunk = tx.get(bucketFileChunks, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if cipherChunk == nil {
+			err = model.ErrFileChunkNotFound
+			s.log.Error(err.Error())
+			return err
+		}
+		if err = fn(seq, cipherChunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package kvstore
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"keeper/internal/model"
+	"slices"
+	"time"
+)
+
+// deleteHistory удаляет все прежние версии записи
+func deleteHistory(tx kvTx, login string, dataKeyWord string) error {
+	var keys []string
+	err := tx.each(bucketHistory, loginPrefix(login), func(key string, value []byte) error {
+		var row historyRow
+		if err := json.Unmarshal(value, &row); err != nil {
+			return err
+		}
+		if row.DataKeyWord == dataKeyWord {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err = tx.delete(bucketHistory, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetHistory выбирает прежние версии записи пользователя, начиная с последней
+func (s *Storage) GetHistory(ctx context.Context, login string,
+	dataKeyWord string) ([]model.Revision, error) {
+	var revisions []model.Revision
+	err := s.view(ctx, func(tx kvTx) error {
+		return tx.each(bucketHistory, loginPrefix(login), func(key string, value []byte) error {
+			var row historyRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return err
+			}
+			if row.DataKeyWord != dataKeyWord {
+				return nil
+			}
+			revision := model.Revision{
+				ID: row.ID,
+				Data: model.DataBlock{
+					DataKeyWord: dataKeyWord,
+					DataType:    row.DataType,
+					CipherData:  row.Data,
+					MetaData:    row.MetaData,
+					Version:     row.Version,
+					Device:      row.Device,
+				},
+				Device:     row.Device,
+				CreatedAt:  row.CreatedAt,
+				ReplacedAt: row.ReplacedAt,
+				Deleted:    row.Deleted,
+			}
+			if revision.CreatedAt.IsZero() {
+				revision.CreatedAt = row.ReplacedAt
+			}
+			revisions = append(revisions, revision)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(revisions)
+	return revisions, nil
+}
+
+// RestoreRevision заменяет запись прежней версией revisionID и возвращает новую
+// версию записи. Запись изменяется, только если ее версия совпадает
+// с expectedVersion, 0 - запись удалена и восстанавливается заново.
+// Заменяемая версия сохраняется в истории
+func (s *Storage) RestoreRevision(ctx context.Context, login string, revisionID int64,
+	expectedVersion int64, device string) (int64, error) {
+	var version int64
+	err := s.update(ctx, func(tx kvTx) error {
+		var row historyRow
+		found, err := getJSON(tx, bucketHistory, idKey(login, revisionID), &row)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return model.ErrRevisionNotFound
+		}
+		record := model.DataBlock{
+			Login:       login,
+			DataKeyWord: row.DataKeyWord,
+			DataType:    row.DataType,
+			CipherData:  row.Data,
+			MetaData:    row.MetaData,
+			Device:      device,
+		}
+		if expectedVersion == 0 {
+			version, err = insertDataIfAbsent(tx, record)
+			return err
+		}
+		record.Version = expectedVersion
+		version, err = changeData(tx, record)
+		if errors.Is(err, model.ErrNoRowsSelected) {
+			err = &model.VersionMismatchError{}
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// PruneHistory удаляет прежние версии записей, не входящие в keep последних
+// версий каждой записи (0 - без ограничения) или замененные раньше replacedBefore
+// (нулевое время - без ограничения). Возвращает количество удаленных версий
+func (s *Storage) PruneHistory(ctx context.Context, keep int,
+	replacedBefore time.Time) (int64, error) {
+	type revision struct {
+		key string
+		row historyRow
+	}
+	var pruned int64
+	err := s.update(ctx, func(tx kvTx) error {
+		records := make(map[string][]revision)
+		err := tx.each(bucketHistory, "", func(key string, value []byte) error {
+			var row historyRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return err
+			}
+			login, _ := splitRecordKey(key)
+			record := recordKey(login, row.DataKeyWord)
+			records[record] = append(records[record], revision{key: key, row: row})
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, revisions := range records {
+			slices.SortFunc(revisions, func(a, b revision) int {
+				return cmp.Compare(b.row.ID, a.row.ID)
+			})
+			for n, revision := range revisions {
+				if (keep > 0 && n >= keep) ||
+					(!replacedBefore.IsZero() && revision.row.ReplacedAt.Before(replacedBefore)) {
+					if err = tx.delete(bucketHistory, revision.key); err != nil {
+						return err
+					}
+					pruned++
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return pruned, nil
+}
@@ -0,0 +1,238 @@
+// Package kvstore реализует service.Storer поверх хранилища ключ-значение:
+// в памяти для тестов и разработки или в файле bbolt для сервера без Postgres.
+// Строки таблиц хранятся в бакетах в формате json, составные ключи
+// разделяются нулевым байтом, поэтому порядок ключей совпадает
+// с порядком строк в Postgres
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Бакеты соответствуют таблицам схемы Postgres
+const (
+	bucketUsers         = "users"
+	bucketUserKeys      = "userKeys"
+	bucketVaultChecks   = "vaultChecks"
+	bucketRefreshTokens = "refreshTokens"
+	bucketSessions      = "sessions"
+	bucketTOTP          = "userTOTP"
+	bucketRecoveryCodes = "recoveryCodes"
+	bucketChallenges    = "authChallenges"
+	bucketData          = "dataTable"
+	bucketDeletedData   = "deletedData"
+	bucketConflicts     = "dataConflicts"
+	bucketTrash         = "dataTrash"
+	bucketHistory       = "dataHistory"
+	bucketFileUploads   = "fileUploads"
+	bucketFileChunks    = "fileChunks"
+	bucketKeyRotation   = "keyRotation"
+	// bucketSequences хранит последние выданные значения счетчиков
+	bucketSequences = "sequences"
+)
+
+var allBuckets = []string{bucketUsers, bucketUserKeys, bucketVaultChecks, bucketRefreshTokens,
+	bucketSessions, bucketTOTP, bucketRecoveryCodes, bucketChallenges, bucketData,
+	bucketDeletedData, bucketConflicts, bucketTrash, bucketHistory, bucketFileUploads,
+	bucketFileChunks, bucketKeyRotation, bucketSequences}
+
+// Счетчики, заменяющие последовательности Postgres
+const (
+	seqDataChange = "dataChangeSeq"
+	seqConflict   = "conflictID"
+	seqTrash      = "trashID"
+	seqRevision   = "revisionID"
+)
+
+// Storage - хранилище данных сервера поверх хранилища ключ-значение
+type Storage struct {
+	db  engine
+	log *logrus.Logger
+}
+
+// NewMemory создает хранилище в памяти. Данные теряются при остановке
+// сервера, оно подходит для тестов и разработки
+func NewMemory(log *logrus.Logger) *Storage {
+	return &Storage{
+		db:  newMemoryEngine(),
+		log: log,
+	}
+}
+
+// OpenBolt открывает хранилище в файле bbolt, создавая файл
+// при необходимости
+func OpenBolt(path string, log *logrus.Logger) (*Storage, error) {
+	db, err := openBoltEngine(path)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	log.Debug("Открыли файл хранилища " + path)
+	return &Storage{
+		db:  db,
+		log: log,
+	}, nil
+}
+
+// Close закрывает хранилище
+func (s *Storage) Close() {
+	if err := s.db.close(); err != nil {
+		s.log.Error(err.Error())
+	}
+}
+
+// view выполняет fn в транзакции чтения и пишет ошибку в журнал
+func (s *Storage) view(ctx context.Context, fn func(tx kvTx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.db.view(fn)
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return err
+}
+
+// update выполняет fn в транзакции записи и пишет ошибку в журнал
+func (s *Storage) update(ctx context.Context, fn func(tx kvTx) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.db.update(fn)
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return err
+}
+
+// userRow - строка таблицы users
+type userRow struct {
+	PasswordHash string
+}
+
+// sessionRow - строка таблицы sessions
+type sessionRow struct {
+	Login     string
+	ExpiresAt time.Time
+	// RevokedAt - nil, если сессия не отозвана
+	RevokedAt *time.Time
+}
+
+// dataRow - строка таблицы dataTable
+type dataRow struct {
+	DataType  string
+	Data      []byte
+	MetaData  string
+	FileID    string
+	Device    string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	ChangeSeq int64
+	Version   int64
+}
+
+// tombstoneRow - строка таблицы deletedData
+type tombstoneRow struct {
+	ChangeSeq int64
+	DeletedAt time.Time
+}
+
+// trashRow - строка таблицы dataTrash
+type trashRow struct {
+	ID          int64
+	DataKeyWord string
+	Record      dataRow
+	DeletedAt   time.Time
+}
+
+// historyRow - строка таблицы dataHistory
+type historyRow struct {
+	ID          int64
+	DataKeyWord string
+	Version     int64
+	DataType    string
+	Data        []byte
+	MetaData    string
+	Device      string
+	CreatedAt   time.Time
+	ReplacedAt  time.Time
+	Deleted     bool
+}
+
+// conflictRow - строка таблицы dataConflicts
+type conflictRow struct {
+	ID           int64
+	DataKeyWord  string
+	BaseVersion  int64
+	HasBase      bool
+	BaseDataType string
+	BaseData     []byte
+	BaseMetaData string
+	DataType     string
+	Data         []byte
+	MetaData     string
+	Deleted      bool
+	Device       string
+	CreatedAt    time.Time
+}
+
+// uploadRow - строка таблицы fileUploads
+type uploadRow struct {
+	Login     string
+	Completed bool
+	CreatedAt time.Time
+}
+
+// recordKey - ключ строки записи пользователя
+func recordKey(login string, dataKeyWord string) string {
+	return login + "\x00" + dataKeyWord
+}
+
+// loginPrefix - префикс ключей строк пользователя
+func loginPrefix(login string) string {
+	return login + "\x00"
+}
+
+// idKey - ключ строки с числовым идентификатором пользователя. Номер
+// дополняется нулями, чтобы ключи сортировались по возрастанию номера
+func idKey(login string, id int64) string {
+	return fmt.Sprintf("%s\x00%020d", login, id)
+}
+
+// chunkKey - ключ части файла
+func chunkKey(fileID string, seq int64) string {
+	return fmt.Sprintf("%s\x00%020d", fileID, seq)
+}
+
+// getJSON читает строку в v, возвращает false, если строки нет
+func getJSON(tx kvTx, bucket string, key string, v interface{}) (bool, error) {
+	value := tx.get(bucket, key)
+	if value == nil {
+		return false, nil
+	}
+	return true, json.Unmarshal(value, v)
+}
+
+// putJSON сохраняет строку v
+func putJSON(tx kvTx, bucket string, key string, v interface{}) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return tx.put(bucket, key, value)
+}
+
+// nextSeq возвращает следующее значение счетчика name
+func nextSeq(tx kvTx, name string) (int64, error) {
+	var seq int64
+	if _, err := getJSON(tx, bucketSequences, name, &seq); err != nil {
+		return 0, err
+	}
+	seq++
+	return seq, putJSON(tx, bucketSequences, name, seq)
+}
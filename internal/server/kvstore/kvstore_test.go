@@ -0,0 +1,64 @@
+package kvstore
+
+import (
+	"context"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/server/service"
+	"keeper/internal/server/storertest"
+	"path/filepath"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryConformance(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	storertest.Run(t, func(t *testing.T) service.Storer {
+		return NewMemory(log)
+	})
+}
+
+func TestBoltConformance(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	storertest.Run(t, func(t *testing.T) service.Storer {
+		s, err := OpenBolt(filepath.Join(t.TempDir(), "keeper.db"), log)
+		require.NoError(t, err)
+		t.Cleanup(s.Close)
+		return s
+	})
+}
+
+func TestBoltReopen(t *testing.T) {
+	ctx := context.Background()
+	log := logger.InitLog(logrus.InfoLevel)
+	path := filepath.Join(t.TempDir(), "data", "keeper.db")
+
+	s, err := OpenBolt(path, log)
+	require.NoError(t, err)
+	require.NoError(t, s.AddUser(ctx, "user", "hash"))
+	require.NoError(t, s.InsertData(ctx, model.DataBlock{Login: "user", DataKeyWord: "first",
+		CipherData: []byte("data")}))
+	// файл открывает только один сервер
+	_, err = OpenBolt(path, log)
+	assert.ErrorIs(t, err, model.ErrStorageBusy)
+	s.Close()
+
+	s, err = OpenBolt(path, log)
+	require.NoError(t, err)
+	defer s.Close()
+	data, err := s.GetData(ctx, "user", "first")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("data"), data[0].CipherData)
+
+	// номера изменений продолжаются после повторного открытия
+	require.NoError(t, s.InsertData(ctx, model.DataBlock{Login: "user", DataKeyWord: "second",
+		CipherData: []byte("data")}))
+	changes, err := s.GetChanges(ctx, "user", model.ChangePosition{}, 10)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.Equal(t, "first", changes[0].Header.DataKeyWord)
+	assert.Less(t, changes[0].Seq, changes[1].Seq)
+}
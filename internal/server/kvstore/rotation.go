@@ -0,0 +1,259 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"keeper/internal/model"
+)
+
+// GetKeyRotation возвращает сохраненное состояние ротации секрета сервера.
+// Для новой ротации возвращается состояние первого этапа
+func (s *Storage) GetKeyRotation(ctx context.Context, rotationID string) (model.KeyRotation, error) {
+	rotation := model.KeyRotation{
+		RotationID: rotationID,
+		Phase:      model.RotationPhaseKeys,
+	}
+	err := s.view(ctx, func(tx kvTx) error {
+		_, err := getJSON(tx, bucketKeyRotation, rotationID, &rotation)
+		return err
+	})
+	return rotation, err
+}
+
+// RotateUserKeysBatch переоборачивает очередную пачку ключей пользователей.
+// Пачка и состояние ротации сохраняются в одной транзакции.
+// Когда ключи заканчиваются, ротация переходит к этапу перешифровки данных
+func (s *Storage) RotateUserKeysBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, rewrap func(login string, wrappedKey []byte) ([]byte, error)) (
+	model.KeyRotation, error) {
+	next := rotation
+	err := s.update(ctx, func(tx kvTx) error {
+		var logins []string
+		err := tx.each(bucketUserKeys, "", func(login string, value []byte) error {
+			if login > rotation.LastLogin && len(logins) < batchSize {
+				logins = append(logins, login)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, login := range logins {
+			wrappedKey, err := rewrap(login, tx.get(bucketUserKeys, login))
+			if err != nil {
+				return err
+			}
+			if err = tx.put(bucketUserKeys, login, wrappedKey); err != nil {
+				return err
+			}
+			next.LastLogin = login
+			next.Processed++
+		}
+		if len(logins) < batchSize {
+			next.Phase = model.RotationPhaseData
+			next.LastLogin = ""
+		}
+		return putJSON(tx, bucketKeyRotation, next.RotationID, next)
+	})
+	if err != nil {
+		return rotation, err
+	}
+	return next, nil
+}
+
+// RotateDataBatch перешифровывает очередную пачку записей. Функция reseal
+// возвращает новый шифротекст и признак того, что запись нужно обновить.
+// Когда записи заканчиваются, ротация переходит к перешифровке истории версий
+func (s *Storage) RotateDataBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (
+	model.KeyRotation, error) {
+	next := rotation
+	err := s.update(ctx, func(tx kvTx) error {
+		// ключи упорядочены так же, как пары (login, dataKeyWord)
+		last := recordKey(rotation.LastLogin, rotation.LastKeyWord)
+		var keys []string
+		err := tx.each(bucketData, "", func(key string, value []byte) error {
+			if key > last && len(keys) < batchSize {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			var row dataRow
+			if _, err = getJSON(tx, bucketData, key, &row); err != nil {
+				return err
+			}
+			login, dataKeyWord := splitRecordKey(key)
+			cipherData, changed, err := reseal(model.DataBlock{
+				Login:       login,
+				DataKeyWord: dataKeyWord,
+				CipherData:  row.Data,
+			})
+			if err != nil {
+				return err
+			}
+			if changed {
+				row.Data = cipherData
+				if err = putJSON(tx, bucketData, key, row); err != nil {
+					return err
+				}
+				next.Processed++
+			}
+			next.LastLogin, next.LastKeyWord = login, dataKeyWord
+		}
+		if len(keys) < batchSize {
+			next.Phase = model.RotationPhaseHistory
+			next.LastLogin, next.LastKeyWord, next.LastID = "", "", 0
+		}
+		return putJSON(tx, bucketKeyRotation, next.RotationID, next)
+	})
+	if err != nil {
+		return rotation, err
+	}
+	return next, nil
+}
+
+// RotateHistoryBatch перешифровывает очередную пачку прежних версий записей.
+// Когда версии заканчиваются, ротация переходит к перешифровке корзины
+func (s *Storage) RotateHistoryBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (
+	model.KeyRotation, error) {
+	return s.rotateRowsBatch(ctx, rotation, batchSize, bucketHistory, model.RotationPhaseTrash,
+		func(login string, value []byte) (int64, []byte, error) {
+			var row historyRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return 0, nil, err
+			}
+			if len(row.Data) == 0 {
+				return row.ID, nil, nil
+			}
+			cipherData, changed, err := reseal(model.DataBlock{
+				Login:       login,
+				DataKeyWord: row.DataKeyWord,
+				CipherData:  row.Data,
+			})
+			if err != nil || !changed {
+				return row.ID, nil, err
+			}
+			row.Data = cipherData
+			updated, err := json.Marshal(row)
+			return row.ID, updated, err
+		})
+}
+
+// RotateTrashBatch перешифровывает очередную пачку записей в корзине.
+// Когда записи заканчиваются, ротация переходит к перешифровке конфликтов
+func (s *Storage) RotateTrashBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (
+	model.KeyRotation, error) {
+	return s.rotateRowsBatch(ctx, rotation, batchSize, bucketTrash, model.RotationPhaseConflicts,
+		func(login string, value []byte) (int64, []byte, error) {
+			var row trashRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return 0, nil, err
+			}
+			if len(row.Record.Data) == 0 {
+				return row.ID, nil, nil
+			}
+			cipherData, changed, err := reseal(model.DataBlock{
+				Login:       login,
+				DataKeyWord: row.DataKeyWord,
+				CipherData:  row.Record.Data,
+			})
+			if err != nil || !changed {
+				return row.ID, nil, err
+			}
+			row.Record.Data = cipherData
+			updated, err := json.Marshal(row)
+			return row.ID, updated, err
+		})
+}
+
+// RotateConflictsBatch перешифровывает очередную пачку конфликтов: версию,
+// которую изменял клиент, и его изменение. Когда конфликты заканчиваются,
+// ротация завершается
+func (s *Storage) RotateConflictsBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (
+	model.KeyRotation, error) {
+	return s.rotateRowsBatch(ctx, rotation, batchSize, bucketConflicts, model.RotationPhaseDone,
+		func(login string, value []byte) (int64, []byte, error) {
+			var row conflictRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return 0, nil, err
+			}
+			var changed bool
+			for _, cipherData := range []*[]byte{&row.BaseData, &row.Data} {
+				if len(*cipherData) == 0 {
+					continue
+				}
+				resealed, ok, err := reseal(model.DataBlock{
+					Login:       login,
+					DataKeyWord: row.DataKeyWord,
+					CipherData:  *cipherData,
+				})
+				if err != nil {
+					return row.ID, nil, err
+				}
+				if ok {
+					*cipherData, changed = resealed, true
+				}
+			}
+			if !changed {
+				return row.ID, nil, nil
+			}
+			updated, err := json.Marshal(row)
+			return row.ID, updated, err
+		})
+}
+
+// rotateRowsBatch перешифровывает очередную пачку строк бакета с числовым
+// ключом после (rotation.LastLogin, rotation.LastID). Функция resealRow
+// возвращает номер строки и ее новое значение, nil - строку менять не нужно.
+// Когда строки заканчиваются, ротация переходит к этапу nextPhase
+func (s *Storage) rotateRowsBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, bucket string, nextPhase string,
+	resealRow func(login string, value []byte) (int64, []byte, error)) (model.KeyRotation, error) {
+	next := rotation
+	err := s.update(ctx, func(tx kvTx) error {
+		last := idKey(rotation.LastLogin, rotation.LastID)
+		var keys []string
+		err := tx.each(bucket, "", func(key string, value []byte) error {
+			if key > last && len(keys) < batchSize {
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range keys {
+			login, _ := splitRecordKey(key)
+			id, updated, err := resealRow(login, tx.get(bucket, key))
+			if err != nil {
+				return err
+			}
+			if updated != nil {
+				if err = tx.put(bucket, key, updated); err != nil {
+					return err
+				}
+				next.Processed++
+			}
+			next.LastLogin, next.LastID = login, id
+		}
+		if len(keys) < batchSize {
+			next.Phase = nextPhase
+			next.LastLogin, next.LastKeyWord, next.LastID = "", "", 0
+		}
+		return putJSON(tx, bucketKeyRotation, next.RotationID, next)
+	})
+	if err != nil {
+		return rotation, err
+	}
+	return next, nil
+}
@@ -0,0 +1,162 @@
+package kvstore
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"keeper/internal/model"
+	"slices"
+	"time"
+)
+
+// ListTrash выбирает заголовки записей в корзине пользователя, начиная
+// с последней удаленной
+func (s *Storage) ListTrash(ctx context.Context, login string) ([]model.TrashedRecord, error) {
+	var records []model.TrashedRecord
+	err := s.view(ctx, func(tx kvTx) error {
+		return tx.each(bucketTrash, loginPrefix(login), func(key string, value []byte) error {
+			var row trashRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return err
+			}
+			header := row.Record.header(tx, row.DataKeyWord)
+			if header.CreatedAt.IsZero() {
+				header.CreatedAt = row.DeletedAt
+			}
+			if header.UpdatedAt.IsZero() {
+				header.UpdatedAt = row.DeletedAt
+			}
+			records = append(records, model.TrashedRecord{
+				ID:        row.ID,
+				Header:    header,
+				DeletedAt: row.DeletedAt,
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(records, func(a, b model.TrashedRecord) int {
+		if c := b.DeletedAt.Compare(a.DeletedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.ID, a.ID)
+	})
+	return records, nil
+}
+
+// RestoreFromTrash возвращает запись из корзины и возвращает ее версию.
+// Если ключ записи уже занят, возвращается model.ErrDataKeyWordExists
+func (s *Storage) RestoreFromTrash(ctx context.Context, login string, trashID int64,
+	device string) (int64, error) {
+	var version int64
+	err := s.update(ctx, func(tx kvTx) error {
+		key := idKey(login, trashID)
+		var trash trashRow
+		found, err := getJSON(tx, bucketTrash, key, &trash)
+		if err != nil {
+			return err
+		}
+		if !found {
+			return model.ErrTrashNotFound
+		}
+		if tx.get(bucketData, recordKey(login, trash.DataKeyWord)) != nil {
+			return model.ErrDataKeyWordExists
+		}
+
+		// версия записи продолжает расти с версии до удаления
+		row := trash.Record
+		now := time.Now()
+		if row.CreatedAt.IsZero() {
+			row.CreatedAt = now
+		}
+		row.UpdatedAt, row.Device = now, device
+		row.Version++
+		if err = insertRecord(tx, login, trash.DataKeyWord, row); err != nil {
+			return err
+		}
+		version = row.Version
+		return tx.delete(bucketTrash, key)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// PurgeTrash навсегда удаляет записи из корзины пользователя login (пустой -
+// всех пользователей), перемещенные в корзину раньше deletedBefore (нулевое
+// время - все записи). Вместе с записью удаляются части файла и история,
+// если ключ больше не занят. Возвращает количество удаленных записей
+func (s *Storage) PurgeTrash(ctx context.Context, login string,
+	deletedBefore time.Time) (int64, error) {
+	prefix := ""
+	if login != "" {
+		prefix = loginPrefix(login)
+	}
+	var purged int64
+	err := s.update(ctx, func(tx kvTx) error {
+		purge := make(map[string]trashRow)
+		err := tx.each(bucketTrash, prefix, func(key string, value []byte) error {
+			var row trashRow
+			if err := json.Unmarshal(value, &row); err != nil {
+				return err
+			}
+			if deletedBefore.IsZero() || row.DeletedAt.Before(deletedBefore) {
+				purge[key] = row
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// записи, которые снова заняли ключ, проверяются до удаления
+		// истории, как в одном запросе Postgres
+		records := make(map[string]bool)
+		for key, row := range purge {
+			owner, _ := splitRecordKey(key)
+			record := recordKey(owner, row.DataKeyWord)
+			records[record] = tx.get(bucketData, record) != nil
+		}
+		files := make(map[string]string)
+		for key, row := range purge {
+			if err = tx.delete(bucketTrash, key); err != nil {
+				return err
+			}
+			if row.Record.FileID != "" {
+				owner, _ := splitRecordKey(key)
+				files[row.Record.FileID] = owner
+			}
+		}
+		// файл, на который ссылаются другие записи, остается
+		for fileID, owner := range files {
+			referenced, err := fileReferenced(tx, owner, fileID)
+			if err != nil {
+				return err
+			}
+			if referenced {
+				continue
+			}
+			if err = deleteUpload(tx, fileID); err != nil {
+				return err
+			}
+		}
+		for record, exists := range records {
+			if exists {
+				continue
+			}
+			owner, dataKeyWord := splitRecordKey(record)
+			if err = deleteHistory(tx, owner, dataKeyWord); err != nil {
+				return err
+			}
+		}
+		purged = int64(len(purge))
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return purged, nil
+}
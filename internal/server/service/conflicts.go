@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+// ListConflicts возвращает конфликты пользователя с расшифрованными версиями
+// записи: той, которую изменял клиент, изменением клиента и текущей на сервере
+func (s *service) ListConflicts(ctx context.Context, dataKeyWord string) ([]model.Conflict, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return nil, err
+	}
+	conflicts, err := s.storage.GetConflicts(ctx, login, dataKeyWord)
+	if err != nil || len(conflicts) == 0 {
+		return nil, err
+	}
+	dataKey, err := s.dataKey(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range conflicts {
+		conflict := &conflicts[i]
+		if conflict.Base != nil {
+			base, err := s.openRecord(*conflict.Base, dataKey)
+			if err != nil {
+				return nil, err
+			}
+			conflict.Base = &base
+		}
+		if !conflict.MineDeleted {
+			if conflict.Mine, err = s.openRecord(conflict.Mine, dataKey); err != nil {
+				return nil, err
+			}
+		}
+		if conflict.Theirs != nil {
+			theirs, err := s.openRecord(*conflict.Theirs, dataKey)
+			if err != nil {
+				return nil, err
+			}
+			conflict.Theirs = &theirs
+		}
+	}
+	return conflicts, nil
+}
+
+// ResolveConflict применяет выбор пользователя для конфликта
+// и возвращает версию записи после разрешения
+func (s *service) ResolveConflict(ctx context.Context,
+	resolution model.ConflictResolution) (int64, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return 0, err
+	}
+	switch resolution.Strategy {
+	case model.ResolveKeepTheirs, model.ResolveKeepMine, model.ResolveKeepBoth:
+	case model.ResolveMerge:
+		if resolution.Merged, err = s.sealRecord(ctx, login, resolution.Merged); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, model.ErrUnknownResolution
+	}
+	return s.storage.ResolveConflict(ctx, login, resolution)
+}
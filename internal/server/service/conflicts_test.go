@@ -0,0 +1,224 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/server/kvstore"
+	"keeper/internal/server/service/mocks"
+	"keeper/internal/utils"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceListConflicts(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	ctx := initContext(true, "user1", log, secretPassword)
+	dataKey, wrappedKey := newDataKey(t, secretPassword, log)
+	mockStorage.On("GetUserKey", ctx, "user1").Return(wrappedKey, nil)
+
+	seal := func(data string) model.DataBlock {
+		cipherData, err := utils.DataKeyCipher(data, dataKey, log)
+		require.NoError(t, err)
+		return model.DataBlock{DataKeyWord: "key", CipherData: cipherData}
+	}
+	base, theirs := seal("base"), seal("theirs")
+	theirs.Version = 3
+	mockStorage.On("GetConflicts", ctx, "user1", "key").Return([]model.Conflict{
+		{
+			ID:          1,
+			DataKeyWord: "key",
+			BaseVersion: 1,
+			Base:        &base,
+			Mine:        seal("mine"),
+			Theirs:      &theirs,
+			CreatedAt:   time.Now(),
+		},
+		{ID: 2, DataKeyWord: "key", BaseVersion: 3, MineDeleted: true, Mine: model.DataBlock{DataKeyWord: "key"}},
+	}, nil).Once()
+
+	conflicts, err := s.ListConflicts(ctx, "key")
+	require.NoError(t, err)
+	require.Len(t, conflicts, 2)
+	assert.Equal(t, "base", conflicts[0].Base.Data)
+	assert.Equal(t, "mine", conflicts[0].Mine.Data)
+	assert.Equal(t, "theirs", conflicts[0].Theirs.Data)
+	assert.Equal(t, int64(3), conflicts[0].Theirs.Version)
+	assert.True(t, conflicts[1].MineDeleted)
+	assert.Nil(t, conflicts[1].Theirs)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestServiceResolveConflict(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+	ctx := initContext(true, "user1", log, secretPassword)
+
+	tests := []struct {
+		name        string
+		resolution  model.ConflictResolution
+		wantVersion int64
+		storageErr  error
+		wantErr     error
+	}{
+		{
+			name:        "Оставить версию на сервере",
+			resolution:  model.ConflictResolution{ConflictID: 1, Strategy: model.ResolveKeepTheirs},
+			wantVersion: 3,
+		},
+		{
+			name: "Оставить свое изменение",
+			resolution: model.ConflictResolution{
+				ConflictID: 1, Strategy: model.ResolveKeepMine, ExpectedVersion: 3,
+			},
+			wantVersion: 4,
+		},
+		{
+			name: "Объединить версии",
+			resolution: model.ConflictResolution{
+				ConflictID:      1,
+				Strategy:        model.ResolveMerge,
+				ExpectedVersion: 3,
+				Merged:          model.DataBlock{DataKeyWord: "key", Data: "merged", MetaData: "a; b"},
+			},
+			wantVersion: 4,
+		},
+		{
+			name: "Сохранить обе версии",
+			resolution: model.ConflictResolution{
+				ConflictID: 1, Strategy: model.ResolveKeepBoth, RenamedKey: "key-conflict-1",
+			},
+			wantVersion: 3,
+		},
+		{
+			name: "Запись на сервере изменилась",
+			resolution: model.ConflictResolution{
+				ConflictID: 1, Strategy: model.ResolveKeepMine, ExpectedVersion: 2,
+			},
+			storageErr: &model.VersionMismatchError{Current: 3},
+			wantErr:    model.ErrVersionMismatch,
+		},
+		{
+			name:       "Неизвестный способ разрешения",
+			resolution: model.ConflictResolution{ConflictID: 1, Strategy: "newest"},
+			wantErr:    model.ErrUnknownResolution,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mocks.Storer)
+			s := &service{
+				storage: mockStorage,
+				log:     log,
+				config:  model.Config{SecretPassword: secretPassword},
+			}
+			dataKey, wrappedKey := newDataKey(t, secretPassword, log)
+			mockStorage.On("GetUserKey", ctx, "user1").Return(wrappedKey, nil).Maybe()
+			mockStorage.On("GetVaultCheck", ctx, "user1").Return(nil, nil).Maybe()
+			if tt.wantErr != model.ErrUnknownResolution {
+				mockStorage.On("ResolveConflict", ctx, "user1",
+					mock.MatchedBy(func(resolution model.ConflictResolution) bool {
+						if resolution.Strategy != model.ResolveMerge {
+							return reflect.DeepEqual(resolution, tt.resolution)
+						}
+						// объединенная запись шифруется перед сохранением
+						merged := tt.resolution.Merged
+						merged.Login = "user1"
+						return matchCipherData(merged, dataKey, log)(resolution.Merged)
+					})).Return(tt.wantVersion, tt.storageErr).Once()
+			}
+
+			version, err := s.ResolveConflict(ctx, tt.resolution)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantVersion, version)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServiceResolveConflictFlow(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+	ctx := initContext(true, "user1", log, secretPassword)
+
+	storage := kvstore.NewMemory(log)
+	s := &service{
+		storage: storage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	require.NoError(t, storage.AddUser(ctx, "user1", "hash"))
+	_, wrappedKey := newDataKey(t, secretPassword, log)
+	require.NoError(t, storage.AddUserKey(ctx, "user1", wrappedKey))
+
+	content := []byte("little gopher")
+	checksum := sha256.Sum256(content)
+	_, err := s.UploadFile(ctx, fileStream([]model.FileChunk{
+		{Info: &model.FileInfo{DataKeyWord: "report", FileName: "report.txt",
+			Size: int64(len(content))}},
+		{Data: content},
+		{Checksum: checksum[:]},
+	}))
+	require.NoError(t, err)
+
+	// пока клиент без связи менял заметку, на сервере под ее ключом загрузили файл
+	result, err := s.Sync(ctx, "", []model.SyncOperation{{
+		Data:        model.DataBlock{DataKeyWord: "report", Data: "мои заметки"},
+		BaseVersion: 2,
+		Base:        &model.DataBlock{DataKeyWord: "report", Data: "заметки"},
+	}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, result.Conflicts)
+
+	conflicts, err := s.ListConflicts(ctx, "report")
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	assert.Equal(t, "мои заметки", conflicts[0].Mine.Data)
+	require.NotNil(t, conflicts[0].Theirs)
+
+	// изменение сохраняется под новым ключом обычной записью, а файл
+	// остается только у исходной записи
+	_, err = s.ResolveConflict(ctx, model.ConflictResolution{
+		ConflictID:      conflicts[0].ID,
+		Strategy:        model.ResolveKeepBoth,
+		ExpectedVersion: conflicts[0].Theirs.Version,
+		RenamedKey:      "report (copy)",
+	})
+	require.NoError(t, err)
+	copied, err := s.GetData(ctx, "report (copy)")
+	require.NoError(t, err)
+	assert.Equal(t, "мои заметки", copied[0].Data)
+	err = s.DownloadFile(ctx, "report (copy)", func(chunk model.FileChunk) error { return nil })
+	assert.ErrorIs(t, err, model.ErrNotFile)
+
+	var downloaded bytes.Buffer
+	err = s.DownloadFile(ctx, "report", func(chunk model.FileChunk) error {
+		downloaded.Write(chunk.Data)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, content, downloaded.Bytes())
+
+	conflicts, err = s.ListConflicts(ctx, "")
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+}
@@ -0,0 +1,219 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+// staleUploadTTL - время, после которого незавершенная загрузка файла удаляется
+const staleUploadTTL = 24 * time.Hour
+
+// UploadFile принимает файл по частям через recv: первым сообщением описание
+// файла, затем части и контрольная сумма. Каждая часть шифруется ключом
+// данных пользователя и сразу сохраняется в storage, поэтому файл
+// не загружается в память целиком. Запись с файлом появляется, только
+// если размер и контрольная сумма совпали
+func (s *service) UploadFile(ctx context.Context,
+	recv func() (model.FileChunk, error)) (model.FileInfo, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return model.FileInfo{}, err
+	}
+
+	first, err := recv()
+	if err != nil {
+		s.log.Error(err.Error())
+		return model.FileInfo{}, err
+	}
+	if first.Info == nil {
+		return model.FileInfo{}, invalidFile(model.ErrFileInfoRequired)
+	}
+	info := *first.Info
+	if info.DataKeyWord == "" || info.Size < 0 {
+		return info, invalidFile(model.ErrFileInfoRequired)
+	}
+	if info.Size > model.MaxFileSize {
+		return info, invalidFile(model.ErrBigFile)
+	}
+	if !info.ClientSealed {
+		if err = s.allowPlaintext(ctx, login); err != nil {
+			return info, err
+		}
+	}
+
+	// проверяем ключ до приема частей, чтобы не передавать файл впустую
+	if _, err = s.storage.GetData(ctx, login, info.DataKeyWord); err == nil {
+		return info, model.ErrDataKeyWordExists
+	} else if !errors.Is(err, model.ErrNoRowsSelected) {
+		return info, err
+	}
+
+	dataKey, err := s.dataKey(ctx, login)
+	if err != nil {
+		return info, err
+	}
+	fileID, err := utils.GenerateRandomToken()
+	if err != nil {
+		s.log.Error(err.Error())
+		return info, err
+	}
+	if err = s.storage.CreateFileUpload(ctx, login, fileID,
+		time.Now().Add(-staleUploadTTL)); err != nil {
+		return info, err
+	}
+	completed := false
+	defer func() {
+		if completed {
+			return
+		}
+		// клиент мог отключиться, части удаляем независимо от контекста запроса
+		if err := s.storage.DeleteFileUpload(context.WithoutCancel(ctx), fileID); err != nil {
+			s.log.Error(err.Error())
+		}
+	}()
+
+	hash := sha256.New()
+	var size, seq int64
+	var checksum []byte
+	for checksum == nil {
+		chunk, err := recv()
+		if errors.Is(err, io.EOF) {
+			return info, invalidFile(model.ErrChecksumRequired)
+		}
+		if err != nil {
+			s.log.Error(err.Error())
+			return info, err
+		}
+		if chunk.Info != nil {
+			return info, invalidFile(model.ErrFileInfoRequired)
+		}
+		if chunk.Checksum != nil {
+			checksum = chunk.Checksum
+			continue
+		}
+		if len(chunk.Data) > model.MaxFileChunkSize {
+			return info, invalidFile(model.ErrFileChunkTooBig)
+		}
+		size += int64(len(chunk.Data))
+		if size > info.Size {
+			return info, invalidFile(model.ErrFileSizeMismatch)
+		}
+		hash.Write(chunk.Data)
+
+		cipherChunk, err := utils.ChunkCipher(chunk.Data, dataKey, fileID, seq, s.log)
+		if err != nil {
+			return info, err
+		}
+		if err = s.storage.AddFileChunk(ctx, fileID, seq, cipherChunk); err != nil {
+			return info, err
+		}
+		seq++
+	}
+
+	if size != info.Size {
+		return info, invalidFile(model.ErrFileSizeMismatch)
+	}
+	info.Checksum = hash.Sum(nil)
+	if !bytes.Equal(info.Checksum, checksum) {
+		return info, invalidFile(model.ErrChecksumMismatch)
+	}
+
+	manifest, err := json.Marshal(info)
+	if err != nil {
+		s.log.Error(err.Error())
+		return info, err
+	}
+	cipherManifest, err := utils.DataKeyCipher(string(manifest), dataKey, s.log)
+	if err != nil {
+		return info, err
+	}
+	if err = s.storage.CompleteFileUpload(ctx, model.DataBlock{
+		Login:       login,
+		DataKeyWord: info.DataKeyWord,
+		DataType:    model.DataTypeFile,
+		CipherData:  cipherManifest,
+		MetaData:    info.MetaData,
+		FileID:      fileID,
+		Device:      utils.GetDeviceFromContext(ctx),
+	}); err != nil {
+		return info, err
+	}
+	completed = true
+	return info, nil
+}
+
+// DownloadFile передает через send описание файла, а затем его части
+// по порядку. После последней части сверяется контрольная сумма
+func (s *service) DownloadFile(ctx context.Context, dataKeyWord string,
+	send func(model.FileChunk) error) error {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return err
+	}
+	data, err := s.storage.GetData(ctx, login, dataKeyWord)
+	if err != nil {
+		return err
+	}
+	file := data[0]
+	if file.DataType != model.DataTypeFile || file.FileID == "" {
+		return model.ErrNotFile
+	}
+
+	dataKey, err := s.dataKey(ctx, login)
+	if err != nil {
+		return err
+	}
+	manifest, err := s.openData(file.CipherData, dataKey)
+	if err != nil {
+		return err
+	}
+	var info model.FileInfo
+	if err = json.Unmarshal([]byte(manifest), &info); err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	info.DataKeyWord = file.DataKeyWord
+	info.MetaData = file.MetaData
+	if err = send(model.FileChunk{Info: &info}); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	var expected int64
+	err = s.storage.GetFileChunks(ctx, file.FileID, func(seq int64, cipherChunk []byte) error {
+		if seq != expected {
+			return model.ErrFileChunkNotFound
+		}
+		expected++
+		chunk, err := utils.ChunkDecipher(cipherChunk, dataKey, file.FileID, seq, s.log)
+		if err != nil {
+			return err
+		}
+		hash.Write(chunk)
+		return send(model.FileChunk{Data: chunk})
+	})
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	if !bytes.Equal(hash.Sum(nil), info.Checksum) {
+		err = model.ErrChecksumMismatch
+		s.log.Error(err.Error())
+		return err
+	}
+	return nil
+}
+
+// invalidFile оборачивает ошибку проверки загружаемого файла
+func invalidFile(err error) error {
+	return fmt.Errorf("%w: %w", model.ErrInvalidFile, err)
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/server/service/mocks"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// fileStream возвращает функцию, которая по очереди отдает сообщения
+// потока загрузки, а затем io.EOF
+func fileStream(chunks []model.FileChunk) func() (model.FileChunk, error) {
+	return func() (model.FileChunk, error) {
+		if len(chunks) == 0 {
+			return model.FileChunk{}, io.EOF
+		}
+		chunk := chunks[0]
+		chunks = chunks[1:]
+		return chunk, nil
+	}
+}
+
+func TestServiceUploadFile(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	content := bytes.Repeat([]byte("little gopher "), 1000)
+	checksum := sha256.Sum256(content)
+	info := &model.FileInfo{
+		DataKeyWord: "file1",
+		FileName:    "gopher.txt",
+		MetaData:    "metadata1",
+		Size:        int64(len(content)),
+	}
+
+	tests := []struct {
+		name    string
+		chunks  []model.FileChunk
+		wantErr error
+	}{
+		{
+			name: "Успешная загрузка и скачивание файла",
+			chunks: []model.FileChunk{
+				{Info: info},
+				{Data: content[:model.FileChunkSize/8]},
+				{Data: content[model.FileChunkSize/8:]},
+				{Checksum: checksum[:]},
+			},
+		},
+		{
+			name: "Контрольная сумма не совпадает",
+			chunks: []model.FileChunk{
+				{Info: info},
+				{Data: content},
+				{Checksum: make([]byte, sha256.Size)},
+			},
+			wantErr: model.ErrChecksumMismatch,
+		},
+		{
+			name: "Размер не совпадает с заявленным",
+			chunks: []model.FileChunk{
+				{Info: info},
+				{Data: content[1:]},
+				{Checksum: checksum[:]},
+			},
+			wantErr: model.ErrFileSizeMismatch,
+		},
+		{
+			name: "Поток оборван до контрольной суммы",
+			chunks: []model.FileChunk{
+				{Info: info},
+				{Data: content},
+			},
+			wantErr: model.ErrChecksumRequired,
+		},
+		{
+			name: "Поток без описания файла",
+			chunks: []model.FileChunk{
+				{Data: content},
+			},
+			wantErr: model.ErrFileInfoRequired,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mocks.Storer)
+			s := &service{
+				storage: mockStorage,
+				log:     log,
+				config:  model.Config{SecretPassword: secretPassword},
+			}
+			ctx := initContext(true, "user1", log, secretPassword)
+			_, wrappedKey := newDataKey(t, secretPassword, log)
+			mockStorage.On("GetUserKey", ctx, "user1").Return(wrappedKey, nil)
+			mockStorage.On("GetVaultCheck", ctx, "user1").Return(nil, nil).Maybe()
+			mockStorage.On("GetData", ctx, "user1", "file1").
+				Return(nil, model.ErrNoRowsSelected).Once()
+
+			var fileID string
+			stored := make(map[int64][]byte)
+			mockStorage.On("CreateFileUpload", ctx, "user1", mock.MatchedBy(func(id string) bool {
+				fileID = id
+				return id != ""
+			}), mock.AnythingOfType("time.Time")).Return(nil)
+			mockStorage.On("AddFileChunk", ctx, mock.AnythingOfType("string"),
+				mock.AnythingOfType("int64"), mock.AnythingOfType("[]uint8")).
+				Run(func(args mock.Arguments) {
+					stored[args.Get(2).(int64)] = args.Get(3).([]byte)
+				}).Return(nil)
+			mockStorage.On("DeleteFileUpload", mock.Anything, mock.AnythingOfType("string")).
+				Return(nil)
+			var fileData model.DataBlock
+			mockStorage.On("CompleteFileUpload", ctx, mock.MatchedBy(func(data model.DataBlock) bool {
+				fileData = data
+				return data.DataType == model.DataTypeFile && data.FileID == fileID
+			})).Return(nil)
+
+			got, err := s.UploadFile(ctx, fileStream(tt.chunks))
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, model.ErrInvalidFile)
+				assert.ErrorIs(t, err, tt.wantErr)
+				mockStorage.AssertNotCalled(t, "CompleteFileUpload", mock.Anything, mock.Anything)
+				if fileID != "" {
+					// незавершенная загрузка удаляется вместе с частями
+					mockStorage.AssertCalled(t, "DeleteFileUpload", mock.Anything, fileID)
+				}
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, checksum[:], got.Checksum)
+			mockStorage.AssertNotCalled(t, "DeleteFileUpload", mock.Anything, mock.Anything)
+			// части хранятся только в зашифрованном виде
+			for _, cipherChunk := range stored {
+				assert.False(t, bytes.Contains(cipherChunk, []byte("little gopher")))
+			}
+
+			fileData.Login = ""
+			mockStorage.On("GetData", ctx, "user1", "file1").Return([]model.DataBlock{fileData}, nil)
+			mockStorage.On("GetFileChunks", ctx, fileID, mock.Anything).
+				Run(func(args mock.Arguments) {
+					fn := args.Get(2).(func(int64, []byte) error)
+					for seq := int64(0); seq < int64(len(stored)); seq++ {
+						require.NoError(t, fn(seq, stored[seq]))
+					}
+				}).Return(nil)
+
+			var downloaded []byte
+			var downloadedInfo *model.FileInfo
+			err = s.DownloadFile(ctx, "file1", func(chunk model.FileChunk) error {
+				if chunk.Info != nil {
+					downloadedInfo = chunk.Info
+				}
+				downloaded = append(downloaded, chunk.Data...)
+				return nil
+			})
+			require.NoError(t, err)
+			require.NotNil(t, downloadedInfo)
+			assert.Equal(t, info.FileName, downloadedInfo.FileName)
+			assert.Equal(t, info.MetaData, downloadedInfo.MetaData)
+			assert.Equal(t, content, downloaded)
+		})
+	}
+}
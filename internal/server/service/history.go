@@ -0,0 +1,103 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+// GetHistory возвращает расшифрованные прежние версии записи, начиная с последней.
+// Версии, которые не удалось расшифровать, пропускаются и попадают в журнал
+func (s *service) GetHistory(ctx context.Context, dataKeyWord string) ([]model.Revision, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := s.storage.GetHistory(ctx, login, dataKeyWord)
+	if err != nil || len(revisions) == 0 {
+		return nil, err
+	}
+	dataKey, err := s.dataKey(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
+	opened := make([]model.Revision, 0, len(revisions))
+	for _, revision := range revisions {
+		if revision.Data, err = s.openRecord(revision.Data, dataKey); err != nil {
+			s.log.WithFields(logrus.Fields{
+				"login":    login,
+				"key":      dataKeyWord,
+				"revision": revision.ID,
+			}).Error("Не удалось расшифровать версию записи: " + err.Error())
+			continue
+		}
+		opened = append(opened, revision)
+	}
+	return opened, nil
+}
+
+// RestoreRevision заменяет запись прежней версией и возвращает новую версию записи.
+// expectedVersion - текущая версия записи, которую видел пользователь,
+// 0 - запись удалена
+func (s *service) RestoreRevision(ctx context.Context, revisionID int64,
+	expectedVersion int64) (int64, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return 0, err
+	}
+	return s.storage.RestoreRevision(ctx, login, revisionID, expectedVersion,
+		utils.GetDeviceFromContext(ctx))
+}
+
+// PruneHistory удаляет прежние версии записей, вышедшие за пределы
+// config.History, и возвращает количество удаленных версий
+func (s *service) PruneHistory(ctx context.Context) (int64, error) {
+	retention := s.config.History
+	if retention.Revisions <= 0 && retention.Days <= 0 {
+		return 0, nil
+	}
+	var replacedBefore time.Time
+	if retention.Days > 0 {
+		replacedBefore = time.Now().AddDate(0, 0, -retention.Days)
+	}
+	return s.storage.PruneHistory(ctx, retention.Revisions, replacedBefore)
+}
+
+// RunHistoryPruning периодически очищает историю записей, пока не отменен ctx
+func (s *service) RunHistoryPruning(ctx context.Context) {
+	interval := model.DefaultHistoryPruneInterval
+	if s.config.History.PruneIntervalMinutes > 0 {
+		interval = time.Duration(s.config.History.PruneIntervalMinutes) * time.Minute
+	}
+	s.runPeriodically(ctx, interval, func(ctx context.Context) {
+		pruned, err := s.PruneHistory(ctx)
+		if err != nil {
+			s.log.Error("Не удалось очистить историю записей: " + err.Error())
+		} else if pruned > 0 {
+			s.log.WithFields(logrus.Fields{
+				"revisions": pruned,
+			}).Info("Удалили устаревшие версии записей")
+		}
+	})
+}
+
+// runPeriodically выполняет job сразу и затем раз в interval, пока не отменен ctx
+func (s *service) runPeriodically(ctx context.Context, interval time.Duration,
+	job func(ctx context.Context)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		job(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
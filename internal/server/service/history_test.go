@@ -0,0 +1,144 @@
+package service
+
+import (
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/server/service/mocks"
+	"keeper/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestServiceGetHistory(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	ctx := initContext(true, "user1", log, secretPassword)
+	dataKey, wrappedKey := newDataKey(t, secretPassword, log)
+	mockStorage.On("GetUserKey", ctx, "user1").Return(wrappedKey, nil)
+
+	cipherData, err := utils.DataKeyCipher("old data", dataKey, log)
+	require.NoError(t, err)
+	// версия, которую не удалось расшифровать, не мешает получить остальные
+	mockStorage.On("GetHistory", ctx, "user1", "key").Return([]model.Revision{{
+		ID: 5,
+		Data: model.DataBlock{
+			DataKeyWord: "key",
+			CipherData:  cipherData,
+			MetaData:    "meta",
+			Version:     2,
+		},
+		Device:  "laptop",
+		Deleted: true,
+	}, {
+		ID:   4,
+		Data: model.DataBlock{DataKeyWord: "key", CipherData: []byte("broken"), Version: 1},
+	}}, nil).Once()
+	mockStorage.On("GetHistory", ctx, "user1", "new").Return(nil, nil).Once()
+
+	revisions, err := s.GetHistory(ctx, "key")
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, "old data", revisions[0].Data.Data)
+	assert.Equal(t, int64(2), revisions[0].Data.Version)
+	assert.Equal(t, "laptop", revisions[0].Device)
+	assert.True(t, revisions[0].Deleted)
+
+	revisions, err = s.GetHistory(ctx, "new")
+	require.NoError(t, err)
+	assert.Empty(t, revisions)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestServiceRestoreRevision(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	// устройство клиента сохраняется вместе с восстановленной версией
+	tokenCtx := initContext(true, "user1", log, secretPassword)
+	md, _ := metadata.FromIncomingContext(tokenCtx)
+	md = md.Copy()
+	md.Set("device", "phone")
+	ctx := metadata.NewIncomingContext(tokenCtx, md)
+
+	mockStorage.On("RestoreRevision", ctx, "user1", int64(5), int64(3), "phone").
+		Return(int64(4), nil).Once()
+	mockStorage.On("RestoreRevision", ctx, "user1", int64(5), int64(2), "phone").
+		Return(int64(0), &model.VersionMismatchError{Current: 3}).Once()
+
+	version, err := s.RestoreRevision(ctx, 5, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), version)
+
+	_, err = s.RestoreRevision(ctx, 5, 2)
+	assert.ErrorIs(t, err, model.ErrVersionMismatch)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestServicePruneHistory(t *testing.T) {
+	ctx := initContext(false, "", nil, "")
+	tests := []struct {
+		name      string
+		retention model.HistoryRetention
+		wantKeep  int
+		wantAge   bool
+	}{
+		{
+			name:      "История без ограничений",
+			retention: model.HistoryRetention{},
+		},
+		{
+			name:      "Ограничение по количеству версий",
+			retention: model.HistoryRetention{Revisions: 10},
+			wantKeep:  10,
+		},
+		{
+			name:      "Ограничение по количеству версий и дням",
+			retention: model.HistoryRetention{Revisions: 10, Days: 30},
+			wantKeep:  10,
+			wantAge:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mocks.Storer)
+			s := &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+				config:  model.Config{History: tt.retention},
+			}
+			if tt.retention.Revisions > 0 || tt.retention.Days > 0 {
+				mockStorage.On("PruneHistory", ctx, tt.wantKeep,
+					mock.MatchedBy(func(replacedBefore time.Time) bool {
+						if !tt.wantAge {
+							return replacedBefore.IsZero()
+						}
+						want := time.Now().AddDate(0, 0, -tt.retention.Days)
+						return replacedBefore.Sub(want).Abs() < time.Minute
+					})).Return(int64(2), nil).Once()
+			}
+
+			_, err := s.PruneHistory(ctx)
+			require.NoError(t, err)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
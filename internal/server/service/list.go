@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+// pageToken - содержимое токена страницы: последняя запись предыдущей
+// страницы и параметры запроса, с которыми токен был выдан
+type pageToken struct {
+	DataType   string    `json:"type,omitempty"`
+	KeyPrefix  string    `json:"prefix,omitempty"`
+	SortBy     string    `json:"sort"`
+	Descending bool      `json:"desc,omitempty"`
+	Key        string    `json:"key"`
+	LastType   string    `json:"lastType,omitempty"`
+	CreatedAt  time.Time `json:"created"`
+	UpdatedAt  time.Time `json:"updated"`
+	Size       int64     `json:"size"`
+}
+
+// ListData возвращает страницу заголовков записей пользователя без расшифровки
+// содержимого и токен следующей страницы. Пустой токен означает, что
+// страница последняя
+func (s *service) ListData(ctx context.Context, query model.ListQuery) ([]model.DataHeader,
+	string, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if query.SortBy == "" {
+		query.SortBy = model.SortByKey
+	}
+	if !model.IsKnownSortField(query.SortBy) {
+		return nil, "", model.ErrInvalidSortField
+	}
+	switch {
+	case query.PageSize < 0 || query.PageSize > model.MaxPageSize:
+		return nil, "", model.ErrInvalidPageSize
+	case query.PageSize == 0:
+		query.PageSize = model.DefaultPageSize
+	}
+
+	var after *model.DataHeader
+	if query.PageToken != "" {
+		if after, err = decodePageToken(query); err != nil {
+			s.log.Error(err.Error())
+			return nil, "", err
+		}
+	}
+
+	// запрашиваем на одну запись больше, чтобы узнать, есть ли следующая страница
+	headers, err := s.storage.ListData(ctx, login, query, after, int(query.PageSize)+1)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(headers) <= int(query.PageSize) {
+		return headers, "", nil
+	}
+	headers = headers[:query.PageSize]
+	nextToken, err := encodePageToken(query, headers[len(headers)-1])
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, "", err
+	}
+	return headers, nextToken, nil
+}
+
+// encodePageToken упаковывает последнюю запись страницы в непрозрачный токен
+func encodePageToken(query model.ListQuery, last model.DataHeader) (string, error) {
+	raw, err := json.Marshal(pageToken{
+		DataType:   query.DataType,
+		KeyPrefix:  query.KeyPrefix,
+		SortBy:     query.SortBy,
+		Descending: query.Descending,
+		Key:        last.DataKeyWord,
+		LastType:   last.DataType,
+		CreatedAt:  last.CreatedAt,
+		UpdatedAt:  last.UpdatedAt,
+		Size:       last.Size,
+	})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// decodePageToken разбирает токен страницы. Токен действителен только
+// с теми же фильтрами и сортировкой, с которыми был выдан
+func decodePageToken(query model.ListQuery) (*model.DataHeader, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(query.PageToken)
+	if err != nil {
+		return nil, model.ErrInvalidPageToken
+	}
+	var token pageToken
+	if err = json.Unmarshal(raw, &token); err != nil {
+		return nil, model.ErrInvalidPageToken
+	}
+	if token.DataType != query.DataType || token.KeyPrefix != query.KeyPrefix ||
+		token.SortBy != query.SortBy || token.Descending != query.Descending {
+		return nil, model.ErrInvalidPageToken
+	}
+	return &model.DataHeader{
+		DataKeyWord: token.Key,
+		DataType:    token.LastType,
+		CreatedAt:   token.CreatedAt,
+		UpdatedAt:   token.UpdatedAt,
+		Size:        token.Size,
+	}, nil
+}
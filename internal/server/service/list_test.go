@@ -0,0 +1,106 @@
+package service
+
+import (
+	"fmt"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/server/service/mocks"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceListData(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	ctx := initContext(true, "user1", log, secretPassword)
+
+	var headers []model.DataHeader
+	updated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		headers = append(headers, model.DataHeader{
+			DataKeyWord: fmt.Sprintf("key%d", i),
+			DataType:    model.DataTypeCredentials,
+			UpdatedAt:   updated.Add(-time.Duration(i) * time.Hour),
+			Size:        int64(100 + i),
+		})
+	}
+	query := model.ListQuery{
+		DataType:   model.DataTypeCredentials,
+		KeyPrefix:  "key",
+		SortBy:     model.SortByUpdated,
+		Descending: true,
+		PageSize:   3,
+	}
+
+	// первая страница: storage возвращает на одну запись больше размера страницы
+	mockStorage.On("ListData", ctx, "user1", query, (*model.DataHeader)(nil), 4).
+		Return(headers[:4], nil).Once()
+	page, nextPageToken, err := s.ListData(ctx, query)
+	require.NoError(t, err)
+	assert.Equal(t, headers[:3], page)
+	require.NotEmpty(t, nextPageToken)
+
+	// вторая страница начинается после последней записи первой
+	secondQuery := query
+	secondQuery.PageToken = nextPageToken
+	mockStorage.On("ListData", ctx, "user1", secondQuery,
+		mock.MatchedBy(func(after *model.DataHeader) bool {
+			return after != nil && after.DataKeyWord == "key2" &&
+				after.UpdatedAt.Equal(headers[2].UpdatedAt) && after.Size == headers[2].Size
+		}), 4).Return(headers[3:], nil).Once()
+	page, nextPageToken, err = s.ListData(ctx, secondQuery)
+	require.NoError(t, err)
+	assert.Equal(t, headers[3:], page)
+	assert.Empty(t, nextPageToken)
+
+	tests := []struct {
+		name    string
+		query   model.ListQuery
+		wantErr error
+	}{
+		{
+			name:    "Сортировка по неизвестному полю",
+			query:   model.ListQuery{SortBy: "data"},
+			wantErr: model.ErrInvalidSortField,
+		},
+		{
+			name:    "Слишком большая страница",
+			query:   model.ListQuery{PageSize: model.MaxPageSize + 1},
+			wantErr: model.ErrInvalidPageSize,
+		},
+		{
+			name:    "Испорченный токен страницы",
+			query:   model.ListQuery{PageToken: "not a token"},
+			wantErr: model.ErrInvalidPageToken,
+		},
+		{
+			name: "Токен страницы с другой сортировкой",
+			query: model.ListQuery{
+				DataType:   model.DataTypeCredentials,
+				KeyPrefix:  "key",
+				SortBy:     model.SortBySize,
+				Descending: true,
+				PageToken:  secondQuery.PageToken,
+			},
+			wantErr: model.ErrInvalidPageToken,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := s.ListData(ctx, tt.query)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
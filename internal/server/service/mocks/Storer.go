@@ -7,6 +7,8 @@ import (
 	model "keeper/internal/model"
 
 	mock "github.com/stretchr/testify/mock"
+
+	time "time"
 )
 
 // Storer is an autogenerated mock type for the Storer type
@@ -14,13 +16,111 @@ type Storer struct {
 	mock.Mock
 }
 
-// AddUser provides a mock function with given fields: ctx, login, password
-func (_m *Storer) AddUser(ctx context.Context, login string, password [32]byte) error {
-	ret := _m.Called(ctx, login, password)
+// AddChallenge provides a mock function with given fields: ctx, challengeHash, login, expiresAt
+func (_m *Storer) AddChallenge(ctx context.Context, challengeHash string, login string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, challengeHash, login, expiresAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) error); ok {
+		r0 = rf(ctx, challengeHash, login, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddConflict provides a mock function with given fields: ctx, login, conflict
+func (_m *Storer) AddConflict(ctx context.Context, login string, conflict model.Conflict) error {
+	ret := _m.Called(ctx, login, conflict)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.Conflict) error); ok {
+		r0 = rf(ctx, login, conflict)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddFileChunk provides a mock function with given fields: ctx, fileID, seq, cipherChunk
+func (_m *Storer) AddFileChunk(ctx context.Context, fileID string, seq int64, cipherChunk []byte) error {
+	ret := _m.Called(ctx, fileID, seq, cipherChunk)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, []byte) error); ok {
+		r0 = rf(ctx, fileID, seq, cipherChunk)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddRefreshToken provides a mock function with given fields: ctx, tokenHash, refreshToken
+func (_m *Storer) AddRefreshToken(ctx context.Context, tokenHash string, refreshToken model.RefreshToken) error {
+	ret := _m.Called(ctx, tokenHash, refreshToken)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.RefreshToken) error); ok {
+		r0 = rf(ctx, tokenHash, refreshToken)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddSession provides a mock function with given fields: ctx, login, sessionID, expiresAt
+func (_m *Storer) AddSession(ctx context.Context, login string, sessionID string, expiresAt time.Time) error {
+	ret := _m.Called(ctx, login, sessionID, expiresAt)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) error); ok {
+		r0 = rf(ctx, login, sessionID, expiresAt)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddUser provides a mock function with given fields: ctx, login, passwordHash
+func (_m *Storer) AddUser(ctx context.Context, login string, passwordHash string) error {
+	ret := _m.Called(ctx, login, passwordHash)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, login, passwordHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// AddUserKey provides a mock function with given fields: ctx, login, wrappedKey
+func (_m *Storer) AddUserKey(ctx context.Context, login string, wrappedKey []byte) error {
+	ret := _m.Called(ctx, login, wrappedKey)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) error); ok {
+		r0 = rf(ctx, login, wrappedKey)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ApplyOperation provides a mock function with given fields: ctx, login, operation
+func (_m *Storer) ApplyOperation(ctx context.Context, login string, operation model.SyncOperation) error {
+	ret := _m.Called(ctx, login, operation)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, [32]byte) error); ok {
-		r0 = rf(ctx, login, password)
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.SyncOperation) error); ok {
+		r0 = rf(ctx, login, operation)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -29,7 +129,31 @@ func (_m *Storer) AddUser(ctx context.Context, login string, password [32]byte)
 }
 
 // ChangeData provides a mock function with given fields: ctx, data
-func (_m *Storer) ChangeData(ctx context.Context, data model.DataBlock) error {
+func (_m *Storer) ChangeData(ctx context.Context, data model.DataBlock) (int64, error) {
+	ret := _m.Called(ctx, data)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.DataBlock) (int64, error)); ok {
+		return rf(ctx, data)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.DataBlock) int64); ok {
+		r0 = rf(ctx, data)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.DataBlock) error); ok {
+		r1 = rf(ctx, data)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CompleteFileUpload provides a mock function with given fields: ctx, data
+func (_m *Storer) CompleteFileUpload(ctx context.Context, data model.DataBlock) error {
 	ret := _m.Called(ctx, data)
 
 	var r0 error
@@ -42,13 +166,65 @@ func (_m *Storer) ChangeData(ctx context.Context, data model.DataBlock) error {
 	return r0
 }
 
-// CheckUserAuth provides a mock function with given fields: ctx, login, password
-func (_m *Storer) CheckUserAuth(ctx context.Context, login string, password string) error {
-	ret := _m.Called(ctx, login, password)
+// ConfirmTOTP provides a mock function with given fields: ctx, login, step, recoveryCodeHashes
+func (_m *Storer) ConfirmTOTP(ctx context.Context, login string, step int64, recoveryCodeHashes []string) error {
+	ret := _m.Called(ctx, login, step, recoveryCodeHashes)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
-		r0 = rf(ctx, login, password)
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, []string) error); ok {
+		r0 = rf(ctx, login, step, recoveryCodeHashes)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// CountChallengeAttempt provides a mock function with given fields: ctx, challengeHash
+func (_m *Storer) CountChallengeAttempt(ctx context.Context, challengeHash string) (model.AuthChallenge, error) {
+	ret := _m.Called(ctx, challengeHash)
+
+	var r0 model.AuthChallenge
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.AuthChallenge, error)); ok {
+		return rf(ctx, challengeHash)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.AuthChallenge); ok {
+		r0 = rf(ctx, challengeHash)
+	} else {
+		r0 = ret.Get(0).(model.AuthChallenge)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, challengeHash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CreateFileUpload provides a mock function with given fields: ctx, login, fileID, staleBefore
+func (_m *Storer) CreateFileUpload(ctx context.Context, login string, fileID string, staleBefore time.Time) error {
+	ret := _m.Called(ctx, login, fileID, staleBefore)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Time) error); ok {
+		r0 = rf(ctx, login, fileID, staleBefore)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteChallenge provides a mock function with given fields: ctx, challengeHash
+func (_m *Storer) DeleteChallenge(ctx context.Context, challengeHash string) error {
+	ret := _m.Called(ctx, challengeHash)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, challengeHash)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -70,6 +246,72 @@ func (_m *Storer) DeleteData(ctx context.Context, login string, dataKeyWord stri
 	return r0
 }
 
+// DeleteFileUpload provides a mock function with given fields: ctx, fileID
+func (_m *Storer) DeleteFileUpload(ctx context.Context, fileID string) error {
+	ret := _m.Called(ctx, fileID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, fileID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetChanges provides a mock function with given fields: ctx, login, after, limit
+func (_m *Storer) GetChanges(ctx context.Context, login string, after model.ChangePosition, limit int) ([]model.DataChange, error) {
+	ret := _m.Called(ctx, login, after, limit)
+
+	var r0 []model.DataChange
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ChangePosition, int) ([]model.DataChange, error)); ok {
+		return rf(ctx, login, after, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ChangePosition, int) []model.DataChange); ok {
+		r0 = rf(ctx, login, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DataChange)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.ChangePosition, int) error); ok {
+		r1 = rf(ctx, login, after, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetConflicts provides a mock function with given fields: ctx, login, dataKeyWord
+func (_m *Storer) GetConflicts(ctx context.Context, login string, dataKeyWord string) ([]model.Conflict, error) {
+	ret := _m.Called(ctx, login, dataKeyWord)
+
+	var r0 []model.Conflict
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) ([]model.Conflict, error)); ok {
+		return rf(ctx, login, dataKeyWord)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []model.Conflict); ok {
+		r0 = rf(ctx, login, dataKeyWord)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Conflict)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, login, dataKeyWord)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetData provides a mock function with given fields: ctx, login, dataKeyWord
 func (_m *Storer) GetData(ctx context.Context, login string, dataKeyWord string) ([]model.DataBlock, error) {
 	ret := _m.Called(ctx, login, dataKeyWord)
@@ -96,6 +338,170 @@ func (_m *Storer) GetData(ctx context.Context, login string, dataKeyWord string)
 	return r0, r1
 }
 
+// GetFileChunks provides a mock function with given fields: ctx, fileID, fn
+func (_m *Storer) GetFileChunks(ctx context.Context, fileID string, fn func(seq int64, cipherChunk []byte) error) error {
+	ret := _m.Called(ctx, fileID, fn)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, func(seq int64, cipherChunk []byte) error) error); ok {
+		r0 = rf(ctx, fileID, fn)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetHistory provides a mock function with given fields: ctx, login, dataKeyWord
+func (_m *Storer) GetHistory(ctx context.Context, login string, dataKeyWord string) ([]model.Revision, error) {
+	ret := _m.Called(ctx, login, dataKeyWord)
+
+	var r0 []model.Revision
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) ([]model.Revision, error)); ok {
+		return rf(ctx, login, dataKeyWord)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) []model.Revision); ok {
+		r0 = rf(ctx, login, dataKeyWord)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.Revision)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, login, dataKeyWord)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetKeyRotation provides a mock function with given fields: ctx, rotationID
+func (_m *Storer) GetKeyRotation(ctx context.Context, rotationID string) (model.KeyRotation, error) {
+	ret := _m.Called(ctx, rotationID)
+
+	var r0 model.KeyRotation
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.KeyRotation, error)); ok {
+		return rf(ctx, rotationID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.KeyRotation); ok {
+		r0 = rf(ctx, rotationID)
+	} else {
+		r0 = ret.Get(0).(model.KeyRotation)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, rotationID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetPasswordHash provides a mock function with given fields: ctx, login
+func (_m *Storer) GetPasswordHash(ctx context.Context, login string) (string, error) {
+	ret := _m.Called(ctx, login)
+
+	var r0 string
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (string, error)); ok {
+		return rf(ctx, login)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) string); ok {
+		r0 = rf(ctx, login)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, login)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetTOTP provides a mock function with given fields: ctx, login
+func (_m *Storer) GetTOTP(ctx context.Context, login string) (model.TOTP, error) {
+	ret := _m.Called(ctx, login)
+
+	var r0 model.TOTP
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.TOTP, error)); ok {
+		return rf(ctx, login)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.TOTP); ok {
+		r0 = rf(ctx, login)
+	} else {
+		r0 = ret.Get(0).(model.TOTP)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, login)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetUserKey provides a mock function with given fields: ctx, login
+func (_m *Storer) GetUserKey(ctx context.Context, login string) ([]byte, error) {
+	ret := _m.Called(ctx, login)
+
+	var r0 []byte
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]byte, error)); ok {
+		return rf(ctx, login)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []byte); ok {
+		r0 = rf(ctx, login)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, login)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetVaultCheck provides a mock function with given fields: ctx, login
+func (_m *Storer) GetVaultCheck(ctx context.Context, login string) ([]byte, error) {
+	ret := _m.Called(ctx, login)
+
+	var r0 []byte
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]byte, error)); ok {
+		return rf(ctx, login)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []byte); ok {
+		r0 = rf(ctx, login)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]byte)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, login)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // InsertData provides a mock function with given fields: ctx, data
 func (_m *Storer) InsertData(ctx context.Context, data model.DataBlock) error {
 	ret := _m.Called(ctx, data)
@@ -110,6 +516,474 @@ func (_m *Storer) InsertData(ctx context.Context, data model.DataBlock) error {
 	return r0
 }
 
+// IsSessionActive provides a mock function with given fields: ctx, sessionID
+func (_m *Storer) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	ret := _m.Called(ctx, sessionID)
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (bool, error)); ok {
+		return rf(ctx, sessionID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) bool); ok {
+		r0 = rf(ctx, sessionID)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, sessionID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListData provides a mock function with given fields: ctx, login, query, after, limit
+func (_m *Storer) ListData(ctx context.Context, login string, query model.ListQuery, after *model.DataHeader, limit int) ([]model.DataHeader, error) {
+	ret := _m.Called(ctx, login, query, after, limit)
+
+	var r0 []model.DataHeader
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ListQuery, *model.DataHeader, int) ([]model.DataHeader, error)); ok {
+		return rf(ctx, login, query, after, limit)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ListQuery, *model.DataHeader, int) []model.DataHeader); ok {
+		r0 = rf(ctx, login, query, after, limit)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.DataHeader)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.ListQuery, *model.DataHeader, int) error); ok {
+		r1 = rf(ctx, login, query, after, limit)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ListTrash provides a mock function with given fields: ctx, login
+func (_m *Storer) ListTrash(ctx context.Context, login string) ([]model.TrashedRecord, error) {
+	ret := _m.Called(ctx, login)
+
+	var r0 []model.TrashedRecord
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) ([]model.TrashedRecord, error)); ok {
+		return rf(ctx, login)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) []model.TrashedRecord); ok {
+		r0 = rf(ctx, login)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]model.TrashedRecord)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, login)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PruneHistory provides a mock function with given fields: ctx, keep, replacedBefore
+func (_m *Storer) PruneHistory(ctx context.Context, keep int, replacedBefore time.Time) (int64, error) {
+	ret := _m.Called(ctx, keep, replacedBefore)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) (int64, error)); ok {
+		return rf(ctx, keep, replacedBefore)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, int, time.Time) int64); ok {
+		r0 = rf(ctx, keep, replacedBefore)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, int, time.Time) error); ok {
+		r1 = rf(ctx, keep, replacedBefore)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// PurgeTrash provides a mock function with given fields: ctx, login, deletedBefore
+func (_m *Storer) PurgeTrash(ctx context.Context, login string, deletedBefore time.Time) (int64, error) {
+	ret := _m.Called(ctx, login, deletedBefore)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) (int64, error)); ok {
+		return rf(ctx, login, deletedBefore)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) int64); ok {
+		r0 = rf(ctx, login, deletedBefore)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, login, deletedBefore)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ResolveConflict provides a mock function with given fields: ctx, login, resolution
+func (_m *Storer) ResolveConflict(ctx context.Context, login string, resolution model.ConflictResolution) (int64, error) {
+	ret := _m.Called(ctx, login, resolution)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ConflictResolution) (int64, error)); ok {
+		return rf(ctx, login, resolution)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, model.ConflictResolution) int64); ok {
+		r0 = rf(ctx, login, resolution)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, model.ConflictResolution) error); ok {
+		r1 = rf(ctx, login, resolution)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreFromTrash provides a mock function with given fields: ctx, login, trashID, device
+func (_m *Storer) RestoreFromTrash(ctx context.Context, login string, trashID int64, device string) (int64, error) {
+	ret := _m.Called(ctx, login, trashID, device)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, string) (int64, error)); ok {
+		return rf(ctx, login, trashID, device)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, string) int64); ok {
+		r0 = rf(ctx, login, trashID, device)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64, string) error); ok {
+		r1 = rf(ctx, login, trashID, device)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RestoreRevision provides a mock function with given fields: ctx, login, revisionID, expectedVersion, device
+func (_m *Storer) RestoreRevision(ctx context.Context, login string, revisionID int64, expectedVersion int64, device string) (int64, error) {
+	ret := _m.Called(ctx, login, revisionID, expectedVersion, device)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, int64, string) (int64, error)); ok {
+		return rf(ctx, login, revisionID, expectedVersion, device)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64, int64, string) int64); ok {
+		r0 = rf(ctx, login, revisionID, expectedVersion, device)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64, int64, string) error); ok {
+		r1 = rf(ctx, login, revisionID, expectedVersion, device)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RevokeAllSessions provides a mock function with given fields: ctx, login
+func (_m *Storer) RevokeAllSessions(ctx context.Context, login string) (int64, error) {
+	ret := _m.Called(ctx, login)
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (int64, error)); ok {
+		return rf(ctx, login)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) int64); ok {
+		r0 = rf(ctx, login)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, login)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RevokeSession provides a mock function with given fields: ctx, login, sessionID
+func (_m *Storer) RevokeSession(ctx context.Context, login string, sessionID string) error {
+	ret := _m.Called(ctx, login, sessionID)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, login, sessionID)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RotateConflictsBatch provides a mock function with given fields: ctx, rotation, batchSize, reseal
+func (_m *Storer) RotateConflictsBatch(ctx context.Context, rotation model.KeyRotation, batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+	ret := _m.Called(ctx, rotation, batchSize, reseal)
+
+	var r0 model.KeyRotation
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error)); ok {
+		return rf(ctx, rotation, batchSize, reseal)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) model.KeyRotation); ok {
+		r0 = rf(ctx, rotation, batchSize, reseal)
+	} else {
+		r0 = ret.Get(0).(model.KeyRotation)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) error); ok {
+		r1 = rf(ctx, rotation, batchSize, reseal)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RotateDataBatch provides a mock function with given fields: ctx, rotation, batchSize, reseal
+func (_m *Storer) RotateDataBatch(ctx context.Context, rotation model.KeyRotation, batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+	ret := _m.Called(ctx, rotation, batchSize, reseal)
+
+	var r0 model.KeyRotation
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error)); ok {
+		return rf(ctx, rotation, batchSize, reseal)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) model.KeyRotation); ok {
+		r0 = rf(ctx, rotation, batchSize, reseal)
+	} else {
+		r0 = ret.Get(0).(model.KeyRotation)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) error); ok {
+		r1 = rf(ctx, rotation, batchSize, reseal)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RotateHistoryBatch provides a mock function with given fields: ctx, rotation, batchSize, reseal
+func (_m *Storer) RotateHistoryBatch(ctx context.Context, rotation model.KeyRotation, batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+	ret := _m.Called(ctx, rotation, batchSize, reseal)
+
+	var r0 model.KeyRotation
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error)); ok {
+		return rf(ctx, rotation, batchSize, reseal)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) model.KeyRotation); ok {
+		r0 = rf(ctx, rotation, batchSize, reseal)
+	} else {
+		r0 = ret.Get(0).(model.KeyRotation)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) error); ok {
+		r1 = rf(ctx, rotation, batchSize, reseal)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RotateTrashBatch provides a mock function with given fields: ctx, rotation, batchSize, reseal
+func (_m *Storer) RotateTrashBatch(ctx context.Context, rotation model.KeyRotation, batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+	ret := _m.Called(ctx, rotation, batchSize, reseal)
+
+	var r0 model.KeyRotation
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error)); ok {
+		return rf(ctx, rotation, batchSize, reseal)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) model.KeyRotation); ok {
+		r0 = rf(ctx, rotation, batchSize, reseal)
+	} else {
+		r0 = ret.Get(0).(model.KeyRotation)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.KeyRotation, int, func(data model.DataBlock) ([]byte, bool, error)) error); ok {
+		r1 = rf(ctx, rotation, batchSize, reseal)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RotateUserKeysBatch provides a mock function with given fields: ctx, rotation, batchSize, rewrap
+func (_m *Storer) RotateUserKeysBatch(ctx context.Context, rotation model.KeyRotation, batchSize int, rewrap func(login string, wrappedKey []byte) ([]byte, error)) (model.KeyRotation, error) {
+	ret := _m.Called(ctx, rotation, batchSize, rewrap)
+
+	var r0 model.KeyRotation
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(login string, wrappedKey []byte) ([]byte, error)) (model.KeyRotation, error)); ok {
+		return rf(ctx, rotation, batchSize, rewrap)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, model.KeyRotation, int, func(login string, wrappedKey []byte) ([]byte, error)) model.KeyRotation); ok {
+		r0 = rf(ctx, rotation, batchSize, rewrap)
+	} else {
+		r0 = ret.Get(0).(model.KeyRotation)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, model.KeyRotation, int, func(login string, wrappedKey []byte) ([]byte, error)) error); ok {
+		r1 = rf(ctx, rotation, batchSize, rewrap)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SaveTOTP provides a mock function with given fields: ctx, login, cipherSecret
+func (_m *Storer) SaveTOTP(ctx context.Context, login string, cipherSecret []byte) error {
+	ret := _m.Called(ctx, login, cipherSecret)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) error); ok {
+		r0 = rf(ctx, login, cipherSecret)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetVaultCheck provides a mock function with given fields: ctx, login, check
+func (_m *Storer) SetVaultCheck(ctx context.Context, login string, check []byte) error {
+	ret := _m.Called(ctx, login, check)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, []byte) error); ok {
+		r0 = rf(ctx, login, check)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// TakeRefreshToken provides a mock function with given fields: ctx, tokenHash
+func (_m *Storer) TakeRefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken, error) {
+	ret := _m.Called(ctx, tokenHash)
+
+	var r0 model.RefreshToken
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (model.RefreshToken, error)); ok {
+		return rf(ctx, tokenHash)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) model.RefreshToken); ok {
+		r0 = rf(ctx, tokenHash)
+	} else {
+		r0 = ret.Get(0).(model.RefreshToken)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, tokenHash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UpdatePasswordHash provides a mock function with given fields: ctx, login, passwordHash
+func (_m *Storer) UpdatePasswordHash(ctx context.Context, login string, passwordHash string) error {
+	ret := _m.Called(ctx, login, passwordHash)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, login, passwordHash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UseRecoveryCode provides a mock function with given fields: ctx, login, codeHash
+func (_m *Storer) UseRecoveryCode(ctx context.Context, login string, codeHash string) (bool, error) {
+	ret := _m.Called(ctx, login, codeHash)
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (bool, error)); ok {
+		return rf(ctx, login, codeHash)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) bool); ok {
+		r0 = rf(ctx, login, codeHash)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, login, codeHash)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// UseTOTPStep provides a mock function with given fields: ctx, login, step
+func (_m *Storer) UseTOTPStep(ctx context.Context, login string, step int64) (bool, error) {
+	ret := _m.Called(ctx, login, step)
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) (bool, error)); ok {
+		return rf(ctx, login, step)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, int64) bool); ok {
+		r0 = rf(ctx, login, step)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, int64) error); ok {
+		r1 = rf(ctx, login, step)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewStorer creates a new instance of Storer. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewStorer(t interface {
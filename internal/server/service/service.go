@@ -4,8 +4,12 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"keeper/internal/model"
 	"keeper/internal/utils"
+	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 )
@@ -14,12 +18,62 @@ import (
 //
 //go:generate mockery --name Storer
 type Storer interface {
-	AddUser(ctx context.Context, login string, password [32]byte) error
-	CheckUserAuth(ctx context.Context, login string, password string) error
+	AddUser(ctx context.Context, login string, passwordHash string) error
+	GetPasswordHash(ctx context.Context, login string) (string, error)
+	UpdatePasswordHash(ctx context.Context, login string, passwordHash string) error
+	AddRefreshToken(ctx context.Context, tokenHash string, refreshToken model.RefreshToken) error
+	TakeRefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken, error)
+	AddSession(ctx context.Context, login string, sessionID string, expiresAt time.Time) error
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+	RevokeSession(ctx context.Context, login string, sessionID string) error
+	RevokeAllSessions(ctx context.Context, login string) (int64, error)
+	SaveTOTP(ctx context.Context, login string, cipherSecret []byte) error
+	GetTOTP(ctx context.Context, login string) (model.TOTP, error)
+	ConfirmTOTP(ctx context.Context, login string, step int64, recoveryCodeHashes []string) error
+	UseTOTPStep(ctx context.Context, login string, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, login string, codeHash string) (bool, error)
+	AddChallenge(ctx context.Context, challengeHash string, login string, expiresAt time.Time) error
+	CountChallengeAttempt(ctx context.Context, challengeHash string) (model.AuthChallenge, error)
+	DeleteChallenge(ctx context.Context, challengeHash string) error
+	AddUserKey(ctx context.Context, login string, wrappedKey []byte) error
+	GetUserKey(ctx context.Context, login string) ([]byte, error)
+	SetVaultCheck(ctx context.Context, login string, check []byte) error
+	GetVaultCheck(ctx context.Context, login string) ([]byte, error)
 	InsertData(ctx context.Context, data model.DataBlock) error
 	GetData(ctx context.Context, login string, dataKeyWord string) ([]model.DataBlock, error)
-	ChangeData(ctx context.Context, data model.DataBlock) error
+	ChangeData(ctx context.Context, data model.DataBlock) (int64, error)
 	DeleteData(ctx context.Context, login string, dataKeyWord string) error
+	ApplyOperation(ctx context.Context, login string, operation model.SyncOperation) error
+	AddConflict(ctx context.Context, login string, conflict model.Conflict) error
+	GetConflicts(ctx context.Context, login string, dataKeyWord string) ([]model.Conflict, error)
+	ResolveConflict(ctx context.Context, login string, resolution model.ConflictResolution) (int64, error)
+	ListTrash(ctx context.Context, login string) ([]model.TrashedRecord, error)
+	RestoreFromTrash(ctx context.Context, login string, trashID int64, device string) (int64, error)
+	PurgeTrash(ctx context.Context, login string, deletedBefore time.Time) (int64, error)
+	GetHistory(ctx context.Context, login string, dataKeyWord string) ([]model.Revision, error)
+	RestoreRevision(ctx context.Context, login string, revisionID int64, expectedVersion int64,
+		device string) (int64, error)
+	PruneHistory(ctx context.Context, keep int, replacedBefore time.Time) (int64, error)
+	GetChanges(ctx context.Context, login string, after model.ChangePosition, limit int) ([]model.DataChange, error)
+	ListData(ctx context.Context, login string, query model.ListQuery, after *model.DataHeader,
+		limit int) ([]model.DataHeader, error)
+	CreateFileUpload(ctx context.Context, login string, fileID string, staleBefore time.Time) error
+	AddFileChunk(ctx context.Context, fileID string, seq int64, cipherChunk []byte) error
+	CompleteFileUpload(ctx context.Context, data model.DataBlock) error
+	DeleteFileUpload(ctx context.Context, fileID string) error
+	GetFileChunks(ctx context.Context, fileID string,
+		fn func(seq int64, cipherChunk []byte) error) error
+	GetKeyRotation(ctx context.Context, rotationID string) (model.KeyRotation, error)
+	RotateUserKeysBatch(ctx context.Context, rotation model.KeyRotation, batchSize int,
+		rewrap func(login string, wrappedKey []byte) ([]byte, error)) (model.KeyRotation, error)
+	RotateDataBatch(ctx context.Context, rotation model.KeyRotation, batchSize int,
+		reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error)
+	RotateHistoryBatch(ctx context.Context, rotation model.KeyRotation, batchSize int,
+		reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error)
+	RotateTrashBatch(ctx context.Context, rotation model.KeyRotation, batchSize int,
+		reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error)
+	RotateConflictsBatch(ctx context.Context, rotation model.KeyRotation, batchSize int,
+		reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error)
 }
 
 // service - структура, реализующая методы пакета service
@@ -27,6 +81,11 @@ type service struct {
 	storage Storer
 	log     *logrus.Logger
 	config  model.Config
+	// sessions - кэш проверок сессий для интерсептора
+	sessions sessionCache
+	// dummyHash - хэш для сверки пароля при неизвестном логине
+	dummyHash     string
+	dummyHashOnce sync.Once
 }
 
 func NewService(ctx context.Context, storage Storer,
@@ -38,55 +97,365 @@ func NewService(ctx context.Context, storage Storer,
 	}
 }
 
-// UserRegister возвращает jwt токен для пользователя, если добавление в бд
+// dummyPasswordHash возвращает хэш фиктивного пароля с текущими параметрами,
+// хэш вычисляется один раз при первом обращении
+func (s *service) dummyPasswordHash() string {
+	s.dummyHashOnce.Do(func() {
+		hash, err := utils.PasswordHash("keeper-dummy-password", s.config.PasswordHash)
+		if err != nil {
+			s.log.Error(err.Error())
+			return
+		}
+		s.dummyHash = hash
+	})
+	return s.dummyHash
+}
+
+// UserRegister возвращает токены для пользователя, если добавление в бд
 // прошло успешно
 func (s *service) UserRegister(ctx context.Context, login string,
-	password string) (string, error) {
+	password string) (model.Tokens, error) {
 
-	// добавляем пользователя в бд
-	err := s.storage.AddUser(ctx, login, utils.PasswordHash(password))
+	passwordHash, err := utils.PasswordHash(password, s.config.PasswordHash)
 	if err != nil {
-		return "", err
+		s.log.Error(err.Error())
+		return model.Tokens{}, err
 	}
 
-	jwtString, err := utils.GenerateJWTToken(login, s.log, s.config.SecretPassword)
+	// добавляем пользователя в бд
+	err = s.storage.AddUser(ctx, login, passwordHash)
 	if err != nil {
-		return "", err
+		return model.Tokens{}, err
 	}
-	return jwtString, nil
+
+	// генерируем ключ данных пользователя
+	if _, err = s.createDataKey(ctx, login); err != nil {
+		return model.Tokens{}, err
+	}
+
+	return s.issueTokens(ctx, login)
 }
 
-// UserAuthentification проверят логин и пароль пользователя, возвращает jwt токен,
-// если все введено верно
+// UserAuthentification проверят логин и пароль пользователя, возвращает токены,
+// если все введено верно. Если у пользователя включен второй фактор,
+// вместо токенов возвращается challenge для CompleteSecondFactor
 func (s *service) UserAuthentification(ctx context.Context, login string,
-	password string) (string, error) {
+	password string) (model.Tokens, error) {
+
+	passwordHash, err := s.storage.GetPasswordHash(ctx, login)
+	if errors.Is(err, model.ErrUserNotFound) {
+		// сверяем пароль с фиктивным хэшем, чтобы ответ для неизвестного
+		// логина занимал столько же времени, сколько для неверного пароля
+		_, _, _ = utils.CheckPasswordHash(password, s.dummyPasswordHash(),
+			s.config.PasswordHash)
+		return model.Tokens{}, err
+	}
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	ok, needsRehash, err := utils.CheckPasswordHash(password, passwordHash,
+		s.config.PasswordHash)
+	if err != nil {
+		s.log.Error(err.Error())
+		return model.Tokens{}, err
+	}
+	if !ok {
+		err = model.ErrIncorrectPassword
+		s.log.Error(err.Error())
+		return model.Tokens{}, err
+	}
+	s.log.Debug("Аутентификация успешна")
+
+	// хэш в устаревшем формате или с прежними параметрами пересчитываем,
+	// ошибка пересчета не мешает входу
+	if needsRehash {
+		if err := s.rehashPassword(ctx, login, password); err != nil {
+			s.log.Error(err.Error())
+		}
+	}
+
+	return s.loginOrChallenge(ctx, login)
+}
+
+// RefreshToken обменивает токен обновления на новую пару токенов.
+// Использованный токен обновления становится недействительным,
+// сессия прежнего токена доступа завершается
+func (s *service) RefreshToken(ctx context.Context, refreshToken string) (model.Tokens, error) {
+	token, err := s.storage.TakeRefreshToken(ctx, utils.TokenHash(refreshToken))
+	if err != nil {
+		return model.Tokens{}, err
+	}
+	if time.Now().After(token.ExpiresAt) {
+		err = model.ErrRefreshTokenExpired
+		s.log.Error(err.Error())
+		return model.Tokens{}, err
+	}
+	if token.SessionID != "" {
+		if err = s.storage.RevokeSession(ctx, token.Login, token.SessionID); err != nil {
+			return model.Tokens{}, err
+		}
+		s.sessions.set(token.SessionID, token.Login, false)
+	}
+	return s.issueTokens(ctx, token.Login)
+}
+
+// issueTokens генерирует токен доступа, открывает для него сессию
+// и сохраняет новый токен обновления
+func (s *service) issueTokens(ctx context.Context, login string) (model.Tokens, error) {
+	jwtString, claims, err := utils.GenerateJWTToken(login, s.log, s.jwtKey())
+	if err != nil {
+		return model.Tokens{}, err
+	}
+	err = s.storage.AddSession(ctx, login, claims.Id, time.Unix(claims.ExpiresAt, 0))
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	refreshToken, err := utils.GenerateRandomToken()
+	if err != nil {
+		s.log.Error(err.Error())
+		return model.Tokens{}, err
+	}
+	err = s.storage.AddRefreshToken(ctx, utils.TokenHash(refreshToken),
+		model.RefreshToken{
+			Login:     login,
+			SessionID: claims.Id,
+			ExpiresAt: time.Now().Add(utils.RefreshTokenTTL),
+		})
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	return model.Tokens{
+		AccessToken:  jwtString,
+		RefreshToken: refreshToken,
+	}, nil
+}
 
-	if err := s.storage.CheckUserAuth(ctx, login, password); err != nil {
-		return "", err
+// jwtKey возвращает ключ подписи jwt токенов
+func (s *service) jwtKey() string {
+	if s.config.JWTSecret != "" {
+		return s.config.JWTSecret
 	}
+	return s.config.SecretPassword
+}
+
+// jwtKeys возвращает ключи проверки jwt токенов. Если отдельный ключ
+// подписи не задан, на время ротации секрета сервера действуют и токены,
+// подписанные прежним секретом
+func (s *service) jwtKeys() []string {
+	if s.config.JWTSecret == "" && s.config.PreviousSecretPassword != "" {
+		return []string{s.config.SecretPassword, s.config.PreviousSecretPassword}
+	}
+	return []string{s.jwtKey()}
+}
 
-	jwtString, err := utils.GenerateJWTToken(login, s.log, s.config.SecretPassword)
+// CheckSession проверяет jwt токен из контекста и то, что его сессия
+// не отозвана. Результат проверки сессии кэшируется
+func (s *service) CheckSession(ctx context.Context) error {
+	token, err := utils.GetTokenFromContext(ctx, s.jwtKeys()...)
 	if err != nil {
-		return "", err
+		s.log.Error(err.Error())
+		if errors.Is(err, model.ErrTokenNotFound) {
+			return err
+		}
+		return model.ErrNotValidToken
 	}
 
-	return jwtString, nil
+	active, ok := s.sessions.get(token.Id)
+	if !ok {
+		active, err = s.storage.IsSessionActive(ctx, token.Id)
+		if err != nil {
+			return err
+		}
+		s.sessions.set(token.Id, token.Login, active)
+	}
+	if !active {
+		return model.ErrSessionRevoked
+	}
+	return nil
+}
+
+// Logout завершает сессию, токен которой передан в контексте
+func (s *service) Logout(ctx context.Context) error {
+	token, err := utils.GetTokenFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		s.log.Error(err.Error())
+		return model.ErrNotValidToken
+	}
+	if err = s.storage.RevokeSession(ctx, token.Login, token.Id); err != nil {
+		return err
+	}
+	s.sessions.set(token.Id, token.Login, false)
+	return nil
+}
+
+// LogoutAll завершает все сессии пользователя, токен которого передан
+// в контексте. Возвращает количество завершенных сессий
+func (s *service) LogoutAll(ctx context.Context) (int64, error) {
+	token, err := utils.GetTokenFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, model.ErrNotValidToken
+	}
+	revoked, err := s.storage.RevokeAllSessions(ctx, token.Login)
+	if err != nil {
+		return 0, err
+	}
+	s.sessions.revokeLogin(token.Login)
+	return revoked, nil
+}
+
+// rehashPassword пересчитывает хэш пароля с текущими параметрами
+func (s *service) rehashPassword(ctx context.Context, login string,
+	password string) error {
+	passwordHash, err := utils.PasswordHash(password, s.config.PasswordHash)
+	if err != nil {
+		return err
+	}
+	return s.storage.UpdatePasswordHash(ctx, login, passwordHash)
+}
+
+// createDataKey генерирует ключ данных пользователя, оборачивает его
+// ключом шифрования ключей и сохраняет в storage.
+// Возвращает обернутый ключ, который фактически сохранен в storage
+func (s *service) createDataKey(ctx context.Context, login string) ([]byte, error) {
+	dataKey, err := utils.GenerateDataKey()
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	wrappedKey, err := utils.WrapDataKey(dataKey, s.config.SecretPassword, s.log)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.storage.AddUserKey(ctx, login, wrappedKey); err != nil {
+		return nil, err
+	}
+	// ключ мог быть создан параллельным запросом, читаем сохраненный
+	return s.storage.GetUserKey(ctx, login)
+}
+
+// dataKey возвращает развернутый ключ данных пользователя.
+// Для пользователей, зарегистрированных до появления ключей, ключ создается
+func (s *service) dataKey(ctx context.Context, login string) ([]byte, error) {
+	wrappedKey, err := s.storage.GetUserKey(ctx, login)
+	if errors.Is(err, model.ErrNoRowsSelected) {
+		wrappedKey, err = s.createDataKey(ctx, login)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return s.unwrapDataKey(wrappedKey)
+}
+
+// unwrapDataKey разворачивает ключ данных текущим секретом сервера,
+// а во время ротации - и прежним секретом
+func (s *service) unwrapDataKey(wrappedKey []byte) ([]byte, error) {
+	dataKey, err := utils.UnwrapDataKey(wrappedKey, s.config.SecretPassword, s.log)
+	if err != nil && s.config.PreviousSecretPassword != "" {
+		return utils.UnwrapDataKey(wrappedKey, s.config.PreviousSecretPassword, s.log)
+	}
+	return dataKey, err
+}
+
+// encodePayload проверяет типизированное содержимое записи и сериализует
+// его в Data. Для данных, зашифрованных на клиенте, проверяется только тип
+func encodePayload(data model.DataBlock) (model.DataBlock, error) {
+	switch {
+	case data.Payload != nil:
+		if len(data.EncryptedData) > 0 {
+			return data, model.ErrInvalidPayload
+		}
+		if err := data.Payload.Validate(); err != nil {
+			return data, err
+		}
+		encoded, err := data.Payload.Encode()
+		if err != nil {
+			return data, err
+		}
+		data.DataType = data.Payload.Type()
+		data.Data = encoded
+	case len(data.EncryptedData) > 0:
+		if data.DataType != "" && !model.IsKnownDataType(data.DataType) {
+			return data, fmt.Errorf("%w: %w", model.ErrInvalidPayload, model.ErrUnknownDataType)
+		}
+	default:
+		// нетипизированная строка
+		data.DataType = ""
+	}
+	return data, nil
+}
+
+// decodePayload восстанавливает типизированное содержимое расшифрованной записи.
+// Записи, которые не удалось разобрать, возвращаются строкой
+func (s *service) decodePayload(data model.DataBlock) model.DataBlock {
+	payload, err := model.DecodePayload(data.DataType, data.Data)
+	if err != nil {
+		s.log.Error(err.Error())
+		return data
+	}
+	if payload != nil {
+		data.Payload = payload
+		data.Data = ""
+	}
+	return data
+}
+
+// sealData шифрует данные ключом данных пользователя перед записью в storage.
+// Данные, зашифрованные на клиенте, сохраняются как есть. Открытые данные
+// не принимаются, если пользователь задал мастер-пароль
+func (s *service) sealData(ctx context.Context, data model.DataBlock) ([]byte, error) {
+	if len(data.EncryptedData) > 0 {
+		if !utils.IsClientSealed(data.EncryptedData) {
+			return nil, model.ErrNotClientSealed
+		}
+		return data.EncryptedData, nil
+	}
+	if err := s.allowPlaintext(ctx, data.Login); err != nil {
+		return nil, err
+	}
+	dataKey, err := s.dataKey(ctx, data.Login)
+	if err != nil {
+		return nil, err
+	}
+	return utils.DataKeyCipher(data.Data, dataKey, s.log)
+}
+
+// openData расшифровывает данные из storage. Записи, зашифрованные
+// до появления ключей пользователей, расшифровываются секретом сервера
+func (s *service) openData(cipherData []byte, dataKey []byte) (string, error) {
+	if utils.IsDataKeySealed(cipherData) {
+		return utils.DataKeyDecipher(cipherData, dataKey, s.log)
+	}
+	data, err := utils.GCMDataDecipher(cipherData, s.config.SecretPassword, s.log)
+	if err != nil && s.config.PreviousSecretPassword != "" {
+		return utils.GCMDataDecipher(cipherData, s.config.PreviousSecretPassword, s.log)
+	}
+	return data, err
 }
 
 // AddData шифрует данные и отправляет их в storage
 func (s *service) AddData(ctx context.Context, data model.DataBlock) error {
-	cipherData, err := utils.GCMDataCipher(data.Data, s.config.SecretPassword, s.log)
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
 	if err != nil {
 		return err
 	}
-	data.CipherData = cipherData
+	data.Login = login
+	data.Device = utils.GetDeviceFromContext(ctx)
 
-	login, err := utils.GetLoginFromContext(ctx, s.config.SecretPassword)
+	data, err = encodePayload(data)
+	if err != nil {
+		s.log.Error(err.Error())
+		return err
+	}
+	cipherData, err := s.sealData(ctx, data)
 	if err != nil {
 		return err
 	}
+	data.CipherData = cipherData
 
-	data.Login = login
 	err = s.storage.InsertData(ctx, data)
 	return err
 }
@@ -95,7 +464,7 @@ func (s *service) AddData(ctx context.Context, data model.DataBlock) error {
 func (s *service) GetData(ctx context.Context,
 	dataKeyWord string) ([]model.DataBlock, error) {
 
-	login, err := utils.GetLoginFromContext(ctx, s.config.SecretPassword)
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
 	if err != nil {
 		return nil, err
 	}
@@ -103,47 +472,244 @@ func (s *service) GetData(ctx context.Context,
 	if err != nil {
 		return nil, err
 	}
+	dataKey, err := s.dataKey(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+
 	var dataReturn []model.DataBlock
 	for _, dataLine := range data {
-		dataDecipher, err := utils.GCMDataDecipher(dataLine.CipherData, s.config.SecretPassword,
-			s.log)
+		dataBlock, err := s.openRecord(dataLine, dataKey)
 		if err != nil {
 			return nil, err
 		}
-
-		dataReturn = append(dataReturn, model.DataBlock{
-			DataKeyWord: dataLine.DataKeyWord,
-			Data:        dataDecipher,
-			MetaData:    dataLine.MetaData,
-		})
+		dataReturn = append(dataReturn, dataBlock)
 	}
 	return dataReturn, err
 }
 
-// ChangeData шифрует новые данные и отправляет их в storage
-func (s *service) ChangeData(ctx context.Context, dataForChange model.DataBlock) error {
-	cipherData, err := utils.GCMDataCipher(dataForChange.Data, s.config.SecretPassword, s.log)
-	if err != nil {
-		return err
+// openRecord готовит запись из storage к отправке клиенту
+func (s *service) openRecord(dataLine model.DataBlock, dataKey []byte) (model.DataBlock, error) {
+	// данные, зашифрованные на клиенте, отдаем без расшифровки
+	if utils.IsClientSealed(dataLine.CipherData) {
+		return model.DataBlock{
+			DataKeyWord:   dataLine.DataKeyWord,
+			DataType:      dataLine.DataType,
+			EncryptedData: dataLine.CipherData,
+			MetaData:      dataLine.MetaData,
+			Version:       dataLine.Version,
+		}, nil
 	}
-	dataForChange.CipherData = cipherData
 
-	login, err := utils.GetLoginFromContext(ctx, s.config.SecretPassword)
+	dataDecipher, err := s.openData(dataLine.CipherData, dataKey)
 	if err != nil {
-		return err
+		return model.DataBlock{}, err
 	}
 
+	return s.decodePayload(model.DataBlock{
+		DataKeyWord: dataLine.DataKeyWord,
+		DataType:    dataLine.DataType,
+		Data:        dataDecipher,
+		MetaData:    dataLine.MetaData,
+		Version:     dataLine.Version,
+	}), nil
+}
+
+// ChangeData шифрует новые данные и отправляет их в storage.
+// Запись изменяется, только если ее версия совпадает с dataForChange.Version,
+// возвращается новая версия записи. Изменение без версии клиенту не доступно,
+// запись с файлом storage не изменяет и возвращает model.ErrFileReadOnly
+func (s *service) ChangeData(ctx context.Context, dataForChange model.DataBlock) (int64, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return 0, err
+	}
+	if dataForChange.Version == 0 {
+		return 0, model.ErrVersionRequired
+	}
 	dataForChange.Login = login
+	dataForChange.Device = utils.GetDeviceFromContext(ctx)
+
+	dataForChange, err = encodePayload(dataForChange)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, err
+	}
+	cipherData, err := s.sealData(ctx, dataForChange)
+	if err != nil {
+		return 0, err
+	}
+	dataForChange.CipherData = cipherData
+
 	return s.storage.ChangeData(ctx, dataForChange)
 }
 
-// DeleteData удаляет данные пользователя
+// DeleteData перемещает запись пользователя в корзину
 func (s *service) DeleteData(ctx context.Context, dataKeyWord string) error {
 
-	login, err := utils.GetLoginFromContext(ctx, s.config.SecretPassword)
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
 	if err != nil {
 		return err
 	}
 
 	return s.storage.DeleteData(ctx, login, dataKeyWord)
 }
+
+// RotateMasterKey переводит ключи пользователей и записи, зашифрованные
+// секретом сервера, с прежнего секрета (PreviousSecretPassword) на текущий.
+// Работа идет пачками по batchSize записей, состояние сохраняется после
+// каждой пачки, поэтому прерванную ротацию можно продолжить повторным запуском
+func (s *service) RotateMasterKey(ctx context.Context, batchSize int) (model.KeyRotation, error) {
+	oldSecret, newSecret := s.config.PreviousSecretPassword, s.config.SecretPassword
+	if oldSecret == "" || newSecret == "" || oldSecret == newSecret {
+		return model.KeyRotation{}, model.ErrRotationSecrets
+	}
+
+	rotationID := utils.KeyFingerprint(oldSecret) + "-" + utils.KeyFingerprint(newSecret)
+	rotation, err := s.storage.GetKeyRotation(ctx, rotationID)
+	if err != nil {
+		return rotation, err
+	}
+	return s.runRotation(ctx, rotation, batchSize, func(data model.DataBlock) ([]byte, bool, error) {
+		return s.resealData(ctx, data)
+	})
+}
+
+// MigrateLegacyCipher перешифровывает в конверт записи, сохраненные в устаревшем
+// формате (nonce из последних байт секрета). Работа идет пачками по batchSize
+// записей, состояние сохраняется в keyRotation, поэтому завершенная перешифровка
+// повторно не выполняется. Записи, которые не удалось перешифровать,
+// пропускаются и попадают в журнал
+func (s *service) MigrateLegacyCipher(ctx context.Context, batchSize int) (model.KeyRotation, error) {
+	rotation, err := s.storage.GetKeyRotation(ctx, model.LegacyCipherRotationID)
+	if err != nil {
+		return rotation, err
+	}
+	// ключи пользователей в устаревшем формате не хранились
+	if rotation.Phase == model.RotationPhaseKeys {
+		rotation.Phase = model.RotationPhaseData
+	}
+	return s.runRotation(ctx, rotation, batchSize, s.resealLegacy)
+}
+
+// legacyCipherBatchSize - количество записей, перешифровываемых из устаревшего
+// формата в одной транзакции
+const legacyCipherBatchSize = 100
+
+// RunLegacyCipherMigration перешифровывает записи в устаревшем формате
+// в фоне, не задерживая запуск сервера. Ошибка попадает в журнал, перешифровка
+// продолжится при следующем запуске
+func (s *service) RunLegacyCipherMigration(ctx context.Context) {
+	rotation, err := s.storage.GetKeyRotation(ctx, model.LegacyCipherRotationID)
+	if err != nil || rotation.Phase == model.RotationPhaseDone {
+		return
+	}
+	rotation, err = s.MigrateLegacyCipher(ctx, legacyCipherBatchSize)
+	if err != nil {
+		s.log.Error("Перешифровка записей в устаревшем формате прервана: " + err.Error())
+		return
+	}
+	if rotation.Processed > 0 {
+		s.log.WithFields(logrus.Fields{
+			"rows": rotation.Processed,
+		}).Info("Перешифровали записи в новом формате")
+	}
+}
+
+// runRotation выполняет этапы ротации пачками по batchSize записей,
+// пока ротация не завершится
+func (s *service) runRotation(ctx context.Context, rotation model.KeyRotation, batchSize int,
+	reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+	var err error
+	for rotation.Phase != model.RotationPhaseDone {
+		if err := ctx.Err(); err != nil {
+			return rotation, err
+		}
+
+		switch rotation.Phase {
+		case model.RotationPhaseKeys:
+			rotation, err = s.storage.RotateUserKeysBatch(ctx, rotation, batchSize,
+				s.rewrapDataKey)
+		case model.RotationPhaseData:
+			rotation, err = s.storage.RotateDataBatch(ctx, rotation, batchSize, reseal)
+		case model.RotationPhaseHistory:
+			rotation, err = s.storage.RotateHistoryBatch(ctx, rotation, batchSize, reseal)
+		case model.RotationPhaseTrash:
+			rotation, err = s.storage.RotateTrashBatch(ctx, rotation, batchSize, reseal)
+		case model.RotationPhaseConflicts:
+			rotation, err = s.storage.RotateConflictsBatch(ctx, rotation, batchSize, reseal)
+		default:
+			err = model.ErrUnknownRotationPhase
+		}
+		if err != nil {
+			return rotation, err
+		}
+
+		s.log.WithFields(logrus.Fields{
+			"rotation":  rotation.RotationID,
+			"phase":     rotation.Phase,
+			"processed": rotation.Processed,
+		}).Info("Ротация: обработана пачка")
+	}
+	return rotation, nil
+}
+
+// rewrapDataKey переоборачивает ключ данных пользователя новым секретом.
+// Ключи, уже обернутые новым секретом, остаются без изменений
+func (s *service) rewrapDataKey(login string, wrappedKey []byte) ([]byte, error) {
+	rewrappedKey, err := utils.RewrapDataKey(wrappedKey, s.config.PreviousSecretPassword,
+		s.config.SecretPassword, s.log)
+	if err == nil {
+		return rewrappedKey, nil
+	}
+	if _, errNew := utils.UnwrapDataKey(wrappedKey, s.config.SecretPassword, s.log); errNew == nil {
+		return wrappedKey, nil
+	}
+	return nil, err
+}
+
+// resealLegacy перешифровывает в конверт запись в устаревшем формате.
+// Запись, которую не удалось расшифровать ни одним секретом, остается
+// как есть, чтобы одна поврежденная запись не останавливала перешифровку
+func (s *service) resealLegacy(data model.DataBlock) ([]byte, bool, error) {
+	cipherData, changed, err := utils.GCMDataReseal(data.CipherData, s.config.SecretPassword, s.log)
+	if (err != nil || !changed) && s.config.PreviousSecretPassword != "" {
+		// запись, не открывшуюся текущим секретом, проверяем предыдущим:
+		// устаревший шифротекст может выглядеть как конверт клиента
+		prevCipherData, prevChanged, prevErr := utils.GCMDataReseal(data.CipherData,
+			s.config.PreviousSecretPassword, s.log)
+		if prevErr == nil && (prevChanged || err != nil) {
+			cipherData, changed, err = prevCipherData, prevChanged, nil
+		}
+	}
+	if err != nil {
+		s.log.WithFields(logrus.Fields{
+			"login": data.Login,
+			"key":   data.DataKeyWord,
+		}).Error("Не удалось перешифровать запись в устаревшем формате: " + err.Error())
+		return data.CipherData, false, nil
+	}
+	return cipherData, changed, nil
+}
+
+// resealData перешифровывает ключом данных пользователя запись,
+// зашифрованную секретом сервера
+func (s *service) resealData(ctx context.Context, data model.DataBlock) ([]byte, bool, error) {
+	if utils.IsClientSealed(data.CipherData) || utils.IsDataKeySealed(data.CipherData) {
+		return data.CipherData, false, nil
+	}
+
+	plainData, err := s.openData(data.CipherData, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	dataKey, err := s.dataKey(ctx, data.Login)
+	if err != nil {
+		return nil, false, err
+	}
+	cipherData, err := utils.DataKeyCipher(plainData, dataKey, s.log)
+	if err != nil {
+		return nil, false, err
+	}
+	return cipherData, true, nil
+}
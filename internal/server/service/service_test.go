@@ -4,19 +4,27 @@ package service
 
 import (
 	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
 	"keeper/internal/logger"
 	"keeper/internal/model"
 	"keeper/internal/server/service/mocks"
 	"keeper/internal/utils"
-	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/grpc/metadata"
 )
 
+// testSecret - секрет сервера в тестах
+const testSecret = "supersecretpasswordforkeeper1234"
+
 func TestUserRegister(t *testing.T) {
 
 	mockStorage := new(mocks.Storer)
@@ -28,7 +36,6 @@ func TestUserRegister(t *testing.T) {
 		password string
 		wantErr  bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешная регистрация",
 			s: &service{
@@ -55,23 +62,31 @@ func TestUserRegister(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
+			// хэш содержит случайную соль, поэтому проверяем его отдельно
+			matchHash := mock.MatchedBy(func(passwordHash string) bool {
+				ok, _, err := utils.CheckPasswordHash(tt.password, passwordHash,
+					model.PasswordHashParams{})
+				return err == nil && ok
+			})
 			if tt.wantErr {
-				mockStorage.On("AddUser", ctx, tt.login,
-					utils.PasswordHash(tt.password)).Return(model.ErrUniqueViolation)
+				mockStorage.On("AddUser", ctx, tt.login, matchHash).Return(model.ErrUniqueViolation)
 			} else {
-				mockStorage.On("AddUser", ctx, tt.login,
-					utils.PasswordHash(tt.password)).Return(nil)
+				mockStorage.On("AddUser", ctx, tt.login, matchHash).Return(nil)
+				mockStorage.On("AddUserKey", ctx, tt.login, mock.AnythingOfType("[]uint8")).Return(nil)
+				mockStorage.On("GetUserKey", ctx, tt.login).Return([]byte("wrapped key"), nil)
+				expectIssueTokens(mockStorage, ctx, tt.login)
 			}
-			var jwtString string
+			var tokens model.Tokens
 			var err error
-			if jwtString, err = tt.s.UserRegister(ctx, tt.login, tt.password); (err != nil) != tt.wantErr {
+			if tokens, err = tt.s.UserRegister(ctx, tt.login, tt.password); (err != nil) != tt.wantErr {
 				t.Errorf("storage.CheckUserAuth() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
 			if err != nil {
 				return
 			}
-			assert.NotNil(t, jwtString)
+			assert.NotEmpty(t, tokens.AccessToken)
+			assert.NotEmpty(t, tokens.RefreshToken)
 
 		})
 	}
@@ -81,23 +96,30 @@ func TestUserAuthentification(t *testing.T) {
 
 	mockStorage := new(mocks.Storer)
 
+	passwordHash, err := utils.PasswordHash("123456", model.PasswordHashParams{})
+	require.NoError(t, err)
+	legacyHash := sha256.Sum256([]byte("123456"))
+
 	tests := []struct {
-		name     string
-		s        *service
-		login    string
-		password string
-		wantErr  bool
+		name         string
+		s            *service
+		login        string
+		password     string
+		passwordHash string
+		wantRehash   bool
+		totpEnabled  bool
+		wantErr      bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешная аутентификация",
 			s: &service{
 				storage: mockStorage,
 				log:     logger.InitLog(logrus.InfoLevel),
 			},
-			login:    "user1",
-			password: "123456",
-			wantErr:  false,
+			login:        "user1",
+			password:     "123456",
+			passwordHash: passwordHash,
+			wantErr:      false,
 		},
 		{
 			name: "Неверный пароль",
@@ -105,9 +127,34 @@ func TestUserAuthentification(t *testing.T) {
 				storage: mockStorage,
 				log:     logger.InitLog(logrus.InfoLevel),
 			},
-			login:    "user1",
-			password: "1234567",
-			wantErr:  true,
+			login:        "user1",
+			password:     "1234567",
+			passwordHash: passwordHash,
+			wantErr:      true,
+		},
+		{
+			name: "Пересчет хэша в устаревшем формате",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			login:        "user2",
+			password:     "123456",
+			passwordHash: string(legacyHash[:]),
+			wantRehash:   true,
+			wantErr:      false,
+		},
+		{
+			name: "Включен второй фактор",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			login:        "user3",
+			password:     "123456",
+			passwordHash: passwordHash,
+			totpEnabled:  true,
+			wantErr:      false,
 		},
 	}
 
@@ -115,33 +162,302 @@ func TestUserAuthentification(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			if tt.wantErr {
-				mockStorage.On("CheckUserAuth", ctx,
-					tt.login, tt.password).Return(model.ErrUserNotFound)
-			} else {
-				mockStorage.On("CheckUserAuth", ctx,
-					tt.login, tt.password).Return(nil)
+			mockStorage.On("GetPasswordHash", ctx, tt.login).Return(tt.passwordHash, nil).Once()
+			if tt.wantRehash {
+				mockStorage.On("UpdatePasswordHash", ctx, tt.login, mock.MatchedBy(
+					func(passwordHash string) bool {
+						return strings.HasPrefix(passwordHash, "$argon2id$")
+					})).Return(nil).Once()
+			}
+			if tt.totpEnabled {
+				mockStorage.On("GetTOTP", ctx, tt.login).
+					Return(model.TOTP{Confirmed: true}, nil).Once()
+				mockStorage.On("AddChallenge", ctx, mock.AnythingOfType("string"), tt.login,
+					mock.AnythingOfType("time.Time")).Return(nil).Once()
+			} else if !tt.wantErr {
+				mockStorage.On("GetTOTP", ctx, tt.login).
+					Return(model.TOTP{}, model.ErrTOTPNotEnrolled).Once()
+				expectIssueTokens(mockStorage, ctx, tt.login)
 			}
-			var jwtString string
+			var tokens model.Tokens
 			var err error
 
-			if jwtString, err = tt.s.UserAuthentification(ctx, tt.login, tt.password); (err != nil) != tt.wantErr {
-				t.Errorf("storage.CheckUserAuth() error = %v, wantErr %v", err, tt.wantErr)
+			if tokens, err = tt.s.UserAuthentification(ctx, tt.login, tt.password); (err != nil) != tt.wantErr {
+				t.Errorf("service.UserAuthentification() error = %v, wantErr %v", err, tt.wantErr)
 			}
 
 			if err != nil {
+				assert.ErrorIs(t, err, model.ErrIncorrectPassword)
+				return
+			}
+			if tt.totpEnabled {
+				// токены выдаются только после второго фактора
+				assert.Empty(t, tokens.AccessToken)
+				assert.NotEmpty(t, tokens.Challenge)
+				mockStorage.AssertExpectations(t)
+				return
+			}
+			assert.NotEmpty(t, tokens.AccessToken)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUserAuthentificationUnknownLogin(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	s := &service{
+		storage: mockStorage,
+		log:     logger.InitLog(logrus.InfoLevel),
+	}
+
+	ctx := context.Background()
+	mockStorage.On("GetPasswordHash", ctx, "ghost").
+		Return("", model.ErrUserNotFound).Once()
+
+	_, err := s.UserAuthentification(ctx, "ghost", "123456")
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+	// пароль сверен с фиктивным хэшем, как для существующего логина
+	assert.True(t, strings.HasPrefix(s.dummyHash, "$argon2id$"))
+	mockStorage.AssertExpectations(t)
+}
+
+func TestServiceRefreshToken(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+
+	tests := []struct {
+		name      string
+		s         *service
+		login     string
+		expiresAt time.Time
+		takeErr   error
+		wantErr   error
+	}{
+		{
+			name: "Успешное обновление токенов",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			login:     "user1",
+			expiresAt: time.Now().Add(time.Hour),
+		},
+		{
+			name: "Токен обновления истек",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			login:     "user1",
+			expiresAt: time.Now().Add(-time.Hour),
+			wantErr:   model.ErrRefreshTokenExpired,
+		},
+		{
+			name: "Токен обновления уже использован",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			takeErr: model.ErrRefreshTokenNotFound,
+			wantErr: model.ErrRefreshTokenNotFound,
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refreshToken, err := utils.GenerateRandomToken()
+			require.NoError(t, err)
+
+			mockStorage.On("TakeRefreshToken", ctx, utils.TokenHash(refreshToken)).
+				Return(model.RefreshToken{
+					Login:     tt.login,
+					SessionID: "old session",
+					ExpiresAt: tt.expiresAt,
+				}, tt.takeErr).Once()
+			if tt.wantErr == nil {
+				// сессия прежнего токена доступа завершается
+				mockStorage.On("RevokeSession", ctx, tt.login, "old session").Return(nil).Once()
+				mockStorage.On("AddSession", ctx, tt.login, mock.AnythingOfType("string"),
+					mock.AnythingOfType("time.Time")).Return(nil).Once()
+				mockStorage.On("AddRefreshToken", ctx, mock.MatchedBy(
+					func(tokenHash string) bool {
+						return tokenHash != utils.TokenHash(refreshToken)
+					}), mock.MatchedBy(func(token model.RefreshToken) bool {
+					return token.Login == tt.login && token.SessionID != ""
+				})).Return(nil).Once()
+			}
+
+			tokens, err := tt.s.RefreshToken(ctx, refreshToken)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
 				return
 			}
-			assert.NotNil(t, jwtString)
+			require.NoError(t, err)
+			assert.NotEmpty(t, tokens.AccessToken)
+			assert.NotEqual(t, refreshToken, tokens.RefreshToken)
+
+			login, err := utils.GetLoginFromContext(metadata.NewIncomingContext(ctx,
+				metadata.Pairs("token", tokens.AccessToken)), tt.s.config.SecretPassword)
+			require.NoError(t, err)
+			assert.Equal(t, tt.login, login)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+// expectIssueTokens настраивает мок хранилища на выдачу пары токенов
+func expectIssueTokens(mockStorage *mocks.Storer, ctx context.Context, login string) {
+	var sessionID string
+	mockStorage.On("AddSession", ctx, login, mock.MatchedBy(func(id string) bool {
+		sessionID = id
+		return id != ""
+	}), mock.AnythingOfType("time.Time")).Return(nil).Once()
+	mockStorage.On("AddRefreshToken", ctx, mock.AnythingOfType("string"),
+		mock.MatchedBy(func(token model.RefreshToken) bool {
+			return token.Login == login && token.SessionID == sessionID
+		})).Return(nil).Once()
+}
+
+func TestServiceCheckSession(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	tests := []struct {
+		name    string
+		active  bool
+		noToken bool
+		wantErr error
+	}{
+		{
+			name:   "Действующая сессия",
+			active: true,
+		},
+		{
+			name:    "Отозванная сессия",
+			active:  false,
+			wantErr: model.ErrSessionRevoked,
+		},
+		{
+			name:    "Нет токена",
+			noToken: true,
+			wantErr: model.ErrTokenNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{
+				storage: mockStorage,
+				log:     log,
+				config:  model.Config{SecretPassword: secretPassword},
+			}
+			ctx := initContext(!tt.noToken, "user1", log, secretPassword)
+			if !tt.noToken {
+				// сессия проверяется в бд один раз, повторная проверка берется из кэша
+				mockStorage.On("IsSessionActive", ctx, mock.AnythingOfType("string")).
+					Return(tt.active, nil).Once()
+			}
+
+			for i := 0; i < 2; i++ {
+				err := s.CheckSession(ctx)
+				if tt.wantErr != nil {
+					assert.ErrorIs(t, err, tt.wantErr)
+					continue
+				}
+				assert.NoError(t, err)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
 
+func TestServiceJWTKeys(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+	newSecret := "new" + secretPassword
+	jwtSecret := "jwt" + secretPassword
+
+	tests := []struct {
+		name    string
+		config  model.Config
+		signKey string
+		wantErr bool
+	}{
+		{
+			name:    "Токен прежнего секрета во время ротации",
+			config:  model.Config{SecretPassword: newSecret, PreviousSecretPassword: secretPassword},
+			signKey: secretPassword,
+		},
+		{
+			name:    "Токен прежнего секрета после ротации",
+			config:  model.Config{SecretPassword: newSecret},
+			signKey: secretPassword,
+			wantErr: true,
+		},
+		{
+			name:    "Токен отдельного ключа после смены секрета",
+			config:  model.Config{SecretPassword: newSecret, JWTSecret: jwtSecret},
+			signKey: jwtSecret,
+		},
+		{
+			name: "Токен секрета сервера при отдельном ключе",
+			config: model.Config{SecretPassword: newSecret, PreviousSecretPassword: secretPassword,
+				JWTSecret: jwtSecret},
+			signKey: secretPassword,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mocks.Storer)
+			s := &service{storage: mockStorage, log: log, config: tt.config}
+			ctx := initContext(true, "user1", log, tt.signKey)
+			if !tt.wantErr {
+				mockStorage.On("IsSessionActive", ctx, mock.AnythingOfType("string")).
+					Return(true, nil).Once()
+			}
+
+			err := s.CheckSession(ctx)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, model.ErrNotValidToken)
+			} else {
+				assert.NoError(t, err)
+			}
+			mockStorage.AssertExpectations(t)
 		})
 	}
 }
 
+func TestServiceLogout(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	ctx := initContext(true, "user1", log, secretPassword)
+
+	mockStorage.On("IsSessionActive", ctx, mock.AnythingOfType("string")).Return(true, nil).Once()
+	require.NoError(t, s.CheckSession(ctx))
+
+	// после выхода токен отклоняется без обращения к бд
+	mockStorage.On("RevokeSession", ctx, "user1", mock.AnythingOfType("string")).Return(nil).Once()
+	require.NoError(t, s.Logout(ctx))
+	assert.ErrorIs(t, s.CheckSession(ctx), model.ErrSessionRevoked)
+
+	mockStorage.On("RevokeAllSessions", ctx, "user1").Return(int64(2), nil).Once()
+	revoked, err := s.LogoutAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), revoked)
+	mockStorage.AssertExpectations(t)
+}
+
 func TestServiceAddData(t *testing.T) {
 	mockStorage := new(mocks.Storer)
-	secretPassword := os.Getenv("GOPRIVATE")
-	require.NotEmpty(t, secretPassword)
+	secretPassword := testSecret
 
 	tests := []struct {
 		name          string
@@ -150,7 +466,6 @@ func TestServiceAddData(t *testing.T) {
 		jwtStringFill bool
 		wantErr       bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешное добавление данных",
 			s: &service{
@@ -189,12 +504,12 @@ func TestServiceAddData(t *testing.T) {
 				secretPassword)
 			require.NotNil(t, ctx)
 
-			dataCipher, err := utils.GCMDataCipher(tt.data.Data, secretPassword, tt.s.log)
-			require.NoError(t, err)
-			tt.data.CipherData = dataCipher
-
 			tt.s.config.SecretPassword = secretPassword
-			mockStorage.On("InsertData", ctx, tt.data).Return(nil)
+			dataKey, wrappedKey := newDataKey(t, secretPassword, tt.s.log)
+			mockStorage.On("GetUserKey", ctx, tt.data.Login).Return(wrappedKey, nil)
+			mockStorage.On("GetVaultCheck", ctx, tt.data.Login).Return(nil, nil)
+			mockStorage.On("InsertData", ctx, mock.MatchedBy(
+				matchCipherData(tt.data, dataKey, tt.s.log))).Return(nil)
 
 			if err := tt.s.AddData(ctx, tt.data); (err != nil) != tt.wantErr {
 				t.Errorf("service.AddData() error = %v, wantErr %v", err, tt.wantErr)
@@ -205,8 +520,7 @@ func TestServiceAddData(t *testing.T) {
 
 func TestServiceGetData(t *testing.T) {
 	mockStorage := new(mocks.Storer)
-	secretPassword := os.Getenv("GOPRIVATE")
-	require.NotEmpty(t, secretPassword)
+	secretPassword := testSecret
 
 	tests := []struct {
 		name          string
@@ -217,7 +531,6 @@ func TestServiceGetData(t *testing.T) {
 		returnData    []model.DataBlock
 		wantErr       bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешное получение данных",
 			s: &service{
@@ -233,6 +546,11 @@ func TestServiceGetData(t *testing.T) {
 					Data:        "data1",
 					MetaData:    "metadata1",
 				},
+				{
+					DataKeyWord: "key1",
+					Data:        "data2",
+					MetaData:    "metadata2",
+				},
 			},
 			wantErr: false,
 		},
@@ -247,10 +565,18 @@ func TestServiceGetData(t *testing.T) {
 			require.NotNil(t, ctx)
 
 			tt.s.config.SecretPassword = secretPassword
-			cipheredData, err := utils.GCMDataCipher("data1", secretPassword, tt.s.log)
-			require.NoError(t, err)
+			dataKey, wrappedKey := newDataKey(t, secretPassword, tt.s.log)
+			mockStorage.On("GetUserKey", ctx, tt.login).Return(wrappedKey, nil)
 
+			cipheredData, err := utils.DataKeyCipher("data1", dataKey, tt.s.log)
+			require.NoError(t, err)
 			tt.returnData[0].CipherData = cipheredData
+
+			// запись, зашифрованная секретом сервера до появления ключей пользователей
+			legacyData, err := utils.GCMDataCipher("data2", secretPassword, tt.s.log)
+			require.NoError(t, err)
+			tt.returnData[1].CipherData = legacyData
+
 			mockStorage.On("GetData", ctx, tt.login, tt.dataKeyWord).Return(tt.returnData, nil)
 
 			if data, err = tt.s.GetData(ctx, tt.dataKeyWord); (err != nil) != tt.wantErr {
@@ -260,24 +586,29 @@ func TestServiceGetData(t *testing.T) {
 			if err != nil {
 				return
 			}
-			assert.NotEmpty(t, data)
+			require.Len(t, data, len(tt.returnData))
+			for i := range data {
+				assert.Equal(t, tt.returnData[i].Data, data[i].Data)
+			}
 		})
 	}
 }
 
 func TestServiceChangeData(t *testing.T) {
 	mockStorage := new(mocks.Storer)
-	secretPassword := os.Getenv("GOPRIVATE")
-	require.NotEmpty(t, secretPassword)
+	secretPassword := testSecret
 
 	tests := []struct {
 		name          string
 		s             *service
 		dataForChange model.DataBlock
 		jwtStringFill bool
+		vaultCheck    []byte
+		storageErr    error
+		wantErrIs     error
+		wantVersion   int64
 		wantErr       bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешное изменение",
 			s: &service{
@@ -289,10 +620,92 @@ func TestServiceChangeData(t *testing.T) {
 				DataKeyWord: "key1",
 				Data:        "data1",
 				MetaData:    "metadata1",
+				Version:     1,
 			},
 			jwtStringFill: true,
+			wantVersion:   2,
 			wantErr:       false,
 		},
+		{
+			name: "Версия записи не совпадает",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			dataForChange: model.DataBlock{
+				Login:       "user2",
+				DataKeyWord: "key2",
+				Data:        "data2",
+				Version:     1,
+			},
+			jwtStringFill: true,
+			storageErr:    &model.VersionMismatchError{Current: 4},
+			wantErr:       true,
+		},
+		{
+			name: "Запись не найдена",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			dataForChange: model.DataBlock{
+				Login:       "user3",
+				DataKeyWord: "key3",
+				Data:        "data3",
+				Version:     1,
+			},
+			jwtStringFill: true,
+			storageErr:    model.ErrNoRowsSelected,
+			wantErr:       true,
+		},
+		{
+			name: "Изменение без версии",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			dataForChange: model.DataBlock{
+				Login:       "user4",
+				DataKeyWord: "key4",
+				Data:        "data4",
+			},
+			jwtStringFill: true,
+			wantErrIs:     model.ErrVersionRequired,
+			wantErr:       true,
+		},
+		{
+			name: "Открытые данные пользователя с мастер-паролем",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			dataForChange: model.DataBlock{
+				Login:       "user5",
+				DataKeyWord: "key5",
+				Data:        "data5",
+				Version:     1,
+			},
+			jwtStringFill: true,
+			vaultCheck:    []byte("check"),
+			wantErrIs:     model.ErrPlaintextForbidden,
+			wantErr:       true,
+		},
+		{
+			name: "Запись с файлом",
+			s: &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+			},
+			dataForChange: model.DataBlock{
+				Login:       "user6",
+				DataKeyWord: "file",
+				Data:        "data6",
+				Version:     1,
+			},
+			jwtStringFill: true,
+			storageErr:    model.ErrFileReadOnly,
+			wantErr:       true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -302,16 +715,25 @@ func TestServiceChangeData(t *testing.T) {
 			require.NotNil(t, ctx)
 
 			tt.s.config.SecretPassword = secretPassword
-			cipheredData, err := utils.GCMDataCipher(tt.dataForChange.Data,
-				secretPassword, tt.s.log)
-			require.NoError(t, err)
-			tt.dataForChange.CipherData = cipheredData
-
-			mockStorage.On("ChangeData", ctx, tt.dataForChange).Return(nil)
+			dataKey, wrappedKey := newDataKey(t, secretPassword, tt.s.log)
+			mockStorage.On("GetUserKey", ctx, tt.dataForChange.Login).Return(wrappedKey, nil)
+			mockStorage.On("GetVaultCheck", ctx, tt.dataForChange.Login).Return(tt.vaultCheck, nil)
+			mockStorage.On("ChangeData", ctx, mock.MatchedBy(func(got model.DataBlock) bool {
+				return matchCipherData(tt.dataForChange, dataKey, tt.s.log)(got) &&
+					got.Version == tt.dataForChange.Version
+			})).Return(tt.wantVersion, tt.storageErr)
 
-			if err := tt.s.ChangeData(ctx, tt.dataForChange); (err != nil) != tt.wantErr {
+			version, err := tt.s.ChangeData(ctx, tt.dataForChange)
+			if (err != nil) != tt.wantErr {
 				t.Errorf("service.ChangeData() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			assert.Equal(t, tt.wantVersion, version)
+			if tt.storageErr != nil {
+				assert.ErrorIs(t, err, tt.storageErr)
+			}
+			if tt.wantErrIs != nil {
+				assert.ErrorIs(t, err, tt.wantErrIs)
+			}
 		})
 	}
 }
@@ -320,7 +742,7 @@ func initContext(fillToken bool, login string, log *logrus.Logger,
 	secretPassword string) context.Context {
 	var ctx context.Context
 	if fillToken {
-		jwtString, err := utils.GenerateJWTToken(login, log, secretPassword)
+		jwtString, _, err := utils.GenerateJWTToken(login, log, secretPassword)
 		if err != nil {
 			return nil
 		}
@@ -331,3 +753,280 @@ func initContext(fillToken bool, login string, log *logrus.Logger,
 	}
 	return ctx
 }
+
+// newDataKey генерирует ключ данных пользователя и оборачивает его секретом сервера
+func newDataKey(t *testing.T, secretPassword string, log *logrus.Logger) ([]byte, []byte) {
+	dataKey, err := utils.GenerateDataKey()
+	require.NoError(t, err)
+	wrappedKey, err := utils.WrapDataKey(dataKey, secretPassword, log)
+	require.NoError(t, err)
+	return dataKey, wrappedKey
+}
+
+// matchCipherData проверяет, что в storage передан блок данных
+// со значением исходных данных, зашифрованным ключом данных пользователя
+func matchCipherData(want model.DataBlock, dataKey []byte,
+	log *logrus.Logger) func(model.DataBlock) bool {
+	return func(got model.DataBlock) bool {
+		data, err := utils.DataKeyDecipher(got.CipherData, dataKey, log)
+		if err != nil {
+			return false
+		}
+		return got.Login == want.Login && got.DataKeyWord == want.DataKeyWord &&
+			got.MetaData == want.MetaData && data == want.Data
+	}
+}
+
+func TestServiceRotateMasterKey(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	oldSecret := "old secret password"
+	newSecret := "new secret password"
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config: model.Config{
+			SecretPassword:         newSecret,
+			PreviousSecretPassword: oldSecret,
+		},
+	}
+	ctx := context.Background()
+
+	dataKey, wrappedKey := newDataKey(t, oldSecret, log)
+	var rewrappedKey []byte
+
+	legacyData, err := utils.GCMDataCipher("legacy data", oldSecret, log)
+	require.NoError(t, err)
+	clientData, err := utils.VaultCipher("client data", utils.DeriveVaultKey("user1", "master"))
+	require.NoError(t, err)
+
+	mockStorage.On("GetKeyRotation", ctx, mock.AnythingOfType("string")).Return(
+		func(ctx context.Context, rotationID string) (model.KeyRotation, error) {
+			return model.KeyRotation{
+				RotationID: rotationID,
+				Phase:      model.RotationPhaseKeys,
+			}, nil
+		})
+	mockStorage.On("RotateUserKeysBatch", ctx, mock.Anything, 10, mock.Anything).Return(
+		func(ctx context.Context, rotation model.KeyRotation, batchSize int,
+			rewrap func(string, []byte) ([]byte, error)) (model.KeyRotation, error) {
+			rewrappedKey, err = rewrap("user1", wrappedKey)
+			require.NoError(t, err)
+
+			// повторный запуск не ломает уже переобернутый ключ
+			again, err := rewrap("user1", rewrappedKey)
+			require.NoError(t, err)
+			assert.Equal(t, rewrappedKey, again)
+
+			rotation.Phase = model.RotationPhaseData
+			return rotation, nil
+		})
+	mockStorage.On("GetUserKey", ctx, "user1").Return(
+		func(ctx context.Context, login string) ([]byte, error) {
+			return rewrappedKey, nil
+		})
+	mockStorage.On("RotateDataBatch", ctx, mock.Anything, 10, mock.Anything).Return(
+		func(ctx context.Context, rotation model.KeyRotation, batchSize int,
+			reseal func(model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+			cipherData, changed, err := reseal(model.DataBlock{
+				Login:      "user1",
+				CipherData: legacyData,
+			})
+			require.NoError(t, err)
+			assert.True(t, changed)
+			data, err := utils.DataKeyDecipher(cipherData, dataKey, log)
+			require.NoError(t, err)
+			assert.Equal(t, "legacy data", data)
+
+			_, changed, err = reseal(model.DataBlock{
+				Login:      "user1",
+				CipherData: clientData,
+			})
+			require.NoError(t, err)
+			assert.False(t, changed)
+
+			rotation.Phase = model.RotationPhaseHistory
+			return rotation, nil
+		})
+	// прежние версии записей, корзина и конфликты перешифровываются так же, как записи
+	mockStorage.On("RotateHistoryBatch", ctx, mock.Anything, 10, mock.Anything).Return(
+		func(ctx context.Context, rotation model.KeyRotation, batchSize int,
+			reseal func(model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+			_, changed, err := reseal(model.DataBlock{
+				Login:      "user1",
+				CipherData: legacyData,
+			})
+			require.NoError(t, err)
+			assert.True(t, changed)
+
+			rotation.Phase = model.RotationPhaseTrash
+			return rotation, nil
+		}).Once()
+	mockStorage.On("RotateTrashBatch", ctx, mock.Anything, 10, mock.Anything).Return(
+		func(ctx context.Context, rotation model.KeyRotation, batchSize int,
+			reseal func(model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+			_, changed, err := reseal(model.DataBlock{
+				Login:      "user1",
+				CipherData: legacyData,
+			})
+			require.NoError(t, err)
+			assert.True(t, changed)
+
+			rotation.Phase = model.RotationPhaseConflicts
+			return rotation, nil
+		}).Once()
+	mockStorage.On("RotateConflictsBatch", ctx, mock.Anything, 10, mock.Anything).Return(
+		func(ctx context.Context, rotation model.KeyRotation, batchSize int,
+			reseal func(model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+			_, changed, err := reseal(model.DataBlock{
+				Login:      "user1",
+				CipherData: legacyData,
+			})
+			require.NoError(t, err)
+			assert.True(t, changed)
+
+			rotation.Phase = model.RotationPhaseDone
+			return rotation, nil
+		}).Once()
+
+	rotation, err := s.RotateMasterKey(ctx, 10)
+	require.NoError(t, err)
+	assert.Equal(t, model.RotationPhaseDone, rotation.Phase)
+
+	unwrappedKey, err := utils.UnwrapDataKey(rewrappedKey, newSecret, log)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrappedKey)
+
+	// без прежнего секрета ротация не запускается
+	s.config.PreviousSecretPassword = ""
+	_, err = s.RotateMasterKey(ctx, 10)
+	assert.ErrorIs(t, err, model.ErrRotationSecrets)
+}
+
+func TestServiceMigrateLegacyCipher(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	ctx := context.Background()
+
+	legacyData := legacyCipher(t, "legacy data", secretPassword)
+	envelopeData, err := utils.GCMDataCipher("envelope data", secretPassword, log)
+	require.NoError(t, err)
+
+	mockStorage.On("GetKeyRotation", ctx, model.LegacyCipherRotationID).Return(
+		model.KeyRotation{
+			RotationID: model.LegacyCipherRotationID,
+			Phase:      model.RotationPhaseKeys,
+		}, nil).Once()
+	// у перешифровки нет этапа ключей пользователей
+	mockStorage.On("RotateDataBatch", ctx, mock.MatchedBy(func(rotation model.KeyRotation) bool {
+		return rotation.Phase == model.RotationPhaseData
+	}), 100, mock.Anything).Return(
+		func(ctx context.Context, rotation model.KeyRotation, batchSize int,
+			reseal func(model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+			cipherData, changed, err := reseal(model.DataBlock{CipherData: legacyData})
+			require.NoError(t, err)
+			assert.True(t, changed)
+			data, err := utils.GCMDataDecipher(cipherData, secretPassword, log)
+			require.NoError(t, err)
+			assert.Equal(t, "legacy data", data)
+
+			_, changed, err = reseal(model.DataBlock{CipherData: envelopeData})
+			require.NoError(t, err)
+			assert.False(t, changed)
+
+			// поврежденная запись пропускается и не прерывает перешифровку
+			cipherData, changed, err = reseal(model.DataBlock{CipherData: []byte("broken")})
+			require.NoError(t, err)
+			assert.False(t, changed)
+			assert.Equal(t, []byte("broken"), cipherData)
+
+			rotation.Phase = model.RotationPhaseHistory
+			rotation.Processed = 1
+			return rotation, nil
+		}).Once()
+	// прежние версии, корзина и конфликты перешифровываются из устаревшего формата так же
+	mockStorage.On("RotateHistoryBatch", ctx, mock.Anything, 100, mock.Anything).Return(
+		model.KeyRotation{RotationID: model.LegacyCipherRotationID,
+			Phase: model.RotationPhaseTrash, Processed: 1}, nil).Once()
+	mockStorage.On("RotateTrashBatch", ctx, mock.Anything, 100, mock.Anything).Return(
+		func(ctx context.Context, rotation model.KeyRotation, batchSize int,
+			reseal func(model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+			_, changed, err := reseal(model.DataBlock{CipherData: legacyData})
+			require.NoError(t, err)
+			assert.True(t, changed)
+
+			rotation.Phase = model.RotationPhaseConflicts
+			rotation.Processed++
+			return rotation, nil
+		}).Once()
+	mockStorage.On("RotateConflictsBatch", ctx, mock.Anything, 100, mock.Anything).Return(
+		func(ctx context.Context, rotation model.KeyRotation, batchSize int,
+			reseal func(model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+			_, changed, err := reseal(model.DataBlock{CipherData: legacyData})
+			require.NoError(t, err)
+			assert.True(t, changed)
+
+			rotation.Phase = model.RotationPhaseDone
+			rotation.Processed++
+			return rotation, nil
+		}).Once()
+
+	rotation, err := s.MigrateLegacyCipher(ctx, 100)
+	require.NoError(t, err)
+	assert.Equal(t, model.RotationPhaseDone, rotation.Phase)
+	assert.Equal(t, int64(3), rotation.Processed)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestServiceResealLegacyPreviousSecret(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	oldSecret := "old secret password"
+
+	s := &service{
+		log:    log,
+		config: model.Config{SecretPassword: testSecret, PreviousSecretPassword: oldSecret},
+	}
+
+	clientData, err := utils.VaultCipher("client data", utils.DeriveVaultKey("user1", "master"))
+	require.NoError(t, err)
+
+	// подбираем открытый текст так, чтобы шифротекст прежним секретом
+	// начинался с заголовка конверта клиента
+	keyStream := legacyCipher(t, string(make([]byte, 2)), oldSecret)
+	plain := string([]byte{keyStream[0] ^ clientData[0], keyStream[1] ^ clientData[1]}) +
+		"legacy data"
+	legacyData := legacyCipher(t, plain, oldSecret)
+	require.True(t, utils.IsClientSealed(legacyData))
+
+	cipherData, changed, err := s.resealLegacy(model.DataBlock{CipherData: legacyData})
+	require.NoError(t, err)
+	assert.True(t, changed)
+	data, err := utils.GCMDataDecipher(cipherData, oldSecret, log)
+	require.NoError(t, err)
+	assert.Equal(t, plain, data)
+
+	// настоящий конверт клиента остается без изменений
+	cipherData, changed, err = s.resealLegacy(model.DataBlock{CipherData: clientData})
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, clientData, cipherData)
+}
+
+// legacyCipher шифрует данные в устаревшем формате с nonce из последних байт секрета
+func legacyCipher(t *testing.T, data string, secretPassword string) []byte {
+	key := sha256.Sum256([]byte(secretPassword))
+	block, err := aes.NewCipher(key[:])
+	require.NoError(t, err)
+	aesGCM, err := cipher.NewGCM(block)
+	require.NoError(t, err)
+	nonce := []byte(secretPassword[len(secretPassword)-aesGCM.NonceSize():])
+	return aesGCM.Seal(nil, nonce, []byte(data), nil)
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// sessionCacheTTL - время, в течение которого результат проверки сессии
+	// берется из кэша без обращения к бд. Сессия, отозванная на другом
+	// экземпляре сервера, перестает действовать не позже чем через это время
+	sessionCacheTTL = 30 * time.Second
+	// sessionCacheLimit - размер кэша, после которого из него удаляются
+	// устаревшие записи
+	sessionCacheLimit = 10000
+)
+
+// sessionCacheEntry - результат проверки сессии
+type sessionCacheEntry struct {
+	login     string
+	active    bool
+	checkedAt time.Time
+}
+
+// sessionCache кэширует результаты проверки сессий по jti токена.
+// Нулевое значение готово к использованию
+type sessionCache struct {
+	mu      sync.Mutex
+	entries map[string]sessionCacheEntry
+}
+
+// get возвращает результат проверки сессии, если он еще не устарел
+func (c *sessionCache) get(sessionID string) (active bool, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[sessionID]
+	if !ok || time.Since(entry.checkedAt) > sessionCacheTTL {
+		return false, false
+	}
+	return entry.active, true
+}
+
+// set сохраняет результат проверки сессии
+func (c *sessionCache) set(sessionID string, login string, active bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]sessionCacheEntry)
+	}
+	if len(c.entries) >= sessionCacheLimit {
+		for id, entry := range c.entries {
+			if time.Since(entry.checkedAt) > sessionCacheTTL {
+				delete(c.entries, id)
+			}
+		}
+	}
+	c.entries[sessionID] = sessionCacheEntry{
+		login:     login,
+		active:    active,
+		checkedAt: time.Now(),
+	}
+}
+
+// revokeLogin помечает отозванными все закэшированные сессии пользователя
+func (c *sessionCache) revokeLogin(login string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, entry := range c.entries {
+		if entry.login == login {
+			entry.active = false
+			c.entries[id] = entry
+		}
+	}
+}
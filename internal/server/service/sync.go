@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+// Sync применяет операции, накопленные клиентом без связи с сервером,
+// и возвращает изменения записей пользователя после cursor. Операции
+// с некорректными данными пропускаются, их ошибки возвращаются клиенту
+// по индексу операции. Операции над устаревшей версией записи сохраняются
+// как конфликты. Пустой курсор означает начало истории
+func (s *service) Sync(ctx context.Context, cursor string,
+	operations []model.SyncOperation) (model.SyncResult, error) {
+	var result model.SyncResult
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return result, err
+	}
+	after, err := decodeSyncCursor(cursor)
+	if err != nil {
+		s.log.Error(err.Error())
+		return result, err
+	}
+
+	for _, operation := range operations {
+		conflict, err := s.applyOperation(ctx, login, operation)
+		switch {
+		case err == nil:
+			if conflict {
+				result.Conflicts++
+			}
+			result.OperationErrors = append(result.OperationErrors, "")
+		case errors.Is(err, model.ErrInvalidPayload) || errors.Is(err, model.ErrNotClientSealed) ||
+			errors.Is(err, model.ErrPlaintextForbidden) || errors.Is(err, model.ErrFileReadOnly):
+			result.OperationErrors = append(result.OperationErrors, err.Error())
+		default:
+			return result, err
+		}
+	}
+
+	changes, err := s.storage.GetChanges(ctx, login, after, model.SyncPageSize+1)
+	if err != nil {
+		return result, err
+	}
+	if len(changes) > model.SyncPageSize {
+		changes = changes[:model.SyncPageSize]
+		result.HasMore = true
+	}
+	if len(changes) == 0 {
+		result.Cursor = cursor
+		return result, nil
+	}
+
+	dataKey, err := s.dataKey(ctx, login)
+	if err != nil {
+		return result, err
+	}
+	for _, change := range changes {
+		if !change.Deleted {
+			if change.Data, err = s.openRecord(change.Data, dataKey); err != nil {
+				return result, err
+			}
+		}
+		result.Changes = append(result.Changes, change)
+	}
+	result.Cursor = encodeSyncCursor(changes[len(changes)-1].ChangePosition)
+	return result, nil
+}
+
+// applyOperation применяет операцию клиента: удаляет запись или сохраняет
+// ее новую версию. Если запись на сервере изменилась после версии, которую
+// изменял клиент, операция сохраняется как конфликт и возвращается true
+func (s *service) applyOperation(ctx context.Context, login string,
+	operation model.SyncOperation) (bool, error) {
+	var err error
+	if !operation.Deleted {
+		if operation.Data, err = s.sealRecord(ctx, login, operation.Data); err != nil {
+			return false, err
+		}
+	}
+	err = s.storage.ApplyOperation(ctx, login, operation)
+	if !errors.Is(err, model.ErrVersionMismatch) {
+		return false, err
+	}
+
+	conflict := model.Conflict{
+		DataKeyWord: operation.Data.DataKeyWord,
+		BaseVersion: operation.BaseVersion,
+		Mine:        operation.Data,
+		MineDeleted: operation.Deleted,
+	}
+	if operation.Base != nil {
+		base, err := s.sealRecord(ctx, login, *operation.Base)
+		if err != nil {
+			return false, err
+		}
+		conflict.Base = &base
+	}
+	return true, s.storage.AddConflict(ctx, login, conflict)
+}
+
+// sealRecord сериализует типизированную запись и шифрует ее для хранения
+func (s *service) sealRecord(ctx context.Context, login string,
+	data model.DataBlock) (model.DataBlock, error) {
+	data.Login = login
+	data.Device = utils.GetDeviceFromContext(ctx)
+	data, err := encodePayload(data)
+	if err != nil {
+		s.log.Error(err.Error())
+		return data, err
+	}
+	data.CipherData, err = s.sealData(ctx, data)
+	return data, err
+}
+
+// encodeSyncCursor упаковывает позицию последнего изменения в непрозрачный курсор
+func encodeSyncCursor(position model.ChangePosition) string {
+	raw := strconv.FormatInt(position.TxID, 10) + "." + strconv.FormatInt(position.Seq, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeSyncCursor возвращает позицию изменения, после которой
+// нужно выбрать изменения. Курсор прежнего формата содержит только номер
+// изменения: по нему повторно выбираются изменения, зафиксированные
+// после перехода на порядок транзакций
+func decodeSyncCursor(cursor string) (model.ChangePosition, error) {
+	var position model.ChangePosition
+	if cursor == "" {
+		return position, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return position, model.ErrInvalidSyncCursor
+	}
+	txID, seq, found := strings.Cut(string(raw), ".")
+	if !found {
+		txID, seq = "0", txID
+	}
+	if position.TxID, err = strconv.ParseInt(txID, 10, 64); err != nil || position.TxID < 0 {
+		return model.ChangePosition{}, model.ErrInvalidSyncCursor
+	}
+	if position.Seq, err = strconv.ParseInt(seq, 10, 64); err != nil || position.Seq < 0 {
+		return model.ChangePosition{}, model.ErrInvalidSyncCursor
+	}
+	return position, nil
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"encoding/base64"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/server/service/mocks"
+	"keeper/internal/utils"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceSync(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	ctx := initContext(true, "user1", log, secretPassword)
+	dataKey, wrappedKey := newDataKey(t, secretPassword, log)
+	mockStorage.On("GetUserKey", ctx, "user1").Return(wrappedKey, nil)
+	mockStorage.On("GetVaultCheck", ctx, "user1").Return(nil, nil)
+
+	operations := []model.SyncOperation{
+		{Data: model.DataBlock{DataKeyWord: "key1", Data: "data1"}},
+		{Data: model.DataBlock{DataKeyWord: "key2"}, Deleted: true, BaseVersion: 3},
+		{Data: model.DataBlock{
+			DataKeyWord: "card",
+			Payload:     &model.Payload{BankCard: &model.BankCard{Number: "1234", Expiry: "12/30"}},
+		}},
+		// запись создана и удалена без связи, на сервере ключ свободен
+		{Data: model.DataBlock{DataKeyWord: "offline"}, Deleted: true},
+		{
+			Data:        model.DataBlock{DataKeyWord: "key3", Data: "mine"},
+			BaseVersion: 2,
+			Base:        &model.DataBlock{DataKeyWord: "key3", Data: "base"},
+		},
+		// запись с файлом не изменяется, операция отклоняется
+		{Data: model.DataBlock{DataKeyWord: "file", Data: "edit"}, BaseVersion: 1},
+	}
+	mockStorage.On("ApplyOperation", ctx, "user1", mock.MatchedBy(func(operation model.SyncOperation) bool {
+		return operation.BaseVersion == 0 && matchCipherData(model.DataBlock{
+			Login:       "user1",
+			DataKeyWord: "key1",
+			Data:        "data1",
+		}, dataKey, log)(operation.Data)
+	})).Return(nil).Once()
+	mockStorage.On("ApplyOperation", ctx, "user1", mock.MatchedBy(func(operation model.SyncOperation) bool {
+		return operation.Deleted && operation.Data.DataKeyWord == "key2" && operation.BaseVersion == 3
+	})).Return(nil).Once()
+	mockStorage.On("ApplyOperation", ctx, "user1", mock.MatchedBy(func(operation model.SyncOperation) bool {
+		return operation.Deleted && operation.Data.DataKeyWord == "offline" && operation.BaseVersion == 0
+	})).Return(nil).Once()
+	mockStorage.On("ApplyOperation", ctx, "user1", mock.MatchedBy(func(operation model.SyncOperation) bool {
+		return operation.Data.DataKeyWord == "key3"
+	})).Return(&model.VersionMismatchError{Current: 4}).Once()
+	mockStorage.On("ApplyOperation", ctx, "user1", mock.MatchedBy(func(operation model.SyncOperation) bool {
+		return operation.Data.DataKeyWord == "file"
+	})).Return(model.ErrFileReadOnly).Once()
+	// изменение над устаревшей версией сохраняется рядом с записью как конфликт
+	mockStorage.On("AddConflict", ctx, "user1", mock.MatchedBy(func(conflict model.Conflict) bool {
+		return conflict.DataKeyWord == "key3" && conflict.BaseVersion == 2 && !conflict.MineDeleted &&
+			conflict.Base != nil &&
+			matchCipherData(model.DataBlock{Login: "user1", DataKeyWord: "key3", Data: "base"},
+				dataKey, log)(*conflict.Base) &&
+			matchCipherData(model.DataBlock{Login: "user1", DataKeyWord: "key3", Data: "mine"},
+				dataKey, log)(conflict.Mine)
+	})).Return(nil).Once()
+
+	cipherData, err := utils.DataKeyCipher("data1", dataKey, log)
+	require.NoError(t, err)
+	changes := []model.DataChange{
+		{
+			Data:           model.DataBlock{DataKeyWord: "key1", CipherData: cipherData},
+			Header:         model.DataHeader{DataKeyWord: "key1"},
+			ChangePosition: model.ChangePosition{TxID: 5, Seq: 7},
+		},
+		{
+			Data:           model.DataBlock{DataKeyWord: "key2"},
+			Header:         model.DataHeader{DataKeyWord: "key2"},
+			Deleted:        true,
+			ChangePosition: model.ChangePosition{TxID: 5, Seq: 8},
+		},
+	}
+	mockStorage.On("GetChanges", ctx, "user1", model.ChangePosition{}, model.SyncPageSize+1).
+		Return(changes, nil).Once()
+
+	result, err := s.Sync(ctx, "", operations)
+	require.NoError(t, err)
+	require.Len(t, result.OperationErrors, len(operations))
+	assert.Empty(t, result.OperationErrors[0])
+	assert.Empty(t, result.OperationErrors[1])
+	// некорректная карта не сохраняется, ошибка возвращается клиенту
+	assert.NotEmpty(t, result.OperationErrors[2])
+	assert.Empty(t, result.OperationErrors[3])
+	assert.Empty(t, result.OperationErrors[4])
+	assert.Equal(t, model.ErrFileReadOnly.Error(), result.OperationErrors[5])
+	assert.Equal(t, 1, result.Conflicts)
+	require.Len(t, result.Changes, 2)
+	assert.Equal(t, "data1", result.Changes[0].Data.Data)
+	assert.True(t, result.Changes[1].Deleted)
+	assert.False(t, result.HasMore)
+
+	// следующая синхронизация продолжается с последнего изменения
+	mockStorage.On("GetChanges", ctx, "user1", model.ChangePosition{TxID: 5, Seq: 8},
+		model.SyncPageSize+1).Return(nil, nil).Once()
+	next, err := s.Sync(ctx, result.Cursor, nil)
+	require.NoError(t, err)
+	assert.Empty(t, next.Changes)
+	assert.Equal(t, result.Cursor, next.Cursor)
+
+	// курсор прежнего формата содержит только номер изменения
+	mockStorage.On("GetChanges", ctx, "user1", model.ChangePosition{Seq: 8},
+		model.SyncPageSize+1).Return(nil, nil).Once()
+	_, err = s.Sync(ctx, base64.RawURLEncoding.EncodeToString([]byte("8")), nil)
+	require.NoError(t, err)
+
+	_, err = s.Sync(ctx, "not a cursor", nil)
+	assert.ErrorIs(t, err, model.ErrInvalidSyncCursor)
+	mockStorage.AssertExpectations(t)
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"keeper/internal/model"
+	"keeper/internal/utils"
+	"time"
+)
+
+const (
+	// challengeTTL - время на ввод кода второго фактора после проверки пароля
+	challengeTTL = 5 * time.Minute
+	// maxChallengeAttempts - количество попыток ввода кода для одного входа
+	maxChallengeAttempts = 5
+)
+
+// loginOrChallenge выдает токены пользователю без второго фактора.
+// Если второй фактор включен, возвращает challenge для завершения входа
+func (s *service) loginOrChallenge(ctx context.Context, login string) (model.Tokens, error) {
+	totp, err := s.storage.GetTOTP(ctx, login)
+	if errors.Is(err, model.ErrTOTPNotEnrolled) || (err == nil && !totp.Confirmed) {
+		return s.issueTokens(ctx, login)
+	}
+	if err != nil {
+		return model.Tokens{}, err
+	}
+
+	challenge, err := utils.GenerateRandomToken()
+	if err != nil {
+		s.log.Error(err.Error())
+		return model.Tokens{}, err
+	}
+	err = s.storage.AddChallenge(ctx, utils.TokenHash(challenge), login,
+		time.Now().Add(challengeTTL))
+	if err != nil {
+		return model.Tokens{}, err
+	}
+	s.log.Debug("Требуется второй фактор")
+	return model.Tokens{Challenge: challenge}, nil
+}
+
+// CompleteSecondFactor завершает вход по коду TOTP или коду восстановления
+func (s *service) CompleteSecondFactor(ctx context.Context, challenge string,
+	code string) (model.Tokens, error) {
+	challengeHash := utils.TokenHash(challenge)
+	authChallenge, err := s.storage.CountChallengeAttempt(ctx, challengeHash)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+	if time.Now().After(authChallenge.ExpiresAt) || authChallenge.Attempts > maxChallengeAttempts {
+		if err = s.storage.DeleteChallenge(ctx, challengeHash); err != nil {
+			return model.Tokens{}, err
+		}
+		if authChallenge.Attempts > maxChallengeAttempts {
+			return model.Tokens{}, model.ErrTooManyAttempts
+		}
+		return model.Tokens{}, model.ErrChallengeExpired
+	}
+
+	ok, err := s.checkSecondFactor(ctx, authChallenge.Login, code)
+	if err != nil {
+		return model.Tokens{}, err
+	}
+	if !ok {
+		err = model.ErrIncorrectTOTPCode
+		s.log.Error(err.Error())
+		return model.Tokens{}, err
+	}
+
+	if err = s.storage.DeleteChallenge(ctx, challengeHash); err != nil {
+		return model.Tokens{}, err
+	}
+	return s.issueTokens(ctx, authChallenge.Login)
+}
+
+// checkSecondFactor проверяет код TOTP, а если он не подошел - код восстановления.
+// Принятые коды повторно не принимаются
+func (s *service) checkSecondFactor(ctx context.Context, login string,
+	code string) (bool, error) {
+	totp, err := s.storage.GetTOTP(ctx, login)
+	if err != nil {
+		return false, err
+	}
+	secret, err := s.openTOTPSecret(ctx, login, totp.CipherSecret)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := utils.ValidateTOTP(secret, code, time.Now(), totp.LastStep); ok {
+		return s.storage.UseTOTPStep(ctx, login, step)
+	}
+	return s.storage.UseRecoveryCode(ctx, login, utils.RecoveryCodeHash(code))
+}
+
+// EnrollTOTP генерирует секрет TOTP для пользователя из контекста.
+// Второй фактор начинает действовать после подтверждения кода в ConfirmTOTP
+func (s *service) EnrollTOTP(ctx context.Context) (model.TOTPEnrollment, error) {
+	login, err := s.sessionLogin(ctx)
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		s.log.Error(err.Error())
+		return model.TOTPEnrollment{}, err
+	}
+	dataKey, err := s.dataKey(ctx, login)
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+	cipherSecret, err := utils.DataKeyCipher(secret, dataKey, s.log)
+	if err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+	if err = s.storage.SaveTOTP(ctx, login, cipherSecret); err != nil {
+		return model.TOTPEnrollment{}, err
+	}
+
+	return model.TOTPEnrollment{
+		Secret: secret,
+		URI:    utils.TOTPURI(login, secret),
+	}, nil
+}
+
+// ConfirmTOTP проверяет первый код из приложения-аутентификатора, включает
+// второй фактор и возвращает коды восстановления. Коды показываются один раз,
+// на сервере хранятся только их хэши
+func (s *service) ConfirmTOTP(ctx context.Context, code string) ([]string, error) {
+	login, err := s.sessionLogin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	totp, err := s.storage.GetTOTP(ctx, login)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Confirmed {
+		return nil, model.ErrTOTPAlreadyEnabled
+	}
+	secret, err := s.openTOTPSecret(ctx, login, totp.CipherSecret)
+	if err != nil {
+		return nil, err
+	}
+	step, ok := utils.ValidateTOTP(secret, code, time.Now(), totp.LastStep)
+	if !ok {
+		err = model.ErrIncorrectTOTPCode
+		s.log.Error(err.Error())
+		return nil, err
+	}
+
+	recoveryCodes, err := utils.GenerateRecoveryCodes()
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, err
+	}
+	codeHashes := make([]string, 0, len(recoveryCodes))
+	for _, recoveryCode := range recoveryCodes {
+		codeHashes = append(codeHashes, utils.RecoveryCodeHash(recoveryCode))
+	}
+	if err = s.storage.ConfirmTOTP(ctx, login, step, codeHashes); err != nil {
+		return nil, err
+	}
+	return recoveryCodes, nil
+}
+
+// openTOTPSecret расшифровывает секрет TOTP ключом данных пользователя
+func (s *service) openTOTPSecret(ctx context.Context, login string,
+	cipherSecret []byte) (string, error) {
+	dataKey, err := s.dataKey(ctx, login)
+	if err != nil {
+		return "", err
+	}
+	return utils.DataKeyDecipher(cipherSecret, dataKey, s.log)
+}
+
+// sessionLogin проверяет токен и сессию из контекста и возвращает
+// логин пользователя. Используется в методах AuthService, которые
+// не проходят через интерсептор
+func (s *service) sessionLogin(ctx context.Context) (string, error) {
+	if err := s.CheckSession(ctx); err != nil {
+		return "", err
+	}
+	return utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+}
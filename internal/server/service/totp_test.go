@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/server/service/mocks"
+	"keeper/internal/utils"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceEnrollTOTP(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+	dataKey, wrappedKey := newDataKey(t, secretPassword, log)
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	ctx := initContext(true, "user1", log, secretPassword)
+
+	mockStorage.On("IsSessionActive", ctx, mock.AnythingOfType("string")).Return(true, nil)
+	mockStorage.On("GetUserKey", ctx, "user1").Return(wrappedKey, nil)
+
+	var cipherSecret []byte
+	mockStorage.On("SaveTOTP", ctx, "user1", mock.MatchedBy(func(secret []byte) bool {
+		cipherSecret = secret
+		return utils.IsDataKeySealed(secret)
+	})).Return(nil).Once()
+
+	enrollment, err := s.EnrollTOTP(ctx)
+	require.NoError(t, err)
+	assert.Contains(t, enrollment.URI, enrollment.Secret)
+
+	// секрет хранится зашифрованным ключом данных пользователя
+	secret, err := utils.DataKeyDecipher(cipherSecret, dataKey, log)
+	require.NoError(t, err)
+	assert.Equal(t, enrollment.Secret, secret)
+
+	mockStorage.On("GetTOTP", ctx, "user1").Return(model.TOTP{CipherSecret: cipherSecret}, nil)
+
+	_, err = s.ConfirmTOTP(ctx, "000000x")
+	assert.ErrorIs(t, err, model.ErrIncorrectTOTPCode)
+
+	step := utils.TOTPStep(time.Now())
+	code, err := utils.TOTPCode(secret, step)
+	require.NoError(t, err)
+	mockStorage.On("ConfirmTOTP", ctx, "user1", step, mock.MatchedBy(func(hashes []string) bool {
+		return len(hashes) == 10
+	})).Return(nil).Once()
+
+	recoveryCodes, err := s.ConfirmTOTP(ctx, code)
+	require.NoError(t, err)
+	assert.Len(t, recoveryCodes, 10)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestServiceCompleteSecondFactor(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+	dataKey, wrappedKey := newDataKey(t, secretPassword, log)
+
+	secret, err := utils.GenerateTOTPSecret()
+	require.NoError(t, err)
+	cipherSecret, err := utils.DataKeyCipher(secret, dataKey, log)
+	require.NoError(t, err)
+	step := utils.TOTPStep(time.Now())
+	code, err := utils.TOTPCode(secret, step)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name        string
+		code        string
+		attempts    int
+		expiresAt   time.Time
+		stepAccept  bool
+		recoveryOk  bool
+		wantErr     error
+		wantDeleted bool
+	}{
+		{
+			name:        "Верный код TOTP",
+			code:        code,
+			attempts:    1,
+			expiresAt:   time.Now().Add(time.Minute),
+			stepAccept:  true,
+			wantDeleted: true,
+		},
+		{
+			name:        "Код восстановления",
+			code:        "abcde-fghij",
+			attempts:    1,
+			expiresAt:   time.Now().Add(time.Minute),
+			recoveryOk:  true,
+			wantDeleted: true,
+		},
+		{
+			name:      "Неверный код",
+			code:      "abcde-fghij",
+			attempts:  2,
+			expiresAt: time.Now().Add(time.Minute),
+			wantErr:   model.ErrIncorrectTOTPCode,
+		},
+		{
+			name:        "Исчерпаны попытки",
+			code:        code,
+			attempts:    maxChallengeAttempts + 1,
+			expiresAt:   time.Now().Add(time.Minute),
+			wantErr:     model.ErrTooManyAttempts,
+			wantDeleted: true,
+		},
+		{
+			name:        "Время на ввод кода истекло",
+			code:        code,
+			attempts:    1,
+			expiresAt:   time.Now().Add(-time.Minute),
+			wantErr:     model.ErrChallengeExpired,
+			wantDeleted: true,
+		},
+	}
+
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &service{
+				storage: mockStorage,
+				log:     log,
+				config:  model.Config{SecretPassword: secretPassword},
+			}
+			challengeHash := utils.TokenHash("challenge")
+
+			mockStorage.On("CountChallengeAttempt", ctx, challengeHash).Return(model.AuthChallenge{
+				Login:     "user1",
+				ExpiresAt: tt.expiresAt,
+				Attempts:  tt.attempts,
+			}, nil).Once()
+			if tt.attempts <= maxChallengeAttempts && time.Now().Before(tt.expiresAt) {
+				mockStorage.On("GetTOTP", ctx, "user1").Return(model.TOTP{
+					CipherSecret: cipherSecret,
+					Confirmed:    true,
+				}, nil).Once()
+				mockStorage.On("GetUserKey", ctx, "user1").Return(wrappedKey, nil).Once()
+				if tt.code == code {
+					mockStorage.On("UseTOTPStep", ctx, "user1", step).Return(tt.stepAccept, nil).Once()
+				} else {
+					mockStorage.On("UseRecoveryCode", ctx, "user1", utils.RecoveryCodeHash(tt.code)).
+						Return(tt.recoveryOk, nil).Once()
+				}
+			}
+			if tt.wantDeleted {
+				mockStorage.On("DeleteChallenge", ctx, challengeHash).Return(nil).Once()
+			}
+			if tt.wantErr == nil {
+				expectIssueTokens(mockStorage, ctx, "user1")
+			}
+
+			tokens, err := s.CompleteSecondFactor(ctx, "challenge", tt.code)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				require.NoError(t, err)
+				assert.NotEmpty(t, tokens.AccessToken)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
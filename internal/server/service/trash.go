@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+// ListTrash возвращает заголовки записей в корзине пользователя
+func (s *service) ListTrash(ctx context.Context) ([]model.TrashedRecord, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.ListTrash(ctx, login)
+}
+
+// RestoreFromTrash возвращает запись из корзины и возвращает ее версию
+func (s *service) RestoreFromTrash(ctx context.Context, trashID int64) (int64, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return 0, err
+	}
+	return s.storage.RestoreFromTrash(ctx, login, trashID, utils.GetDeviceFromContext(ctx))
+}
+
+// EmptyTrash навсегда удаляет все записи из корзины пользователя
+// и возвращает их количество
+func (s *service) EmptyTrash(ctx context.Context) (int64, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return 0, err
+	}
+	return s.storage.PurgeTrash(ctx, login, time.Time{})
+}
+
+// PurgeTrash навсегда удаляет записи, пролежавшие в корзине дольше
+// config.Trash.Days, и возвращает их количество
+func (s *service) PurgeTrash(ctx context.Context) (int64, error) {
+	days := s.config.Trash.Days
+	if days <= 0 {
+		days = model.DefaultTrashDays
+	}
+	return s.storage.PurgeTrash(ctx, "", time.Now().AddDate(0, 0, -days))
+}
+
+// RunTrashPurge периодически очищает корзину, пока не отменен ctx
+func (s *service) RunTrashPurge(ctx context.Context) {
+	interval := model.DefaultTrashPurgeInterval
+	if s.config.Trash.PurgeIntervalMinutes > 0 {
+		interval = time.Duration(s.config.Trash.PurgeIntervalMinutes) * time.Minute
+	}
+	s.runPeriodically(ctx, interval, func(ctx context.Context) {
+		purged, err := s.PurgeTrash(ctx)
+		if err != nil {
+			s.log.Error("Не удалось очистить корзину: " + err.Error())
+		} else if purged > 0 {
+			s.log.WithFields(logrus.Fields{
+				"records": purged,
+			}).Info("Удалили записи из корзины")
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package service
+
+import (
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/server/service/mocks"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceTrash(t *testing.T) {
+	mockStorage := new(mocks.Storer)
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	ctx := initContext(true, "user1", log, secretPassword)
+
+	deletedAt := time.Now()
+	mockStorage.On("ListTrash", ctx, "user1").Return([]model.TrashedRecord{{
+		ID:        3,
+		Header:    model.DataHeader{DataKeyWord: "key"},
+		DeletedAt: deletedAt,
+	}}, nil).Once()
+	records, err := s.ListTrash(ctx)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "key", records[0].Header.DataKeyWord)
+
+	mockStorage.On("RestoreFromTrash", ctx, "user1", int64(3), "").Return(int64(2), nil).Once()
+	mockStorage.On("RestoreFromTrash", ctx, "user1", int64(4), "").
+		Return(int64(0), model.ErrDataKeyWordExists).Once()
+	version, err := s.RestoreFromTrash(ctx, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+	_, err = s.RestoreFromTrash(ctx, 4)
+	assert.ErrorIs(t, err, model.ErrDataKeyWordExists)
+
+	// очистка корзины пользователем удаляет все его записи
+	mockStorage.On("PurgeTrash", ctx, "user1", time.Time{}).Return(int64(1), nil).Once()
+	purged, err := s.EmptyTrash(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+	mockStorage.AssertExpectations(t)
+}
+
+func TestServicePurgeTrash(t *testing.T) {
+	ctx := initContext(false, "", nil, "")
+	tests := []struct {
+		name     string
+		trash    model.TrashRetention
+		wantDays int
+	}{
+		{
+			name:     "Срок хранения по умолчанию",
+			trash:    model.TrashRetention{},
+			wantDays: model.DefaultTrashDays,
+		},
+		{
+			name:     "Срок хранения из конфигурации",
+			trash:    model.TrashRetention{Days: 7},
+			wantDays: 7,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mocks.Storer)
+			s := &service{
+				storage: mockStorage,
+				log:     logger.InitLog(logrus.InfoLevel),
+				config:  model.Config{Trash: tt.trash},
+			}
+			mockStorage.On("PurgeTrash", ctx, "", mock.MatchedBy(func(deletedBefore time.Time) bool {
+				want := time.Now().AddDate(0, 0, -tt.wantDays)
+				return deletedBefore.Sub(want).Abs() < time.Minute
+			})).Return(int64(0), nil).Once()
+
+			_, err := s.PurgeTrash(ctx)
+			require.NoError(t, err)
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+
+	"keeper/internal/model"
+	"keeper/internal/utils"
+)
+
+// GetVaultCheck возвращает проверочное значение хранилища пользователя
+// или nil, если клиент его еще не сохранил
+func (s *service) GetVaultCheck(ctx context.Context) ([]byte, error) {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return nil, err
+	}
+	return s.storage.GetVaultCheck(ctx, login)
+}
+
+// SetVaultCheck сохраняет проверочное значение хранилища пользователя.
+// Значение должно быть зашифровано на клиенте и сохраняется только один раз
+func (s *service) SetVaultCheck(ctx context.Context, check []byte) error {
+	login, err := utils.GetLoginFromContext(ctx, s.jwtKeys()...)
+	if err != nil {
+		return err
+	}
+	if !utils.IsClientSealed(check) {
+		return model.ErrNotClientSealed
+	}
+	return s.storage.SetVaultCheck(ctx, login, check)
+}
+
+// allowPlaintext запрещает сохранять на сервере открытые данные
+// пользователя, который задал мастер-пароль для шифрования на клиенте
+func (s *service) allowPlaintext(ctx context.Context, login string) error {
+	check, err := s.storage.GetVaultCheck(ctx, login)
+	if err != nil {
+		return err
+	}
+	if check != nil {
+		return model.ErrPlaintextForbidden
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"keeper/internal/server/service/mocks"
+	"keeper/internal/utils"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceVaultCheck(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	sealed, err := utils.NewVaultCheck(utils.DeriveVaultKey("user1", "master"))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		check      []byte
+		storageErr error
+		wantErrIs  error
+	}{
+		{
+			name:  "Сохранение проверочного значения",
+			check: sealed,
+		},
+		{
+			name:       "Проверочное значение уже сохранено",
+			check:      sealed,
+			storageErr: model.ErrVaultCheckExists,
+			wantErrIs:  model.ErrVaultCheckExists,
+		},
+		{
+			name:      "Значение не зашифровано на клиенте",
+			check:     []byte("keeper-vault"),
+			wantErrIs: model.ErrNotClientSealed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorage := new(mocks.Storer)
+			s := &service{
+				storage: mockStorage,
+				log:     log,
+				config:  model.Config{SecretPassword: secretPassword},
+			}
+			ctx := initContext(true, "user1", log, secretPassword)
+			if tt.wantErrIs != model.ErrNotClientSealed {
+				mockStorage.On("SetVaultCheck", ctx, "user1", tt.check).Return(tt.storageErr).Once()
+			}
+
+			err := s.SetVaultCheck(ctx, tt.check)
+			if tt.wantErrIs != nil {
+				assert.ErrorIs(t, err, tt.wantErrIs)
+			} else {
+				require.NoError(t, err)
+				mockStorage.On("GetVaultCheck", ctx, "user1").Return(tt.check, nil).Once()
+				check, err := s.GetVaultCheck(ctx)
+				require.NoError(t, err)
+				assert.Equal(t, tt.check, check)
+			}
+			mockStorage.AssertExpectations(t)
+		})
+	}
+}
+
+func TestServiceUploadPlaintextFile(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	mockStorage := new(mocks.Storer)
+	s := &service{
+		storage: mockStorage,
+		log:     log,
+		config:  model.Config{SecretPassword: secretPassword},
+	}
+	ctx := initContext(true, "user1", log, secretPassword)
+	mockStorage.On("GetVaultCheck", ctx, "user1").Return([]byte("check"), nil)
+
+	// файл не зашифрован на клиенте, хотя пользователь задал мастер-пароль
+	_, err := s.UploadFile(ctx, fileStream([]model.FileChunk{
+		{Info: &model.FileInfo{DataKeyWord: "file1", Size: 1}},
+		{Data: []byte("1")},
+	}))
+	assert.ErrorIs(t, err, model.ErrPlaintextForbidden)
+	mockStorage.AssertNotCalled(t, "CreateFileUpload", mock.Anything, mock.Anything,
+		mock.Anything, mock.Anything)
+}
@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"io/fs"
+	"keeper/internal/model"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v4/pgxpool"
+	"github.com/sirupsen/logrus"
+)
+
+// migrationFiles - миграции схемы бд, встроенные в сервер
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID - ключ рекомендательной блокировки Postgres. Под ней
+// миграции применяются, поэтому одновременно запущенные экземпляры сервера
+// не применяют одну миграцию дважды
+const migrationLockID int64 = 0x6b6565706572
+
+// migrationName - имя файла миграции вида 0001_users_and_data.up.sql
+var migrationName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var (
+	createSchemaVersionTable = `CREATE TABLE IF NOT EXISTS schema_version(
+						version BIGINT PRIMARY KEY,
+						name TEXT,
+						appliedAt TIMESTAMPTZ DEFAULT now()
+						)`
+	selectSchemaVersions = `SELECT version, COALESCE(name, ''), appliedAt
+							FROM schema_version ORDER BY version`
+	insertSchemaVersion = `INSERT INTO schema_version(version, name) VALUES($1, $2)`
+	deleteSchemaVersion = `DELETE FROM schema_version WHERE version = $1`
+
+	tryAdvisoryLock = `SELECT pg_try_advisory_lock($1)`
+	advisoryLock    = `SELECT pg_advisory_lock($1)`
+	advisoryUnlock  = `SELECT pg_advisory_unlock($1)`
+)
+
+// migration - миграция схемы бд из пары файлов up и down
+type migration struct {
+	version int64
+	name    string
+	up      string
+	down    string
+}
+
+// Migrator применяет и откатывает миграции схемы бд
+type Migrator struct {
+	pgxPool    *pgxpool.Pool
+	log        *logrus.Logger
+	migrations []migration
+}
+
+// NewMigrator подключается к бд для работы с миграциями, не применяя их
+func NewMigrator(ctx context.Context, log *logrus.Logger,
+	config model.Config) (*Migrator, error) {
+	ctxTimeout, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	pool, err := pgxpool.Connect(ctxTimeout, config.Database)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	migrator, err := newMigrator(pool, log)
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return migrator, nil
+}
+
+// newMigrator создает мигратор со встроенными миграциями на готовом пуле
+func newMigrator(pool *pgxpool.Pool, log *logrus.Logger) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return &Migrator{
+		pgxPool:    pool,
+		log:        log,
+		migrations: migrations,
+	}, nil
+}
+
+// loadMigrations читает миграции из каталога и упорядочивает их по версии.
+// У каждой версии должны быть оба файла, up и down
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		parts := migrationName.FindStringSubmatch(entry.Name())
+		if parts == nil {
+			return nil, fmt.Errorf("%w: %s", model.ErrInvalidMigration, entry.Name())
+		}
+		version, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("%w: %s", model.ErrInvalidMigration, entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: parts[2]}
+			byVersion[version] = m
+		}
+		if m.name != parts[2] {
+			return nil, fmt.Errorf("%w: version %d has names %s and %s",
+				model.ErrInvalidMigration, version, m.name, parts[2])
+		}
+		if parts[3] == "up" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("%w: version %d needs both up and down files",
+				model.ErrInvalidMigration, m.version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// Status возвращает известные миграции и миграции, примененные
+// более новой версией сервера, по возрастанию версии
+func (m *Migrator) Status(ctx context.Context) ([]model.Migration, error) {
+	var status []model.Migration
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			item := model.Migration{Version: migration.version, Name: migration.name}
+			if appliedItem, ok := applied[migration.version]; ok {
+				item = appliedItem
+				delete(applied, migration.version)
+			}
+			status = append(status, item)
+		}
+		// оставшиеся версии неизвестны этому серверу
+		for _, item := range applied {
+			item.Unknown = true
+			status = append(status, item)
+		}
+		return nil
+	})
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Version < status[j].Version
+	})
+	return status, err
+}
+
+// Up применяет все непримененные миграции по возрастанию версии, каждую
+// в своей транзакции, и возвращает количество примененных
+func (m *Migrator) Up(ctx context.Context) (int, error) {
+	var count int
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.checkKnown(applied); err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.version]; ok {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, migration.up, insertSchemaVersion,
+				migration.version, migration.name); err != nil {
+				return err
+			}
+			count++
+			m.log.WithFields(logrus.Fields{
+				"version": migration.version,
+				"name":    migration.name,
+			}).Info("Применили миграцию схемы бд")
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Down откатывает steps последних примененных миграций и возвращает
+// количество откаченных
+func (m *Migrator) Down(ctx context.Context, steps int) (int, error) {
+	var count int
+	err := m.withLock(ctx, func(conn *pgxpool.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+		// откатить миграцию без ее файла down нельзя
+		if err := m.checkKnown(applied); err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			return model.ErrNothingToRevert
+		}
+		for i := len(m.migrations) - 1; i >= 0 && count < steps; i-- {
+			migration := m.migrations[i]
+			if _, ok := applied[migration.version]; !ok {
+				continue
+			}
+			if err := m.run(ctx, conn, migration, migration.down, deleteSchemaVersion,
+				migration.version); err != nil {
+				return err
+			}
+			count++
+			m.log.WithFields(logrus.Fields{
+				"version": migration.version,
+				"name":    migration.name,
+			}).Warn("Откатили миграцию схемы бд")
+		}
+		return nil
+	})
+	return count, err
+}
+
+// Close закрывает соединения с бд
+func (m *Migrator) Close() {
+	m.pgxPool.Close()
+}
+
+// withLock выполняет f на отдельном соединении под рекомендательной
+// блокировкой миграций. Если блокировку держит другой экземпляр сервера,
+// ждет ее освобождения
+func (m *Migrator) withLock(ctx context.Context, f func(conn *pgxpool.Conn) error) error {
+	conn, err := m.pgxPool.Acquire(ctx)
+	if err != nil {
+		m.log.Error(err.Error())
+		return err
+	}
+	defer conn.Release()
+
+	var locked bool
+	if err = conn.QueryRow(ctx, tryAdvisoryLock, migrationLockID).Scan(&locked); err != nil {
+		m.log.Error(err.Error())
+		return err
+	}
+	if !locked {
+		m.log.Info("Миграции применяет другой экземпляр сервера, ждем")
+		if _, err = conn.Exec(ctx, advisoryLock, migrationLockID); err != nil {
+			m.log.Error(err.Error())
+			return err
+		}
+	}
+	defer func() {
+		// контекст мог быть отменен, а блокировку нужно снять в любом случае
+		if _, err := conn.Exec(context.Background(), advisoryUnlock, migrationLockID); err != nil {
+			m.log.Error(err.Error())
+			// блокировка снимается вместе с закрытием сессии
+			conn.Conn().Close(context.Background())
+		}
+	}()
+
+	if _, err = conn.Exec(ctx, createSchemaVersionTable); err != nil {
+		m.log.Error(err.Error())
+		return err
+	}
+	if err = f(conn); err != nil {
+		m.log.Error(err.Error())
+	}
+	return err
+}
+
+// applied возвращает примененные миграции по версии
+func (m *Migrator) applied(ctx context.Context,
+	conn *pgxpool.Conn) (map[int64]model.Migration, error) {
+	rows, err := conn.Query(ctx, selectSchemaVersions)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]model.Migration)
+	for rows.Next() {
+		item := model.Migration{Applied: true}
+		if err = rows.Scan(&item.Version, &item.Name, &item.AppliedAt); err != nil {
+			return nil, err
+		}
+		applied[item.Version] = item
+	}
+	return applied, rows.Err()
+}
+
+// checkKnown проверяет, что все примененные миграции известны серверу
+func (m *Migrator) checkKnown(applied map[int64]model.Migration) error {
+	known := make(map[int64]bool, len(m.migrations))
+	for _, migration := range m.migrations {
+		known[migration.version] = true
+	}
+	for version := range applied {
+		if !known[version] {
+			return fmt.Errorf("%w: version %d", model.ErrSchemaTooNew, version)
+		}
+	}
+	return nil
+}
+
+// run выполняет sql миграции и запрос к schema_version в одной транзакции
+func (m *Migrator) run(ctx context.Context, conn *pgxpool.Conn, migration migration,
+	sql string, versionSQL string, args ...interface{}) error {
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, sql); err != nil {
+		return fmt.Errorf("migration %d_%s: %w", migration.version, migration.name, err)
+	}
+	if _, err = tx.Exec(ctx, versionSQL, args...); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"keeper/internal/config"
+	"keeper/internal/logger"
+	"keeper/internal/model"
+	"testing"
+	"testing/fstest"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	sql := &fstest.MapFile{Data: []byte("SELECT 1;")}
+
+	tests := []struct {
+		name         string
+		files        fstest.MapFS
+		wantVersions []int64
+		wantErr      bool
+	}{
+		{
+			name: "Миграции упорядочены по версии",
+			files: fstest.MapFS{
+				"migrations/0010_b.up.sql":   sql,
+				"migrations/0010_b.down.sql": sql,
+				"migrations/0002_a.up.sql":   sql,
+				"migrations/0002_a.down.sql": sql,
+			},
+			wantVersions: []int64{2, 10},
+		},
+		{
+			name: "Нет файла down",
+			files: fstest.MapFS{
+				"migrations/0001_a.up.sql": sql,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Разные имена одной версии",
+			files: fstest.MapFS{
+				"migrations/0001_a.up.sql":   sql,
+				"migrations/0001_b.down.sql": sql,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Некорректное имя файла",
+			files: fstest.MapFS{
+				"migrations/users.sql": sql,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := loadMigrations(tt.files, "migrations")
+			if tt.wantErr {
+				assert.ErrorIs(t, err, model.ErrInvalidMigration)
+				return
+			}
+			require.NoError(t, err)
+			var versions []int64
+			for _, migration := range migrations {
+				versions = append(versions, migration.version)
+			}
+			assert.Equal(t, tt.wantVersions, versions)
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	migrations, err := loadMigrations(migrationFiles, "migrations")
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	// версии идут подряд, пропуск означает потерянный файл
+	for i, migration := range migrations {
+		assert.Equal(t, int64(i+1), migration.version, migration.name)
+	}
+}
+
+func TestMigratorUpDown(t *testing.T) {
+	ctx := context.Background()
+	log := logger.InitLog(logrus.InfoLevel)
+	cfg, err := config.GetConfig(log, config.Flags{})
+	require.NoError(t, err)
+	migrator, err := NewMigrator(ctx, log, cfg)
+	require.NoError(t, err)
+	defer migrator.Close()
+
+	_, err = migrator.Up(ctx)
+	require.NoError(t, err)
+
+	reverted, err := migrator.Down(ctx, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, reverted)
+
+	status, err := migrator.Status(ctx)
+	require.NoError(t, err)
+	require.Len(t, status, len(migrator.migrations))
+	assert.False(t, status[len(status)-1].Applied)
+
+	applied, err := migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, applied)
+
+	// повторный запуск ничего не применяет
+	applied, err = migrator.Up(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, applied)
+}
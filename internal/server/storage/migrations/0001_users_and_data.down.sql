@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS userKeys;
+DROP TABLE IF EXISTS dataTable;
+DROP TABLE IF EXISTS users;
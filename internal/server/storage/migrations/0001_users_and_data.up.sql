@@ -0,0 +1,22 @@
+-- Миграции написаны через IF NOT EXISTS: базы, созданные до появления
+-- миграций, принимают их без ошибок и без потери данных
+CREATE TABLE IF NOT EXISTS users(
+    login TEXT PRIMARY KEY,
+    password BYTEA
+);
+
+CREATE TABLE IF NOT EXISTS dataTable(
+    login TEXT,
+    dataKeyWord TEXT,
+    dataType TEXT,
+    data BYTEA,
+    metadata TEXT,
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login),
+    CONSTRAINT search_index UNIQUE (login, dataKeyWord)
+);
+
+CREATE TABLE IF NOT EXISTS userKeys(
+    login TEXT PRIMARY KEY,
+    dataKey BYTEA,
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
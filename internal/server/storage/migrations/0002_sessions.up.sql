@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS refreshTokens(
+    tokenHash TEXT PRIMARY KEY,
+    login TEXT,
+    expiresAt TIMESTAMPTZ,
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
+
+ALTER TABLE refreshTokens ADD COLUMN IF NOT EXISTS sessionID TEXT;
+
+CREATE TABLE IF NOT EXISTS sessions(
+    jti TEXT PRIMARY KEY,
+    login TEXT,
+    expiresAt TIMESTAMPTZ,
+    revokedAt TIMESTAMPTZ,
+    createdAt TIMESTAMPTZ DEFAULT now(),
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
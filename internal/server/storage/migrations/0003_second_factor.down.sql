@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS authChallenges;
+DROP TABLE IF EXISTS recoveryCodes;
+DROP TABLE IF EXISTS userTOTP;
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS userTOTP(
+    login TEXT PRIMARY KEY,
+    secret BYTEA,
+    confirmed BOOLEAN DEFAULT false,
+    lastStep BIGINT DEFAULT 0,
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
+
+CREATE TABLE IF NOT EXISTS recoveryCodes(
+    login TEXT,
+    codeHash TEXT,
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login),
+    CONSTRAINT recovery_code UNIQUE (login, codeHash)
+);
+
+CREATE TABLE IF NOT EXISTS authChallenges(
+    challengeHash TEXT PRIMARY KEY,
+    login TEXT,
+    expiresAt TIMESTAMPTZ,
+    attempts INT DEFAULT 0,
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS keyRotation(
+    rotationID TEXT PRIMARY KEY,
+    phase TEXT,
+    lastLogin TEXT,
+    lastKeyWord TEXT,
+    processed BIGINT,
+    startedAt TIMESTAMPTZ DEFAULT now(),
+    updatedAt TIMESTAMPTZ DEFAULT now()
+);
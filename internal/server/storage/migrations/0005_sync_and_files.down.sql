@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS fileChunks;
+DROP TABLE IF EXISTS fileUploads;
+DROP TABLE IF EXISTS deletedData;
+
+ALTER TABLE dataTable
+    DROP COLUMN IF EXISTS device,
+    DROP COLUMN IF EXISTS version,
+    DROP COLUMN IF EXISTS changeSeq,
+    DROP COLUMN IF EXISTS updatedAt,
+    DROP COLUMN IF EXISTS createdAt,
+    DROP COLUMN IF EXISTS fileID;
+
+DROP SEQUENCE IF EXISTS dataChangeSeq;
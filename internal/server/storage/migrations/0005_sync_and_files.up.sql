@@ -0,0 +1,38 @@
+-- dataChangeSeq нумерует изменения записей для синхронизации клиентов
+CREATE SEQUENCE IF NOT EXISTS dataChangeSeq;
+
+ALTER TABLE dataTable
+    ADD COLUMN IF NOT EXISTS fileID TEXT,
+    ADD COLUMN IF NOT EXISTS createdAt TIMESTAMPTZ DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS updatedAt TIMESTAMPTZ DEFAULT now(),
+    ADD COLUMN IF NOT EXISTS changeSeq BIGINT DEFAULT nextval('dataChangeSeq'),
+    ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1,
+    ADD COLUMN IF NOT EXISTS device TEXT;
+
+-- deletedData хранит сведения об удаленных записях, чтобы клиенты
+-- удалили их из локальной копии
+CREATE TABLE IF NOT EXISTS deletedData(
+    login TEXT,
+    dataKeyWord TEXT,
+    changeSeq BIGINT,
+    deletedAt TIMESTAMPTZ DEFAULT now(),
+    PRIMARY KEY (login, dataKeyWord),
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
+
+CREATE TABLE IF NOT EXISTS fileUploads(
+    fileID TEXT PRIMARY KEY,
+    login TEXT,
+    completed BOOLEAN DEFAULT false,
+    createdAt TIMESTAMPTZ DEFAULT now(),
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
+
+CREATE TABLE IF NOT EXISTS fileChunks(
+    fileID TEXT,
+    seq BIGINT,
+    data BYTEA,
+    PRIMARY KEY (fileID, seq),
+    CONSTRAINT fk_file FOREIGN KEY (fileID) REFERENCES fileUploads(fileID)
+    ON DELETE CASCADE
+);
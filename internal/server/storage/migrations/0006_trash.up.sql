@@ -0,0 +1,16 @@
+-- dataTrash хранит удаленные записи до очистки корзины
+CREATE TABLE IF NOT EXISTS dataTrash(
+    trashID BIGSERIAL PRIMARY KEY,
+    login TEXT,
+    dataKeyWord TEXT,
+    dataType TEXT,
+    data BYTEA,
+    metadata TEXT,
+    fileID TEXT,
+    device TEXT,
+    createdAt TIMESTAMPTZ,
+    updatedAt TIMESTAMPTZ,
+    version BIGINT,
+    deletedAt TIMESTAMPTZ DEFAULT now(),
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
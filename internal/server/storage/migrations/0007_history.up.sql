@@ -0,0 +1,19 @@
+-- dataHistory хранит прежние версии записей, замененные при изменении
+-- или удалении записи
+CREATE TABLE IF NOT EXISTS dataHistory(
+    revisionID BIGSERIAL PRIMARY KEY,
+    login TEXT,
+    dataKeyWord TEXT,
+    version BIGINT,
+    dataType TEXT,
+    data BYTEA,
+    metadata TEXT,
+    device TEXT,
+    createdAt TIMESTAMPTZ,
+    replacedAt TIMESTAMPTZ DEFAULT now(),
+    deleted BOOLEAN DEFAULT false,
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
+
+CREATE INDEX IF NOT EXISTS dataHistory_record
+    ON dataHistory(login, dataKeyWord, revisionID);
@@ -0,0 +1,19 @@
+-- dataConflicts хранит изменения клиентов, сделанные на основе устаревшей
+-- версии записи, вместе с этой версией
+CREATE TABLE IF NOT EXISTS dataConflicts(
+    conflictID BIGSERIAL PRIMARY KEY,
+    login TEXT,
+    dataKeyWord TEXT,
+    baseVersion BIGINT,
+    baseDataType TEXT,
+    baseData BYTEA,
+    baseMetadata TEXT,
+    dataType TEXT,
+    data BYTEA,
+    metadata TEXT,
+    deleted BOOLEAN DEFAULT false,
+    createdAt TIMESTAMPTZ DEFAULT now(),
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
+
+ALTER TABLE dataConflicts ADD COLUMN IF NOT EXISTS device TEXT;
@@ -0,0 +1,6 @@
+DROP TRIGGER IF EXISTS deletedDataChangeXid ON deletedData;
+DROP TRIGGER IF EXISTS dataTableChangeXid ON dataTable;
+DROP FUNCTION IF EXISTS setChangeXid();
+
+ALTER TABLE deletedData DROP COLUMN IF EXISTS changeXid;
+ALTER TABLE dataTable DROP COLUMN IF EXISTS changeXid;
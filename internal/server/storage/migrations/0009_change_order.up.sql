@@ -0,0 +1,21 @@
+-- changeXid - транзакция, в которой запись изменена последний раз.
+-- changeSeq выдается при выполнении запроса, а не при фиксации транзакции,
+-- поэтому изменения выдаются клиентам в порядке транзакций и только
+-- после завершения всех более ранних транзакций
+ALTER TABLE dataTable ADD COLUMN IF NOT EXISTS changeXid xid8 NOT NULL DEFAULT '0';
+ALTER TABLE deletedData ADD COLUMN IF NOT EXISTS changeXid xid8 NOT NULL DEFAULT '0';
+
+CREATE OR REPLACE FUNCTION setChangeXid() RETURNS trigger AS $$
+BEGIN
+    NEW.changeXid := pg_current_xact_id();
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS dataTableChangeXid ON dataTable;
+CREATE TRIGGER dataTableChangeXid BEFORE INSERT OR UPDATE OF changeSeq ON dataTable
+    FOR EACH ROW EXECUTE FUNCTION setChangeXid();
+
+DROP TRIGGER IF EXISTS deletedDataChangeXid ON deletedData;
+CREATE TRIGGER deletedDataChangeXid BEFORE INSERT OR UPDATE OF changeSeq ON deletedData
+    FOR EACH ROW EXECUTE FUNCTION setChangeXid();
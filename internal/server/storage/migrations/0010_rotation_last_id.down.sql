@@ -0,0 +1 @@
+ALTER TABLE keyRotation DROP COLUMN IF EXISTS lastID;
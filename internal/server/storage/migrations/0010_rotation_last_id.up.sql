@@ -0,0 +1,3 @@
+-- lastID - последняя обработанная строка таблиц с числовым ключом,
+-- например dataHistory
+ALTER TABLE keyRotation ADD COLUMN IF NOT EXISTS lastID BIGINT NOT NULL DEFAULT 0;
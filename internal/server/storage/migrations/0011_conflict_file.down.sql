@@ -0,0 +1 @@
+ALTER TABLE dataConflicts DROP COLUMN IF EXISTS fileID;
@@ -0,0 +1,3 @@
+-- fileID - файл записи на сервере в момент конфликта. Запись, сохраненная
+-- под новым ключом, ссылается на тот же файл
+ALTER TABLE dataConflicts ADD COLUMN IF NOT EXISTS fileID TEXT;
@@ -0,0 +1,9 @@
+-- vaultChecks хранит проверочное значение хранилища пользователя,
+-- зашифрованное ключом мастер-пароля на клиенте. Сервер не может его
+-- расшифровать и только отдает клиенту для проверки мастер-пароля
+CREATE TABLE IF NOT EXISTS vaultChecks(
+    login TEXT PRIMARY KEY,
+    data BYTEA NOT NULL,
+    createdAt TIMESTAMPTZ DEFAULT now(),
+    CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login)
+);
@@ -0,0 +1 @@
+ALTER TABLE dataConflicts ADD COLUMN IF NOT EXISTS fileID TEXT;
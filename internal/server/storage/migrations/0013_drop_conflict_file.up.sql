@@ -0,0 +1,3 @@
+-- запись, сохраненная под новым ключом при разрешении конфликта, больше
+-- не ссылается на файл исходной записи
+ALTER TABLE dataConflicts DROP COLUMN IF EXISTS fileID;
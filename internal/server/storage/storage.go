@@ -2,12 +2,14 @@ package storage
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"keeper/internal/model"
 	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/sirupsen/logrus"
 )
@@ -17,33 +19,346 @@ type storage struct {
 	log     *logrus.Logger
 }
 
+// querier - общий для пула соединений и транзакции метод запроса
+type querier interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
 var (
-	createUsersTable = `CREATE TABLE IF NOT EXISTS users(
-						login TEXT PRIMARY KEY,
-						password BYTEA
-					    );`
 	insertUser     = `INSERT INTO users(login, password) VALUES($1, $2)`
 	selectPassword = `SELECT password FROM users WHERE login = $1`
+	updatePassword = `UPDATE users SET password = $1 WHERE login = $2`
+
+	insertUserKey = `INSERT INTO userKeys(login, dataKey) VALUES($1, $2)
+					 ON CONFLICT (login) DO NOTHING`
+	selectUserKey = `SELECT dataKey FROM userKeys WHERE login = $1`
+
+	insertVaultCheck = `INSERT INTO vaultChecks(login, data) VALUES($1, $2)
+						ON CONFLICT (login) DO NOTHING`
+	selectVaultCheck = `SELECT data FROM vaultChecks WHERE login = $1`
+
+	insertRefreshToken = `INSERT INTO refreshTokens(tokenHash, login, sessionID, expiresAt)
+						  VALUES($1, $2, $3, $4)`
+	deleteRefreshToken = `DELETE FROM refreshTokens WHERE tokenHash = $1
+						  RETURNING login, COALESCE(sessionID, ''), expiresAt`
+	deleteSessionRefreshTokens = `DELETE FROM refreshTokens WHERE sessionID = $1`
+	deleteUserRefreshTokens    = `DELETE FROM refreshTokens WHERE login = $1`
+
+	insertSession       = `INSERT INTO sessions(jti, login, expiresAt) VALUES($1, $2, $3)`
+	selectSessionActive = `SELECT revokedAt IS NULL AND expiresAt > now() FROM sessions WHERE jti = $1`
+	revokeSession       = `UPDATE sessions SET revokedAt = now()
+						    WHERE jti = $1 AND login = $2 AND revokedAt IS NULL`
+	revokeUserSessions = `UPDATE sessions SET revokedAt = now()
+						    WHERE login = $1 AND revokedAt IS NULL AND expiresAt > now()`
+	deleteExpiredSession = `DELETE FROM sessions WHERE login = $1 AND expiresAt < now()`
+
+	upsertTOTP = `INSERT INTO userTOTP(login, secret) VALUES($1, $2)
+				  ON CONFLICT (login) DO UPDATE SET secret = $2, lastStep = 0
+				  WHERE userTOTP.confirmed = false`
+	selectTOTP  = `SELECT secret, confirmed, lastStep FROM userTOTP WHERE login = $1`
+	confirmTOTP = `UPDATE userTOTP SET confirmed = true, lastStep = $2
+				   WHERE login = $1 AND confirmed = false AND lastStep < $2`
+	useTOTPStep = `UPDATE userTOTP SET lastStep = $2
+				   WHERE login = $1 AND confirmed = true AND lastStep < $2`
+
+	insertRecoveryCode      = `INSERT INTO recoveryCodes(login, codeHash) VALUES($1, $2)`
+	deleteRecoveryCode      = `DELETE FROM recoveryCodes WHERE login = $1 AND codeHash = $2`
+	deleteUserRecoveryCodes = `DELETE FROM recoveryCodes WHERE login = $1`
 
-	createDataTable = `CREATE TABLE IF NOT EXISTS dataTable(
-						login TEXT,
-						dataKeyWord TEXT,
-						dataType TEXT,
-						data BYTEA,
-						metadata TEXT,
-						CONSTRAINT fk_login FOREIGN KEY (login) REFERENCES users(login),
-       				    CONSTRAINT search_index UNIQUE (login, dataKeyWord)  
-						)`
-	insertData = `INSERT INTO dataTable(login, dataKeyWord, dataType, data, metadata)
-				  VALUES($1, $2, $3, $4, $5)`
-	selectData = `SELECT dataKeyWord, dataType, data, metadata 
+	insertChallenge = `INSERT INTO authChallenges(challengeHash, login, expiresAt)
+					   VALUES($1, $2, $3)`
+	countChallengeAttempt = `UPDATE authChallenges SET attempts = attempts + 1
+							 WHERE challengeHash = $1
+							 RETURNING login, expiresAt, attempts`
+	deleteChallenge         = `DELETE FROM authChallenges WHERE challengeHash = $1`
+	deleteExpiredChallenges = `DELETE FROM authChallenges WHERE expiresAt < now()`
+
+	selectKeyRotation = `SELECT phase, lastLogin, lastKeyWord, lastID, processed
+						 FROM keyRotation WHERE rotationID = $1`
+	upsertKeyRotation = `INSERT INTO keyRotation(rotationID, phase, lastLogin, lastKeyWord,
+						 lastID, processed)
+						 VALUES($1, $2, $3, $4, $5, $6)
+						 ON CONFLICT (rotationID) DO UPDATE
+						 SET phase = $2, lastLogin = $3, lastKeyWord = $4, lastID = $5,
+						 processed = $6, updatedAt = now()`
+	selectUserKeysBatch = `SELECT login, dataKey FROM userKeys
+						   WHERE login > $1 ORDER BY login LIMIT $2 FOR UPDATE`
+	updateUserKey   = `UPDATE userKeys SET dataKey = $1 WHERE login = $2`
+	selectDataBatch = `SELECT login, dataKeyWord, data FROM dataTable
+					   WHERE (login, dataKeyWord) > ($1, $2)
+					   ORDER BY login, dataKeyWord LIMIT $3 FOR UPDATE`
+	selectHistoryBatch = `SELECT revisionID, login, dataKeyWord, data FROM dataHistory
+						  WHERE revisionID > $1 AND data IS NOT NULL
+						  ORDER BY revisionID LIMIT $2 FOR UPDATE`
+	updateHistoryCipherData = `UPDATE dataHistory SET data = $1 WHERE revisionID = $2`
+	selectTrashBatch        = `SELECT trashID, login, dataKeyWord, data FROM dataTrash
+							   WHERE trashID > $1 AND data IS NOT NULL
+							   ORDER BY trashID LIMIT $2 FOR UPDATE`
+	updateTrashCipherData = `UPDATE dataTrash SET data = $1 WHERE trashID = $2`
+	selectConflictsBatch  = `SELECT conflictID, login, dataKeyWord, baseData, data
+							 FROM dataConflicts
+							 WHERE conflictID > $1 AND (baseData IS NOT NULL OR data IS NOT NULL)
+							 ORDER BY conflictID LIMIT $2 FOR UPDATE`
+	updateConflictCipherData = `UPDATE dataConflicts SET baseData = $1, data = $2
+								WHERE conflictID = $3`
+
+	insertFileUpload   = `INSERT INTO fileUploads(fileID, login) VALUES($1, $2)`
+	completeFileUpload = `UPDATE fileUploads SET completed = true WHERE fileID = $1`
+	deleteFileUpload   = `DELETE FROM fileUploads WHERE fileID = $1`
+	deleteStaleUploads = `DELETE FROM fileUploads WHERE completed = false AND createdAt < $1`
+	insertFileChunk    = `INSERT INTO fileChunks(fileID, seq, data) VALUES($1, $2, $3)`
+	selectFileChunks   = `SELECT seq, data FROM fileChunks WHERE fileID = $1 ORDER BY seq`
+	insertFileData     = `INSERT INTO dataTable(login, dataKeyWord, dataType, data, metadata, fileID,
+						   device)
+						   VALUES($1, $2, $3, $4, $5, $6, $7)`
+
+	insertData = `INSERT INTO dataTable(login, dataKeyWord, dataType, data, metadata, device)
+				  VALUES($1, $2, $3, $4, $5, $6)`
+	selectData = `SELECT dataKeyWord, dataType, data, metadata, COALESCE(fileID, ''), version
 				  FROM dataTable
 				  WHERE login = $1 AND dataKeyWord = $2`
-	updateData = `UPDATE dataTable SET data = $1, metadata = $2
-				  WHERE login = $3 AND dataKeyWord = $4`
-	deleteData = `DELETE FROM dataTable WHERE login = $1 AND dataKeyWord = $2`
+	// updateData изменяет запись, если ее версия совпадает с $6 (0 - без проверки),
+	// и возвращает новую версию, версию записи до изменения и признак файла.
+	// Если запись не изменена, по второй версии понятно, есть ли она вообще.
+	// Запись с файлом не изменяется, прежняя версия остальных записей
+	// сохраняется в истории
+	updateData = `WITH previous AS (
+					SELECT login, dataKeyWord, dataType, data, metadata, fileID, device,
+					updatedAt, version
+					FROM dataTable WHERE login = $3 AND dataKeyWord = $4 FOR UPDATE
+				  ), updated AS (
+					UPDATE dataTable d SET data = $1, metadata = $2, dataType = $5, device = $7,
+					updatedAt = now(), changeSeq = nextval('dataChangeSeq'), version = d.version + 1
+					FROM previous p
+					WHERE d.login = p.login AND d.dataKeyWord = p.dataKeyWord
+					AND ($6::BIGINT = 0 OR p.version = $6) AND p.fileID IS NULL
+					RETURNING d.version
+				  ), history AS (
+					INSERT INTO dataHistory(login, dataKeyWord, version, dataType, data, metadata,
+					device, createdAt)
+					SELECT login, dataKeyWord, version, dataType, data, metadata, device, updatedAt
+					FROM previous
+					WHERE EXISTS (SELECT 1 FROM updated)
+				  )
+				  SELECT (SELECT version FROM updated), (SELECT version FROM previous),
+				  COALESCE((SELECT fileID IS NOT NULL FROM previous), false)`
+	// insertDataIfAbsent добавляет запись, только если ее еще нет, и возвращает
+	// версию добавленной записи и версию уже существующей
+	insertDataIfAbsent = `WITH inserted AS (
+					INSERT INTO dataTable(login, dataKeyWord, dataType, data, metadata, device,
+					fileID)
+					VALUES($1, $2, $3, $4, $5, $6, NULLIF($7, ''))
+					ON CONFLICT (login, dataKeyWord) DO NOTHING
+					RETURNING version
+				  )
+				  SELECT (SELECT version FROM inserted),
+				  (SELECT version FROM dataTable WHERE login = $1 AND dataKeyWord = $2)`
+	selectDataVersion = `SELECT version FROM dataTable WHERE login = $1 AND dataKeyWord = $2`
+	// deleteData перемещает запись в корзину, если ее версия совпадает с $3
+	// (0 - без проверки). Части загруженного файла остаются до очистки корзины,
+	// а удаление записи отмечается для синхронизации. Удаленная запись, кроме файлов,
+	// сохраняется в истории. Возвращает количество удаленных записей и версию
+	// записи до удаления
+	deleteData = `WITH deleted AS (
+					DELETE FROM dataTable WHERE login = $1 AND dataKeyWord = $2
+					AND ($3::BIGINT = 0 OR version = $3)
+					RETURNING login, dataKeyWord, dataType, data, metadata, fileID, device,
+					createdAt, updatedAt, version
+				  ), trash AS (
+					INSERT INTO dataTrash(login, dataKeyWord, dataType, data, metadata, fileID,
+					device, createdAt, updatedAt, version)
+					SELECT login, dataKeyWord, dataType, data, metadata, fileID, device,
+					createdAt, updatedAt, version
+					FROM deleted
+				  ), history AS (
+					INSERT INTO dataHistory(login, dataKeyWord, version, dataType, data, metadata,
+					device, createdAt, deleted)
+					SELECT login, dataKeyWord, version, dataType, data, metadata, device, updatedAt,
+					true
+					FROM deleted WHERE fileID IS NULL
+				  ), tombstone AS (
+					INSERT INTO deletedData(login, dataKeyWord, changeSeq)
+					SELECT login, dataKeyWord, nextval('dataChangeSeq') FROM deleted
+					ON CONFLICT (login, dataKeyWord) DO UPDATE
+					SET changeSeq = EXCLUDED.changeSeq, deletedAt = now()
+				  )
+				  SELECT (SELECT count(*) FROM deleted),
+				  (SELECT version FROM dataTable WHERE login = $1 AND dataKeyWord = $2)`
+
+	selectTrash = `SELECT d.trashID, d.dataKeyWord, COALESCE(d.dataType, ''),
+				   COALESCE(d.metadata, ''), COALESCE(d.createdAt, d.deletedAt),
+				   COALESCE(d.updatedAt, d.deletedAt), ` + dataSize + `, d.version, d.deletedAt
+				   FROM dataTrash d WHERE d.login = $1
+				   ORDER BY d.deletedAt DESC, d.trashID DESC`
+	selectTrashForUpdate = `SELECT dataKeyWord FROM dataTrash
+							WHERE trashID = $1 AND login = $2 FOR UPDATE`
+	// restoreFromTrash возвращает запись из корзины, если ключ свободен.
+	// Версия записи продолжает расти с версии до удаления
+	restoreFromTrash = `INSERT INTO dataTable(login, dataKeyWord, dataType, data, metadata, fileID,
+						device, createdAt, version)
+						SELECT login, dataKeyWord, dataType, data, metadata, fileID, $3,
+						COALESCE(createdAt, now()), COALESCE(version, 0) + 1
+						FROM dataTrash WHERE trashID = $1 AND login = $2
+						ON CONFLICT (login, dataKeyWord) DO NOTHING
+						RETURNING version`
+	deleteFromTrash = `DELETE FROM dataTrash WHERE trashID = $1`
+	// purgeTrash навсегда удаляет записи из корзины пользователя $1 (пустой - всех
+	// пользователей), удаленные раньше $2 (NULL - все). Вместе с записью удаляются
+	// части файла и история, если ключ больше не занят
+	// purgeTrash удаляет файл записи, только если на него не ссылаются другие
+	// записи пользователя или корзины
+	purgeTrash = `WITH purged AS (
+					DELETE FROM dataTrash WHERE ($1 = '' OR login = $1)
+					AND ($2::TIMESTAMPTZ IS NULL OR deletedAt < $2)
+					RETURNING trashID, login, dataKeyWord, fileID
+				  ), uploads AS (
+					DELETE FROM fileUploads f WHERE f.fileID IN (SELECT fileID FROM purged)
+					AND NOT EXISTS (SELECT 1 FROM dataTable d WHERE d.fileID = f.fileID)
+					AND NOT EXISTS (SELECT 1 FROM dataTrash t WHERE t.fileID = f.fileID
+					AND t.trashID NOT IN (SELECT trashID FROM purged))
+				  ), history AS (
+					DELETE FROM dataHistory h USING purged p
+					WHERE h.login = p.login AND h.dataKeyWord = p.dataKeyWord
+					AND NOT EXISTS (SELECT 1 FROM dataTable d
+					WHERE d.login = p.login AND d.dataKeyWord = p.dataKeyWord)
+				  )
+				  SELECT count(*) FROM purged`
+
+	selectHistory = `SELECT revisionID, version, COALESCE(dataType, ''), data,
+					 COALESCE(metadata, ''), COALESCE(device, ''), COALESCE(createdAt, replacedAt),
+					 replacedAt, deleted
+					 FROM dataHistory WHERE login = $1 AND dataKeyWord = $2
+					 ORDER BY revisionID DESC`
+	selectRevision = `SELECT dataKeyWord, COALESCE(dataType, ''), data, COALESCE(metadata, '')
+					  FROM dataHistory WHERE revisionID = $1 AND login = $2`
+	// pruneHistory удаляет версии, не входящие в $1 последних версий записи
+	// (0 - без ограничения), и версии, замененные раньше $2 (NULL - без ограничения)
+	pruneHistory = `DELETE FROM dataHistory WHERE revisionID IN (
+					SELECT revisionID FROM (
+						SELECT revisionID, replacedAt, row_number() OVER (
+						PARTITION BY login, dataKeyWord ORDER BY revisionID DESC) AS n
+						FROM dataHistory
+					) revisions
+					WHERE ($1::INT > 0 AND n > $1)
+					OR ($2::TIMESTAMPTZ IS NOT NULL AND replacedAt < $2)
+				   )`
+
+	insertConflict = `INSERT INTO dataConflicts(login, dataKeyWord, baseVersion, baseDataType,
+					  baseData, baseMetadata, dataType, data, metadata, deleted, device)
+					  VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	// selectConflicts выбирает конфликты вместе с текущей записью на сервере
+	selectConflicts = `SELECT c.conflictID, c.dataKeyWord, c.baseVersion, c.baseData IS NOT NULL,
+					  COALESCE(c.baseDataType, ''), c.baseData, COALESCE(c.baseMetadata, ''),
+					  COALESCE(c.dataType, ''), c.data, COALESCE(c.metadata, ''), c.deleted,
+					  c.createdAt, COALESCE(d.dataType, ''), d.data, COALESCE(d.metadata, ''),
+					  d.version
+					  FROM dataConflicts c
+					  LEFT JOIN dataTable d ON d.login = c.login AND d.dataKeyWord = c.dataKeyWord
+					  WHERE c.login = $1 AND ($2 = '' OR c.dataKeyWord = $2)
+					  ORDER BY c.conflictID`
+	selectConflictForUpdate = `SELECT dataKeyWord, COALESCE(dataType, ''), data,
+					  COALESCE(metadata, ''), deleted, COALESCE(device, '')
+					  FROM dataConflicts WHERE conflictID = $1 AND login = $2 FOR UPDATE`
+	deleteConflict = `DELETE FROM dataConflicts WHERE conflictID = $1`
+
+	// dataSize - размер хранимых данных записи. Размер файла
+	// складывается из размеров его частей
+	dataSize = `(COALESCE(octet_length(d.data), 0) + COALESCE((SELECT SUM(octet_length(c.data))
+						FROM fileChunks c WHERE c.fileID = d.fileID), 0))::BIGINT`
+
+	// selectDataHeaders выбирает заголовки записей без содержимого.
+	// Условие страницы и сортировка добавляются по полю из listSortColumns
+	selectDataHeaders = `SELECT dataKeyWord, dataType, metadata, createdAt, updatedAt, size, version
+				  FROM (
+					SELECT d.dataKeyWord, COALESCE(d.dataType, '') AS dataType,
+					COALESCE(d.metadata, '') AS metadata, d.createdAt, d.updatedAt,
+					` + dataSize + ` AS size, d.version
+					FROM dataTable d
+					WHERE d.login = $1
+				  ) headers
+				  WHERE ($2 = '' OR dataType = $2) AND ($3 = '' OR starts_with(dataKeyWord, $3))`
+	selectDataHeadersAfter = ` AND (%[1]s, dataKeyWord) %[2]s ($5::%[3]s, $6)`
+	selectDataHeadersOrder = ` ORDER BY %[1]s %[2]s, dataKeyWord %[2]s LIMIT $4`
+
+	// selectChanges выбирает измененные и удаленные записи пользователя
+	// после позиции ($2, $3) в порядке фиксации. Изменения транзакций,
+	// начатых не раньше самой старой незавершенной, не выбираются: после
+	// фиксации они могут оказаться раньше уже выданных
+	selectChanges = `SELECT dataKeyWord, dataType, data, metadata, createdAt, updatedAt, size,
+				  version, changeXid, changeSeq, deleted
+				  FROM (
+					SELECT d.dataKeyWord, COALESCE(d.dataType, '') AS dataType, d.data,
+					COALESCE(d.metadata, '') AS metadata, d.createdAt, d.updatedAt,
+					` + dataSize + ` AS size, d.version, d.changeXid::text::bigint AS changeXid,
+					d.changeSeq, false AS deleted
+					FROM dataTable d
+					WHERE d.login = $1 AND d.changeXid < pg_snapshot_xmin(pg_current_snapshot())
+					UNION ALL
+					SELECT dataKeyWord, '', NULL, '', deletedAt, deletedAt, 0, 0,
+					changeXid::text::bigint, changeSeq, true
+					FROM deletedData
+					WHERE login = $1 AND changeXid < pg_snapshot_xmin(pg_current_snapshot())
+				  ) changes
+				  WHERE (changeXid, changeSeq) > ($2, $3)
+				  ORDER BY changeXid, changeSeq LIMIT $4`
+
+	updateCipherData = `UPDATE dataTable SET data = $1 WHERE login = $2 AND dataKeyWord = $3`
 )
 
+// listSortColumn - колонка для сортировки списка записей и ее тип
+type listSortColumn struct {
+	name    string
+	sqlType string
+	value   func(header model.DataHeader) interface{}
+}
+
+// listSortColumns - поля, по которым разрешена сортировка. Имена колонок
+// подставляются в запрос только из этого списка
+var listSortColumns = map[string]listSortColumn{
+	model.SortByKey: {"dataKeyWord", "TEXT",
+		func(header model.DataHeader) interface{} { return header.DataKeyWord }},
+	model.SortByType: {"dataType", "TEXT",
+		func(header model.DataHeader) interface{} { return header.DataType }},
+	model.SortByCreated: {"createdAt", "TIMESTAMPTZ",
+		func(header model.DataHeader) interface{} { return header.CreatedAt }},
+	model.SortByUpdated: {"updatedAt", "TIMESTAMPTZ",
+		func(header model.DataHeader) interface{} { return header.UpdatedAt }},
+	model.SortBySize: {"size", "BIGINT",
+		func(header model.DataHeader) interface{} { return header.Size }},
+}
+
+// isViolation проверяет, что Postgres отклонил запрос из-за нарушения
+// ограничения с кодом code
+func isViolation(err error, code string) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == code
+}
+
+// storageError оборачивает в model.ErrStorageBusy временные ошибки
+// Postgres, после которых запрос можно повторить. Остальные ошибки
+// возвращаются без изменений
+func storageError(err error) error {
+	if err == nil || errors.Is(err, model.ErrStorageBusy) || !isTransient(err) {
+		return err
+	}
+	return fmt.Errorf("%w: %w", model.ErrStorageBusy, err)
+}
+
+// isTransient проверяет, что Postgres недоступен, перегружен или отменил
+// транзакцию из-за конфликта с другой транзакцией
+func isTransient(err error) bool {
+	if pgconn.Timeout(err) || pgconn.SafeToRetry(err) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgerrcode.IsTransactionRollback(pgErr.Code) ||
+		pgerrcode.IsInsufficientResources(pgErr.Code) ||
+		pgerrcode.IsConnectionException(pgErr.Code) || pgErr.Code == pgerrcode.CannotConnectNow
+}
+
 // NewStorage инициализирует пул соединений с базой данных
 func NewStorage(ctx context.Context, log *logrus.Logger,
 	config model.Config) (
@@ -55,15 +370,24 @@ func NewStorage(ctx context.Context, log *logrus.Logger,
 	pool, err := pgxpool.Connect(ctxTimeout, config.Database)
 	if err != nil {
 		log.Error(err.Error())
-		return nil, err
+		return nil, storageError(err)
 	}
 
-	err = InitTable(ctxTimeout, pool)
+	migrator, err := newMigrator(pool, log)
 	if err != nil {
-		log.Error(err.Error())
-		return nil, err
+		pool.Close()
+		return nil, storageError(err)
+	}
+	// миграции могут ждать другой экземпляр сервера, таймаут подключения
+	// на них не распространяется
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		pool.Close()
+		return nil, storageError(err)
 	}
-	log.Debug("Запустили соединение с Postgres, инициализировали таблицы")
+	log.WithFields(logrus.Fields{
+		"applied": applied,
+	}).Debug("Запустили соединение с Postgres, применили миграции схемы бд")
 
 	return &storage{
 		pgxPool: pool,
@@ -71,62 +395,345 @@ func NewStorage(ctx context.Context, log *logrus.Logger,
 	}, nil
 }
 
-// InitTable создает таблицы в бд, если они не существуют
-func InitTable(ctx context.Context, pool *pgxpool.Pool) error {
-	if _, err := pool.Exec(ctx, createUsersTable); err != nil {
-		return err
+// AddUser добавляет нового пользователя в бд. Если логин занят,
+// возвращает model.ErrUserAlreadyExists
+func (s *storage) AddUser(ctx context.Context, login string,
+	passwordHash string) error {
+
+	_, err := s.pgxPool.Exec(ctx, insertUser, login, []byte(passwordHash))
+	if err != nil {
+		s.log.Error(err.Error())
+		if isViolation(err, pgerrcode.UniqueViolation) {
+			return model.ErrUserAlreadyExists
+		}
+		return storageError(err)
 	}
-	_, err := pool.Exec(ctx, createDataTable)
-	return err
+	return nil
 }
 
-// AddUser добавляет нового пользователя в бд
-func (s *storage) AddUser(ctx context.Context, login string,
-	password [32]byte) error {
+// GetPasswordHash возвращает сохраненный хэш пароля пользователя
+func (s *storage) GetPasswordHash(ctx context.Context, login string) (string, error) {
+	s.log.Debug("Проверяем наличие пользователя в бд")
+	var passwordHash []byte
 
-	// Convert the byte slice to hexadecimal format
-	hexEncodedPassword := fmt.Sprintf("\\x%x", password)
+	row := s.pgxPool.QueryRow(ctx, selectPassword, login)
+	err := row.Scan(&passwordHash)
+	if err != nil {
+		s.log.Error(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", model.ErrUserNotFound
+		}
+		return "", storageError(err)
+	}
+	return string(passwordHash), nil
+}
+
+// UpdatePasswordHash заменяет хэш пароля пользователя
+func (s *storage) UpdatePasswordHash(ctx context.Context, login string,
+	passwordHash string) error {
 
-	_, err := s.pgxPool.Exec(ctx, insertUser, login, hexEncodedPassword)
+	_, err := s.pgxPool.Exec(ctx, updatePassword, []byte(passwordHash), login)
 	if err != nil {
 		s.log.Error(err.Error())
-		return err
+	}
+	return storageError(err)
+}
+
+// AddUserKey сохраняет обернутый ключ данных пользователя.
+// Если ключ уже существует, он не перезаписывается
+func (s *storage) AddUserKey(ctx context.Context, login string, wrappedKey []byte) error {
+	_, err := s.pgxPool.Exec(ctx, insertUserKey, login, wrappedKey)
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return storageError(err)
+}
+
+// GetUserKey возвращает обернутый ключ данных пользователя
+func (s *storage) GetUserKey(ctx context.Context, login string) ([]byte, error) {
+	var wrappedKey []byte
+	err := s.pgxPool.QueryRow(ctx, selectUserKey, login).Scan(&wrappedKey)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, model.ErrNoRowsSelected
+		}
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	return wrappedKey, nil
+}
+
+// SetVaultCheck сохраняет проверочное значение хранилища пользователя.
+// Сохраненное значение не перезаписывается, в этом случае возвращается
+// model.ErrVaultCheckExists
+func (s *storage) SetVaultCheck(ctx context.Context, login string, check []byte) error {
+	tag, err := s.pgxPool.Exec(ctx, insertVaultCheck, login, check)
+	if err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrVaultCheckExists
 	}
 	return nil
 }
 
-// CheckUserAuth проверяет логин и хэш пароля пользователя по таблице users
-func (s *storage) CheckUserAuth(ctx context.Context, login string,
-	password string) error {
-	s.log.Debug("Проверяем наличие пользователя в бд")
-	var hashPassword []byte
+// GetVaultCheck возвращает проверочное значение хранилища пользователя
+// или nil, если оно еще не сохранено
+func (s *storage) GetVaultCheck(ctx context.Context, login string) ([]byte, error) {
+	var check []byte
+	err := s.pgxPool.QueryRow(ctx, selectVaultCheck, login).Scan(&check)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil
+		}
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	return check, nil
+}
 
-	row := s.pgxPool.QueryRow(ctx, selectPassword, login)
-	err := row.Scan(&hashPassword)
+// AddRefreshToken сохраняет хэш токена обновления пользователя
+func (s *storage) AddRefreshToken(ctx context.Context, tokenHash string,
+	refreshToken model.RefreshToken) error {
+	_, err := s.pgxPool.Exec(ctx, insertRefreshToken, tokenHash, refreshToken.Login,
+		refreshToken.SessionID, refreshToken.ExpiresAt)
 	if err != nil {
 		s.log.Error(err.Error())
-		return err
 	}
-	inputPasswordHash := sha256.Sum256([]byte(password))
+	return storageError(err)
+}
 
-	if hex.EncodeToString(inputPasswordHash[:]) != hex.EncodeToString(hashPassword) {
-		err = model.ErrIncorrectPassword
+// TakeRefreshToken удаляет токен обновления и возвращает сведения о нем.
+// Токен можно использовать только один раз
+func (s *storage) TakeRefreshToken(ctx context.Context, tokenHash string) (model.RefreshToken,
+	error) {
+	var refreshToken model.RefreshToken
+	err := s.pgxPool.QueryRow(ctx, deleteRefreshToken, tokenHash).Scan(&refreshToken.Login,
+		&refreshToken.SessionID, &refreshToken.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.RefreshToken{}, model.ErrRefreshTokenNotFound
+		}
 		s.log.Error(err.Error())
-		return err
+		return model.RefreshToken{}, storageError(err)
+	}
+	return refreshToken, nil
+}
+
+// AddSession сохраняет сессию, открытую токеном доступа с идентификатором sessionID.
+// Заодно удаляются истекшие сессии пользователя
+func (s *storage) AddSession(ctx context.Context, login string, sessionID string,
+	expiresAt time.Time) error {
+	if _, err := s.pgxPool.Exec(ctx, deleteExpiredSession, login); err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	_, err := s.pgxPool.Exec(ctx, insertSession, sessionID, login, expiresAt)
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return storageError(err)
+}
+
+// IsSessionActive проверяет, что сессия существует, не отозвана и не истекла
+func (s *storage) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	var active bool
+	err := s.pgxPool.QueryRow(ctx, selectSessionActive, sessionID).Scan(&active)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		s.log.Error(err.Error())
+		return false, storageError(err)
+	}
+	return active, nil
+}
+
+// RevokeSession отзывает сессию пользователя и удаляет выданные в ней
+// токены обновления
+func (s *storage) RevokeSession(ctx context.Context, login string, sessionID string) error {
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, revokeSession, sessionID, login); err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	if _, err = tx.Exec(ctx, deleteSessionRefreshTokens, sessionID); err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	return tx.Commit(ctx)
+}
+
+// RevokeAllSessions отзывает все действующие сессии пользователя и удаляет
+// его токены обновления. Возвращает количество отозванных сессий
+func (s *storage) RevokeAllSessions(ctx context.Context, login string) (int64, error) {
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, revokeUserSessions, login)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	if _, err = tx.Exec(ctx, deleteUserRefreshTokens, login); err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// SaveTOTP сохраняет зашифрованный секрет TOTP пользователя.
+// Секрет подтвержденного второго фактора не перезаписывается
+func (s *storage) SaveTOTP(ctx context.Context, login string, cipherSecret []byte) error {
+	tag, err := s.pgxPool.Exec(ctx, upsertTOTP, login, cipherSecret)
+	if err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrTOTPAlreadyEnabled
 	}
-	s.log.Debug("Аутентификация успешна")
 	return nil
 }
 
-// InsertData добавляет данные пользователя в бд
+// GetTOTP возвращает настройки второго фактора пользователя
+func (s *storage) GetTOTP(ctx context.Context, login string) (model.TOTP, error) {
+	var totp model.TOTP
+	err := s.pgxPool.QueryRow(ctx, selectTOTP, login).Scan(&totp.CipherSecret,
+		&totp.Confirmed, &totp.LastStep)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.TOTP{}, model.ErrTOTPNotEnrolled
+		}
+		s.log.Error(err.Error())
+		return model.TOTP{}, storageError(err)
+	}
+	return totp, nil
+}
+
+// ConfirmTOTP включает второй фактор и заменяет коды восстановления пользователя
+func (s *storage) ConfirmTOTP(ctx context.Context, login string, step int64,
+	recoveryCodeHashes []string) error {
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, confirmTOTP, login, step)
+	if err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	if tag.RowsAffected() == 0 {
+		return model.ErrIncorrectTOTPCode
+	}
+	if _, err = tx.Exec(ctx, deleteUserRecoveryCodes, login); err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		if _, err = tx.Exec(ctx, insertRecoveryCode, login, codeHash); err != nil {
+			s.log.Error(err.Error())
+			return storageError(err)
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// UseTOTPStep запоминает принятый шаг TOTP. Возвращает false, если код
+// этого или более позднего шага уже использовался
+func (s *storage) UseTOTPStep(ctx context.Context, login string, step int64) (bool, error) {
+	tag, err := s.pgxPool.Exec(ctx, useTOTPStep, login, step)
+	if err != nil {
+		s.log.Error(err.Error())
+		return false, storageError(err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// UseRecoveryCode удаляет код восстановления. Возвращает false,
+// если такого кода у пользователя нет
+func (s *storage) UseRecoveryCode(ctx context.Context, login string,
+	codeHash string) (bool, error) {
+	tag, err := s.pgxPool.Exec(ctx, deleteRecoveryCode, login, codeHash)
+	if err != nil {
+		s.log.Error(err.Error())
+		return false, storageError(err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// AddChallenge сохраняет незавершенный вход, ожидающий второй фактор.
+// Заодно удаляются истекшие
+func (s *storage) AddChallenge(ctx context.Context, challengeHash string, login string,
+	expiresAt time.Time) error {
+	if _, err := s.pgxPool.Exec(ctx, deleteExpiredChallenges); err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	_, err := s.pgxPool.Exec(ctx, insertChallenge, challengeHash, login, expiresAt)
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return storageError(err)
+}
+
+// CountChallengeAttempt увеличивает счетчик попыток ввода кода
+// и возвращает незавершенный вход
+func (s *storage) CountChallengeAttempt(ctx context.Context,
+	challengeHash string) (model.AuthChallenge, error) {
+	var challenge model.AuthChallenge
+	err := s.pgxPool.QueryRow(ctx, countChallengeAttempt, challengeHash).Scan(
+		&challenge.Login, &challenge.ExpiresAt, &challenge.Attempts)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return model.AuthChallenge{}, model.ErrChallengeExpired
+		}
+		s.log.Error(err.Error())
+		return model.AuthChallenge{}, storageError(err)
+	}
+	return challenge, nil
+}
+
+// DeleteChallenge удаляет незавершенный вход
+func (s *storage) DeleteChallenge(ctx context.Context, challengeHash string) error {
+	_, err := s.pgxPool.Exec(ctx, deleteChallenge, challengeHash)
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return storageError(err)
+}
+
+// InsertData добавляет данные пользователя в бд. Если ключ занят,
+// возвращает model.ErrDataKeyWordExists
 func (s *storage) InsertData(ctx context.Context, data model.DataBlock) error {
 	s.log.Debug("Вставляем строку с данными в таблицу dataTable")
 	_, err := s.pgxPool.Exec(ctx, insertData, data.Login, data.DataKeyWord,
-		data.DataType, data.CipherData, data.MetaData)
+		data.DataType, data.CipherData, data.MetaData, data.Device)
 	if err != nil {
 		s.log.Error(err.Error())
+		if isViolation(err, pgerrcode.UniqueViolation) {
+			return model.ErrDataKeyWordExists
+		}
 	}
-	return err
+	return storageError(err)
 }
 
 // GetData выбирает данные пользователя по ключу логин + ключевое слово
@@ -137,49 +744,940 @@ func (s *storage) GetData(ctx context.Context, login string,
 	defer rows.Close()
 	if err != nil {
 		s.log.Error(err.Error())
-		return nil, err
+		return nil, storageError(err)
 	}
 
 	var dataBlock model.DataBlock
 	var data []model.DataBlock
 	for rows.Next() {
-		err := rows.Scan(&dataBlock.DataKeyWord, &dataBlock.DataType, &dataBlock.CipherData, &dataBlock.MetaData)
+		err := rows.Scan(&dataBlock.DataKeyWord, &dataBlock.DataType, &dataBlock.CipherData, &dataBlock.MetaData,
+			&dataBlock.FileID, &dataBlock.Version)
 		if err != nil {
 			s.log.Error(err.Error())
-			return nil, err
+			return nil, storageError(err)
 		}
 		data = append(data, dataBlock)
 	}
 	if rows.Err() != nil {
 		s.log.Error(err.Error())
-		return nil, err
+		return nil, storageError(err)
 	}
 	if data == nil {
 		err := model.ErrNoRowsSelected
 		s.log.Error(err.Error())
-		return nil, err
+		return nil, storageError(err)
 	}
 	s.log.Debug("Данные успешно выбраны")
 	return data, nil
 }
 
-// ChangeData запускает UPDATE на данные пользователя
-func (s *storage) ChangeData(ctx context.Context, data model.DataBlock) error {
-	_, err := s.pgxPool.Exec(ctx, updateData, data.CipherData, data.MetaData,
-		data.Login, data.DataKeyWord)
+// ChangeData запускает UPDATE на данные пользователя, если версия записи
+// совпадает с data.Version, и возвращает новую версию. Если версия не совпала,
+// возвращается *model.VersionMismatchError с текущей версией записи.
+// Версия 0 изменяет запись без проверки, она допустима только для
+// внутренних вызовов сервера, сервис отклоняет ее в запросах клиента.
+// Запись с файлом не изменяется, возвращается model.ErrFileReadOnly
+func (s *storage) ChangeData(ctx context.Context, data model.DataBlock) (int64, error) {
+	return s.changeData(ctx, s.pgxPool, data)
+}
+
+func (s *storage) changeData(ctx context.Context, q querier, data model.DataBlock) (int64, error) {
+	var updated, current *int64
+	var isFile bool
+	err := q.QueryRow(ctx, updateData, data.CipherData, data.MetaData, data.Login,
+		data.DataKeyWord, data.DataType, data.Version, data.Device).Scan(&updated, &current, &isFile)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	switch {
+	case updated != nil:
+		return *updated, nil
+	case current == nil:
+		err = model.ErrNoRowsSelected
+	case data.Version != 0 && *current != data.Version:
+		err = &model.VersionMismatchError{Current: *current}
+	case isFile:
+		err = model.ErrFileReadOnly
+	default:
+		err = &model.VersionMismatchError{Current: *current}
+	}
+	s.log.Error(err.Error())
+	return 0, storageError(err)
+}
+
+// insertDataIfAbsent добавляет запись, если ключ свободен. Если запись
+// с таким ключом уже есть, возвращает *model.VersionMismatchError
+func (s *storage) insertDataIfAbsent(ctx context.Context, q querier,
+	data model.DataBlock) (int64, error) {
+	var inserted, current *int64
+	err := q.QueryRow(ctx, insertDataIfAbsent, data.Login, data.DataKeyWord, data.DataType,
+		data.CipherData, data.MetaData, data.Device, data.FileID).Scan(&inserted, &current)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	if inserted != nil {
+		return *inserted, nil
+	}
+	err = &model.VersionMismatchError{Current: *current}
+	s.log.Error(err.Error())
+	return 0, storageError(err)
+}
+
+// deleteData удаляет запись, если ее версия совпадает с version (0 - без проверки),
+// и сообщает, была ли запись удалена. Отсутствие записи ошибкой не считается
+func (s *storage) deleteData(ctx context.Context, q querier, login string,
+	dataKeyWord string, version int64) (bool, error) {
+	var deleted int64
+	var current *int64
+	err := q.QueryRow(ctx, deleteData, login, dataKeyWord, version).Scan(&deleted, &current)
+	if err != nil {
+		s.log.Error(err.Error())
+		return false, storageError(err)
+	}
+	if deleted == 0 && current != nil {
+		err = &model.VersionMismatchError{Current: *current}
+		s.log.Error(err.Error())
+	}
+	return deleted > 0, storageError(err)
+}
+
+// ApplyOperation применяет операцию клиента, если запись на сервере не менялась
+// с версии operation.BaseVersion. Иначе возвращает *model.VersionMismatchError,
+// текущая версия 0 означает, что запись удалена. Удаление с нулевой версией
+// означает, что клиент создал и удалил запись без связи с сервером: если
+// запись с таким ключом есть на сервере, это конфликт
+func (s *storage) ApplyOperation(ctx context.Context, login string,
+	operation model.SyncOperation) error {
+	data := operation.Data
+	data.Login = login
+	var err error
+	switch {
+	case operation.Deleted && operation.BaseVersion == 0:
+		var current int64
+		err = s.pgxPool.QueryRow(ctx, selectDataVersion, login, data.DataKeyWord).Scan(&current)
+		switch {
+		case errors.Is(err, pgx.ErrNoRows):
+			err = nil
+		case err == nil:
+			err = &model.VersionMismatchError{Current: current}
+			s.log.Error(err.Error())
+		default:
+			s.log.Error(err.Error())
+		}
+	case operation.Deleted:
+		_, err = s.deleteData(ctx, s.pgxPool, login, data.DataKeyWord, operation.BaseVersion)
+	case operation.BaseVersion == 0:
+		_, err = s.insertDataIfAbsent(ctx, s.pgxPool, data)
+	default:
+		data.Version = operation.BaseVersion
+		_, err = s.changeData(ctx, s.pgxPool, data)
+		if errors.Is(err, model.ErrNoRowsSelected) {
+			err = &model.VersionMismatchError{}
+		}
+	}
+	return storageError(err)
+}
+
+// AddConflict сохраняет изменение клиента, не примененное из-за конфликта
+func (s *storage) AddConflict(ctx context.Context, login string, conflict model.Conflict) error {
+	var baseDataType, baseMetaData *string
+	var baseData []byte
+	if conflict.Base != nil {
+		baseDataType, baseMetaData = &conflict.Base.DataType, &conflict.Base.MetaData
+		baseData = conflict.Base.CipherData
+	}
+	var data []byte
+	if !conflict.MineDeleted {
+		data = conflict.Mine.CipherData
+	}
+	_, err := s.pgxPool.Exec(ctx, insertConflict, login, conflict.DataKeyWord,
+		conflict.BaseVersion, baseDataType, baseData, baseMetaData, conflict.Mine.DataType,
+		data, conflict.Mine.MetaData, conflict.MineDeleted, conflict.Mine.Device)
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return storageError(err)
+}
+
+// GetConflicts выбирает конфликты пользователя, для непустого dataKeyWord -
+// только конфликты этой записи
+func (s *storage) GetConflicts(ctx context.Context, login string,
+	dataKeyWord string) ([]model.Conflict, error) {
+	rows, err := s.pgxPool.Query(ctx, selectConflicts, login, dataKeyWord)
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	defer rows.Close()
+
+	var conflicts []model.Conflict
+	for rows.Next() {
+		var conflict model.Conflict
+		var hasBase bool
+		var base, theirs model.DataBlock
+		var theirsVersion *int64
+		if err = rows.Scan(&conflict.ID, &conflict.DataKeyWord, &conflict.BaseVersion, &hasBase,
+			&base.DataType, &base.CipherData, &base.MetaData, &conflict.Mine.DataType,
+			&conflict.Mine.CipherData, &conflict.Mine.MetaData, &conflict.MineDeleted,
+			&conflict.CreatedAt, &theirs.DataType, &theirs.CipherData, &theirs.MetaData,
+			&theirsVersion); err != nil {
+			s.log.Error(err.Error())
+			return nil, storageError(err)
+		}
+		conflict.Mine.DataKeyWord = conflict.DataKeyWord
+		if hasBase {
+			base.DataKeyWord, base.Version = conflict.DataKeyWord, conflict.BaseVersion
+			conflict.Base = &base
+		}
+		if theirsVersion != nil {
+			theirs.DataKeyWord, theirs.Version = conflict.DataKeyWord, *theirsVersion
+			conflict.Theirs = &theirs
+		}
+		conflicts = append(conflicts, conflict)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	return conflicts, nil
+}
+
+// ResolveConflict в одной транзакции применяет выбор пользователя и удаляет
+// конфликт. Запись на сервере изменяется, только если ее версия совпадает
+// с resolution.ExpectedVersion. Возвращает версию измененной записи
+func (s *storage) ResolveConflict(ctx context.Context, login string,
+	resolution model.ConflictResolution) (int64, error) {
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	mine := model.DataBlock{Login: login}
+	var deleted bool
+	err = tx.QueryRow(ctx, selectConflictForUpdate, resolution.ConflictID, login).Scan(
+		&mine.DataKeyWord, &mine.DataType, &mine.CipherData, &mine.MetaData, &deleted, &mine.Device)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = model.ErrConflictNotFound
+		}
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+
+	version := resolution.ExpectedVersion
+	switch resolution.Strategy {
+	case model.ResolveKeepTheirs:
+	case model.ResolveKeepMine, model.ResolveMerge:
+		record := mine
+		if resolution.Strategy == model.ResolveMerge {
+			record = resolution.Merged
+			record.Login, record.DataKeyWord = login, mine.DataKeyWord
+		}
+		switch {
+		case deleted && resolution.Strategy == model.ResolveKeepMine:
+			version = 0
+			_, err = s.deleteData(ctx, tx, login, mine.DataKeyWord, resolution.ExpectedVersion)
+		case resolution.ExpectedVersion == 0:
+			version, err = s.insertDataIfAbsent(ctx, tx, record)
+		default:
+			record.Version = resolution.ExpectedVersion
+			version, err = s.changeData(ctx, tx, record)
+			if errors.Is(err, model.ErrNoRowsSelected) {
+				err = &model.VersionMismatchError{}
+			}
+		}
+	case model.ResolveKeepBoth:
+		if resolution.RenamedKey == "" || resolution.RenamedKey == mine.DataKeyWord {
+			return 0, model.ErrRenamedKeyRequired
+		}
+		// данные записи с файлом - описание частей, привязанных к ее ключу,
+		// под новым ключом файл не скачать
+		if mine.DataType == model.DataTypeFile {
+			return 0, model.ErrKeepBothFile
+		}
+		// удаление сохранять под новым ключом нечего
+		if !deleted {
+			record := mine
+			record.DataKeyWord = resolution.RenamedKey
+			if _, err = s.insertDataIfAbsent(ctx, tx, record); errors.Is(err, model.ErrVersionMismatch) {
+				err = model.ErrDataKeyWordExists
+			}
+		}
+	default:
+		return 0, model.ErrUnknownResolution
+	}
+	if err != nil {
+		return 0, storageError(err)
+	}
+
+	if _, err = tx.Exec(ctx, deleteConflict, resolution.ConflictID); err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	return version, nil
+}
+
+// GetChanges выбирает не больше limit изменений записей пользователя,
+// зафиксированных после позиции after
+func (s *storage) GetChanges(ctx context.Context, login string, after model.ChangePosition,
+	limit int) ([]model.DataChange, error) {
+	rows, err := s.pgxPool.Query(ctx, selectChanges, login, after.TxID, after.Seq, limit)
 	if err != nil {
 		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	defer rows.Close()
+
+	var changes []model.DataChange
+	for rows.Next() {
+		var change model.DataChange
+		if err = rows.Scan(&change.Header.DataKeyWord, &change.Header.DataType,
+			&change.Data.CipherData, &change.Header.MetaData, &change.Header.CreatedAt,
+			&change.Header.UpdatedAt, &change.Header.Size, &change.Header.Version,
+			&change.TxID, &change.Seq, &change.Deleted); err != nil {
+			s.log.Error(err.Error())
+			return nil, storageError(err)
+		}
+		change.Data.DataKeyWord = change.Header.DataKeyWord
+		change.Data.DataType = change.Header.DataType
+		change.Data.MetaData = change.Header.MetaData
+		change.Data.Version = change.Header.Version
+		changes = append(changes, change)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(err.Error())
+		return nil, storageError(err)
 	}
-	return err
+	return changes, nil
 }
 
-// DeleteData удаляет данные пользователя по ключу
+// DeleteData перемещает запись пользователя в корзину. Если записи нет,
+// возвращает model.ErrNoRowsSelected
 func (s *storage) DeleteData(ctx context.Context, login string, dataKeyWord string) error {
-	_, err := s.pgxPool.Exec(ctx, deleteData, login, dataKeyWord)
+	deleted, err := s.deleteData(ctx, s.pgxPool, login, dataKeyWord, 0)
+	if err == nil && !deleted {
+		err = model.ErrNoRowsSelected
+	}
+	return storageError(err)
+}
+
+// ListTrash выбирает заголовки записей в корзине пользователя, начиная
+// с последней удаленной
+func (s *storage) ListTrash(ctx context.Context, login string) ([]model.TrashedRecord, error) {
+	rows, err := s.pgxPool.Query(ctx, selectTrash, login)
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	defer rows.Close()
+
+	var records []model.TrashedRecord
+	for rows.Next() {
+		var record model.TrashedRecord
+		var version *int64
+		if err = rows.Scan(&record.ID, &record.Header.DataKeyWord, &record.Header.DataType,
+			&record.Header.MetaData, &record.Header.CreatedAt, &record.Header.UpdatedAt,
+			&record.Header.Size, &version, &record.DeletedAt); err != nil {
+			s.log.Error(err.Error())
+			return nil, storageError(err)
+		}
+		if version != nil {
+			record.Header.Version = *version
+		}
+		records = append(records, record)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	return records, nil
+}
+
+// RestoreFromTrash возвращает запись из корзины и возвращает ее версию.
+// Если ключ записи уже занят, возвращается model.ErrDataKeyWordExists
+func (s *storage) RestoreFromTrash(ctx context.Context, login string, trashID int64,
+	device string) (int64, error) {
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	var dataKeyWord string
+	err = tx.QueryRow(ctx, selectTrashForUpdate, trashID, login).Scan(&dataKeyWord)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = model.ErrTrashNotFound
+		}
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	var version int64
+	err = tx.QueryRow(ctx, restoreFromTrash, trashID, login, device).Scan(&version)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = model.ErrDataKeyWordExists
+		}
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	if _, err = tx.Exec(ctx, deleteFromTrash, trashID); err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	return version, nil
+}
+
+// PurgeTrash навсегда удаляет записи из корзины пользователя login (пустой -
+// всех пользователей), перемещенные в корзину раньше deletedBefore (нулевое
+// время - все записи). Возвращает количество удаленных записей
+func (s *storage) PurgeTrash(ctx context.Context, login string,
+	deletedBefore time.Time) (int64, error) {
+	var before *time.Time
+	if !deletedBefore.IsZero() {
+		before = &deletedBefore
+	}
+	var purged int64
+	if err := s.pgxPool.QueryRow(ctx, purgeTrash, login, before).Scan(&purged); err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	return purged, nil
+}
+
+// GetHistory выбирает прежние версии записи пользователя, начиная с последней
+func (s *storage) GetHistory(ctx context.Context, login string,
+	dataKeyWord string) ([]model.Revision, error) {
+	rows, err := s.pgxPool.Query(ctx, selectHistory, login, dataKeyWord)
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	defer rows.Close()
+
+	var revisions []model.Revision
+	for rows.Next() {
+		revision := model.Revision{Data: model.DataBlock{DataKeyWord: dataKeyWord}}
+		if err = rows.Scan(&revision.ID, &revision.Data.Version, &revision.Data.DataType,
+			&revision.Data.CipherData, &revision.Data.MetaData, &revision.Device,
+			&revision.CreatedAt, &revision.ReplacedAt, &revision.Deleted); err != nil {
+			s.log.Error(err.Error())
+			return nil, storageError(err)
+		}
+		revision.Data.Device = revision.Device
+		revisions = append(revisions, revision)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	return revisions, nil
+}
+
+// RestoreRevision заменяет запись прежней версией revisionID и возвращает новую
+// версию записи. Запись изменяется, только если ее версия совпадает
+// с expectedVersion, 0 - запись удалена и восстанавливается заново.
+// Заменяемая версия сохраняется в истории
+func (s *storage) RestoreRevision(ctx context.Context, login string, revisionID int64,
+	expectedVersion int64, device string) (int64, error) {
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	record := model.DataBlock{Login: login, Device: device}
+	err = tx.QueryRow(ctx, selectRevision, revisionID, login).Scan(&record.DataKeyWord,
+		&record.DataType, &record.CipherData, &record.MetaData)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			err = model.ErrRevisionNotFound
+		}
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+
+	var version int64
+	if expectedVersion == 0 {
+		version, err = s.insertDataIfAbsent(ctx, tx, record)
+	} else {
+		record.Version = expectedVersion
+		version, err = s.changeData(ctx, tx, record)
+		if errors.Is(err, model.ErrNoRowsSelected) {
+			err = &model.VersionMismatchError{}
+		}
+	}
+	if err != nil {
+		return 0, storageError(err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	return version, nil
+}
+
+// PruneHistory удаляет прежние версии записей, не входящие в keep последних
+// версий каждой записи (0 - без ограничения) или замененные раньше replacedBefore
+// (нулевое время - без ограничения). Возвращает количество удаленных версий
+func (s *storage) PruneHistory(ctx context.Context, keep int,
+	replacedBefore time.Time) (int64, error) {
+	var before *time.Time
+	if !replacedBefore.IsZero() {
+		before = &replacedBefore
+	}
+	tag, err := s.pgxPool.Exec(ctx, pruneHistory, keep, before)
+	if err != nil {
+		s.log.Error(err.Error())
+		return 0, storageError(err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// ListData выбирает заголовки записей пользователя по фильтрам query,
+// отсортированные по query.SortBy. Если задан after, выбираются записи,
+// следующие за ним в порядке сортировки
+func (s *storage) ListData(ctx context.Context, login string, query model.ListQuery,
+	after *model.DataHeader, limit int) ([]model.DataHeader, error) {
+	column, ok := listSortColumns[query.SortBy]
+	if !ok {
+		err := model.ErrInvalidSortField
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	direction, compare := "ASC", ">"
+	if query.Descending {
+		direction, compare = "DESC", "<"
+	}
+
+	sql := selectDataHeaders
+	args := []interface{}{login, query.DataType, query.KeyPrefix, limit}
+	if after != nil {
+		sql += fmt.Sprintf(selectDataHeadersAfter, column.name, compare, column.sqlType)
+		args = append(args, column.value(*after), after.DataKeyWord)
+	}
+	sql += fmt.Sprintf(selectDataHeadersOrder, column.name, direction)
+
+	rows, err := s.pgxPool.Query(ctx, sql, args...)
+	if err != nil {
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	defer rows.Close()
+
+	var headers []model.DataHeader
+	for rows.Next() {
+		var header model.DataHeader
+		if err = rows.Scan(&header.DataKeyWord, &header.DataType, &header.MetaData,
+			&header.CreatedAt, &header.UpdatedAt, &header.Size, &header.Version); err != nil {
+			s.log.Error(err.Error())
+			return nil, storageError(err)
+		}
+		headers = append(headers, header)
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(err.Error())
+		return nil, storageError(err)
+	}
+	return headers, nil
+}
+
+// CreateFileUpload регистрирует загрузку файла. Заодно удаляет
+// незавершенные загрузки, начатые раньше staleBefore
+func (s *storage) CreateFileUpload(ctx context.Context, login string, fileID string,
+	staleBefore time.Time) error {
+	if _, err := s.pgxPool.Exec(ctx, deleteStaleUploads, staleBefore); err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	_, err := s.pgxPool.Exec(ctx, insertFileUpload, fileID, login)
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return storageError(err)
+}
+
+// AddFileChunk сохраняет зашифрованную часть файла. Если загрузки нет,
+// возвращает model.ErrUploadNotFound
+func (s *storage) AddFileChunk(ctx context.Context, fileID string, seq int64,
+	cipherChunk []byte) error {
+	_, err := s.pgxPool.Exec(ctx, insertFileChunk, fileID, seq, cipherChunk)
+	if err != nil {
+		s.log.Error(err.Error())
+		if isViolation(err, pgerrcode.ForeignKeyViolation) {
+			return model.ErrUploadNotFound
+		}
+	}
+	return storageError(err)
+}
+
+// CompleteFileUpload в одной транзакции добавляет запись с описанием
+// файла и отмечает загрузку завершенной
+func (s *storage) CompleteFileUpload(ctx context.Context, data model.DataBlock) error {
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err = tx.Exec(ctx, insertFileData, data.Login, data.DataKeyWord, data.DataType,
+		data.CipherData, data.MetaData, data.FileID, data.Device); err != nil {
+		s.log.Error(err.Error())
+		if isViolation(err, pgerrcode.UniqueViolation) {
+			return model.ErrDataKeyWordExists
+		}
+		return storageError(err)
+	}
+	if _, err = tx.Exec(ctx, completeFileUpload, data.FileID); err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		s.log.Error(err.Error())
+	}
+	return storageError(err)
+}
+
+// DeleteFileUpload удаляет незавершенную загрузку вместе с частями файла
+func (s *storage) DeleteFileUpload(ctx context.Context, fileID string) error {
+	_, err := s.pgxPool.Exec(ctx, deleteFileUpload, fileID)
+	if err != nil {
+		s.log.Error(err.Error())
+	}
+	return storageError(err)
+}
+
+// GetFileChunks по порядку передает части файла в функцию fn,
+// не загружая файл в память целиком
+func (s *storage) GetFileChunks(ctx context.Context, fileID string,
+	fn func(seq int64, cipherChunk []byte) error) error {
+	rows, err := s.pgxPool.Query(ctx, selectFileChunks, fileID)
+	if err != nil {
+		s.log.Error(err.Error())
+		return storageError(err)
+	}
+	defer rows.Close()
+
+	var seq int64
+	var cipherChunk []byte
+	for rows.Next() {
+		if err = rows.Scan(&seq, &cipherChunk); err != nil {
+			s.log.Error(err.Error())
+			return storageError(err)
+		}
+		if err = fn(seq, cipherChunk); err != nil {
+			return storageError(err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		s.log.Error(err.Error())
+	}
+	return storageError(err)
+}
+
+// GetKeyRotation возвращает сохраненное состояние ротации секрета сервера.
+// Для новой ротации возвращается состояние первого этапа
+func (s *storage) GetKeyRotation(ctx context.Context, rotationID string) (model.KeyRotation, error) {
+	rotation := model.KeyRotation{
+		RotationID: rotationID,
+		Phase:      model.RotationPhaseKeys,
+	}
+	err := s.pgxPool.QueryRow(ctx, selectKeyRotation, rotationID).Scan(&rotation.Phase,
+		&rotation.LastLogin, &rotation.LastKeyWord, &rotation.LastID, &rotation.Processed)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+	return rotation, nil
+}
+
+// RotateUserKeysBatch переоборачивает очередную пачку ключей пользователей.
+// Пачка и состояние ротации сохраняются в одной транзакции.
+// Когда ключи заканчиваются, ротация переходит к этапу перешифровки данных
+func (s *storage) RotateUserKeysBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, rewrap func(login string, wrappedKey []byte) ([]byte, error)) (
+	model.KeyRotation, error) {
+
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, selectUserKeysBatch, rotation.LastLogin, batchSize)
+	if err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+
+	type userKey struct {
+		login      string
+		wrappedKey []byte
+	}
+	var batch []userKey
+	for rows.Next() {
+		var key userKey
+		if err := rows.Scan(&key.login, &key.wrappedKey); err != nil {
+			rows.Close()
+			s.log.Error(err.Error())
+			return rotation, storageError(err)
+		}
+		batch = append(batch, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+
+	next := rotation
+	for _, key := range batch {
+		wrappedKey, err := rewrap(key.login, key.wrappedKey)
+		if err != nil {
+			s.log.Error(err.Error())
+			return rotation, storageError(err)
+		}
+		if _, err := tx.Exec(ctx, updateUserKey, wrappedKey, key.login); err != nil {
+			s.log.Error(err.Error())
+			return rotation, storageError(err)
+		}
+		next.LastLogin = key.login
+		next.Processed++
+	}
+	if len(batch) < batchSize {
+		next.Phase = model.RotationPhaseData
+		next.LastLogin = ""
+	}
+
+	if err := s.saveKeyRotation(ctx, tx, next); err != nil {
+		return rotation, storageError(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+	return next, nil
+}
+
+// RotateDataBatch перешифровывает очередную пачку записей dataTable.
+// Функция reseal возвращает новый шифротекст и признак того, что запись
+// нужно обновить. Когда записи заканчиваются, ротация переходит
+// к перешифровке истории версий
+func (s *storage) RotateDataBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (
+	model.KeyRotation, error) {
+
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, selectDataBatch, rotation.LastLogin, rotation.LastKeyWord,
+		batchSize)
+	if err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+
+	var batch []model.DataBlock
+	for rows.Next() {
+		var dataBlock model.DataBlock
+		if err := rows.Scan(&dataBlock.Login, &dataBlock.DataKeyWord,
+			&dataBlock.CipherData); err != nil {
+			rows.Close()
+			s.log.Error(err.Error())
+			return rotation, storageError(err)
+		}
+		batch = append(batch, dataBlock)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+
+	next := rotation
+	for _, dataBlock := range batch {
+		cipherData, changed, err := reseal(dataBlock)
+		if err != nil {
+			s.log.Error(err.Error())
+			return rotation, storageError(err)
+		}
+		if changed {
+			if _, err := tx.Exec(ctx, updateCipherData, cipherData, dataBlock.Login,
+				dataBlock.DataKeyWord); err != nil {
+				s.log.Error(err.Error())
+				return rotation, storageError(err)
+			}
+			next.Processed++
+		}
+		next.LastLogin = dataBlock.Login
+		next.LastKeyWord = dataBlock.DataKeyWord
+	}
+	if len(batch) < batchSize {
+		next.Phase = model.RotationPhaseHistory
+		next.LastLogin, next.LastKeyWord, next.LastID = "", "", 0
+	}
+
+	if err := s.saveKeyRotation(ctx, tx, next); err != nil {
+		return rotation, storageError(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+	return next, nil
+}
+
+// RotateHistoryBatch перешифровывает очередную пачку прежних версий записей.
+// Когда версии заканчиваются, ротация переходит к перешифровке корзины
+func (s *storage) RotateHistoryBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (
+	model.KeyRotation, error) {
+	return s.rotateRowsBatch(ctx, rotation, batchSize, selectHistoryBatch,
+		updateHistoryCipherData, model.RotationPhaseTrash, reseal)
+}
+
+// RotateTrashBatch перешифровывает очередную пачку записей в корзине.
+// Когда записи заканчиваются, ротация переходит к перешифровке конфликтов
+func (s *storage) RotateTrashBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (
+	model.KeyRotation, error) {
+	return s.rotateRowsBatch(ctx, rotation, batchSize, selectTrashBatch,
+		updateTrashCipherData, model.RotationPhaseConflicts, reseal)
+}
+
+// RotateConflictsBatch перешифровывает очередную пачку конфликтов: версию,
+// которую изменял клиент, и его изменение. Когда конфликты заканчиваются,
+// ротация завершается
+func (s *storage) RotateConflictsBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, reseal func(data model.DataBlock) ([]byte, bool, error)) (
+	model.KeyRotation, error) {
+	return s.rotateRowsBatch(ctx, rotation, batchSize, selectConflictsBatch,
+		updateConflictCipherData, model.RotationPhaseDone, reseal)
+}
+
+// rotateRowsBatch перешифровывает очередную пачку строк таблицы с числовым
+// ключом после rotation.LastID. Запрос selectBatch выбирает ключ строки, логин,
+// ключ записи и один или несколько шифротекстов, updateBatch сохраняет новые
+// шифротексты в том же порядке по ключу строки. Пустые шифротексты
+// пропускаются. Когда строки заканчиваются, ротация переходит к этапу nextPhase
+func (s *storage) rotateRowsBatch(ctx context.Context, rotation model.KeyRotation,
+	batchSize int, selectBatch string, updateBatch string, nextPhase string,
+	reseal func(data model.DataBlock) ([]byte, bool, error)) (model.KeyRotation, error) {
+
+	tx, err := s.pgxPool.Begin(ctx)
+	if err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, selectBatch, rotation.LastID, batchSize)
+	if err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+
+	type cipherRow struct {
+		id          int64
+		login       string
+		dataKeyWord string
+		cipherData  [][]byte
+	}
+	var batch []cipherRow
+	for rows.Next() {
+		var row cipherRow
+		row.cipherData = make([][]byte, len(rows.FieldDescriptions())-3)
+		dest := []interface{}{&row.id, &row.login, &row.dataKeyWord}
+		for i := range row.cipherData {
+			dest = append(dest, &row.cipherData[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			rows.Close()
+			s.log.Error(err.Error())
+			return rotation, storageError(err)
+		}
+		batch = append(batch, row)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+
+	next := rotation
+	for _, row := range batch {
+		var changed bool
+		args := make([]interface{}, 0, len(row.cipherData)+1)
+		for _, cipherData := range row.cipherData {
+			if cipherData != nil {
+				resealed, ok, err := reseal(model.DataBlock{
+					Login:       row.login,
+					DataKeyWord: row.dataKeyWord,
+					CipherData:  cipherData,
+				})
+				if err != nil {
+					s.log.Error(err.Error())
+					return rotation, storageError(err)
+				}
+				if ok {
+					cipherData, changed = resealed, true
+				}
+			}
+			args = append(args, cipherData)
+		}
+		if changed {
+			if _, err := tx.Exec(ctx, updateBatch, append(args, row.id)...); err != nil {
+				s.log.Error(err.Error())
+				return rotation, storageError(err)
+			}
+			next.Processed++
+		}
+		next.LastID = row.id
+	}
+	if len(batch) < batchSize {
+		next.Phase = nextPhase
+		next.LastLogin, next.LastKeyWord, next.LastID = "", "", 0
+	}
+
+	if err := s.saveKeyRotation(ctx, tx, next); err != nil {
+		return rotation, storageError(err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		s.log.Error(err.Error())
+		return rotation, storageError(err)
+	}
+	return next, nil
+}
+
+// saveKeyRotation сохраняет состояние ротации в рамках транзакции
+func (s *storage) saveKeyRotation(ctx context.Context, tx pgx.Tx,
+	rotation model.KeyRotation) error {
+	_, err := tx.Exec(ctx, upsertKeyRotation, rotation.RotationID, rotation.Phase,
+		rotation.LastLogin, rotation.LastKeyWord, rotation.LastID, rotation.Processed)
 	if err != nil {
 		s.log.Error(err.Error())
 	}
-	return err
+	return storageError(err)
 }
 
 func (s *storage) Close() {
@@ -2,18 +2,67 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"keeper/internal/config"
 	"keeper/internal/logger"
 	"keeper/internal/model"
+	"keeper/internal/server/service"
+	"keeper/internal/server/storertest"
 	"keeper/internal/utils"
-	"os"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgerrcode"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// testSecret - секрет сервера в тестах
+const testSecret = "supersecretpasswordforkeeper1234"
+
+func TestStorageError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantBusy bool
+	}{
+		{
+			name:     "Конфликт транзакций",
+			err:      &pgconn.PgError{Code: pgerrcode.SerializationFailure},
+			wantBusy: true,
+		},
+		{
+			name:     "Слишком много соединений",
+			err:      &pgconn.PgError{Code: pgerrcode.TooManyConnections},
+			wantBusy: true,
+		},
+		{
+			name:     "Взаимная блокировка транзакций",
+			err:      &pgconn.PgError{Code: pgerrcode.DeadlockDetected},
+			wantBusy: true,
+		},
+		{
+			name: "Нарушение уникальности",
+			err:  &pgconn.PgError{Code: pgerrcode.UniqueViolation},
+		},
+		{
+			name: "Ошибка модели",
+			err:  model.ErrNoRowsSelected,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := storageError(tt.err)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Equal(t, tt.wantBusy, errors.Is(err, model.ErrStorageBusy))
+			// повторная обертка не меняет ошибку
+			assert.Equal(t, err, storageError(err))
+		})
+	}
+}
+
 func TestStorageAddUser(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -21,7 +70,6 @@ func TestStorageAddUser(t *testing.T) {
 		password string
 		wantErr  bool
 	}{
-		// TODO: Add test cases.
 		{
 			name:     "Успешное добавление пользователя",
 			login:    "user17",
@@ -39,21 +87,22 @@ func TestStorageAddUser(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := s.AddUser(ctx, tt.login, utils.PasswordHash(tt.password)); (err != nil) != tt.wantErr {
+			passwordHash, err := utils.PasswordHash(tt.password, model.PasswordHashParams{})
+			require.NoError(t, err)
+			if err := s.AddUser(ctx, tt.login, passwordHash); (err != nil) != tt.wantErr {
 				t.Errorf("storage.AddUser() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func TestStorageCheckUserAuth(t *testing.T) {
+func TestStorageGetPasswordHash(t *testing.T) {
 	tests := []struct {
 		name     string
 		login    string
 		password string
 		wantErr  bool
 	}{
-		// TODO: Add test cases.
 		{
 			name:     "Успешная проверка",
 			login:    "user4",
@@ -61,18 +110,27 @@ func TestStorageCheckUserAuth(t *testing.T) {
 			wantErr:  false,
 		},
 		{
-			name:     "Неверный пароль",
-			login:    "user4",
-			password: "98765",
+			name:     "Несуществующий пользователь",
+			login:    "user_",
+			password: "123456",
 			wantErr:  true,
 		},
 	}
 	ctx, s := initStorage(t)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := s.CheckUserAuth(ctx, tt.login, tt.password); (err != nil) != tt.wantErr {
-				t.Errorf("storage.CheckUserAuth() error = %v, wantErr %v", err, tt.wantErr)
+			passwordHash, err := s.GetPasswordHash(ctx, tt.login)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("storage.GetPasswordHash() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
 			}
+			ok, _, err := utils.CheckPasswordHash(tt.password, passwordHash,
+				model.PasswordHashParams{})
+			require.NoError(t, err)
+			assert.True(t, ok)
 		})
 	}
 }
@@ -80,7 +138,7 @@ func TestStorageCheckUserAuth(t *testing.T) {
 func initStorage(t *testing.T) (context.Context, *storage) {
 	ctx := context.Background()
 	log := logger.InitLog(logrus.InfoLevel)
-	config, err := config.GetConfig(log)
+	config, err := config.GetConfig(log, config.Flags{})
 	require.NoError(t, err)
 	s, err := NewStorage(ctx, log, config)
 	require.NoError(t, err)
@@ -94,7 +152,6 @@ func TestStorageInsertData(t *testing.T) {
 		data    model.DataBlock
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешное добавление данных",
 			data: model.DataBlock{
@@ -132,7 +189,6 @@ func TestStorageChangeData(t *testing.T) {
 		data    model.DataBlock
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name: "Успешное изменение",
 			data: model.DataBlock{
@@ -143,17 +199,36 @@ func TestStorageChangeData(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Ошибка устаревшая версия",
+			data: model.DataBlock{
+				DataKeyWord: "key555",
+				Login:       "user3",
+				Data:        "stale_data",
+				Version:     1 << 40,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Ошибка запись не найдена",
+			data: model.DataBlock{
+				DataKeyWord: "no_such_key",
+				Login:       "user3",
+				Data:        "changed_data",
+			},
+			wantErr: true,
+		},
 	}
 	ctx, s := initStorage(t)
-	secretPassword := os.Getenv("GOPRIVATE")
-	require.NotEmpty(t, secretPassword)
+	secretPassword := testSecret
 	log := logger.InitLog(logrus.InfoLevel)
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			dataCipher, err := utils.GCMDataCipher(tt.data.Data, secretPassword, log)
 			require.NoError(t, err)
 			tt.data.CipherData = dataCipher
-			if err = s.ChangeData(ctx, tt.data); (err != nil) != tt.wantErr {
+			version, err := s.ChangeData(ctx, tt.data)
+			if (err != nil) != tt.wantErr {
 				t.Errorf("storage.ChangeData() error = %v, wantErr %v", err, tt.wantErr)
 			}
 			if err != nil {
@@ -162,6 +237,7 @@ func TestStorageChangeData(t *testing.T) {
 			changedData, err := s.GetData(ctx, tt.data.Login, tt.data.DataKeyWord)
 			require.NoError(t, err)
 			changed := changedData[0]
+			assert.Equal(t, version, changed.Version)
 
 			dataDecipher, err := utils.GCMDataDecipher(changed.CipherData, secretPassword,
 				log)
@@ -171,6 +247,147 @@ func TestStorageChangeData(t *testing.T) {
 	}
 }
 
+func TestStorageApplyOperation(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation model.SyncOperation
+		wantErr   error
+	}{
+		{
+			name: "Изменение над устаревшей версией",
+			operation: model.SyncOperation{
+				Data:        model.DataBlock{DataKeyWord: "key555", Data: "offline_data"},
+				BaseVersion: 1 << 40,
+			},
+			wantErr: model.ErrVersionMismatch,
+		},
+		{
+			name: "Добавление записи, созданной на другом устройстве",
+			operation: model.SyncOperation{
+				Data: model.DataBlock{DataKeyWord: "key555", Data: "offline_data"},
+			},
+			wantErr: model.ErrVersionMismatch,
+		},
+		{
+			name: "Удаление устаревшей версии",
+			operation: model.SyncOperation{
+				Data:        model.DataBlock{DataKeyWord: "key555"},
+				Deleted:     true,
+				BaseVersion: 1 << 40,
+			},
+			wantErr: model.ErrVersionMismatch,
+		},
+		{
+			name: "Удаление записи, созданной без связи, при занятом ключе",
+			operation: model.SyncOperation{
+				Data:    model.DataBlock{DataKeyWord: "key555"},
+				Deleted: true,
+			},
+			wantErr: model.ErrVersionMismatch,
+		},
+	}
+	ctx, s := initStorage(t)
+	secretPassword := testSecret
+	log := logger.InitLog(logrus.InfoLevel)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.operation.Data.Login = "user3"
+			dataCipher, err := utils.GCMDataCipher(tt.operation.Data.Data, secretPassword, log)
+			require.NoError(t, err)
+			tt.operation.Data.CipherData = dataCipher
+			err = s.ApplyOperation(ctx, "user3", tt.operation)
+			assert.ErrorIs(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestStorageHistory(t *testing.T) {
+	ctx, s := initStorage(t)
+	secretPassword := testSecret
+	log := logger.InitLog(logrus.InfoLevel)
+
+	data := model.DataBlock{Login: "user3", DataKeyWord: "history_key", Device: "laptop"}
+	for _, value := range []string{"first", "second"} {
+		dataCipher, err := utils.GCMDataCipher(value, secretPassword, log)
+		require.NoError(t, err)
+		data.CipherData = dataCipher
+		if data.Version == 0 {
+			require.NoError(t, s.DeleteData(ctx, data.Login, data.DataKeyWord))
+			require.NoError(t, s.InsertData(ctx, data))
+			data.Version = 1
+			continue
+		}
+		data.Version, err = s.ChangeData(ctx, data)
+		require.NoError(t, err)
+	}
+
+	// прежняя версия сохранена в истории с устройством, которое ее записало
+	revisions, err := s.GetHistory(ctx, data.Login, data.DataKeyWord)
+	require.NoError(t, err)
+	require.NotEmpty(t, revisions)
+	assert.Equal(t, int64(1), revisions[0].Data.Version)
+	assert.Equal(t, "laptop", revisions[0].Device)
+	first, err := utils.GCMDataDecipher(revisions[0].Data.CipherData, secretPassword, log)
+	require.NoError(t, err)
+	assert.Equal(t, "first", first)
+
+	_, err = s.RestoreRevision(ctx, data.Login, revisions[0].ID, data.Version-1, "phone")
+	assert.ErrorIs(t, err, model.ErrVersionMismatch)
+	version, err := s.RestoreRevision(ctx, data.Login, revisions[0].ID, data.Version, "phone")
+	require.NoError(t, err)
+	assert.Equal(t, data.Version+1, version)
+	_, err = s.RestoreRevision(ctx, "user_", revisions[0].ID, version, "phone")
+	assert.ErrorIs(t, err, model.ErrRevisionNotFound)
+
+	_, err = s.PruneHistory(ctx, 1, time.Time{})
+	require.NoError(t, err)
+	revisions, err = s.GetHistory(ctx, data.Login, data.DataKeyWord)
+	require.NoError(t, err)
+	assert.Len(t, revisions, 1)
+}
+
+func TestStorageTrash(t *testing.T) {
+	ctx, s := initStorage(t)
+	secretPassword := testSecret
+	log := logger.InitLog(logrus.InfoLevel)
+
+	dataCipher, err := utils.GCMDataCipher("trash_data", secretPassword, log)
+	require.NoError(t, err)
+	data := model.DataBlock{Login: "user3", DataKeyWord: "trash_key", CipherData: dataCipher}
+	_, err = s.PurgeTrash(ctx, data.Login, time.Time{})
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteData(ctx, data.Login, data.DataKeyWord))
+	require.NoError(t, s.InsertData(ctx, data))
+
+	// удаленная запись попадает в корзину и возвращается с новой версией
+	require.NoError(t, s.DeleteData(ctx, data.Login, data.DataKeyWord))
+	records, err := s.ListTrash(ctx, data.Login)
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	assert.Equal(t, data.DataKeyWord, records[0].Header.DataKeyWord)
+	version, err := s.RestoreFromTrash(ctx, data.Login, records[0].ID, "laptop")
+	require.NoError(t, err)
+	assert.Equal(t, records[0].Header.Version+1, version)
+	_, err = s.RestoreFromTrash(ctx, data.Login, records[0].ID, "laptop")
+	assert.ErrorIs(t, err, model.ErrTrashNotFound)
+
+	// ключ уже занят новой записью
+	require.NoError(t, s.DeleteData(ctx, data.Login, data.DataKeyWord))
+	require.NoError(t, s.InsertData(ctx, data))
+	records, err = s.ListTrash(ctx, data.Login)
+	require.NoError(t, err)
+	require.NotEmpty(t, records)
+	_, err = s.RestoreFromTrash(ctx, data.Login, records[0].ID, "laptop")
+	assert.ErrorIs(t, err, model.ErrDataKeyWordExists)
+
+	purged, err := s.PurgeTrash(ctx, data.Login, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(records)), purged)
+	records, err = s.ListTrash(ctx, data.Login)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
 func TestStorageGetData(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -178,7 +395,6 @@ func TestStorageGetData(t *testing.T) {
 		dataKeyWord string
 		wantErr     bool
 	}{
-		// TODO: Add test cases.
 		{
 			name:        "Ошибка данные не выбраны",
 			login:       "user_",
@@ -205,7 +421,6 @@ func TestStorageDeleteData(t *testing.T) {
 		dataKeyWord string
 		wantErr     bool
 	}{
-		// TODO: Add test cases.
 		{
 			name:        "Успешное удаление",
 			login:       "user3",
@@ -225,3 +440,54 @@ func TestStorageDeleteData(t *testing.T) {
 		})
 	}
 }
+
+func TestStorageListData(t *testing.T) {
+	tests := []struct {
+		name    string
+		sortBy  string
+		after   *model.DataHeader
+		wantErr bool
+	}{
+		{
+			name:    "Список по ключу",
+			sortBy:  model.SortByKey,
+			wantErr: false,
+		},
+		{
+			name:    "Следующая страница по дате изменения",
+			sortBy:  model.SortByUpdated,
+			after:   &model.DataHeader{DataKeyWord: "key1", UpdatedAt: time.Now()},
+			wantErr: false,
+		},
+		{
+			name:    "Следующая страница по размеру",
+			sortBy:  model.SortBySize,
+			after:   &model.DataHeader{DataKeyWord: "key1", Size: 10},
+			wantErr: false,
+		},
+		{
+			name:    "Сортировка по неизвестному полю",
+			sortBy:  "data; DROP TABLE dataTable",
+			wantErr: true,
+		},
+	}
+	ctx, s := initStorage(t)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query := model.ListQuery{SortBy: tt.sortBy, Descending: tt.after != nil}
+			_, err := s.ListData(ctx, "user_", query, tt.after, 10)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("storage.ListData() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+		})
+	}
+}
+
+func TestStorageConformance(t *testing.T) {
+	storertest.Run(t, func(t *testing.T) service.Storer {
+		_, s := initStorage(t)
+		t.Cleanup(s.Close)
+		return s
+	})
+}
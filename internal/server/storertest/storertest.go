@@ -0,0 +1,848 @@
+// Package storertest - общий набор проверок, который должна проходить каждая
+// реализация service.Storer. Проверки работают с уникальными логинами,
+// поэтому хранилище может быть общим для всех проверок и содержать
+// другие данные
+package storertest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"keeper/internal/model"
+	"keeper/internal/server/service"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// NewStorer возвращает проверяемое хранилище
+type NewStorer func(t *testing.T) service.Storer
+
+// loginCounter отличает логины, созданные в одну наносекунду
+var loginCounter atomic.Int64
+
+// Run запускает все проверки для хранилищ, которые создает newStorer
+func Run(t *testing.T, newStorer NewStorer) {
+	tests := []struct {
+		name string
+		test func(ctx context.Context, t *testing.T, s service.Storer, login string)
+	}{
+		{name: "Пользователи", test: testUsers},
+		{name: "Ключи пользователей", test: testUserKeys},
+		{name: "Проверочные значения хранилищ", test: testVaultChecks},
+		{name: "Токены обновления", test: testRefreshTokens},
+		{name: "Сессии", test: testSessions},
+		{name: "Второй фактор", test: testTOTP},
+		{name: "Незавершенные входы", test: testChallenges},
+		{name: "Данные", test: testData},
+		{name: "Операции синхронизации", test: testApplyOperation},
+		{name: "Изменения для синхронизации", test: testChanges},
+		{name: "Список записей", test: testListData},
+		{name: "Конфликты", test: testConflicts},
+		{name: "Корзина", test: testTrash},
+		{name: "История", test: testHistory},
+		{name: "Файлы", test: testFiles},
+		{name: "Ротация секрета", test: testKeyRotation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newStorer(t)
+			tt.test(ctx, t, s, addUser(ctx, t, s))
+		})
+	}
+}
+
+// addUser добавляет пользователя с уникальным логином
+func addUser(ctx context.Context, t *testing.T, s service.Storer) string {
+	login := fmt.Sprintf("storertest_%d_%d", time.Now().UnixNano(), loginCounter.Add(1))
+	require.NoError(t, s.AddUser(ctx, login, "hash_"+login))
+	return login
+}
+
+// insert добавляет запись и возвращает ее
+func insert(ctx context.Context, t *testing.T, s service.Storer, login string,
+	dataKeyWord string, value string) model.DataBlock {
+	data := model.DataBlock{
+		Login:       login,
+		DataKeyWord: dataKeyWord,
+		DataType:    "text",
+		CipherData:  []byte(value),
+		MetaData:    "meta_" + dataKeyWord,
+		Device:      "laptop",
+	}
+	require.NoError(t, s.InsertData(ctx, data))
+	data.Version = 1
+	return data
+}
+
+// get возвращает запись, которая должна существовать
+func get(ctx context.Context, t *testing.T, s service.Storer, login string,
+	dataKeyWord string) model.DataBlock {
+	data, err := s.GetData(ctx, login, dataKeyWord)
+	require.NoError(t, err)
+	require.Len(t, data, 1)
+	return data[0]
+}
+
+// currentVersion возвращает версию из ошибки несовпадения версии
+func currentVersion(t *testing.T, err error) int64 {
+	var mismatch *model.VersionMismatchError
+	require.True(t, errors.As(err, &mismatch), "ожидалась ошибка несовпадения версии, получено %v", err)
+	return mismatch.Current
+}
+
+func testUsers(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	passwordHash, err := s.GetPasswordHash(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, "hash_"+login, passwordHash)
+
+	assert.ErrorIs(t, s.AddUser(ctx, login, "other"), model.ErrUserAlreadyExists)
+	_, err = s.GetPasswordHash(ctx, login+"_unknown")
+	assert.ErrorIs(t, err, model.ErrUserNotFound)
+
+	require.NoError(t, s.UpdatePasswordHash(ctx, login, "rehashed"))
+	passwordHash, err = s.GetPasswordHash(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, "rehashed", passwordHash)
+}
+
+func testUserKeys(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	_, err := s.GetUserKey(ctx, login)
+	assert.ErrorIs(t, err, model.ErrNoRowsSelected)
+
+	require.NoError(t, s.AddUserKey(ctx, login, []byte("first")))
+	// существующий ключ не перезаписывается
+	require.NoError(t, s.AddUserKey(ctx, login, []byte("second")))
+	wrappedKey, err := s.GetUserKey(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), wrappedKey)
+}
+
+func testVaultChecks(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	check, err := s.GetVaultCheck(ctx, login)
+	require.NoError(t, err)
+	assert.Nil(t, check)
+
+	require.NoError(t, s.SetVaultCheck(ctx, login, []byte("first")))
+	// сохраненное значение не перезаписывается
+	err = s.SetVaultCheck(ctx, login, []byte("second"))
+	assert.ErrorIs(t, err, model.ErrVaultCheckExists)
+	check, err = s.GetVaultCheck(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("first"), check)
+}
+
+func testRefreshTokens(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	tokenHash := "token_" + login
+	refreshToken := model.RefreshToken{
+		Login:     login,
+		SessionID: "session_" + login,
+		ExpiresAt: time.Now().Add(time.Hour).Truncate(time.Second),
+	}
+	require.NoError(t, s.AddRefreshToken(ctx, tokenHash, refreshToken))
+
+	taken, err := s.TakeRefreshToken(ctx, tokenHash)
+	require.NoError(t, err)
+	assert.Equal(t, refreshToken.Login, taken.Login)
+	assert.Equal(t, refreshToken.SessionID, taken.SessionID)
+	assert.True(t, refreshToken.ExpiresAt.Equal(taken.ExpiresAt))
+
+	// токен можно использовать только один раз
+	_, err = s.TakeRefreshToken(ctx, tokenHash)
+	assert.ErrorIs(t, err, model.ErrRefreshTokenNotFound)
+}
+
+func testSessions(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	first, second, expired := login+"_first", login+"_second", login+"_expired"
+	require.NoError(t, s.AddSession(ctx, login, expired, time.Now().Add(-time.Minute)))
+	require.NoError(t, s.AddSession(ctx, login, first, time.Now().Add(time.Hour)))
+	require.NoError(t, s.AddSession(ctx, login, second, time.Now().Add(time.Hour)))
+	for _, sessionID := range []string{first, second} {
+		require.NoError(t, s.AddRefreshToken(ctx, "token_"+sessionID, model.RefreshToken{
+			Login:     login,
+			SessionID: sessionID,
+			ExpiresAt: time.Now().Add(time.Hour),
+		}))
+	}
+
+	for sessionID, want := range map[string]bool{first: true, expired: false,
+		login + "_unknown": false} {
+		active, err := s.IsSessionActive(ctx, sessionID)
+		require.NoError(t, err)
+		assert.Equal(t, want, active, sessionID)
+	}
+
+	// отзыв сессии удаляет токены, выданные в ней
+	require.NoError(t, s.RevokeSession(ctx, login, first))
+	active, err := s.IsSessionActive(ctx, first)
+	require.NoError(t, err)
+	assert.False(t, active)
+	_, err = s.TakeRefreshToken(ctx, "token_"+first)
+	assert.ErrorIs(t, err, model.ErrRefreshTokenNotFound)
+
+	// чужую сессию отозвать нельзя
+	require.NoError(t, s.RevokeSession(ctx, login+"_other", second))
+	active, err = s.IsSessionActive(ctx, second)
+	require.NoError(t, err)
+	assert.True(t, active)
+
+	revoked, err := s.RevokeAllSessions(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), revoked)
+	active, err = s.IsSessionActive(ctx, second)
+	require.NoError(t, err)
+	assert.False(t, active)
+	_, err = s.TakeRefreshToken(ctx, "token_"+second)
+	assert.ErrorIs(t, err, model.ErrRefreshTokenNotFound)
+}
+
+func testTOTP(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	_, err := s.GetTOTP(ctx, login)
+	assert.ErrorIs(t, err, model.ErrTOTPNotEnrolled)
+
+	require.NoError(t, s.SaveTOTP(ctx, login, []byte("secret")))
+	totp, err := s.GetTOTP(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("secret"), totp.CipherSecret)
+	assert.False(t, totp.Confirmed)
+
+	// до подтверждения шаг не принимается
+	used, err := s.UseTOTPStep(ctx, login, 5)
+	require.NoError(t, err)
+	assert.False(t, used)
+
+	require.NoError(t, s.ConfirmTOTP(ctx, login, 10, []string{"code1", "code2"}))
+	assert.ErrorIs(t, s.ConfirmTOTP(ctx, login, 11, nil), model.ErrIncorrectTOTPCode)
+	assert.ErrorIs(t, s.SaveTOTP(ctx, login, []byte("other")), model.ErrTOTPAlreadyEnabled)
+	totp, err = s.GetTOTP(ctx, login)
+	require.NoError(t, err)
+	assert.True(t, totp.Confirmed)
+	assert.Equal(t, int64(10), totp.LastStep)
+
+	for step, want := range map[int64]bool{10: false, 9: false} {
+		used, err = s.UseTOTPStep(ctx, login, step)
+		require.NoError(t, err)
+		assert.Equal(t, want, used, step)
+	}
+	used, err = s.UseTOTPStep(ctx, login, 11)
+	require.NoError(t, err)
+	assert.True(t, used)
+
+	used, err = s.UseRecoveryCode(ctx, login, "code1")
+	require.NoError(t, err)
+	assert.True(t, used)
+	used, err = s.UseRecoveryCode(ctx, login, "code1")
+	require.NoError(t, err)
+	assert.False(t, used)
+}
+
+func testChallenges(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	challengeHash := "challenge_" + login
+	require.NoError(t, s.AddChallenge(ctx, challengeHash, login, time.Now().Add(time.Minute)))
+
+	for attempts := 1; attempts <= 2; attempts++ {
+		challenge, err := s.CountChallengeAttempt(ctx, challengeHash)
+		require.NoError(t, err)
+		assert.Equal(t, login, challenge.Login)
+		assert.Equal(t, attempts, challenge.Attempts)
+	}
+
+	require.NoError(t, s.DeleteChallenge(ctx, challengeHash))
+	_, err := s.CountChallengeAttempt(ctx, challengeHash)
+	assert.ErrorIs(t, err, model.ErrChallengeExpired)
+}
+
+func testData(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	data := insert(ctx, t, s, login, "key", "first")
+	assert.ErrorIs(t, s.InsertData(ctx, data), model.ErrDataKeyWordExists)
+
+	got := get(ctx, t, s, login, "key")
+	assert.Equal(t, []byte("first"), got.CipherData)
+	assert.Equal(t, data.DataType, got.DataType)
+	assert.Equal(t, data.MetaData, got.MetaData)
+	assert.Equal(t, int64(1), got.Version)
+
+	data.CipherData = []byte("second")
+	version, err := s.ChangeData(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+	assert.Equal(t, []byte("second"), get(ctx, t, s, login, "key").CipherData)
+
+	// изменение устаревшей версии сообщает текущую
+	_, err = s.ChangeData(ctx, data)
+	assert.Equal(t, int64(2), currentVersion(t, err))
+	// нулевая версия изменяет запись без проверки
+	data.Version = 0
+	version, err = s.ChangeData(ctx, data)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), version)
+
+	data.DataKeyWord = "unknown"
+	_, err = s.ChangeData(ctx, data)
+	assert.ErrorIs(t, err, model.ErrNoRowsSelected)
+
+	require.NoError(t, s.DeleteData(ctx, login, "key"))
+	_, err = s.GetData(ctx, login, "key")
+	assert.ErrorIs(t, err, model.ErrNoRowsSelected)
+	err = s.DeleteData(ctx, login, "key")
+	assert.ErrorIs(t, err, model.ErrNoRowsSelected)
+}
+
+func testApplyOperation(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	operation := model.SyncOperation{
+		Data: model.DataBlock{DataKeyWord: "key", DataType: "text", CipherData: []byte("offline")},
+	}
+	require.NoError(t, s.ApplyOperation(ctx, login, operation))
+	// запись уже создана на другом устройстве
+	err := s.ApplyOperation(ctx, login, operation)
+	assert.Equal(t, int64(1), currentVersion(t, err))
+	// удаление записи, созданной без связи, не удаляет запись с тем же ключом
+	// на сервере
+	offline := model.SyncOperation{Data: model.DataBlock{DataKeyWord: "key"}, Deleted: true}
+	err = s.ApplyOperation(ctx, login, offline)
+	assert.Equal(t, int64(1), currentVersion(t, err))
+	assert.Equal(t, []byte("offline"), get(ctx, t, s, login, "key").CipherData)
+
+	operation.BaseVersion = 1
+	operation.Data.CipherData = []byte("changed")
+	require.NoError(t, s.ApplyOperation(ctx, login, operation))
+	assert.Equal(t, []byte("changed"), get(ctx, t, s, login, "key").CipherData)
+	err = s.ApplyOperation(ctx, login, operation)
+	assert.Equal(t, int64(2), currentVersion(t, err))
+
+	deletion := model.SyncOperation{
+		Data:        model.DataBlock{DataKeyWord: "key"},
+		Deleted:     true,
+		BaseVersion: 1,
+	}
+	err = s.ApplyOperation(ctx, login, deletion)
+	assert.Equal(t, int64(2), currentVersion(t, err))
+	deletion.BaseVersion = 2
+	require.NoError(t, s.ApplyOperation(ctx, login, deletion))
+	require.NoError(t, s.ApplyOperation(ctx, login, offline))
+
+	// изменение удаленной записи - конфликт с нулевой текущей версией
+	operation.BaseVersion = 2
+	err = s.ApplyOperation(ctx, login, operation)
+	assert.Equal(t, int64(0), currentVersion(t, err))
+}
+
+func testChanges(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	first := insert(ctx, t, s, login, "first", "value")
+	insert(ctx, t, s, login, "second", "value")
+	first.CipherData = []byte("changed")
+	_, err := s.ChangeData(ctx, first)
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteData(ctx, login, "second"))
+
+	changes, err := s.GetChanges(ctx, login, model.ChangePosition{}, 100)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.True(t, changes[1].After(changes[0].ChangePosition))
+
+	assert.Equal(t, "first", changes[0].Header.DataKeyWord)
+	assert.False(t, changes[0].Deleted)
+	assert.Equal(t, int64(2), changes[0].Header.Version)
+	assert.Equal(t, int64(len("changed")), changes[0].Header.Size)
+	assert.Equal(t, []byte("changed"), changes[0].Data.CipherData)
+	assert.Equal(t, first.MetaData, changes[0].Data.MetaData)
+
+	assert.Equal(t, "second", changes[1].Header.DataKeyWord)
+	assert.Equal(t, "second", changes[1].Data.DataKeyWord)
+	assert.True(t, changes[1].Deleted)
+
+	changes, err = s.GetChanges(ctx, login, changes[0].ChangePosition, 100)
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	assert.Equal(t, "second", changes[0].Header.DataKeyWord)
+
+	changes, err = s.GetChanges(ctx, login, model.ChangePosition{}, 1)
+	require.NoError(t, err)
+	assert.Len(t, changes, 1)
+}
+
+func testListData(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	insert(ctx, t, s, login, "note/b", "12")
+	insert(ctx, t, s, login, "note/a", "1234")
+	card := model.DataBlock{Login: login, DataKeyWord: "card", DataType: "card",
+		CipherData: []byte("123")}
+	require.NoError(t, s.InsertData(ctx, card))
+
+	keys := func(headers []model.DataHeader) []string {
+		var keys []string
+		for _, header := range headers {
+			keys = append(keys, header.DataKeyWord)
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name     string
+		query    model.ListQuery
+		wantKeys []string
+	}{
+		{name: "По ключу", query: model.ListQuery{SortBy: model.SortByKey},
+			wantKeys: []string{"card", "note/a", "note/b"}},
+		{name: "По ключу в обратном порядке",
+			query:    model.ListQuery{SortBy: model.SortByKey, Descending: true},
+			wantKeys: []string{"note/b", "note/a", "card"}},
+		{name: "По размеру", query: model.ListQuery{SortBy: model.SortBySize},
+			wantKeys: []string{"note/b", "card", "note/a"}},
+		{name: "По типу", query: model.ListQuery{SortBy: model.SortByType},
+			wantKeys: []string{"card", "note/a", "note/b"}},
+		{name: "Фильтр по типу", query: model.ListQuery{SortBy: model.SortByKey, DataType: "card"},
+			wantKeys: []string{"card"}},
+		{name: "Фильтр по префиксу ключа",
+			query:    model.ListQuery{SortBy: model.SortByKey, KeyPrefix: "note/"},
+			wantKeys: []string{"note/a", "note/b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers, err := s.ListData(ctx, login, tt.query, nil, 10)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantKeys, keys(headers))
+
+			// постраничный вывод возвращает те же записи
+			var paged []model.DataHeader
+			var after *model.DataHeader
+			for {
+				page, err := s.ListData(ctx, login, tt.query, after, 1)
+				require.NoError(t, err)
+				if len(page) == 0 {
+					break
+				}
+				paged = append(paged, page...)
+				after = &page[0]
+			}
+			assert.Equal(t, tt.wantKeys, keys(paged))
+		})
+	}
+
+	headers, err := s.ListData(ctx, login, model.ListQuery{SortBy: model.SortByKey,
+		KeyPrefix: "card"}, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, headers, 1)
+	assert.Equal(t, int64(3), headers[0].Size)
+	assert.Equal(t, int64(1), headers[0].Version)
+	assert.False(t, headers[0].CreatedAt.IsZero())
+
+	_, err = s.ListData(ctx, login, model.ListQuery{SortBy: "data; DROP TABLE dataTable"}, nil, 10)
+	assert.ErrorIs(t, err, model.ErrInvalidSortField)
+}
+
+func testConflicts(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	theirs := insert(ctx, t, s, login, "key", "theirs")
+	insert(ctx, t, s, login, "taken", "value")
+	base := theirs
+	conflict := model.Conflict{
+		DataKeyWord: "key",
+		BaseVersion: 1,
+		Base:        &base,
+		Mine: model.DataBlock{DataKeyWord: "key", DataType: "text", CipherData: []byte("mine"),
+			Device: "phone"},
+	}
+	require.NoError(t, s.AddConflict(ctx, login, conflict))
+	require.NoError(t, s.AddConflict(ctx, login, conflict))
+
+	conflicts, err := s.GetConflicts(ctx, login, "")
+	require.NoError(t, err)
+	require.Len(t, conflicts, 2)
+	assert.Less(t, conflicts[0].ID, conflicts[1].ID)
+	assert.Equal(t, []byte("mine"), conflicts[0].Mine.CipherData)
+	require.NotNil(t, conflicts[0].Base)
+	assert.Equal(t, []byte("theirs"), conflicts[0].Base.CipherData)
+	require.NotNil(t, conflicts[0].Theirs)
+	assert.Equal(t, int64(1), conflicts[0].Theirs.Version)
+	other, err := s.GetConflicts(ctx, login, "other")
+	require.NoError(t, err)
+	assert.Empty(t, other)
+
+	resolution := model.ConflictResolution{ConflictID: conflicts[0].ID, Strategy: "unknown"}
+	_, err = s.ResolveConflict(ctx, login, resolution)
+	assert.ErrorIs(t, err, model.ErrUnknownResolution)
+	resolution.Strategy = model.ResolveKeepBoth
+	_, err = s.ResolveConflict(ctx, login, resolution)
+	assert.ErrorIs(t, err, model.ErrRenamedKeyRequired)
+	// при ошибке конфликт остается
+	resolution.RenamedKey = "taken"
+	_, err = s.ResolveConflict(ctx, login, resolution)
+	assert.ErrorIs(t, err, model.ErrDataKeyWordExists)
+	conflicts, err = s.GetConflicts(ctx, login, "key")
+	require.NoError(t, err)
+	assert.Len(t, conflicts, 2)
+
+	resolution.RenamedKey = "key (copy)"
+	_, err = s.ResolveConflict(ctx, login, resolution)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("mine"), get(ctx, t, s, login, "key (copy)").CipherData)
+	_, err = s.ResolveConflict(ctx, login, resolution)
+	assert.ErrorIs(t, err, model.ErrConflictNotFound)
+
+	mine := model.ConflictResolution{
+		ConflictID:      conflicts[1].ID,
+		Strategy:        model.ResolveKeepMine,
+		ExpectedVersion: 2,
+	}
+	_, err = s.ResolveConflict(ctx, login, mine)
+	assert.Equal(t, int64(1), currentVersion(t, err))
+	mine.ExpectedVersion = 1
+	version, err := s.ResolveConflict(ctx, login, mine)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+	assert.Equal(t, []byte("mine"), get(ctx, t, s, login, "key").CipherData)
+
+	conflicts, err = s.GetConflicts(ctx, login, "")
+	require.NoError(t, err)
+	assert.Empty(t, conflicts)
+
+	// изменение клиента сохраняется под новым ключом обычной записью,
+	// файл исходной записи ему не достается
+	fileID := login + "_file"
+	require.NoError(t, s.CreateFileUpload(ctx, login, fileID, time.Now().Add(-time.Hour)))
+	require.NoError(t, s.AddFileChunk(ctx, fileID, 0, []byte("chunk")))
+	require.NoError(t, s.CompleteFileUpload(ctx, model.DataBlock{Login: login,
+		DataKeyWord: "file", DataType: model.DataTypeFile, CipherData: []byte("info"),
+		FileID: fileID}))
+	require.NoError(t, s.AddConflict(ctx, login, model.Conflict{
+		DataKeyWord: "file",
+		BaseVersion: 1,
+		Mine:        model.DataBlock{DataKeyWord: "file", DataType: "text", CipherData: []byte("note")},
+	}))
+	require.NoError(t, s.AddConflict(ctx, login, model.Conflict{
+		DataKeyWord: "file",
+		BaseVersion: 1,
+		Mine: model.DataBlock{DataKeyWord: "file", DataType: model.DataTypeFile,
+			CipherData: []byte("renamed info")},
+	}))
+	conflicts, err = s.GetConflicts(ctx, login, "file")
+	require.NoError(t, err)
+	require.Len(t, conflicts, 2)
+	_, err = s.ResolveConflict(ctx, login, model.ConflictResolution{
+		ConflictID: conflicts[0].ID,
+		Strategy:   model.ResolveKeepBoth,
+		RenamedKey: "file (copy)",
+	})
+	require.NoError(t, err)
+	copied := get(ctx, t, s, login, "file (copy)")
+	assert.Empty(t, copied.FileID)
+	assert.Equal(t, "text", copied.DataType)
+	assert.Equal(t, []byte("note"), copied.CipherData)
+
+	// описание частей файла под новым ключом не сохраняется, конфликт остается
+	both := model.ConflictResolution{
+		ConflictID: conflicts[1].ID,
+		Strategy:   model.ResolveKeepBoth,
+		RenamedKey: "file (info)",
+	}
+	_, err = s.ResolveConflict(ctx, login, both)
+	assert.ErrorIs(t, err, model.ErrKeepBothFile)
+	_, err = s.GetData(ctx, login, "file (info)")
+	assert.ErrorIs(t, err, model.ErrNoRowsSelected)
+	both.Strategy = model.ResolveKeepTheirs
+	_, err = s.ResolveConflict(ctx, login, both)
+	require.NoError(t, err)
+	assert.Equal(t, fileID, get(ctx, t, s, login, "file").FileID)
+
+	chunks := func() int {
+		var count int
+		require.NoError(t, s.GetFileChunks(ctx, fileID, func(seq int64, cipherChunk []byte) error {
+			count++
+			return nil
+		}))
+		return count
+	}
+	assert.Equal(t, 1, chunks())
+	require.NoError(t, s.DeleteData(ctx, login, "file"))
+	_, err = s.PurgeTrash(ctx, login, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, 0, chunks())
+	assert.Equal(t, []byte("note"), get(ctx, t, s, login, "file (copy)").CipherData)
+}
+
+func testTrash(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	data := insert(ctx, t, s, login, "key", "value")
+	require.NoError(t, s.DeleteData(ctx, login, "key"))
+
+	records, err := s.ListTrash(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "key", records[0].Header.DataKeyWord)
+	assert.Equal(t, data.MetaData, records[0].Header.MetaData)
+	assert.Equal(t, int64(1), records[0].Header.Version)
+	assert.Equal(t, int64(len("value")), records[0].Header.Size)
+
+	// версия записи продолжает расти с версии до удаления
+	version, err := s.RestoreFromTrash(ctx, login, records[0].ID, "phone")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), version)
+	assert.Equal(t, []byte("value"), get(ctx, t, s, login, "key").CipherData)
+	_, err = s.RestoreFromTrash(ctx, login, records[0].ID, "phone")
+	assert.ErrorIs(t, err, model.ErrTrashNotFound)
+
+	// ключ уже занят новой записью
+	require.NoError(t, s.DeleteData(ctx, login, "key"))
+	insert(ctx, t, s, login, "key", "new")
+	require.NoError(t, s.DeleteData(ctx, login, "key"))
+	insert(ctx, t, s, login, "key", "newest")
+	records, err = s.ListTrash(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	// последняя удаленная запись первая
+	assert.Greater(t, records[0].ID, records[1].ID)
+	_, err = s.RestoreFromTrash(ctx, login, records[0].ID, "phone")
+	assert.ErrorIs(t, err, model.ErrDataKeyWordExists)
+	_, err = s.RestoreFromTrash(ctx, login+"_other", records[0].ID, "phone")
+	assert.ErrorIs(t, err, model.ErrTrashNotFound)
+
+	purged, err := s.PurgeTrash(ctx, login, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), purged)
+	purged, err = s.PurgeTrash(ctx, login, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+	records, err = s.ListTrash(ctx, login)
+	require.NoError(t, err)
+	assert.Empty(t, records)
+}
+
+func testHistory(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	data := insert(ctx, t, s, login, "key", "first")
+	for _, value := range []string{"second", "third"} {
+		data.CipherData = []byte(value)
+		data.Device = "device_" + value
+		version, err := s.ChangeData(ctx, data)
+		require.NoError(t, err)
+		data.Version = version
+	}
+
+	// прежние версии начиная с последней, с устройством, которое их записало
+	revisions, err := s.GetHistory(ctx, login, "key")
+	require.NoError(t, err)
+	require.Len(t, revisions, 2)
+	assert.Equal(t, int64(2), revisions[0].Data.Version)
+	assert.Equal(t, []byte("second"), revisions[0].Data.CipherData)
+	assert.Equal(t, "device_second", revisions[0].Device)
+	assert.Equal(t, int64(1), revisions[1].Data.Version)
+	assert.Equal(t, "laptop", revisions[1].Device)
+	assert.False(t, revisions[1].Deleted)
+
+	first := revisions[1].ID
+	_, err = s.RestoreRevision(ctx, login, first, data.Version-1, "phone")
+	assert.Equal(t, data.Version, currentVersion(t, err))
+	_, err = s.RestoreRevision(ctx, login+"_other", first, data.Version, "phone")
+	assert.ErrorIs(t, err, model.ErrRevisionNotFound)
+	version, err := s.RestoreRevision(ctx, login, first, data.Version, "phone")
+	require.NoError(t, err)
+	assert.Equal(t, data.Version+1, version)
+	assert.Equal(t, []byte("first"), get(ctx, t, s, login, "key").CipherData)
+
+	// удаленная запись тоже попадает в историю и восстанавливается заново
+	require.NoError(t, s.DeleteData(ctx, login, "key"))
+	revisions, err = s.GetHistory(ctx, login, "key")
+	require.NoError(t, err)
+	require.Len(t, revisions, 4)
+	assert.True(t, revisions[0].Deleted)
+	version, err = s.RestoreRevision(ctx, login, revisions[0].ID, 0, "phone")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), version)
+
+	_, err = s.PruneHistory(ctx, 1, time.Time{})
+	require.NoError(t, err)
+	revisions, err = s.GetHistory(ctx, login, "key")
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.True(t, revisions[0].Deleted)
+}
+
+func testFiles(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	fileID := login + "_file"
+	require.NoError(t, s.CreateFileUpload(ctx, login, fileID, time.Now().Add(-time.Hour)))
+	chunks := [][]byte{[]byte("chunk0"), []byte("chunk_1")}
+	for seq, chunk := range chunks {
+		require.NoError(t, s.AddFileChunk(ctx, fileID, int64(seq), chunk))
+	}
+	assert.ErrorIs(t, s.AddFileChunk(ctx, login+"_unknown", 0, []byte("chunk")),
+		model.ErrUploadNotFound)
+
+	file := model.DataBlock{Login: login, DataKeyWord: "file", DataType: model.DataTypeFile,
+		CipherData: []byte("info"), FileID: fileID}
+	require.NoError(t, s.CompleteFileUpload(ctx, file))
+	assert.ErrorIs(t, s.CompleteFileUpload(ctx, file), model.ErrDataKeyWordExists)
+	stored := get(ctx, t, s, login, "file")
+	assert.Equal(t, fileID, stored.FileID)
+
+	// запись с файлом не изменяется ни напрямую, ни операцией синхронизации
+	edit := model.DataBlock{Login: login, DataKeyWord: "file", DataType: "text",
+		CipherData: []byte("edit"), Version: stored.Version}
+	_, err := s.ChangeData(ctx, edit)
+	assert.ErrorIs(t, err, model.ErrFileReadOnly)
+	err = s.ApplyOperation(ctx, login, model.SyncOperation{Data: edit, BaseVersion: stored.Version})
+	assert.ErrorIs(t, err, model.ErrFileReadOnly)
+	// при устаревшей версии это конфликт версий
+	edit.Version = stored.Version + 1
+	_, err = s.ChangeData(ctx, edit)
+	assert.Equal(t, stored.Version, currentVersion(t, err))
+	unchanged := get(ctx, t, s, login, "file")
+	assert.Equal(t, model.DataTypeFile, unchanged.DataType)
+	assert.Equal(t, []byte("info"), unchanged.CipherData)
+	assert.Equal(t, stored.Version, unchanged.Version)
+
+	var got [][]byte
+	require.NoError(t, s.GetFileChunks(ctx, fileID, func(seq int64, cipherChunk []byte) error {
+		assert.Equal(t, int64(len(got)), seq)
+		got = append(got, cipherChunk)
+		return nil
+	}))
+	assert.Equal(t, chunks, got)
+
+	// размер файла складывается из размеров частей
+	headers, err := s.ListData(ctx, login, model.ListQuery{SortBy: model.SortByKey}, nil, 10)
+	require.NoError(t, err)
+	require.Len(t, headers, 1)
+	assert.Equal(t, int64(len("info")+len(bytes.Join(chunks, nil))), headers[0].Size)
+
+	// незавершенная загрузка удаляется вместе с частями
+	abandoned := login + "_abandoned"
+	require.NoError(t, s.CreateFileUpload(ctx, login, abandoned, time.Now().Add(-time.Hour)))
+	require.NoError(t, s.AddFileChunk(ctx, abandoned, 0, []byte("chunk")))
+	require.NoError(t, s.DeleteFileUpload(ctx, abandoned))
+	require.NoError(t, s.GetFileChunks(ctx, abandoned, func(seq int64, cipherChunk []byte) error {
+		t.Errorf("часть %d удаленной загрузки", seq)
+		return nil
+	}))
+
+	// устаревшие незавершенные загрузки удаляются при создании новой,
+	// завершенные остаются
+	require.NoError(t, s.CreateFileUpload(ctx, login, abandoned, time.Now().Add(-time.Hour)))
+	require.NoError(t, s.CreateFileUpload(ctx, login, login+"_next", time.Now().Add(time.Hour)))
+	assert.ErrorIs(t, s.AddFileChunk(ctx, abandoned, 0, []byte("chunk")), model.ErrUploadNotFound)
+	assert.NoError(t, s.AddFileChunk(ctx, fileID, 2, []byte("chunk2")))
+
+	// ошибка fn прерывает чтение
+	errStop := errors.New("stop")
+	calls := 0
+	err = s.GetFileChunks(ctx, fileID, func(seq int64, cipherChunk []byte) error {
+		calls++
+		return errStop
+	})
+	assert.ErrorIs(t, err, errStop)
+	assert.Equal(t, 1, calls)
+}
+
+func testKeyRotation(ctx context.Context, t *testing.T, s service.Storer, login string) {
+	require.NoError(t, s.AddUserKey(ctx, login, []byte("key")))
+	first := insert(ctx, t, s, login, "first", "old")
+	insert(ctx, t, s, login, "second", "data")
+	first.CipherData = []byte("data")
+	_, err := s.ChangeData(ctx, first)
+	require.NoError(t, err)
+	insert(ctx, t, s, login, "third", "gone")
+	require.NoError(t, s.DeleteData(ctx, login, "third"))
+	base := model.DataBlock{DataKeyWord: "second", CipherData: []byte("base")}
+	require.NoError(t, s.AddConflict(ctx, login, model.Conflict{
+		DataKeyWord: "second",
+		BaseVersion: 1,
+		Base:        &base,
+		Mine:        model.DataBlock{DataKeyWord: "second", CipherData: []byte("mine")},
+	}))
+	// в конфликте удаления без исходной версии перешифровывать нечего
+	require.NoError(t, s.AddConflict(ctx, login, model.Conflict{
+		DataKeyWord: "first",
+		BaseVersion: 1,
+		Mine:        model.DataBlock{DataKeyWord: "first"},
+		MineDeleted: true,
+	}))
+
+	rotation, err := s.GetKeyRotation(ctx, "rotation_"+login)
+	require.NoError(t, err)
+	assert.Equal(t, model.RotationPhaseKeys, rotation.Phase)
+
+	// ключи и данные других пользователей хранилища не меняются
+	for rotation.Phase == model.RotationPhaseKeys {
+		rotation, err = s.RotateUserKeysBatch(ctx, rotation, 2,
+			func(keyLogin string, wrappedKey []byte) ([]byte, error) {
+				if keyLogin != login {
+					return wrappedKey, nil
+				}
+				return append(wrappedKey, "_rotated"...), nil
+			})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, model.RotationPhaseData, rotation.Phase)
+	wrappedKey, err := s.GetUserKey(ctx, login)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("key_rotated"), wrappedKey)
+
+	saved, err := s.GetKeyRotation(ctx, rotation.RotationID)
+	require.NoError(t, err)
+	assert.Equal(t, rotation, saved)
+
+	var resealed []string
+	reseal := func(data model.DataBlock) ([]byte, bool, error) {
+		if data.Login != login {
+			return nil, false, nil
+		}
+		resealed = append(resealed, data.DataKeyWord)
+		return []byte(strings.ToUpper(string(data.CipherData))), true, nil
+	}
+	for rotation.Phase == model.RotationPhaseData {
+		rotation, err = s.RotateDataBatch(ctx, rotation, 2, reseal)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, model.RotationPhaseHistory, rotation.Phase)
+	assert.Equal(t, []string{"first", "second"}, resealed)
+	// перешифровка не меняет версию записи
+	assert.Equal(t, int64(2), get(ctx, t, s, login, "first").Version)
+	assert.Equal(t, int64(1), get(ctx, t, s, login, "second").Version)
+
+	resealed = nil
+	for rotation.Phase == model.RotationPhaseHistory {
+		rotation, err = s.RotateHistoryBatch(ctx, rotation, 2, reseal)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, model.RotationPhaseTrash, rotation.Phase)
+	assert.Equal(t, []string{"first", "third"}, resealed)
+
+	resealed = nil
+	for rotation.Phase == model.RotationPhaseTrash {
+		rotation, err = s.RotateTrashBatch(ctx, rotation, 2, reseal)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, model.RotationPhaseConflicts, rotation.Phase)
+	assert.Equal(t, []string{"third"}, resealed)
+
+	resealed = nil
+	for rotation.Phase == model.RotationPhaseConflicts {
+		rotation, err = s.RotateConflictsBatch(ctx, rotation, 2, reseal)
+		require.NoError(t, err)
+	}
+	assert.Equal(t, model.RotationPhaseDone, rotation.Phase)
+	assert.Equal(t, []string{"second", "second"}, resealed)
+	conflicts, err := s.GetConflicts(ctx, login, "second")
+	require.NoError(t, err)
+	require.Len(t, conflicts, 1)
+	require.NotNil(t, conflicts[0].Base)
+	assert.Equal(t, []byte("BASE"), conflicts[0].Base.CipherData)
+	assert.Equal(t, []byte("MINE"), conflicts[0].Mine.CipherData)
+
+	for _, dataKeyWord := range []string{"first", "second"} {
+		assert.Equal(t, []byte("DATA"), get(ctx, t, s, login, dataKeyWord).CipherData)
+	}
+	revisions, err := s.GetHistory(ctx, login, "first")
+	require.NoError(t, err)
+	require.Len(t, revisions, 1)
+	assert.Equal(t, []byte("OLD"), revisions[0].Data.CipherData)
+
+	trash, err := s.ListTrash(ctx, login)
+	require.NoError(t, err)
+	require.Len(t, trash, 1)
+	_, err = s.RestoreFromTrash(ctx, login, trash[0].ID, "")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("GONE"), get(ctx, t, s, login, "third").CipherData)
+}
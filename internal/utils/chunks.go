@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"encoding/binary"
+	"keeper/internal/model"
+
+	"github.com/sirupsen/logrus"
+)
+
+// VaultChunkOverhead - на сколько байт увеличивается часть файла
+// при шифровании на клиенте: заголовок конверта, nonce и тег GCM
+const VaultChunkOverhead = envelopeHeaderSize + 12 + 16
+
+// chunkAAD привязывает часть файла к файлу и ее порядковому номеру,
+// чтобы части нельзя было переставить или подменить частями другого файла
+func chunkAAD(fileID string, seq int64) []byte {
+	aad := make([]byte, 8, 8+len(fileID))
+	binary.BigEndian.PutUint64(aad, uint64(seq))
+	return append(aad, fileID...)
+}
+
+// ChunkCipher шифрует часть файла ключом данных пользователя
+func ChunkCipher(chunk []byte, dataKey []byte, fileID string, seq int64,
+	log *logrus.Logger) ([]byte, error) {
+	aesGCM, err := newAESGCM(dataKey)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	cipherChunk, err := sealEnvelopeAAD(aesGCM, algChunkAES256GCM, chunk, chunkAAD(fileID, seq))
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return cipherChunk, nil
+}
+
+// ChunkDecipher дешифрует часть файла ключом данных пользователя
+func ChunkDecipher(cipherChunk []byte, dataKey []byte, fileID string, seq int64,
+	log *logrus.Logger) ([]byte, error) {
+	aesGCM, err := newAESGCM(dataKey)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	chunk, err := openEnvelopeAAD(aesGCM, algChunkAES256GCM, cipherChunk, chunkAAD(fileID, seq))
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return chunk, nil
+}
+
+// VaultChunkCipher шифрует на клиенте часть файла ключом хранилища.
+// Часть привязывается к ключу записи и порядковому номеру
+func VaultChunkCipher(chunk []byte, vaultKey []byte, dataKeyWord string,
+	seq int64) ([]byte, error) {
+	aesGCM, err := newAESGCM(vaultKey)
+	if err != nil {
+		return nil, err
+	}
+	return sealEnvelopeAAD(aesGCM, algClientChunkAES256GCM, chunk, chunkAAD(dataKeyWord, seq))
+}
+
+// VaultChunkDecipher дешифрует на клиенте часть файла, зашифрованную ключом хранилища
+func VaultChunkDecipher(cipherChunk []byte, vaultKey []byte, dataKeyWord string,
+	seq int64) ([]byte, error) {
+	aesGCM, err := newAESGCM(vaultKey)
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := openEnvelopeAAD(aesGCM, algClientChunkAES256GCM, cipherChunk,
+		chunkAAD(dataKeyWord, seq))
+	if err != nil {
+		return nil, model.ErrWrongMasterPassword
+	}
+	return chunk, nil
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"keeper/internal/logger"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkCipher(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	dataKey, err := GenerateDataKey()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name       string
+		openFileID string
+		openSeq    int64
+		wantErr    bool
+	}{
+		{
+			name:       "Расшифровка части файла",
+			openFileID: "file1",
+			openSeq:    3,
+			wantErr:    false,
+		},
+		{
+			name:       "Часть переставлена на другое место",
+			openFileID: "file1",
+			openSeq:    4,
+			wantErr:    true,
+		},
+		{
+			name:       "Часть подставлена в другой файл",
+			openFileID: "file2",
+			openSeq:    3,
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunk := []byte("little gopher")
+			cipherChunk, err := ChunkCipher(chunk, dataKey, "file1", 3, log)
+			require.NoError(t, err)
+
+			plainChunk, err := ChunkDecipher(cipherChunk, dataKey, tt.openFileID, tt.openSeq, log)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ChunkDecipher() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil {
+				assert.Equal(t, chunk, plainChunk)
+			}
+		})
+	}
+}
+
+func TestVaultChunkCipher(t *testing.T) {
+	vaultKey := DeriveVaultKey("user1", "master")
+	chunk := []byte("little gopher")
+
+	cipherChunk, err := VaultChunkCipher(chunk, vaultKey, "key1", 0)
+	require.NoError(t, err)
+	// часть файла не должна открываться как обычная запись
+	assert.False(t, IsClientSealed(cipherChunk))
+
+	plainChunk, err := VaultChunkDecipher(cipherChunk, vaultKey, "key1", 0)
+	require.NoError(t, err)
+	assert.Equal(t, chunk, plainChunk)
+
+	_, err = VaultChunkDecipher(cipherChunk, vaultKey, "key1", 1)
+	assert.Error(t, err)
+}
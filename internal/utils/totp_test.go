@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTOTPCode(t *testing.T) {
+	// тестовые векторы RFC 6238 для SHA1, последние 6 цифр
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).
+		EncodeToString([]byte("12345678901234567890"))
+
+	tests := []struct {
+		name string
+		time int64
+		want string
+	}{
+		{
+			name: "T = 59",
+			time: 59,
+			want: "287082",
+		},
+		{
+			name: "T = 1111111109",
+			time: 1111111109,
+			want: "081804",
+		},
+		{
+			name: "T = 2000000000",
+			time: 2000000000,
+			want: "279037",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, err := TOTPCode(secret, TOTPStep(time.Unix(tt.time, 0)))
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, code)
+		})
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+
+	now := time.Now()
+	step := TOTPStep(now)
+	code, err := TOTPCode(secret, step)
+	require.NoError(t, err)
+	prevCode, err := TOTPCode(secret, step-1)
+	require.NoError(t, err)
+	oldCode, err := TOTPCode(secret, step-3)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		wantStep int64
+		wantOk   bool
+	}{
+		{
+			name:     "Текущий код",
+			code:     code,
+			wantStep: step,
+			wantOk:   true,
+		},
+		{
+			name:     "Код предыдущего шага",
+			code:     prevCode,
+			wantStep: step - 1,
+			wantOk:   true,
+		},
+		{
+			name: "Устаревший код",
+			code: oldCode,
+		},
+		{
+			name:     "Повторное использование кода",
+			code:     code,
+			lastStep: step,
+		},
+		{
+			name: "Код неверной длины",
+			code: "123",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := ValidateTOTP(secret, tt.code, now, tt.lastStep)
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantStep, gotStep)
+		})
+	}
+}
+
+func TestGenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	unique := make(map[string]struct{})
+	for _, code := range codes {
+		unique[code] = struct{}{}
+	}
+	assert.Len(t, unique, len(codes))
+
+	// при вводе регистр и дефис не важны
+	assert.Equal(t, RecoveryCodeHash(codes[0]),
+		RecoveryCodeHash(strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))))
+	assert.True(t, strings.HasPrefix(TOTPURI("user1", "SECRET"), "otpauth://totp/GophKeeper:user1?"))
+}
@@ -4,39 +4,205 @@ import (
 	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
 	"keeper/internal/model"
+	"strings"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/argon2"
 	"google.golang.org/grpc/metadata"
 )
 
-// GenerateJWTToken генерирует jwt токен
+// Время жизни токенов
+const (
+	AccessTokenTTL  = 15 * time.Minute
+	RefreshTokenTTL = 30 * 24 * time.Hour
+
+	randomTokenLength = 32
+)
+
+// GenerateJWTToken генерирует jwt токен доступа с ограниченным сроком действия,
+// возвращает подписанный токен и его claims
 func GenerateJWTToken(login string, log *logrus.Logger,
-	secretPassword string) (string, error) {
+	secretPassword string) (string, model.Token, error) {
 	log.Debug("Генерируем JWT токен")
 
-	tk := &model.Token{Login: login}
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		log.Error(err.Error())
+		return "", model.Token{}, err
+	}
+
+	now := time.Now()
+	tk := &model.Token{
+		Login: login,
+		StandardClaims: jwt.StandardClaims{
+			Id:        hex.EncodeToString(jti),
+			IssuedAt:  now.Unix(),
+			ExpiresAt: now.Add(AccessTokenTTL).Unix(),
+		},
+	}
 
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, tk)
 	jwtString, err := token.SignedString([]byte(secretPassword))
 	if err != nil {
 		log.Error(err.Error())
+		return "", model.Token{}, err
+	}
+	return jwtString, *tk, nil
+}
+
+// Параметры argon2id по умолчанию для хэширования паролей
+const (
+	passwordHashTime    = 1
+	passwordHashMemory  = 64 * 1024
+	passwordHashThreads = 4
+	passwordHashLength  = 32
+	passwordSaltLength  = 16
+
+	passwordHashPrefix = "$argon2id$"
+)
+
+// passwordHashParams подставляет значения по умолчанию вместо незаданных параметров
+func passwordHashParams(params model.PasswordHashParams) model.PasswordHashParams {
+	if params.Time == 0 {
+		params.Time = passwordHashTime
+	}
+	if params.Memory == 0 {
+		params.Memory = passwordHashMemory
+	}
+	if params.Threads == 0 {
+		params.Threads = passwordHashThreads
+	}
+	return params
+}
+
+// GenerateRandomToken генерирует случайный токен (обновления, входа
+// со вторым фактором)
+func GenerateRandomToken() (string, error) {
+	token := make([]byte, randomTokenLength)
+	if _, err := rand.Read(token); err != nil {
 		return "", err
 	}
-	return jwtString, nil
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// TokenHash возвращает хэш случайного токена для хранения в бд.
+// Сам токен на сервере не сохраняется
+func TokenHash(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
 }
 
-// PasswordHash возвращает хэш пароля по методу SHA-256
-func PasswordHash(password string) [32]byte {
-	return sha256.Sum256([]byte(password))
+// PasswordHash возвращает хэш пароля по методу argon2id со случайной солью.
+// Параметры и соль кодируются в строку хэша:
+// $argon2id$v=19$m=65536,t=1,p=4$<соль>$<хэш>
+func PasswordHash(password string, params model.PasswordHashParams) (string, error) {
+	params = passwordHashParams(params)
+
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := argon2.IDKey([]byte(password), salt, params.Time, params.Memory,
+		params.Threads, passwordHashLength)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", passwordHashPrefix, argon2.Version,
+		params.Memory, params.Time, params.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(hash)), nil
 }
 
-// prepareAESGCM подготавливает режим AES-256 GCM,
-// возвращает вектор инициализации
+// CheckPasswordHash сравнивает пароль с сохраненным хэшем за постоянное время.
+// Возвращает признак совпадения и признак того, что хэш нужно пересчитать:
+// он сохранен в устаревшем формате SHA-256 или с другими параметрами
+func CheckPasswordHash(password string, passwordHash string,
+	params model.PasswordHashParams) (bool, bool, error) {
+
+	if !strings.HasPrefix(passwordHash, passwordHashPrefix) {
+		// устаревший формат: несоленый SHA-256
+		legacyHash := sha256.Sum256([]byte(password))
+		ok := subtle.ConstantTimeCompare(legacyHash[:], []byte(passwordHash)) == 1
+		return ok, true, nil
+	}
+
+	var version int
+	var stored model.PasswordHashParams
+	parts := strings.Split(passwordHash, "$")
+	if len(parts) != 6 {
+		return false, false, model.ErrInvalidPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return false, false, model.ErrInvalidPasswordHash
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &stored.Memory, &stored.Time,
+		&stored.Threads); err != nil {
+		return false, false, model.ErrInvalidPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, model.ErrInvalidPasswordHash
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, model.ErrInvalidPasswordHash
+	}
+
+	inputHash := argon2.IDKey([]byte(password), salt, stored.Time, stored.Memory,
+		stored.Threads, uint32(len(hash)))
+	ok := subtle.ConstantTimeCompare(inputHash, hash) == 1
+
+	needsRehash := version != argon2.Version || stored != passwordHashParams(params)
+	return ok, needsRehash, nil
+}
+
+// Версии и алгоритмы конверта зашифрованных данных.
+// Конверт имеет вид: версия (1 байт) | алгоритм (1 байт) | nonce | шифротекст
+const (
+	cipherVersion1 byte = 1
+	// algAES256GCM - данные зашифрованы на сервере
+	algAES256GCM byte = 1
+	// algClientAES256GCM - данные зашифрованы на клиенте ключом хранилища,
+	// сервер хранит их как есть
+	algClientAES256GCM byte = 2
+	// algDataKeyAES256GCM - данные зашифрованы ключом данных пользователя
+	algDataKeyAES256GCM byte = 3
+	// algKeyWrapAES256GCM - ключ данных пользователя, обернутый
+	// ключом шифрования ключей
+	algKeyWrapAES256GCM byte = 4
+	// algChunkAES256GCM - часть файла, зашифрованная ключом данных пользователя
+	algChunkAES256GCM byte = 5
+	// algClientChunkAES256GCM - часть файла, зашифрованная на клиенте
+	// ключом хранилища
+	algClientChunkAES256GCM byte = 6
+
+	dataKeyLength = 32
+
+	envelopeHeaderSize = 2
+)
+
+// Параметры argon2id для получения ключа хранилища из мастер-пароля
+const (
+	vaultKeyTime    = 3
+	vaultKeyMemory  = 64 * 1024
+	vaultKeyThreads = 4
+	vaultKeyLength  = 32
+)
+
+// vaultCheckValue шифруется ключом хранилища в проверочное значение.
+// Успешная расшифровка проверочного значения подтверждает мастер-пароль
+const vaultCheckValue = "keeper-vault"
+
+// prepareAESGCM подготавливает режим AES-256 GCM
 func prepareAESGCM(log *logrus.Logger,
-	secretPassword string) (cipher.AEAD, []byte, error) {
+	secretPassword string) (cipher.AEAD, error) {
 
 	// Создаем новый хеш SHA-256.
 	hash := sha256.New()
@@ -45,86 +211,391 @@ func prepareAESGCM(log *logrus.Logger,
 	_, err := hash.Write([]byte(secretPassword))
 	if err != nil {
 		log.Error(err.Error())
-		return nil, nil, err
+		return nil, err
 	}
 
-	key := hash.Sum(nil)
+	aesGCM, err := newAESGCM(hash.Sum(nil))
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return aesGCM, nil
+}
 
+// newAESGCM создает AES-256 GCM по готовому ключу
+func newAESGCM(key []byte) (cipher.AEAD, error) {
 	// Создаем AES-256 GCM блок с использованием ключа
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		log.Error(err.Error())
-		return nil, nil, err
+		return nil, err
 	}
 
 	// создаем GCM режим шифрования
-	aesGCM, err := cipher.NewGCM(block)
-	if err != nil {
-		log.Error(err.Error())
-		return nil, nil, err
-	}
+	return cipher.NewGCM(block)
+}
 
-	// создаем вектор инициализации из последних байт ключа
-	iv := []byte(secretPassword[len(secretPassword)-aesGCM.NonceSize():])
-	return aesGCM, iv, nil
+// legacyNonce возвращает вектор инициализации в устаревшем формате:
+// последние байты секрета. Используется только для расшифровки старых записей
+func legacyNonce(aesGCM cipher.AEAD, secretPassword string) ([]byte, error) {
+	if len(secretPassword) < aesGCM.NonceSize() {
+		return nil, model.ErrLegacyCipher
+	}
+	return []byte(secretPassword[len(secretPassword)-aesGCM.NonceSize():]), nil
 }
 
-// GCMDataCipher шифрует данные по методу AES-256 GCM
+// GCMDataCipher шифрует данные по методу AES-256 GCM.
+// Для каждой записи генерируется случайный nonce, результат
+// упаковывается в конверт с версией и идентификатором алгоритма
 func GCMDataCipher(data string, secretPassword string,
 	log *logrus.Logger) ([]byte, error) {
 
-	aesGCM, nonce, err := prepareAESGCM(log, secretPassword)
+	aesGCM, err := prepareAESGCM(log, secretPassword)
 	if err != nil {
 		return nil, err
 	}
-	// шифруем данные
-	cipherData := aesGCM.Seal(nil, nonce, []byte(data), nil)
-	return cipherData, nil
+
+	envelope, err := sealEnvelope(aesGCM, algAES256GCM, []byte(data))
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return envelope, nil
+}
+
+// sealEnvelope шифрует данные со случайным nonce и упаковывает их в конверт
+func sealEnvelope(aesGCM cipher.AEAD, alg byte, data []byte) ([]byte, error) {
+	return sealEnvelopeAAD(aesGCM, alg, data, nil)
+}
+
+// sealEnvelopeAAD шифрует данные как sealEnvelope и дополнительно привязывает
+// шифротекст к aad: расшифровать его можно только с теми же aad
+func sealEnvelopeAAD(aesGCM cipher.AEAD, alg byte, data []byte, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aesGCM.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	envelope := make([]byte, 0, envelopeHeaderSize+len(nonce)+len(data)+aesGCM.Overhead())
+	envelope = append(envelope, cipherVersion1, alg)
+	envelope = append(envelope, nonce...)
+	// шифруем данные, заголовок конверта защищаем как дополнительные данные
+	additionalData := append(envelope[:envelopeHeaderSize:envelopeHeaderSize], aad...)
+	return aesGCM.Seal(envelope, nonce, data, additionalData), nil
 }
 
-// GCMDataDecipher дешифрует данные по методу AES-256 GCM
+// GCMDataDecipher дешифрует данные по методу AES-256 GCM.
+// Данные в устаревшем формате (без конверта) тоже расшифровываются
 func GCMDataDecipher(cipherData []byte, secretPassword string, log *logrus.Logger) (string, error) {
 	log.Debug("Дешифруем данные")
 
-	aesGCM, iv, err := prepareAESGCM(log, secretPassword)
+	aesGCM, err := prepareAESGCM(log, secretPassword)
 	if err != nil {
 		return "", err
 	}
 
-	// Расшифровать данные
-	plainData, err := aesGCM.Open(nil, iv, cipherData, nil)
+	plainData, err := openEnvelope(aesGCM, algAES256GCM, cipherData)
+	if err != nil {
+		// запись могла быть зашифрована в устаревшем формате
+		var legacyErr error
+		plainData, legacyErr = openLegacy(aesGCM, cipherData, secretPassword)
+		if legacyErr != nil {
+			log.Error(err.Error())
+			return "", err
+		}
+	}
+	return string(plainData), nil
+}
+
+// openEnvelope разбирает конверт с ожидаемым алгоритмом и расшифровывает данные
+func openEnvelope(aesGCM cipher.AEAD, alg byte, envelope []byte) ([]byte, error) {
+	return openEnvelopeAAD(aesGCM, alg, envelope, nil)
+}
+
+// openEnvelopeAAD расшифровывает конверт, созданный sealEnvelopeAAD
+func openEnvelopeAAD(aesGCM cipher.AEAD, alg byte, envelope []byte, aad []byte) ([]byte, error) {
+	if len(envelope) < envelopeHeaderSize+aesGCM.NonceSize()+aesGCM.Overhead() {
+		return nil, model.ErrCipherTooShort
+	}
+	if envelope[0] != cipherVersion1 || envelope[1] != alg {
+		return nil, model.ErrUnknownCipherVersion
+	}
+	header := append(envelope[:envelopeHeaderSize:envelopeHeaderSize], aad...)
+	nonce := envelope[envelopeHeaderSize : envelopeHeaderSize+aesGCM.NonceSize()]
+	cipherData := envelope[envelopeHeaderSize+aesGCM.NonceSize():]
+	return aesGCM.Open(nil, nonce, cipherData, header)
+}
+
+// openLegacy расшифровывает данные, записанные в устаревшем формате
+func openLegacy(aesGCM cipher.AEAD, cipherData []byte, secretPassword string) ([]byte, error) {
+	iv, err := legacyNonce(aesGCM, secretPassword)
+	if err != nil {
+		return nil, err
+	}
+	return aesGCM.Open(nil, iv, cipherData, nil)
+}
+
+// IsLegacyCipher проверяет, зашифрованы ли данные в устаревшем формате
+// (без конверта, с nonce из секрета)
+func IsLegacyCipher(cipherData []byte, secretPassword string, log *logrus.Logger) (bool, error) {
+	aesGCM, err := prepareAESGCM(log, secretPassword)
+	if err != nil {
+		return false, err
+	}
+	if _, err := openEnvelope(aesGCM, algAES256GCM, cipherData); err == nil {
+		return false, nil
+	}
+	if _, err := openLegacy(aesGCM, cipherData, secretPassword); err != nil {
+		// данные, зашифрованные на клиенте или ключом данных пользователя,
+		// не относятся к устаревшему формату
+		if IsClientSealed(cipherData) || IsDataKeySealed(cipherData) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// GCMDataReseal перешифровывает данные в устаревшем формате в новый конверт.
+// Второе значение показывает, были ли данные перешифрованы. Формат определяется
+// пробной расшифровкой, а не по заголовку: шифротекст в устаревшем формате
+// может случайно начинаться с байтов заголовка конверта
+func GCMDataReseal(cipherData []byte, secretPassword string,
+	log *logrus.Logger) ([]byte, bool, error) {
+
+	aesGCM, err := prepareAESGCM(log, secretPassword)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := openEnvelope(aesGCM, algAES256GCM, cipherData); err == nil {
+		return cipherData, false, nil
+	}
+	plainData, err := openLegacy(aesGCM, cipherData, secretPassword)
+	if err != nil {
+		// данные, зашифрованные на клиенте или ключом данных пользователя,
+		// секретом сервера не открываются и перешифровки не требуют
+		if IsClientSealed(cipherData) || IsDataKeySealed(cipherData) {
+			return cipherData, false, nil
+		}
+		return nil, false, err
+	}
+	resealed, err := sealEnvelope(aesGCM, algAES256GCM, plainData)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, false, err
+	}
+	return resealed, true, nil
+}
+
+// GenerateDataKey генерирует случайный ключ данных пользователя
+func GenerateDataKey() ([]byte, error) {
+	dataKey := make([]byte, dataKeyLength)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	return dataKey, nil
+}
+
+// WrapDataKey оборачивает ключ данных пользователя ключом шифрования ключей,
+// полученным из секрета сервера
+func WrapDataKey(dataKey []byte, secretPassword string, log *logrus.Logger) ([]byte, error) {
+	aesGCM, err := prepareAESGCM(log, secretPassword)
+	if err != nil {
+		return nil, err
+	}
+	wrappedKey, err := sealEnvelope(aesGCM, algKeyWrapAES256GCM, dataKey)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return wrappedKey, nil
+}
+
+// UnwrapDataKey разворачивает ключ данных пользователя
+func UnwrapDataKey(wrappedKey []byte, secretPassword string, log *logrus.Logger) ([]byte, error) {
+	aesGCM, err := prepareAESGCM(log, secretPassword)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := openEnvelope(aesGCM, algKeyWrapAES256GCM, wrappedKey)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return dataKey, nil
+}
+
+// RewrapDataKey переоборачивает ключ данных пользователя новым ключом
+// шифрования ключей. Зашифрованные данные пользователя при этом не меняются
+func RewrapDataKey(wrappedKey []byte, oldSecretPassword string, newSecretPassword string,
+	log *logrus.Logger) ([]byte, error) {
+	dataKey, err := UnwrapDataKey(wrappedKey, oldSecretPassword, log)
+	if err != nil {
+		return nil, err
+	}
+	return WrapDataKey(dataKey, newSecretPassword, log)
+}
+
+// KeyFingerprint возвращает отпечаток секрета сервера, по которому
+// можно отличить секреты, не раскрывая их
+func KeyFingerprint(secretPassword string) string {
+	key := sha256.Sum256([]byte(secretPassword))
+	fingerprint := sha256.Sum256(append([]byte("keeper-fingerprint:"), key[:]...))
+	return hex.EncodeToString(fingerprint[:8])
+}
+
+// DataKeyCipher шифрует данные ключом данных пользователя
+func DataKeyCipher(data string, dataKey []byte, log *logrus.Logger) ([]byte, error) {
+	aesGCM, err := newAESGCM(dataKey)
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	cipherData, err := sealEnvelope(aesGCM, algDataKeyAES256GCM, []byte(data))
+	if err != nil {
+		log.Error(err.Error())
+		return nil, err
+	}
+	return cipherData, nil
+}
+
+// DataKeyDecipher дешифрует данные ключом данных пользователя
+func DataKeyDecipher(cipherData []byte, dataKey []byte, log *logrus.Logger) (string, error) {
+	aesGCM, err := newAESGCM(dataKey)
 	if err != nil {
 		log.Error(err.Error())
 		return "", err
 	}
+	plainData, err := openEnvelope(aesGCM, algDataKeyAES256GCM, cipherData)
+	if err != nil {
+		log.Error(err.Error())
+		return "", err
+	}
+	return string(plainData), nil
+}
+
+// IsDataKeySealed проверяет, что данные зашифрованы ключом данных пользователя
+func IsDataKeySealed(cipherData []byte) bool {
+	return len(cipherData) > envelopeHeaderSize &&
+		cipherData[0] == cipherVersion1 && cipherData[1] == algDataKeyAES256GCM
+}
+
+// DeriveVaultKey получает ключ хранилища из мастер-пароля пользователя
+// по алгоритму argon2id. Солью служит хэш логина, поэтому один и тот же
+// мастер-пароль дает один и тот же ключ на любом устройстве пользователя
+func DeriveVaultKey(login string, masterPassword string) []byte {
+	salt := sha256.Sum256([]byte("keeper-vault:" + login))
+	return argon2.IDKey([]byte(masterPassword), salt[:], vaultKeyTime, vaultKeyMemory,
+		vaultKeyThreads, vaultKeyLength)
+}
+
+// DeriveCacheKey получает ключ локальной копии клиента из пароля пользователя.
+// Ключ отличается от ключа хранилища, даже если мастер-пароль совпадает с паролем
+func DeriveCacheKey(login string, password string) []byte {
+	salt := sha256.Sum256([]byte("keeper-cache:" + login))
+	return argon2.IDKey([]byte(password), salt[:], vaultKeyTime, vaultKeyMemory,
+		vaultKeyThreads, vaultKeyLength)
+}
 
-	log.WithFields(logrus.Fields{
-		"plaintext": string(plainData),
-	}).Debug("Дешифрованные данные")
+// VaultCipher шифрует данные на клиенте ключом хранилища
+func VaultCipher(data string, vaultKey []byte) ([]byte, error) {
+	aesGCM, err := newAESGCM(vaultKey)
+	if err != nil {
+		return nil, err
+	}
+	return sealEnvelope(aesGCM, algClientAES256GCM, []byte(data))
+}
+
+// VaultDecipher дешифрует на клиенте данные, зашифрованные ключом хранилища
+func VaultDecipher(cipherData []byte, vaultKey []byte) (string, error) {
+	aesGCM, err := newAESGCM(vaultKey)
+	if err != nil {
+		return "", err
+	}
+	plainData, err := openEnvelope(aesGCM, algClientAES256GCM, cipherData)
+	if err != nil {
+		return "", model.ErrWrongMasterPassword
+	}
 	return string(plainData), nil
 }
 
+// NewVaultCheck шифрует проверочное значение хранилища ключом хранилища
+func NewVaultCheck(vaultKey []byte) ([]byte, error) {
+	return VaultCipher(vaultCheckValue, vaultKey)
+}
+
+// VerifyVaultCheck проверяет, что проверочное значение хранилища
+// зашифровано тем же ключом хранилища
+func VerifyVaultCheck(check []byte, vaultKey []byte) error {
+	value, err := VaultDecipher(check, vaultKey)
+	if err != nil {
+		return err
+	}
+	if value != vaultCheckValue {
+		return model.ErrWrongMasterPassword
+	}
+	return nil
+}
+
+// IsClientSealed проверяет, что данные упакованы в конверт,
+// зашифрованный на клиенте
+func IsClientSealed(cipherData []byte) bool {
+	return len(cipherData) > envelopeHeaderSize &&
+		cipherData[0] == cipherVersion1 && cipherData[1] == algClientAES256GCM
+}
+
 // GetLoginFromContext получает логин пользователя из метаданных контекста
-func GetLoginFromContext(ctx context.Context, secretPassword string) (string, error) {
+func GetLoginFromContext(ctx context.Context, secretPasswords ...string) (string, error) {
+	tk, err := GetTokenFromContext(ctx, secretPasswords...)
+	if err != nil {
+		return "", err
+	}
+	return tk.Login, nil
+}
+
+// GetDeviceFromContext возвращает имя устройства клиента из метаданных контекста,
+// пустую строку, если клиент его не передал
+func GetDeviceFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get("device")
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// GetTokenFromContext проверяет jwt токен из метаданных контекста
+// и возвращает его claims. Подпись проверяется ключами secretPasswords
+// по очереди, чтобы после смены ключа действовали уже выданные токены
+func GetTokenFromContext(ctx context.Context, secretPasswords ...string) (model.Token, error) {
 	md, ok := metadata.FromIncomingContext(ctx)
 	if !ok {
-		return "", model.ErrTokenNotFound
+		return model.Token{}, model.ErrTokenNotFound
 	}
 	values := md.Get("token")
 	if len(values) == 0 {
-		return "", model.ErrTokenNotFound
+		return model.Token{}, model.ErrTokenNotFound
 	}
 	jwtString := values[0]
 
-	tk := model.Token{}
-	token, err := jwt.ParseWithClaims(jwtString, &tk, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secretPassword), nil
-	})
-	if err != nil {
-		return "", err
-	}
-	if !token.Valid {
-		return "", model.ErrNotValidToken
+	err := model.ErrNotValidToken
+	for _, secretPassword := range secretPasswords {
+		var tk model.Token
+		var token *jwt.Token
+		token, err = jwt.ParseWithClaims(jwtString, &tk, func(token *jwt.Token) (interface{}, error) {
+			if token.Method != jwt.SigningMethodHS256 {
+				return nil, model.ErrNotValidToken
+			}
+			return []byte(secretPassword), nil
+		})
+		if err == nil && token.Valid {
+			return tk, nil
+		}
+		if err == nil {
+			err = model.ErrNotValidToken
+		}
 	}
-	return tk.Login, nil
+	return model.Token{}, err
 }
@@ -2,10 +2,11 @@ package utils
 
 import (
 	"context"
+	"crypto/sha256"
 	"keeper/internal/logger"
 	"keeper/internal/model"
-	"os"
 	"testing"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/sirupsen/logrus"
@@ -14,6 +15,9 @@ import (
 	"google.golang.org/grpc/metadata"
 )
 
+// testSecret - секрет сервера в тестах
+const testSecret = "supersecretpasswordforkeeper1234"
+
 func TestGenerateJWTToken(t *testing.T) {
 
 	tests := []struct {
@@ -31,10 +35,7 @@ func TestGenerateJWTToken(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			goprivate := os.Getenv("GOPRIVATE")
-			require.NotEmpty(t, goprivate)
-
-			jwtString, err := GenerateJWTToken(tt.login, tt.log, goprivate)
+			jwtString, claims, err := GenerateJWTToken(tt.login, tt.log, testSecret)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GenerateJWTToken() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -43,12 +44,15 @@ func TestGenerateJWTToken(t *testing.T) {
 
 			tk := model.Token{}
 			token, err := jwt.ParseWithClaims(jwtString, &tk, func(token *jwt.Token) (interface{}, error) {
-				return []byte(goprivate), nil
+				return []byte(testSecret), nil
 			})
 			require.NoError(t, err)
 			require.True(t, token.Valid)
 
 			require.Equal(t, tt.login, tk.Login)
+			assert.NotEmpty(t, tk.Id)
+			assert.Equal(t, claims, tk)
+			assert.Equal(t, int64(AccessTokenTTL.Seconds()), tk.ExpiresAt-tk.IssuedAt)
 
 		})
 	}
@@ -67,8 +71,80 @@ func TestPasswordHash(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			hash := PasswordHash(tt.password)
+			hash, err := PasswordHash(tt.password, model.PasswordHashParams{})
+			require.NoError(t, err)
 			assert.NotEmpty(t, hash)
+
+			// соль случайная, поэтому хэши одного пароля различаются
+			again, err := PasswordHash(tt.password, model.PasswordHashParams{})
+			require.NoError(t, err)
+			assert.NotEqual(t, hash, again)
+		})
+	}
+}
+
+func TestCheckPasswordHash(t *testing.T) {
+	params := model.PasswordHashParams{Time: 1, Memory: 8 * 1024, Threads: 1}
+	passwordHash, err := PasswordHash("123456", params)
+	require.NoError(t, err)
+	legacyHash := sha256.Sum256([]byte("123456"))
+
+	tests := []struct {
+		name            string
+		password        string
+		passwordHash    string
+		params          model.PasswordHashParams
+		wantOk          bool
+		wantNeedsRehash bool
+		wantErr         bool
+	}{
+		{
+			name:         "Верный пароль",
+			password:     "123456",
+			passwordHash: passwordHash,
+			params:       params,
+			wantOk:       true,
+		},
+		{
+			name:         "Неверный пароль",
+			password:     "654321",
+			passwordHash: passwordHash,
+			params:       params,
+			wantOk:       false,
+		},
+		{
+			name:            "Изменились параметры хэширования",
+			password:        "123456",
+			passwordHash:    passwordHash,
+			params:          model.PasswordHashParams{Time: 2, Memory: 8 * 1024, Threads: 1},
+			wantOk:          true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:            "Хэш в устаревшем формате SHA-256",
+			password:        "123456",
+			passwordHash:    string(legacyHash[:]),
+			params:          params,
+			wantOk:          true,
+			wantNeedsRehash: true,
+		},
+		{
+			name:         "Поврежденный хэш",
+			password:     "123456",
+			passwordHash: "$argon2id$v=19$broken",
+			params:       params,
+			wantErr:      true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := CheckPasswordHash(tt.password, tt.passwordHash, tt.params)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckPasswordHash() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			assert.Equal(t, tt.wantOk, ok)
+			assert.Equal(t, tt.wantNeedsRehash, needsRehash)
 		})
 	}
 }
@@ -80,7 +156,6 @@ func TestGCMDataCipher(t *testing.T) {
 		log     *logrus.Logger
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name:    "Успешное шифрование",
 			data:    "little gopher",
@@ -89,8 +164,7 @@ func TestGCMDataCipher(t *testing.T) {
 		},
 	}
 
-	secretPassword := os.Getenv("GOPRIVATE")
-	require.NotEmpty(t, secretPassword)
+	secretPassword := testSecret
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -100,19 +174,30 @@ func TestGCMDataCipher(t *testing.T) {
 				return
 			}
 			assert.NotEmpty(t, got)
+			assert.Equal(t, cipherVersion1, got[0])
+			assert.Equal(t, algAES256GCM, got[1])
+
+			// повторное шифрование тех же данных дает другой шифротекст
+			again, err := GCMDataCipher(tt.data, secretPassword, tt.log)
+			require.NoError(t, err)
+			assert.NotEqual(t, got, again)
 		})
 	}
 }
 
 func TestGetLoginFromContext(t *testing.T) {
 
-	goprivate, exist := os.LookupEnv("GOPRIVATE")
-	require.True(t, exist)
-	require.NotEmpty(t, goprivate)
-
 	log := logger.InitLog(logrus.InfoLevel)
 
-	jwtString, err := GenerateJWTToken("user1", log, goprivate)
+	jwtString, _, err := GenerateJWTToken("user1", log, testSecret)
+	require.NoError(t, err)
+
+	expiredString, err := jwt.NewWithClaims(jwt.SigningMethodHS256, &model.Token{
+		Login: "user1",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+		},
+	}).SignedString([]byte(testSecret))
 	require.NoError(t, err)
 
 	tests := []struct {
@@ -133,12 +218,18 @@ func TestGetLoginFromContext(t *testing.T) {
 			ctx:     context.Background(),
 			wantErr: true,
 		},
+		{
+			name: "Истекший токен",
+			ctx: metadata.NewIncomingContext(context.Background(),
+				metadata.Pairs("token", expiredString)),
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 
-			login, err := GetLoginFromContext(tt.ctx, goprivate)
+			login, err := GetLoginFromContext(tt.ctx, testSecret)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetLoginFromContext() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -156,24 +247,22 @@ func TestPrepareAESGCM(t *testing.T) {
 		secretPassword string
 		wantErr        bool
 	}{
-		// TODO: Add test cases.
 		{
 			name:           "Подготовка режима AES-256 GCM",
 			log:            logger.InitLog(logrus.InfoLevel),
-			secretPassword: os.Getenv("GOPRIVATE"),
+			secretPassword: testSecret,
 			wantErr:        false,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			aesGCM, vector, err := prepareAESGCM(tt.log, tt.secretPassword)
+			aesGCM, err := prepareAESGCM(tt.log, tt.secretPassword)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("prepareAESGCM() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
 			assert.NotEmpty(t, aesGCM)
-			assert.NotEmpty(t, vector)
 		})
 	}
 }
@@ -185,7 +274,6 @@ func TestGCMDataDecipher(t *testing.T) {
 		log     *logrus.Logger
 		wantErr bool
 	}{
-		// TODO: Add test cases.
 		{
 			name:    "Успешное шифрование",
 			data:    "little gopher",
@@ -194,8 +282,7 @@ func TestGCMDataDecipher(t *testing.T) {
 		},
 	}
 
-	secretPassword := os.Getenv("GOPRIVATE")
-	require.NotEmpty(t, secretPassword)
+	secretPassword := testSecret
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			cipherData, err := GCMDataCipher(tt.data, secretPassword, tt.log)
@@ -209,3 +296,202 @@ func TestGCMDataDecipher(t *testing.T) {
 		})
 	}
 }
+
+func TestGCMDataReseal(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	secretPassword := testSecret
+
+	aesGCM, err := prepareAESGCM(log, secretPassword)
+	require.NoError(t, err)
+	iv, err := legacyNonce(aesGCM, secretPassword)
+	require.NoError(t, err)
+	legacyData := aesGCM.Seal(nil, iv, []byte("little gopher"), nil)
+
+	envelopeData, err := GCMDataCipher("little gopher", secretPassword, log)
+	require.NoError(t, err)
+
+	// подбираем открытый текст так, чтобы шифротекст в устаревшем формате
+	// начинался с заголовка конверта клиента
+	keyStream := aesGCM.Seal(nil, iv, make([]byte, envelopeHeaderSize), nil)
+	headerPlain := append([]byte{keyStream[0] ^ cipherVersion1,
+		keyStream[1] ^ algClientAES256GCM}, "little gopher"...)
+	headerLegacyData := aesGCM.Seal(nil, iv, headerPlain, nil)
+	require.True(t, IsClientSealed(headerLegacyData))
+
+	tests := []struct {
+		name        string
+		cipherData  []byte
+		data        string
+		wantChanged bool
+		wantErr     bool
+	}{
+		{
+			name:        "Запись в устаревшем формате",
+			cipherData:  legacyData,
+			data:        "little gopher",
+			wantChanged: true,
+			wantErr:     false,
+		},
+		{
+			name:        "Запись в устаревшем формате с заголовком конверта",
+			cipherData:  headerLegacyData,
+			data:        string(headerPlain),
+			wantChanged: true,
+			wantErr:     false,
+		},
+		{
+			name:        "Запись в новом формате",
+			cipherData:  envelopeData,
+			data:        "little gopher",
+			wantChanged: false,
+			wantErr:     false,
+		},
+		{
+			name:       "Поврежденная запись",
+			cipherData: []byte("broken"),
+			wantErr:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resealed, changed, err := GCMDataReseal(tt.cipherData, secretPassword, log)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GCMDataReseal() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			assert.Equal(t, tt.wantChanged, changed)
+
+			data, err := GCMDataDecipher(resealed, secretPassword, log)
+			require.NoError(t, err)
+			assert.Equal(t, tt.data, data)
+
+			legacy, err := IsLegacyCipher(resealed, secretPassword, log)
+			require.NoError(t, err)
+			assert.False(t, legacy)
+		})
+	}
+}
+
+func TestVaultCipher(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           string
+		masterPassword string
+		openPassword   string
+		wantErr        bool
+	}{
+		{
+			name:           "Расшифровка правильным мастер-паролем",
+			data:           "little gopher",
+			masterPassword: "master",
+			openPassword:   "master",
+			wantErr:        false,
+		},
+		{
+			name:           "Расшифровка неверным мастер-паролем",
+			data:           "little gopher",
+			masterPassword: "master",
+			openPassword:   "wrong",
+			wantErr:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cipherData, err := VaultCipher(tt.data, DeriveVaultKey("user1", tt.masterPassword))
+			require.NoError(t, err)
+			assert.True(t, IsClientSealed(cipherData))
+
+			data, err := VaultDecipher(cipherData, DeriveVaultKey("user1", tt.openPassword))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("VaultDecipher() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				assert.ErrorIs(t, err, model.ErrWrongMasterPassword)
+				return
+			}
+			assert.Equal(t, tt.data, data)
+		})
+	}
+}
+
+func TestVerifyVaultCheck(t *testing.T) {
+	vaultKey := DeriveVaultKey("user1", "master")
+	otherData, err := VaultCipher("little gopher", vaultKey)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		check     func(t *testing.T) []byte
+		vaultKey  []byte
+		wantErrIs error
+	}{
+		{
+			name: "Верный мастер-пароль",
+			check: func(t *testing.T) []byte {
+				check, err := NewVaultCheck(vaultKey)
+				require.NoError(t, err)
+				return check
+			},
+			vaultKey: vaultKey,
+		},
+		{
+			name: "Неверный мастер-пароль",
+			check: func(t *testing.T) []byte {
+				check, err := NewVaultCheck(vaultKey)
+				require.NoError(t, err)
+				return check
+			},
+			vaultKey:  DeriveVaultKey("user1", "wrong"),
+			wantErrIs: model.ErrWrongMasterPassword,
+		},
+		{
+			name:      "Зашифровано не проверочное значение",
+			check:     func(t *testing.T) []byte { return otherData },
+			vaultKey:  vaultKey,
+			wantErrIs: model.ErrWrongMasterPassword,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := VerifyVaultCheck(tt.check(t), tt.vaultKey)
+			if tt.wantErrIs != nil {
+				assert.ErrorIs(t, err, tt.wantErrIs)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestRewrapDataKey(t *testing.T) {
+	log := logger.InitLog(logrus.InfoLevel)
+	oldSecret := "old secret password"
+	newSecret := "new secret password"
+
+	dataKey, err := GenerateDataKey()
+	require.NoError(t, err)
+	wrappedKey, err := WrapDataKey(dataKey, oldSecret, log)
+	require.NoError(t, err)
+
+	cipherData, err := DataKeyCipher("little gopher", dataKey, log)
+	require.NoError(t, err)
+	assert.True(t, IsDataKeySealed(cipherData))
+
+	rewrappedKey, err := RewrapDataKey(wrappedKey, oldSecret, newSecret, log)
+	require.NoError(t, err)
+
+	// старым секретом новый обернутый ключ не разворачивается
+	_, err = UnwrapDataKey(rewrappedKey, oldSecret, log)
+	assert.Error(t, err)
+
+	// данные, зашифрованные до смены ключа шифрования ключей, по-прежнему читаются
+	unwrappedKey, err := UnwrapDataKey(rewrappedKey, newSecret, log)
+	require.NoError(t, err)
+	data, err := DataKeyDecipher(cipherData, unwrappedKey, log)
+	require.NoError(t, err)
+	assert.Equal(t, "little gopher", data)
+}